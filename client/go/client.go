@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"github.com/netorcai/netorcai/protocol"
 	"io"
 	"net"
 	"strconv"
@@ -14,13 +15,29 @@ type Client struct {
 	conn   net.Conn
 	reader *bufio.Reader
 	writer *bufio.Writer
+
+	// Compression algorithms offered at LOGIN, by order of preference
+	// (e.g. []string{"gzip"}). Messages are not compressed if empty.
+	Compression []string
+	// Compression algorithm chosen by netorcai in LOGIN_ACK.
+	compression string
+	// Serialization format asked at LOGIN ("json" or "msgpack").
+	// Messages are serialized in JSON if empty.
+	Serialization string
+	// Whether netorcai accepted MessagePack in LOGIN_ACK.
+	messagePack bool
+	stats       protocol.TrafficStats
 }
 
 func (c *Client) Connect(hostname string, port int) error {
-	var err error
-	connectAddress := hostname + ":" + strconv.Itoa(port)
+	return c.ConnectAddress("tcp", hostname+":"+strconv.Itoa(port))
+}
 
-	c.conn, err = net.Dial("tcp", connectAddress)
+// ConnectAddress connects to netorcai on any network supported by net.Dial,
+// e.g. ConnectAddress("unix", "/tmp/netorcai.sock").
+func (c *Client) ConnectAddress(network, address string) error {
+	var err error
+	c.conn, err = net.Dial(network, address)
 	if err != nil {
 		return err
 	}
@@ -36,16 +53,31 @@ func (c *Client) Disconnect() error {
 	return c.conn.Close()
 }
 
+// Stats returns how many messages and bytes have been exchanged with
+// netorcai, before and after compression.
+func (c *Client) Stats() protocol.TrafficStats {
+	return c.stats
+}
+
+// SendBytes sends a raw message content, which is never compressed.
 func (c *Client) SendBytes(content []byte, checkSize bool) error {
-	contentSize := len(content)
-	if checkSize && contentSize >= 16777215 {
+	return c.writeFrame(content, checkSize, true)
+}
+
+// writeFrame writes a size-prefixed message content then flushes it.
+// Uncompressed contents are terminated by a "\n" character.
+func (c *Client) writeFrame(content []byte, checkSize, newline bool) error {
+	frameSize := len(content)
+	if newline {
+		frameSize++
+	}
+	if checkSize && frameSize > protocol.MaxMessageSize {
 		return fmt.Errorf("content too big: size does not fit in 24 bits")
 	}
 
 	// Write content size on socket
-	var contentSizeUint32 uint32 = uint32(contentSize) + 1 // +1 for \n
 	contentSizeBuf := make([]byte, 4)
-	binary.LittleEndian.PutUint32(contentSizeBuf, contentSizeUint32)
+	binary.LittleEndian.PutUint32(contentSizeBuf, uint32(frameSize))
 	_, err := c.writer.Write(contentSizeBuf)
 	if err != nil {
 		return fmt.Errorf("Remote endpoint closed? Write error: %v", err)
@@ -57,10 +89,12 @@ func (c *Client) SendBytes(content []byte, checkSize bool) error {
 		return fmt.Errorf("Remote endpoint closed? Write error: %v", err)
 	}
 
-	// Write terminating "\n" character on socket
-	err = c.writer.WriteByte(0x0A)
-	if err != nil {
-		return fmt.Errorf("Remote endpoint closed? Write error: %v", err)
+	if newline {
+		// Write terminating "\n" character on socket
+		err = c.writer.WriteByte(0x0A)
+		if err != nil {
+			return fmt.Errorf("Remote endpoint closed? Write error: %v", err)
+		}
 	}
 
 	// Flush socket
@@ -73,31 +107,112 @@ func (c *Client) SendString(str string) error {
 }
 
 func (c *Client) SendJSON(msg map[string]interface{}) error {
-	content, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("Cannot marshall JSON message: %v", err)
+	return c.sendMessage(msg)
+}
+
+func (c *Client) sendMessage(msg interface{}) error {
+	var content []byte
+	var err error
+	if c.messagePack {
+		content, err = protocol.MarshalMessagePack(msg)
+		if err != nil {
+			return fmt.Errorf("Cannot marshall MessagePack message: %v", err)
+		}
+	} else {
+		content, err = json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("Cannot marshall JSON message: %v", err)
+		}
+	}
+
+	// JSON contents are terminated by a \n character.
+	uncompressedSize := len(content)
+	if !c.messagePack {
+		uncompressedSize++
+	}
+	frameSize := uncompressedSize
+	if c.compression != "" &&
+		len(content) >= protocol.MinCompressedMessageSize {
+		content, err = protocol.CompressMessage(content)
+		if err != nil {
+			return err
+		}
+		frameSize = len(content)
+		err = c.writeFrame(content, true, false)
 	} else {
-		return c.SendBytes(content, true)
+		err = c.writeFrame(content, true, !c.messagePack)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.stats.MessagesSent++
+	c.stats.BytesSent += int64(frameSize)
+	c.stats.UncompressedBytesSent += int64(uncompressedSize)
+	return nil
+}
+
+// offeredCompression returns the algorithms of c.Compression that this
+// client supports.
+func (c *Client) offeredCompression() []string {
+	var offered []string
+	for _, algorithm := range c.Compression {
+		if protocol.ChooseCompression([]string{algorithm}) != "" {
+			offered = append(offered, algorithm)
+		}
 	}
+	return offered
 }
 
-func (c *Client) SendLogin(role, nickname, metaprotocolVersion string) error {
-	msg := map[string]interface{}{
-		"message_type":         "LOGIN",
-		"role":                 role,
-		"nickname":             nickname,
-		"metaprotocol_version": metaprotocolVersion,
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
+	return false
+}
+
+func (c *Client) SendLogin(role, nickname, metaprotocolVersion string) error {
+	return c.SendLoginWithGameStateEncoding(role, nickname,
+		metaprotocolVersion, "")
+}
 
-	return c.SendJSON(msg)
+// SendLoginWithGameStateEncoding sends a LOGIN that chooses how game states
+// are sent in TURN messages ("full", "merge-patch" or "json-patch").
+// No encoding is sent if gameStateEncoding is empty.
+func (c *Client) SendLoginWithGameStateEncoding(role, nickname,
+	metaprotocolVersion, gameStateEncoding string) error {
+	return c.sendMessage(protocol.MessageLogin{
+		MessageType:         "LOGIN",
+		Nickname:            nickname,
+		Role:                role,
+		MetaprotocolVersion: metaprotocolVersion,
+		GameStateEncoding:   gameStateEncoding,
+		Compression:         c.offeredCompression(),
+		Serialization:       c.Serialization,
+	})
 }
 
-func (c *Client) ReadMessage() (map[string]interface{}, error) {
-	var msg map[string]interface{}
+// SendAgentLogin logs in as an agent that controls nbSlots player slots.
+func (c *Client) SendAgentLogin(nickname, metaprotocolVersion string,
+	nbSlots int) error {
+	return c.sendMessage(protocol.MessageLogin{
+		MessageType:         "LOGIN",
+		Nickname:            nickname,
+		Role:                "agent",
+		MetaprotocolVersion: metaprotocolVersion,
+		Compression:         c.offeredCompression(),
+		Serialization:       c.Serialization,
+		NbSlots:             nbSlots,
+	})
+}
+
+func (c *Client) readContent() ([]byte, error) {
 	contentSizeBuf := make([]byte, 4)
 	_, err := io.ReadFull(c.reader, contentSizeBuf)
 	if err != nil {
-		return msg, fmt.Errorf("Remote endpoint closed? Read error: %v", err)
+		return nil, fmt.Errorf("Remote endpoint closed? Read error: %v", err)
 	}
 
 	// Read message content size
@@ -107,10 +222,44 @@ func (c *Client) ReadMessage() (map[string]interface{}, error) {
 	contentBuf := make([]byte, contentSize)
 	_, err = io.ReadFull(c.reader, contentBuf)
 	if err != nil {
-		return msg, fmt.Errorf("Remote endpoint closed? Read error: %v", err)
+		return nil, fmt.Errorf("Remote endpoint closed? Read error: %v", err)
+	}
+
+	uncompressedSize := len(contentBuf)
+	if c.compression != "" && protocol.IsCompressedMessage(contentBuf) {
+		contentBuf, err = protocol.DecompressMessage(contentBuf)
+		if err != nil {
+			return nil, err
+		}
+		uncompressedSize = len(contentBuf)
+		if !c.messagePack {
+			uncompressedSize++ // as if sent uncompressed, with its \n
+		}
+	}
+
+	c.stats.MessagesReceived++
+	c.stats.BytesReceived += int64(contentSize)
+	c.stats.UncompressedBytesReceived += int64(uncompressedSize)
+	return contentBuf, nil
+}
+
+func (c *Client) ReadMessage() (map[string]interface{}, error) {
+	var msg map[string]interface{}
+	contentBuf, err := c.readContent()
+	if err != nil {
+		return msg, err
 	}
 
 	// Read message content
+	if c.messagePack {
+		msg, err = protocol.UnmarshalMessagePack(contentBuf)
+		if err != nil {
+			return msg, fmt.Errorf("Invalid MessagePack message received: "+
+				"%v", err)
+		}
+		return msg, nil
+	}
+
 	err = json.Unmarshal(contentBuf, &msg)
 	if err != nil {
 		return msg, fmt.Errorf("Non-JSON message received")
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/netorcai/netorcai/protocol"
+)
+
+// GameLogic is implemented by game logics driven by RunGameLogic.
+// Game states are game-dependent objects that are forwarded to all clients.
+type GameLogic interface {
+	// Init is called when DO_INIT is received, at the start of each episode.
+	// It resets the game and returns the initial game state.
+	Init(nbPlayers, nbSpecialPlayers, nbTurnsMax int) map[string]interface{}
+	// Turn is called on each DO_TURN with the actions of the players.
+	// It returns the new game state and the current winner
+	// (-1 if there is no winner).
+	Turn(playerActions []protocol.MessageDoTurnPlayerAction) (gameState map[string]interface{},
+		winnerPlayerID int)
+}
+
+// ActionSchemaGameLogic is implemented by game logics that declare the JSON
+// Schema of player actions, so that netorcai drops invalid actions.
+type ActionSchemaGameLogic interface {
+	GameLogic
+	// ActionSchema is called after each Init.
+	ActionSchema() map[string]interface{}
+}
+
+// FeedbackGameLogic is implemented by game logics that send messages to
+// individual players, such as why their actions have been rejected.
+type FeedbackGameLogic interface {
+	GameLogic
+	// Feedback is called after each Turn. The returned messages are
+	// delivered to their players in the next TURN.
+	Feedback() []protocol.PlayerFeedback
+}
+
+// DeltaGameLogic is implemented by game logics that only send the changes
+// of their game states to netorcai, which saves bandwidth for big states.
+type DeltaGameLogic interface {
+	GameLogic
+	// GameStateDeltas is called after each Init. If it returns true,
+	// DO_TURN_ACK messages contain JSON merge patches of the previous game
+	// state (or the whole game state if a patch cannot express it).
+	GameStateDeltas() bool
+}
+
+// RunGameLogic logs in to netorcai with c, which must be connected, then
+// drives gameLogic until netorcai tells that the game is finished.
+// An error is returned if the game logic is kicked for another reason,
+// if the connection is lost or if a message is invalid.
+func RunGameLogic(c *Client, nickname string, gameLogic GameLogic) error {
+	err := c.SendLogin("game logic", nickname, protocol.Version)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.ReadLoginAck()
+	if err != nil {
+		return err
+	}
+
+	doInit, err := c.ReadDoInit()
+	if err != nil {
+		return err
+	}
+
+	sendDeltas, lastGameState, err := initGameLogic(c, gameLogic, doInit)
+	if err != nil {
+		return err
+	}
+
+	nbPlayers := doInit.NbPlayers + doInit.NbSpecialPlayers
+	for {
+		// netorcai sends DO_INIT again when another episode (or game)
+		// starts.
+		messageType, msg, err := c.readMessageOfType("DO_TURN", "DO_INIT",
+			"KICK")
+		if err != nil {
+			return err
+		}
+		if messageType == "KICK" {
+			kick, err := protocol.ReadKickMessage(msg)
+			if err != nil {
+				return err
+			}
+			if kick.KickReason == protocol.KickReasonGameFinished {
+				return nil
+			}
+			return fmt.Errorf("Kicked from netorcai. Reason: %v",
+				kick.KickReason)
+		}
+		if messageType == "DO_INIT" {
+			doInit, err = protocol.ReadDoInitMessage(msg)
+			if err != nil {
+				return err
+			}
+
+			sendDeltas, lastGameState, err = initGameLogic(c, gameLogic,
+				doInit)
+			if err != nil {
+				return err
+			}
+			nbPlayers = doInit.NbPlayers + doInit.NbSpecialPlayers
+			continue
+		}
+
+		doTurn, err := protocol.ReadDoTurnMessage(msg, nbPlayers)
+		if err != nil {
+			return err
+		}
+
+		gameState, winnerPlayerID := gameLogic.Turn(doTurn.PlayerActions)
+		// Checked here, as netorcai would only tell that DO_TURN_ACK is
+		// invalid by kicking the game logic.
+		if winnerPlayerID < -1 || winnerPlayerID >= nbPlayers {
+			return fmt.Errorf("Invalid winner_player_id %v: not in [-1, %v[",
+				winnerPlayerID, nbPlayers)
+		}
+
+		var feedback []protocol.PlayerFeedback
+		if feedbackGameLogic, hasFeedback := gameLogic.(FeedbackGameLogic); hasFeedback {
+			feedback = feedbackGameLogic.Feedback()
+		}
+		if !sendDeltas {
+			err = c.SendDoTurnAckWithFeedback(gameState, winnerPlayerID,
+				feedback)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		decodedGameState, err := decodedCopy(gameState)
+		if err != nil {
+			return err
+		}
+		patch, canPatch := protocol.MergePatch(lastGameState, decodedGameState)
+		if canPatch {
+			err = c.SendDoTurnAckWithPatch(patch, winnerPlayerID, feedback)
+		} else {
+			err = c.SendDoTurnAckWithFeedback(gameState, winnerPlayerID,
+				feedback)
+		}
+		if err != nil {
+			return err
+		}
+		lastGameState = decodedGameState
+	}
+}
+
+// initGameLogic calls Init then sends DO_INIT_ACK. It returns whether
+// game state deltas are sent, and the game state they apply to.
+func initGameLogic(c *Client, gameLogic GameLogic,
+	doInit protocol.MessageDoInit) (bool, map[string]interface{}, error) {
+	initialGameState := gameLogic.Init(doInit.NbPlayers,
+		doInit.NbSpecialPlayers, doInit.NbTurnsMax)
+	var actionSchema map[string]interface{}
+	if schemaGameLogic, hasSchema := gameLogic.(ActionSchemaGameLogic); hasSchema {
+		actionSchema = schemaGameLogic.ActionSchema()
+	}
+	err := c.SendDoInitAckWithActionSchema(initialGameState, actionSchema)
+	if err != nil {
+		return false, nil, err
+	}
+
+	sendDeltas := false
+	if deltaGameLogic, hasDeltas := gameLogic.(DeltaGameLogic); hasDeltas {
+		sendDeltas = deltaGameLogic.GameStateDeltas()
+	}
+	if !sendDeltas {
+		return false, nil, nil
+	}
+	lastGameState, err := decodedCopy(initialGameState)
+	return sendDeltas, lastGameState, err
+}
+
+// decodedCopy returns gameState as netorcai decodes it. Game logics may
+// modify their game state in place, so patches are computed on such copies.
+func decodedCopy(gameState map[string]interface{}) (map[string]interface{},
+	error) {
+	content, err := json.Marshal(gameState)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot encode game state: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	err = json.Unmarshal(content, &decoded)
+	return decoded, err
+}
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"fmt"
+	"github.com/netorcai/netorcai/protocol"
+	"sort"
+)
+
+// readMessageOfType reads a message whose type is one of expectedTypes.
+// A KICK is always reported as an error, unless it has been expected.
+func (c *Client) readMessageOfType(expectedTypes ...string) (
+	messageType string, msg map[string]interface{}, err error) {
+	msg, err = c.ReadMessage()
+	if err != nil {
+		return "", nil, err
+	}
+
+	messageType, err = protocol.ReadString(msg, "message_type")
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, expectedType := range expectedTypes {
+		if messageType == expectedType {
+			return messageType, msg, nil
+		}
+	}
+
+	if messageType == "KICK" {
+		kick, err := protocol.ReadKickMessage(msg)
+		if err != nil {
+			return "", nil, err
+		}
+		return "", nil, fmt.Errorf("Kicked from netorcai. Reason: %v",
+			kick.KickReason)
+	}
+	return "", nil, fmt.Errorf("Unexpected message received: expected %v, "+
+		"got '%v'", expectedTypes, messageType)
+}
+
+func (c *Client) ReadLoginAck() (protocol.MessageLoginAck, error) {
+	_, msg, err := c.readMessageOfType("LOGIN_ACK")
+	if err != nil {
+		return protocol.MessageLoginAck{}, err
+	}
+
+	loginAck, err := protocol.ReadLoginAckMessage(msg)
+	if err == nil {
+		if loginAck.Compression != "" &&
+			!containsString(c.offeredCompression(), loginAck.Compression) {
+			return loginAck, fmt.Errorf("Unexpected compression '%v' "+
+				"in LOGIN_ACK", loginAck.Compression)
+		}
+		if loginAck.Serialization != "" &&
+			loginAck.Serialization != c.Serialization {
+			return loginAck, fmt.Errorf("Unexpected serialization '%v' "+
+				"in LOGIN_ACK", loginAck.Serialization)
+		}
+		c.compression = loginAck.Compression
+		c.messagePack = loginAck.Serialization ==
+			protocol.SerializationMessagePack
+	}
+	return loginAck, err
+}
+
+func (c *Client) ReadGameStarts() (protocol.MessageGameStarts, error) {
+	_, msg, err := c.readMessageOfType("GAME_STARTS")
+	if err != nil {
+		return protocol.MessageGameStarts{}, err
+	}
+	return protocol.ReadGameStartsMessage(msg)
+}
+
+func (c *Client) ReadTurn() (protocol.MessageTurn, error) {
+	_, msg, err := c.readMessageOfType("TURN")
+	if err != nil {
+		return protocol.MessageTurn{}, err
+	}
+	return protocol.ReadTurnMessage(msg)
+}
+
+func (c *Client) ReadGameEnds() (protocol.MessageGameEnds, error) {
+	_, msg, err := c.readMessageOfType("GAME_ENDS")
+	if err != nil {
+		return protocol.MessageGameEnds{}, err
+	}
+	return protocol.ReadGameEndsMessage(msg)
+}
+
+func (c *Client) ReadDoInit() (protocol.MessageDoInit, error) {
+	_, msg, err := c.readMessageOfType("DO_INIT")
+	if err != nil {
+		return protocol.MessageDoInit{}, err
+	}
+	return protocol.ReadDoInitMessage(msg)
+}
+
+// ReadDoTurn reads a DO_TURN. nbPlayers is the total number of players
+// (special players included), as received in DO_INIT.
+func (c *Client) ReadDoTurn(nbPlayers int) (protocol.MessageDoTurn, error) {
+	_, msg, err := c.readMessageOfType("DO_TURN")
+	if err != nil {
+		return protocol.MessageDoTurn{}, err
+	}
+	return protocol.ReadDoTurnMessage(msg, nbPlayers)
+}
+
+func (c *Client) SendTurnAck(turnNumber int, actions []interface{}) error {
+	if actions == nil {
+		actions = []interface{}{}
+	}
+
+	return c.sendMessage(protocol.MessageTurnAck{
+		MessageType: "TURN_ACK",
+		TurnNumber:  turnNumber,
+		Actions:     actions,
+	})
+}
+
+// SendAgentTurnAck sends the TURN_ACK of an agent, with the actions of each
+// of its slots by player ID.
+func (c *Client) SendAgentTurnAck(turnNumber int,
+	actions map[int][]interface{}) error {
+	playerIDs := make([]int, 0, len(actions))
+	for playerID := range actions {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Ints(playerIDs)
+
+	playerActions := make([]protocol.AgentPlayerActions, 0, len(actions))
+	for _, playerID := range playerIDs {
+		slotActions := actions[playerID]
+		if slotActions == nil {
+			slotActions = []interface{}{}
+		}
+		playerActions = append(playerActions, protocol.AgentPlayerActions{
+			PlayerID: playerID,
+			Actions:  slotActions,
+		})
+	}
+
+	return c.sendMessage(protocol.MessageAgentTurnAck{
+		MessageType:   "TURN_ACK",
+		TurnNumber:    turnNumber,
+		PlayerActions: playerActions,
+	})
+}
+
+func (c *Client) SendDoInitAck(initialGameState map[string]interface{}) error {
+	return c.SendDoInitAckWithActionSchema(initialGameState, nil)
+}
+
+// SendDoInitAckWithActionSchema sends a DO_INIT_ACK that declares the JSON
+// Schema of player actions. No schema is sent if actionSchema is nil.
+func (c *Client) SendDoInitAckWithActionSchema(
+	initialGameState, actionSchema map[string]interface{}) error {
+	if initialGameState == nil {
+		initialGameState = map[string]interface{}{}
+	}
+
+	msg := map[string]interface{}{
+		"message_type": "DO_INIT_ACK",
+		"initial_game_state": map[string]interface{}{
+			"all_clients": initialGameState,
+		},
+	}
+	if actionSchema != nil {
+		msg["action_schema"] = actionSchema
+	}
+
+	return c.SendJSON(msg)
+}
+
+func (c *Client) SendDoTurnAck(gameState map[string]interface{},
+	winnerPlayerID int) error {
+	return c.SendDoTurnAckWithFeedback(gameState, winnerPlayerID, nil)
+}
+
+// SendDoTurnAckWithFeedback sends a DO_TURN_ACK that carries messages to
+// individual players. No feedback is sent if feedback is nil.
+func (c *Client) SendDoTurnAckWithFeedback(gameState map[string]interface{},
+	winnerPlayerID int, feedback []protocol.PlayerFeedback) error {
+	if gameState == nil {
+		gameState = map[string]interface{}{}
+	}
+
+	msg := map[string]interface{}{
+		"message_type":     "DO_TURN_ACK",
+		"winner_player_id": winnerPlayerID,
+		"game_state": map[string]interface{}{
+			"all_clients": gameState,
+		},
+	}
+	if feedback != nil {
+		msg["feedback"] = feedback
+	}
+
+	return c.SendJSON(msg)
+}
+
+// SendDoTurnAckWithPatch sends a DO_TURN_ACK that only contains the changes
+// of the game state, as a JSON merge patch of the previous game state.
+func (c *Client) SendDoTurnAckWithPatch(gameStatePatch map[string]interface{},
+	winnerPlayerID int, feedback []protocol.PlayerFeedback) error {
+	if gameStatePatch == nil {
+		gameStatePatch = map[string]interface{}{}
+	}
+
+	msg := map[string]interface{}{
+		"message_type":     "DO_TURN_ACK",
+		"winner_player_id": winnerPlayerID,
+		"game_state_patch": map[string]interface{}{
+			"all_clients": gameStatePatch,
+		},
+	}
+	if feedback != nil {
+		msg["feedback"] = feedback
+	}
+
+	return c.SendJSON(msg)
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"fmt"
+	"github.com/netorcai/netorcai/protocol"
+)
+
+// Player is implemented by bots (players, special players or
+// visualizations) driven by RunPlayer.
+type Player interface {
+	// OnGameStarts is called when GAME_STARTS is received, once per episode.
+	OnGameStarts(gameStarts protocol.MessageGameStarts)
+	// OnTurn is called on each TURN. The returned actions are sent back in a
+	// TURN_ACK. They are ignored by netorcai if the turn is not actionable.
+	// Visualizations must not return any action.
+	OnTurn(turn protocol.MessageTurn) []interface{}
+	// OnGameEnds is called when GAME_ENDS is received, once per episode.
+	OnGameEnds(gameEnds protocol.MessageGameEnds)
+}
+
+// EncodingPlayer is implemented by players that choose how netorcai sends
+// them game states. The client library always gives whole game states to
+// OnTurn, but netorcai may only send their changes over the network.
+type EncodingPlayer interface {
+	Player
+	// GameStateEncoding returns the game_state_encoding of LOGIN:
+	// "full", "merge-patch" or "json-patch".
+	GameStateEncoding() string
+}
+
+// RunPlayer logs in to netorcai with c, which must be connected, then drives
+// player until the session ends (after the last episode of its last game).
+// An error is returned if the client is kicked, if the connection is lost or
+// if netorcai does not follow the metaprotocol.
+func RunPlayer(c *Client, role, nickname string, player Player) error {
+	gameStateEncoding := ""
+	if encodingPlayer, hasEncoding := player.(EncodingPlayer); hasEncoding {
+		gameStateEncoding = encodingPlayer.GameStateEncoding()
+	}
+	err := c.SendLoginWithGameStateEncoding(role, nickname, protocol.Version,
+		gameStateEncoding)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.ReadLoginAck()
+	if err != nil {
+		return err
+	}
+
+	return playEpisodes(c, player.OnGameStarts,
+		func(turn protocol.MessageTurn) error {
+			return c.SendTurnAck(turn.TurnNumber, player.OnTurn(turn))
+		}, player.OnGameEnds)
+}
+
+// Agent is implemented by bots that control several player slots at once,
+// driven by RunAgent.
+type Agent interface {
+	// OnGameStarts is called when GAME_STARTS is received, once per episode.
+	// The player IDs of the slots of the agent are in gameStarts.PlayerIDs.
+	OnGameStarts(gameStarts protocol.MessageGameStarts)
+	// OnTurn is called on each TURN. It returns the actions of the slots
+	// that can act (turn.ActionablePlayerIDs), by player ID. The slots
+	// without actions do nothing.
+	OnTurn(turn protocol.MessageTurn) map[int][]interface{}
+	// OnGameEnds is called when GAME_ENDS is received, once per episode.
+	OnGameEnds(gameEnds protocol.MessageGameEnds)
+}
+
+// RunAgent logs in to netorcai with c, which must be connected, as an agent
+// that controls nbSlots player slots. Then it drives agent as RunPlayer
+// drives players.
+func RunAgent(c *Client, nickname string, nbSlots int, agent Agent) error {
+	err := c.SendAgentLogin(nickname, protocol.Version, nbSlots)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.ReadLoginAck()
+	if err != nil {
+		return err
+	}
+
+	return playEpisodes(c, agent.OnGameStarts,
+		func(turn protocol.MessageTurn) error {
+			return c.SendAgentTurnAck(turn.TurnNumber, agent.OnTurn(turn))
+		}, agent.OnGameEnds)
+}
+
+// playEpisodes reads the messages of each episode until the session ends.
+// onTurn must send the TURN_ACK.
+func playEpisodes(c *Client,
+	onGameStarts func(protocol.MessageGameStarts),
+	onTurn func(protocol.MessageTurn) error,
+	onGameEnds func(protocol.MessageGameEnds)) error {
+	for {
+		// The game may end before it starts (e.g., game logic timeout).
+		messageType, msg, err := c.readMessageOfType("GAME_STARTS",
+			"GAME_ENDS")
+		if err != nil {
+			return err
+		}
+		if messageType == "GAME_ENDS" {
+			return readGameEnds(msg, onGameEnds)
+		}
+
+		gameStarts, err := protocol.ReadGameStartsMessage(msg)
+		if err != nil {
+			return err
+		}
+		onGameStarts(gameStarts)
+
+		gameEnds, err := playEpisode(c, gameStarts, onTurn)
+		if err != nil {
+			return err
+		}
+		onGameEnds(gameEnds)
+
+		// Other episodes (or games) follow a finished episode, with the
+		// same clients.
+		if gameEnds.Status != "finished" ||
+			(gameEnds.Episode+1 >= gameEnds.NbEpisodes &&
+				!gameEnds.NextGame) {
+			return nil
+		}
+	}
+}
+
+// playEpisode calls onTurn on each TURN until GAME_ENDS is received.
+func playEpisode(c *Client, gameStarts protocol.MessageGameStarts,
+	onTurn func(protocol.MessageTurn) error) (protocol.MessageGameEnds,
+	error) {
+	gameState := gameStarts.InitialGameState
+	lastTurnNumber := -1
+	for {
+		messageType, msg, err := c.readMessageOfType("TURN", "GAME_ENDS")
+		if err != nil {
+			return protocol.MessageGameEnds{}, err
+		}
+		if messageType == "GAME_ENDS" {
+			return protocol.ReadGameEndsMessage(msg)
+		}
+
+		turn, err := protocol.ReadTurnMessage(msg)
+		if err != nil {
+			return protocol.MessageGameEnds{}, err
+		}
+
+		// Turns can be skipped by netorcai, but never repeated.
+		if turn.TurnNumber <= lastTurnNumber {
+			return protocol.MessageGameEnds{}, fmt.Errorf("Invalid TURN "+
+				"message: turn_number %v received after turn_number %v",
+				turn.TurnNumber, lastTurnNumber)
+		}
+		lastTurnNumber = turn.TurnNumber
+
+		// Rebuild the whole game state if netorcai only sent its changes.
+		if turn.GameStatePatch != nil {
+			turn.GameState = protocol.ApplyMergePatch(gameState,
+				turn.GameStatePatch).(map[string]interface{})
+		} else if turn.GameStateJSONPatch != nil {
+			patched, err := protocol.ApplyJSONPatch(gameState,
+				turn.GameStateJSONPatch)
+			if err != nil {
+				return protocol.MessageGameEnds{}, fmt.Errorf(
+					"Invalid game_state_json_patch: %v", err)
+			}
+			patchedObject, isObject := patched.(map[string]interface{})
+			if !isObject {
+				return protocol.MessageGameEnds{}, fmt.Errorf(
+					"Invalid game_state_json_patch: " +
+						"Patched game state is not an object")
+			}
+			turn.GameState = patchedObject
+		}
+		gameState = turn.GameState
+
+		err = onTurn(turn)
+		if err != nil {
+			return protocol.MessageGameEnds{}, err
+		}
+	}
+}
+
+func readGameEnds(msg map[string]interface{},
+	onGameEnds func(protocol.MessageGameEnds)) error {
+	gameEnds, err := protocol.ReadGameEndsMessage(msg)
+	if err != nil {
+		return err
+	}
+	onGameEnds(gameEnds)
+	return nil
+}
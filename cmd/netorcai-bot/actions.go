@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+)
+
+// actionSource computes the actions a bot sends in its TURN_ACK messages.
+type actionSource interface {
+	actions(turnNumber int) []interface{}
+}
+
+// emptyActions always sends an empty action list.
+type emptyActions struct{}
+
+func (emptyActions) actions(turnNumber int) []interface{} {
+	return []interface{}{}
+}
+
+// replayActions sends the actions read from a file.
+// The i-th element of turns is sent in reply to the TURN whose turn_number
+// is i. Empty actions are sent once the file is exhausted.
+type replayActions struct {
+	turns [][]interface{}
+}
+
+func (r *replayActions) actions(turnNumber int) []interface{} {
+	if turnNumber < 0 || turnNumber >= len(r.turns) {
+		return []interface{}{}
+	}
+	return r.turns[turnNumber]
+}
+
+// randomActions sends one random action per turn.
+// Each key of template is associated with its candidate values. The sent
+// action associates each key with one of its candidates, chosen at random.
+type randomActions struct {
+	template map[string][]interface{}
+	keys     []string
+	rng      *rand.Rand
+}
+
+func (r *randomActions) actions(turnNumber int) []interface{} {
+	action := make(map[string]interface{})
+	for _, key := range r.keys {
+		candidates := r.template[key]
+		action[key] = candidates[r.rng.Intn(len(candidates))]
+	}
+	return []interface{}{action}
+}
+
+// readReplayActions reads a JSON array whose elements are the action lists
+// of successive turns, such as [[{"move": "up"}], [], [{"move": "left"}]].
+func readReplayActions(filename string) (*replayActions, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var turns [][]interface{}
+	err = json.Unmarshal(content, &turns)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid replay file '%v': expected a JSON "+
+			"array of action arrays. %v", filename, err)
+	}
+
+	for index, actions := range turns {
+		if actions == nil {
+			turns[index] = []interface{}{}
+		}
+	}
+	return &replayActions{turns: turns}, nil
+}
+
+// readRandomActions reads a JSON object whose values are non-empty arrays of
+// candidates, such as {"move": ["up", "down"], "speed": [1, 2]}.
+func readRandomActions(filename string, seed int64) (*randomActions, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var template map[string][]interface{}
+	err = json.Unmarshal(content, &template)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template file '%v': expected a JSON "+
+			"object whose values are arrays. %v", filename, err)
+	}
+
+	keys := []string{}
+	for key, candidates := range template {
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("Invalid template file '%v': "+
+				"no candidate for key '%v'", filename, key)
+		}
+		keys = append(keys, key)
+	}
+	// Iterate keys in a fixed order so that a seed always gives the same
+	// actions.
+	sort.Strings(keys)
+
+	return &randomActions{
+		template: template,
+		keys:     keys,
+		rng:      rand.New(rand.NewSource(seed)),
+	}, nil
+}
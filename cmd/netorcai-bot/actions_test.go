@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "netorcai-bot")
+	assert.NoError(t, err, "Cannot create temporary file")
+	defer file.Close()
+
+	_, err = file.WriteString(content)
+	assert.NoError(t, err, "Cannot write temporary file")
+	return file.Name()
+}
+
+func TestReplayActions(t *testing.T) {
+	filename := writeTempFile(t, `[[{"move": "up"}], null, []]`)
+	defer os.Remove(filename)
+
+	replay, err := readReplayActions(filename)
+	assert.NoError(t, err, "Valid replay file not read")
+	assert.Equal(t, []interface{}{map[string]interface{}{"move": "up"}},
+		replay.actions(0))
+	assert.Equal(t, []interface{}{}, replay.actions(1))
+	assert.Equal(t, []interface{}{}, replay.actions(2))
+	assert.Equal(t, []interface{}{}, replay.actions(42),
+		"Non-empty actions after the end of the file")
+
+	invalid := writeTempFile(t, `{"move": "up"}`)
+	defer os.Remove(invalid)
+	_, err = readReplayActions(invalid)
+	assert.Error(t, err, "No error on non-array replay file")
+}
+
+func TestRandomActions(t *testing.T) {
+	filename := writeTempFile(t, `{"move": ["up", "down"], "speed": [1]}`)
+	defer os.Remove(filename)
+
+	random, err := readRandomActions(filename, 42)
+	assert.NoError(t, err, "Valid template file not read")
+	sameSeed, err := readRandomActions(filename, 42)
+	assert.NoError(t, err, "Valid template file not read")
+
+	for turnNumber := 0; turnNumber < 10; turnNumber++ {
+		actions := random.actions(turnNumber)
+		assert.Len(t, actions, 1, "Unexpected number of random actions")
+		action := actions[0].(map[string]interface{})
+		assert.Contains(t, []interface{}{"up", "down"}, action["move"])
+		assert.Equal(t, 1.0, action["speed"])
+		assert.Equal(t, actions, sameSeed.actions(turnNumber),
+			"Same seed gave different actions")
+	}
+
+	invalid := writeTempFile(t, `{"move": []}`)
+	defer os.Remove(invalid)
+	_, err = readRandomActions(invalid, 42)
+	assert.Error(t, err, "No error on key without candidate")
+}
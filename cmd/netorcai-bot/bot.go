@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// errCrashed is returned by bot.run when the bot crashed on purpose.
+var errCrashed = fmt.Errorf("Crashed on purpose")
+
+type bot struct {
+	client   *client.Client
+	role     string
+	nickname string
+	actions  actionSource
+
+	// Time to wait before sending each TURN_ACK.
+	latency time.Duration
+	// The bot closes its socket on the first TURN whose turn_number is
+	// greater than or equal to crashAtTurn. -1 means never.
+	crashAtTurn int
+	// The bot sends an invalid TURN_ACK in reply to the first TURN whose
+	// turn_number is greater than or equal to malformedTurnAckAt.
+	// -1 means never.
+	malformedTurnAckAt int
+}
+
+// run logs in then plays until the GAME_ENDS that ends the session.
+// An error is returned if the bot is kicked or if the connection is lost.
+func (b *bot) run() error {
+	err := b.client.SendLogin(b.role, b.nickname, protocol.Version)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.client.ReadLoginAck()
+	if err != nil {
+		return err
+	}
+	log.Info("Logged in")
+
+	for {
+		msg, err := b.client.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		messageType, err := protocol.ReadString(msg, "message_type")
+		if err != nil {
+			return err
+		}
+
+		switch messageType {
+		case "GAME_STARTS":
+			gameStarts, err := protocol.ReadGameStartsMessage(msg)
+			if err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"episode":      gameStarts.Episode,
+				"game":         gameStarts.Game,
+				"player ID":    gameStarts.PlayerID,
+				"nb players":   gameStarts.NbPlayers,
+				"nb turns max": gameStarts.NbTurnsMax,
+			}).Info("Game starts")
+		case "TURN":
+			turn, err := protocol.ReadTurnMessage(msg)
+			if err != nil {
+				return err
+			}
+			err = b.onTurn(turn)
+			if err != nil {
+				return err
+			}
+		case "GAME_ENDS":
+			gameEnds, err := protocol.ReadGameEndsMessage(msg)
+			if err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"episode":          gameEnds.Episode,
+				"game":             gameEnds.Game,
+				"status":           gameEnds.Status,
+				"winner player ID": gameEnds.WinnerPlayerID,
+			}).Info("Game ends")
+
+			// Other episodes (or games) follow a finished episode.
+			if gameEnds.Status != "finished" ||
+				(gameEnds.Episode+1 >= gameEnds.NbEpisodes &&
+					!gameEnds.NextGame) {
+				return nil
+			}
+		case "KICK":
+			kick, err := protocol.ReadKickMessage(msg)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("Kicked from netorcai. Reason: %v",
+				kick.KickReason)
+		default:
+			return fmt.Errorf("Unexpected message received: '%v'",
+				messageType)
+		}
+	}
+}
+
+func (b *bot) onTurn(turn protocol.MessageTurn) error {
+	log.WithFields(log.Fields{
+		"turn number":   turn.TurnNumber,
+		"actionable":    turn.Actionable,
+		"skipped turns": turn.SkippedTurns,
+	}).Debug("TURN received")
+
+	if b.crashAtTurn >= 0 && turn.TurnNumber >= b.crashAtTurn {
+		log.WithFields(log.Fields{
+			"turn number": turn.TurnNumber,
+		}).Warn("Crashing on purpose")
+		b.client.Disconnect()
+		return errCrashed
+	}
+
+	time.Sleep(b.latency)
+
+	if b.malformedTurnAckAt >= 0 && turn.TurnNumber >= b.malformedTurnAckAt {
+		log.WithFields(log.Fields{
+			"turn number": turn.TurnNumber,
+		}).Warn("Sending a malformed TURN_ACK on purpose")
+		b.malformedTurnAckAt = -1
+		// turn_number is missing.
+		return b.client.SendString(`{"message_type": "TURN_ACK", "actions": []}`)
+	}
+
+	actions := []interface{}{}
+	if b.role != "visualization" && turn.Actionable {
+		actions = b.actions.actions(turn.TurnNumber)
+	}
+	return b.client.SendTurnAck(turn.TurnNumber, actions)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	docopt "github.com/docopt/docopt-go"
+	"github.com/netorcai/netorcai"
+	"github.com/netorcai/netorcai/client/go"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+var (
+	version string
+)
+
+// Exit codes of netorcai-bot.
+const (
+	EXIT_SUCCESS       = 0
+	EXIT_BAD_ARGUMENTS = 1
+	EXIT_FAILURE       = 2
+	EXIT_CRASHED       = 3
+)
+
+func setupLogging(arguments map[string]interface{}) {
+	log.SetOutput(os.Stdout)
+
+	if arguments["--json-logs"] == true {
+		log.SetFormatter(&log.JSONFormatter{})
+	} else {
+		customFormatter := new(log.TextFormatter)
+		customFormatter.TimestampFormat = "2006-01-02 15:04:05.000"
+		customFormatter.FullTimestamp = true
+		customFormatter.QuoteEmptyFields = true
+		log.SetFormatter(customFormatter)
+	}
+
+	if arguments["--debug"] == true {
+		log.SetLevel(log.DebugLevel)
+	} else if arguments["--quiet"] == true {
+		log.SetLevel(log.WarnLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+}
+
+func readBot(arguments map[string]interface{}) (*bot, string, string, error) {
+	network, address, err := netorcai.ParseListenAddress(
+		arguments["--address"].(string))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("Field '--address' is invalid: %v",
+			err.Error())
+	}
+
+	b := &bot{
+		nickname: arguments["--nickname"].(string),
+		actions:  emptyActions{},
+	}
+
+	switch arguments["--role"] {
+	case "player", "visualization":
+		b.role = arguments["--role"].(string)
+	case "special-player":
+		b.role = "special player"
+	default:
+		return nil, "", "", fmt.Errorf("Field '--role' is invalid: %v is "+
+			"not in {player, special-player, visualization}",
+			arguments["--role"])
+	}
+
+	msLatency, err := netorcai.ReadFloatInString(arguments, "--latency", 64,
+		0, 3600000)
+	if err != nil {
+		return nil, "", "", err
+	}
+	b.latency = time.Duration(msLatency * float64(time.Millisecond))
+
+	b.crashAtTurn, err = netorcai.ReadIntInString(arguments,
+		"--crash-at-turn", 64, -1, 65535)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	b.malformedTurnAckAt, err = netorcai.ReadIntInString(arguments,
+		"--malformed-turn-ack-at", 64, -1, 65535)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	seed := time.Now().UnixNano()
+	if arguments["--seed"] != nil {
+		intSeed, err := netorcai.ReadIntInString(arguments, "--seed", 64,
+			0, 2147483647)
+		if err != nil {
+			return nil, "", "", err
+		}
+		seed = int64(intSeed)
+	}
+
+	if arguments["--replay"] != nil {
+		b.actions, err = readReplayActions(arguments["--replay"].(string))
+	} else if arguments["--random"] != nil {
+		b.actions, err = readRandomActions(arguments["--random"].(string),
+			seed)
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return b, network, address, nil
+}
+
+func main() {
+	os.Exit(mainReturnWithCode())
+}
+
+func mainReturnWithCode() int {
+	usage := `Reference bot for netorcai games.
+
+The bot logs in to netorcai then replies to every TURN with a TURN_ACK.
+Its actions are empty by default.
+
+Usage:
+  netorcai-bot [--address=<address>] [--role=<role>] [--nickname=<nick>]
+               [--replay=<file> | --random=<file>] [--seed=<seed>]
+               [--latency=<ms>]
+               [--crash-at-turn=<turn>]
+               [--malformed-turn-ack-at=<turn>]
+               [(--verbose | --quiet | --debug)] [--json-logs]
+  netorcai-bot -h | --help
+  netorcai-bot --version
+
+Options:
+  --address=<address>       netorcai's address, such as tcp://localhost:4242
+                            or unix:///tmp/netorcai.sock.
+                            [default: tcp://localhost:4242]
+  --role=<role>             The bot role: player, special-player or
+                            visualization. [default: player]
+  --nickname=<nick>         The bot nickname. [default: bot]
+  --replay=<file>           Replay the actions of a JSON file, which contains
+                            one array of actions per turn_number,
+                            e.g. [[{"move": "up"}], [], [{"move": "left"}]].
+  --random=<file>           Send one random action per turn, built from the
+                            JSON template in file. The template associates
+                            each key with its candidate values,
+                            e.g. {"move": ["up", "down"], "speed": [1, 2]}.
+  --seed=<seed>             The random seed of --random. Time-based if unset.
+  --latency=<ms>            The amount of time (in milliseconds) to wait
+                            before sending each TURN_ACK. [default: 0]
+  --crash-at-turn=<turn>    Close the socket on the first TURN whose
+                            turn_number is at least <turn>. -1 means never.
+                            [default: -1]
+  --malformed-turn-ack-at=<turn>  Send an invalid TURN_ACK in reply to the
+                            first TURN whose turn_number is at least <turn>.
+                            Never if -1. [default: -1]
+  --quiet                   Only print critical information.
+  --verbose                 Print information. Default verbosity mode.
+  --debug                   Print debug information.
+  --json-logs               Print log information in JSON.
+
+Exit codes:
+  0  The last GAME_ENDS of the session received.
+  1  Invalid arguments.
+  2  Connection failure, metaprotocol error or KICK received.
+  3  Crashed on purpose (--crash-at-turn).`
+
+	botVersion := version
+	if botVersion == "" {
+		botVersion = "v" + netorcai.Version
+	}
+
+	ret := -1
+
+	parser := &docopt.Parser{
+		HelpHandler: func(err error, usage string) {
+			fmt.Println(usage)
+			if err != nil {
+				ret = EXIT_BAD_ARGUMENTS
+			} else {
+				ret = EXIT_SUCCESS
+			}
+		},
+		OptionsFirst: false,
+	}
+
+	arguments, _ := parser.ParseArgs(usage, os.Args[1:], botVersion)
+	if ret != -1 {
+		return ret
+	}
+
+	setupLogging(arguments)
+
+	b, network, address, err := readBot(arguments)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Invalid argument")
+		return EXIT_BAD_ARGUMENTS
+	}
+
+	b.client = &client.Client{}
+	err = b.client.ConnectAddress(network, address)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":     err,
+			"network": network,
+			"address": address,
+		}).Error("Cannot connect to netorcai")
+		return EXIT_FAILURE
+	}
+
+	err = b.run()
+	if err == errCrashed {
+		return EXIT_CRASHED
+	} else if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Bot failed")
+		return EXIT_FAILURE
+	}
+	return EXIT_SUCCESS
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	docopt "github.com/docopt/docopt-go"
 	"github.com/netorcai/netorcai"
@@ -8,6 +10,7 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 )
 
@@ -37,69 +40,201 @@ func setupLogging(arguments map[string]interface{}) {
 	}
 }
 
-func initializeGlobalState(arguments map[string]interface{}) (
-	*netorcai.GlobalState, error) {
+func readConfig(arguments map[string]interface{}) (netorcai.Config, error) {
+	config := netorcai.DefaultConfig()
+
 	nbPlayersMax, err := netorcai.ReadIntInString(arguments,
 		"--nb-players-max", 64, 0, 1024)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid arguments: %v", err.Error())
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
 	}
 
 	nbSpecialPlayersMax, err := netorcai.ReadIntInString(arguments,
 		"--nb-splayers-max", 64, 0, 1024)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid arguments: %v", err.Error())
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
 	}
 
 	nbVisusMax, err := netorcai.ReadIntInString(arguments,
 		"--nb-visus-max", 64, 0, 1024)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid arguments: %v", err.Error())
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
 	}
 
 	nbTurnsMax, err := netorcai.ReadIntInString(arguments,
 		"--nb-turns-max", 64, 1, 65535)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid arguments: %v", err.Error())
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
+	}
+
+	nbEpisodes, err := netorcai.ReadIntInString(arguments,
+		"--episodes", 64, 1, 1000000)
+	if err != nil {
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
+	}
+
+	nbGames, err := netorcai.ReadIntInString(arguments,
+		"--games", 64, 1, 1000000)
+	if err != nil {
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
 	}
 
 	msBeforeFirstTurn, err := netorcai.ReadFloatInString(arguments, "--delay-first-turn", 64, 50, 10000)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid arguments: %v", err.Error())
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
 	}
 
 	msBetweenTurns, err := netorcai.ReadFloatInString(arguments,
 		"--delay-turns", 64, 50, 10000)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid arguments: %v", err.Error())
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
 	}
 
-	autostart := arguments["--autostart"].(bool)
-	fast := arguments["--fast"].(bool)
+	msInitTimeout, err := netorcai.ReadFloatInString(arguments,
+		"--gl-init-timeout", 64, 0, 3600000)
+	if err != nil {
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
+	}
+
+	msTurnTimeout, err := netorcai.ReadFloatInString(arguments,
+		"--gl-turn-timeout", 64, 0, 3600000)
+	if err != nil {
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
+	}
+
+	msDrainTimeout, err := netorcai.ReadFloatInString(arguments,
+		"--drain-timeout", 64, 0, 3600000)
+	if err != nil {
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
+	}
+
+	visuMaxFrameRate, err := netorcai.ReadFloatInString(arguments,
+		"--visu-max-fps", 64, 0, 1000)
+	if err != nil {
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
+	}
+
+	keyframeInterval, err := netorcai.ReadIntInString(arguments,
+		"--keyframe-interval", 64, 0, 65535)
+	if err != nil {
+		return config, fmt.Errorf("Invalid arguments: %v", err.Error())
+	}
 
-	gs := &netorcai.GlobalState{
-		GameState:                   netorcai.GAME_NOT_RUNNING,
-		NbPlayersMax:                nbPlayersMax,
-		NbSpecialPlayersMax:         nbSpecialPlayersMax,
-		NbVisusMax:                  nbVisusMax,
-		NbTurnsMax:                  nbTurnsMax,
-		Autostart:                   autostart,
-		Fast:                        fast,
-		MillisecondsBeforeFirstTurn: msBeforeFirstTurn,
-		MillisecondsBetweenTurns:    msBetweenTurns,
+	switch arguments["--turn-order"] {
+	case "simultaneous":
+		config.TurnOrder = netorcai.TURN_ORDER_SIMULTANEOUS
+	case "round-robin":
+		config.TurnOrder = netorcai.TURN_ORDER_ROUND_ROBIN
+	default:
+		return config, fmt.Errorf("Invalid arguments: "+
+			"Field '--turn-order' is invalid: %v is not in "+
+			"{simultaneous, round-robin}", arguments["--turn-order"])
 	}
 
-	return gs, nil
+	policyOptions := map[string]*netorcai.SkipPolicy{
+		"--player-skip-policy":  &config.PlayerSkipPolicy,
+		"--splayer-skip-policy": &config.SpecialPlayerSkipPolicy,
+		"--visu-skip-policy":    &config.VisuSkipPolicy,
+	}
+	for option, policy := range policyOptions {
+		*policy, err = netorcai.ParseSkipPolicy(arguments[option].(string))
+		if err != nil {
+			return config, fmt.Errorf("Invalid arguments: "+
+				"Field '%v' is invalid: %v", option, err.Error())
+		}
+	}
+
+	config.NbPlayersMax = nbPlayersMax
+	config.NbSpecialPlayersMax = nbSpecialPlayersMax
+	config.NbVisusMax = nbVisusMax
+	config.NbTurnsMax = nbTurnsMax
+	config.NbEpisodes = nbEpisodes
+	config.NbGames = nbGames
+	if arguments["--loop"].(bool) {
+		config.NbGames = 0
+	}
+	config.Autostart = arguments["--autostart"].(bool)
+	config.Fast = arguments["--fast"].(bool)
+	config.VisuFeedback = arguments["--visu-feedback"].(bool)
+	config.VisuMaxFrameRate = visuMaxFrameRate
+	config.VisuDeltas = arguments["--visu-deltas"].(bool)
+	config.KeyframeInterval = keyframeInterval
+	config.DisableCompression = arguments["--no-compression"].(bool)
+	config.MillisecondsBeforeFirstTurn = msBeforeFirstTurn
+	config.MillisecondsBetweenTurns = msBetweenTurns
+	config.MillisecondsInitTimeout = msInitTimeout
+	config.MillisecondsTurnTimeout = msTurnTimeout
+	config.MillisecondsDrainTimeout = msDrainTimeout
+	config.MockGameLogic = arguments["--mock-game-logic"].(bool)
+	err = json.Unmarshal([]byte(arguments["--mock-game-state"].(string)),
+		&config.MockGameState)
+	if err != nil || config.MockGameState == nil {
+		return config, fmt.Errorf("Invalid arguments: " +
+			"Field '--mock-game-state' is invalid: not a JSON object")
+	}
+
+	return config, nil
 }
 
-func setupGuards(gs *netorcai.GlobalState, onAbort chan int) {
-	// Guard against SIGINT (ctrl+C) and SIGTERM (kill)
-	sigterm := make(chan os.Signal, 2)
-	signal.Notify(sigterm, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-sigterm
-		onAbort <- 1
-	}()
+func runConformance(arguments map[string]interface{},
+	listenAddress string) int {
+	msTimeout, err := netorcai.ReadFloatInString(arguments, "--timeout", 64,
+		1, 3600000)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Invalid argument")
+		return logExitReason(netorcai.EXIT_BAD_ARGUMENTS)
+	}
+
+	clientCommand := ""
+	if arguments["--client"] != nil {
+		clientCommand = arguments["--client"].(string)
+	}
+
+	results, err := netorcai.RunConformance(netorcai.ConformanceConfig{
+		ListenAddress:       listenAddress,
+		Role:                arguments["--role"].(string),
+		ClientCommand:       clientCommand,
+		Scenarios:           arguments["--scenario"].([]string),
+		MillisecondsTimeout: msTimeout,
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Cannot run conformance tests")
+		return logExitReason(netorcai.EXIT_BAD_ARGUMENTS)
+	}
+
+	nbFailed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			nbFailed++
+		}
+	}
+	log.WithFields(log.Fields{
+		"passed": len(results) - nbFailed,
+		"failed": nbFailed,
+	}).Info("Conformance tests done")
+
+	if nbFailed > 0 {
+		return logExitReason(netorcai.EXIT_CONFORMANCE_FAILED)
+	}
+	return logExitReason(netorcai.EXIT_SUCCESS)
+}
+
+func logExitReason(exitCode int) int {
+	entry := log.WithFields(log.Fields{
+		"exit code":   exitCode,
+		"exit reason": netorcai.ExitReason(exitCode),
+	})
+
+	if exitCode == netorcai.EXIT_SUCCESS {
+		entry.Info("Exiting")
+	} else {
+		entry.Warn("Exiting")
+	}
+	return exitCode
 }
 
 func main() {
@@ -110,42 +245,144 @@ func mainReturnWithCode() int {
 	usage := `NETwork ORChestrator for Artificial Intelligence games.
 
 Usage:
-  netorcai [--port=<port-number>]
-           [--nb-turns-max=<nbt>]
+  netorcai [--port=<port-number>] [--listen=<address>...]
+           [--unix-socket-mode=<mode>]
+           [--nb-turns-max=<nbt>] [--episodes=<n>]
+           [--games=<n> | --loop]
            [--nb-players-max=<nbp>]
            [--nb-splayers-max=<nbsp>]
            [--nb-visus-max=<nbv>]
            [--delay-first-turn=<ms>]
            [--delay-turns=<ms>]
+           [--gl-init-timeout=<ms>]
+           [--gl-turn-timeout=<ms>]
+           [--drain-timeout=<ms>]
            [--autostart]
            [--fast]
+           [--turn-order=<order>]
+           [--visu-feedback]
+           [--player-skip-policy=<policy>]
+           [--splayer-skip-policy=<policy>]
+           [--visu-skip-policy=<policy>]
+           [--visu-max-fps=<fps>] [--visu-deltas]
+           [--keyframe-interval=<n>] [--no-compression]
+           [--mock-game-logic] [--mock-game-state=<json>]
            [--simple-prompt]
            [(--verbose | --quiet | --debug)] [--json-logs]
+  netorcai conformance --role=<role>
+           [--port=<port-number>] [--listen=<address>...]
+           [--client=<command>] [--scenario=<name>...] [--timeout=<ms>]
+           [(--verbose | --quiet | --debug)] [--json-logs]
   netorcai -h | --help
   netorcai --version
 
 Options:
   --port=<port-number>      The TCP port to listen incoming connections.
                             [default: 4242]
+  --listen=<address>        An address to listen incoming connections on,
+                            such as tcp://127.0.0.1:4242, tcp6://[::1]:4242
+                            or unix:///tmp/netorcai.sock. Can be repeated.
+                            Overrides --port if set.
+  --unix-socket-mode=<mode>
+                            The permissions of the unix socket files, in
+                            octal (e.g. 0660). By default, they depend on
+                            the umask of netorcai.
   --nb-turns-max=<nbt>      The maximum number of turns. [default: 100]
+  --episodes=<n>            The number of episodes the game logic plays in a
+                            row with the same clients, without new LOGIN.
+                            [default: 1]
+  --games=<n>               The number of games played in a row. Clients
+                            stay logged in between games, and each game
+                            starts as the first one (--autostart or prompt).
+                            [default: 1]
+  --loop                    Play games until netorcai is stopped.
   --nb-players-max=<nbp>    The maximum number of players. [default: 4]
   --nb-splayers-max=<nbsp>  The maximum number of special players. [default: 0]
   --nb-visus-max=<nbv>      The maximum number of visualizations. [default: 1]
   --delay-first-turn=<ms>   The amount of time (in milliseconds) between the
-                            GAME_STARTS message and the first TURN message.
+                            GAME_STARTS message and the first TURN message
+                            of the first episode of each game.
                             [default: 1000]
   --delay-turns=<ms>        The amount of time (in milliseconds) between two
                             consecutive TURNs. [default: 1000]
+  --gl-init-timeout=<ms>    The maximum amount of time (in milliseconds) the
+                            game logic can take to answer DO_INIT.
+                            0 means no timeout. [default: 3000]
+  --gl-turn-timeout=<ms>    The maximum amount of time (in milliseconds) the
+                            game logic can take to answer DO_TURN.
+                            0 means no timeout. [default: 0]
+  --drain-timeout=<ms>      On SIGINT or SIGTERM during a game, the maximum
+                            amount of time (in milliseconds) to wait for the
+                            current turn to finish before aborting the game.
+                            0 means that clients are kicked right away.
+                            [default: 0]
   --autostart               Start game when all clients are connnected.
                             Set --nb-{players,splayers,visus}-max accordingly.
   --fast                    Do not rely on timers to manage turns.
                             Send DO_TURN as soon as all players have played.
                             This assumes players play/crash in finite time.
+  --turn-order=<order>      How players take turns. simultaneous: all players
+                            act on every TURN. round-robin: one player acts
+                            per TURN, in player_id order.
+                            [default: simultaneous]
+  --visu-feedback           Forward the feedback that the game logic sends
+                            to each player to the visualizations too.
+  --player-skip-policy=<policy>
+                            What happens to the TURNs that a player
+                            receives while it has not answered the previous
+                            one. drop-old: only the latest TURN is kept.
+                            queue:<N>: up to N TURNs are kept.
+                            block: the game waits for the player.
+                            [default: drop-old]
+  --splayer-skip-policy=<policy>
+                            The same for special players.
+                            [default: drop-old]
+  --visu-skip-policy=<policy>
+                            The same for visualizations.
+                            [default: drop-old]
+  --visu-max-fps=<fps>      The maximum number of TURNs sent to each
+                            visualization per second. Extra TURNs are
+                            handled by --visu-skip-policy.
+                            0 means unlimited. [default: 0]
+  --visu-deltas             Only send the changes of the game state to
+                            visualizations, as JSON merge patches.
+                            Visualizations can also choose at LOGIN.
+  --keyframe-interval=<n>   Clients that receive the changes of the game
+                            state receive the whole game state every <n>
+                            TURNs. 0 means never. [default: 10]
+  --no-compression          Never compress messages, even for clients that
+                            support it.
+  --mock-game-logic         Run a built-in game logic without game rules,
+                            to test players against a lone netorcai.
+                            Its game state contains the turn_number and the
+                            player_actions received in the last DO_TURN.
+  --mock-game-state=<json>  A JSON object whose fields are added to the game
+                            states of --mock-game-logic. [default: {}]
   --simple-prompt           Always use a simple prompt.
+  --role=<role>             conformance: The role of the client under test,
+                            player, visualization or game-logic.
+  --client=<command>        conformance: The command that runs the client
+                            under test, once per scenario. Without it,
+                            the client must be run by hand for each scenario.
+  --scenario=<name>         conformance: Only run this scenario.
+                            Can be repeated. All scenarios are run by default.
+  --timeout=<ms>            conformance: The maximum amount of time (in
+                            milliseconds) to wait for each client action.
+                            [default: 3000]
   --quiet                   Only print critical information.
   --verbose                 Print information. Default verbosity mode.
   --debug                   Print debug information.
-  --json-logs               Print log information in JSON.`
+  --json-logs               Print log information in JSON.
+
+Exit codes:
+  0  Game finished, or quit from the prompt.
+  1  Invalid arguments.
+  2  The game logic did not answer in time.
+  3  The game logic failed or was kicked.
+  4  Cannot listen (or accept) incoming connections.
+  5  SIGINT or SIGTERM received.
+  6  The interactive prompt has been closed.
+  7  A conformance scenario failed.`
 
 	netorcaiVersion := version
 	if netorcaiVersion == "" {
@@ -158,9 +395,9 @@ Options:
 		HelpHandler: func(err error, usage string) {
 			fmt.Println(usage)
 			if err != nil {
-				ret = 1
+				ret = netorcai.EXIT_BAD_ARGUMENTS
 			} else {
-				ret = 0
+				ret = netorcai.EXIT_SUCCESS
 			}
 		},
 		OptionsFirst: false,
@@ -178,26 +415,63 @@ Options:
 		log.WithFields(log.Fields{
 			"err": err,
 		}).Error("Invalid argument")
-		return 1
+		return logExitReason(netorcai.EXIT_BAD_ARGUMENTS)
 	}
 
-	globalState, err := initializeGlobalState(arguments)
+	listenAddresses := arguments["--listen"].([]string)
+	if len(listenAddresses) == 0 {
+		listenAddresses = []string{fmt.Sprintf("tcp://:%v", port)}
+	}
+	for _, listenAddress := range listenAddresses {
+		if _, _, err := netorcai.ParseListenAddress(listenAddress); err != nil {
+			log.WithFields(log.Fields{
+				"err":            err,
+				"listen address": listenAddress,
+			}).Error("Invalid argument: Field '--listen' is invalid")
+			return logExitReason(netorcai.EXIT_BAD_ARGUMENTS)
+		}
+	}
+
+	if arguments["conformance"] == true {
+		return runConformance(arguments, listenAddresses[0])
+	}
+
+	config, err := readConfig(arguments)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
 		}).Error("Invalid argument")
-		return 1
+		return logExitReason(netorcai.EXIT_BAD_ARGUMENTS)
+	}
+	config.ListenAddresses = listenAddresses
+	if mode, isSet := arguments["--unix-socket-mode"].(string); isSet {
+		value, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || value > 0777 {
+			log.WithFields(log.Fields{
+				"unix socket mode": mode,
+			}).Error("Invalid argument: Field '--unix-socket-mode' " +
+				"is not an octal permission mode")
+			return logExitReason(netorcai.EXIT_BAD_ARGUMENTS)
+		}
+		config.UnixSocketMode = os.FileMode(value)
 	}
-	defer globalState.WaitGroup.Wait()
 
-	guardExit := make(chan int, 1)
-	serverExit := make(chan int, 1)
-	gameLogicExit := make(chan int, 1)
-	shellExit := make(chan int, 1)
+	server, err := netorcai.NewServer(config)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Invalid argument")
+		return logExitReason(netorcai.EXIT_BAD_ARGUMENTS)
+	}
+
+	err = server.Start()
+	if err != nil {
+		return logExitReason(netorcai.EXIT_LISTEN_FAILURE)
+	}
 
-	setupGuards(globalState, guardExit)
-	globalState.WaitGroup.Add(1)
-	go netorcai.RunServer(int(port), globalState, serverExit, gameLogicExit)
+	// Guard against SIGINT (ctrl+C) and SIGTERM (kill)
+	sigterm := make(chan os.Signal, 2)
+	signal.Notify(sigterm, os.Interrupt, syscall.SIGTERM)
 
 	interactivePrompt := true
 	if arguments["--simple-prompt"] == true {
@@ -206,24 +480,20 @@ Options:
 		interactivePrompt = terminal.IsTerminal(int(os.Stdout.Fd()))
 	}
 
-	go netorcai.RunPrompt(globalState, shellExit, interactivePrompt)
+	go server.RunPrompt(interactivePrompt)
+
+	serverExit := make(chan int, 1)
+	go func() {
+		exitCode, _ := server.Wait(context.Background())
+		serverExit <- exitCode
+	}()
 
 	select {
 	case serverExitCode := <-serverExit:
-		return serverExitCode
-	case guardExitCode := <-guardExit:
+		return logExitReason(serverExitCode)
+	case <-sigterm:
 		log.Warn("SIGTERM received. Aborting.")
-		netorcai.Cleanup()
-		return guardExitCode
-	case gameLogicExitCode := <-gameLogicExit:
-		if gameLogicExitCode != 0 {
-			log.Warn("Game logic failed. Aborting.")
-		}
-		netorcai.Cleanup()
-		return gameLogicExitCode
-	case shellExitCode := <-shellExit:
-		log.Warn("Shell exited. Aborting.")
-		netorcai.Cleanup()
-		return shellExitCode
+		server.Shutdown()
+		return logExitReason(<-serverExit)
 	}
 }
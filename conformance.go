@@ -0,0 +1,686 @@
+package netorcai
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/netorcai/netorcai/protocol"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ConformanceConfig holds the parameters of a conformance test session,
+// in which netorcai acts as a scripted server to check a client
+// implementation.
+type ConformanceConfig struct {
+	// Address to listen on, such as "tcp://:4242".
+	ListenAddress string
+	// Role of the client under test: "player", "visualization" or
+	// "game-logic".
+	Role string
+	// If not empty, the command that runs the client under test.
+	// It is split on whitespace and run once per scenario.
+	// Otherwise, the client must be started by the user for each scenario.
+	ClientCommand string
+	// The scenarios to run. All the scenarios of Role are run if empty.
+	Scenarios []string
+	// Maximum amount of time to wait for each client action
+	// (connection, message or disconnection).
+	MillisecondsTimeout float64
+}
+
+// ConformanceResult is the outcome of a conformance scenario.
+// Err is nil if the client behaved as expected.
+type ConformanceResult struct {
+	Scenario string
+	Err      error
+}
+
+type conformanceScenario struct {
+	name        string
+	description string
+	roles       []string
+	run         func(c *conformanceClient) error
+}
+
+// conformanceClient is the client under test, seen from netorcai.
+type conformanceClient struct {
+	client  *Client
+	role    string
+	timeout time.Duration
+}
+
+var conformanceRoles = []string{"player", "visualization", "game-logic"}
+
+var conformanceScenarios = []conformanceScenario{
+	{"login-denied",
+		"LOGIN is answered by KICK. The client must disconnect.",
+		[]string{"player", "visualization", "game-logic"},
+		func(c *conformanceClient) error {
+			err := c.expectLogin()
+			if err == nil {
+				err = c.sendKick("LOGIN denied: Conformance test")
+			}
+			if err == nil {
+				err = c.expectDisconnection()
+			}
+			return err
+		}},
+	{"kick-during-init",
+		"KICK is sent before the game starts. The client must disconnect.",
+		[]string{"player", "visualization"},
+		func(c *conformanceClient) error {
+			err := c.login()
+			if err == nil {
+				err = c.sendKick("netorcai abort")
+			}
+			if err == nil {
+				err = c.expectDisconnection()
+			}
+			return err
+		}},
+	{"kick-during-init",
+		"KICK is sent right after DO_INIT. The client must disconnect " +
+			"(a DO_INIT_ACK sent meanwhile is tolerated).",
+		[]string{"game-logic"},
+		func(c *conformanceClient) error {
+			err := c.login()
+			if err == nil {
+				err = c.sendDoInit(2, 0, 3, episodeInfo{nbEpisodes: 1})
+			}
+			if err == nil {
+				err = c.sendKick("netorcai abort")
+			}
+			if err == nil {
+				err = c.expectDisconnectionIgnoring("DO_INIT_ACK")
+			}
+			return err
+		}},
+	{"game-ends-before-start",
+		"GAME_ENDS is sent without GAME_STARTS (e.g., game logic timeout). " +
+			"The client must disconnect.",
+		[]string{"player", "visualization"},
+		func(c *conformanceClient) error {
+			err := c.login()
+			if err == nil {
+				err = c.sendGameEnds(GAME_ENDS_GAME_LOGIC_TIMEOUT)
+			}
+			if err == nil {
+				err = c.expectDisconnection()
+			}
+			return err
+		}},
+	{"normal-game",
+		"A 4-turn game. Each TURN must be acknowledged, " +
+			"then the client must disconnect after GAME_ENDS.",
+		[]string{"player", "visualization"},
+		func(c *conformanceClient) error {
+			return c.playSession(1, 1, []int{0, 1, 2},
+				map[string]interface{}{})
+		}},
+	{"turns-skipped",
+		"Some TURNs are skipped, as netorcai does for late clients. " +
+			"The client must acknowledge the TURNs it receives.",
+		[]string{"player", "visualization"},
+		func(c *conformanceClient) error {
+			return c.playSession(1, 1, []int{0, 3, 7},
+				map[string]interface{}{})
+		}},
+	{"large-messages",
+		"GAME_STARTS and TURN contain a game state of several megabytes.",
+		[]string{"player", "visualization"},
+		func(c *conformanceClient) error {
+			return c.playSession(1, 1, []int{0, 1},
+				largeConformanceObject())
+		}},
+	{"episodes",
+		"A game of 2 episodes. The client must stay logged in after the " +
+			"first GAME_ENDS and acknowledge the TURNs of the second " +
+			"episode, then disconnect after the last GAME_ENDS.",
+		[]string{"player", "visualization"},
+		func(c *conformanceClient) error {
+			return c.playSession(1, 2, []int{0, 1, 2},
+				map[string]interface{}{})
+		}},
+	{"games",
+		"A session of 2 games. The first GAME_ENDS has next_game set: the " +
+			"client must stay logged in and play the next game, then " +
+			"disconnect after its GAME_ENDS.",
+		[]string{"player", "visualization"},
+		func(c *conformanceClient) error {
+			return c.playSession(2, 1, []int{0, 1, 2},
+				map[string]interface{}{})
+		}},
+	{"normal-game",
+		"A 4-turn game with 2 players. DO_INIT and each DO_TURN must be " +
+			"acknowledged, then the client must disconnect after KICK.",
+		[]string{"game-logic"},
+		func(c *conformanceClient) error {
+			return c.runSession(1, 1, 4, []interface{}{
+				map[string]interface{}{"move": "up"}})
+		}},
+	{"large-messages",
+		"DO_TURN contains player actions of several megabytes.",
+		[]string{"game-logic"},
+		func(c *conformanceClient) error {
+			return c.runSession(1, 1, 2,
+				[]interface{}{largeConformanceObject()})
+		}},
+	{"episodes",
+		"A game of 2 episodes. The second DO_INIT and its DO_TURNs must " +
+			"be acknowledged without logging in again, then the client " +
+			"must disconnect after KICK.",
+		[]string{"game-logic"},
+		func(c *conformanceClient) error {
+			return c.runSession(1, 2, 3, []interface{}{
+				map[string]interface{}{"move": "up"}})
+		}},
+	{"games",
+		"A session of 2 games. The DO_INIT of the second game must be " +
+			"acknowledged without logging in again, then the client must " +
+			"disconnect after KICK.",
+		[]string{"game-logic"},
+		func(c *conformanceClient) error {
+			return c.runSession(2, 1, 3, []interface{}{
+				map[string]interface{}{"move": "up"}})
+		}},
+}
+
+// ConformanceScenarios returns the names of the scenarios of a role.
+func ConformanceScenarios(role string) []string {
+	names := []string{}
+	for _, scenario := range conformanceScenarios {
+		if scenario.hasRole(role) {
+			names = append(names, scenario.name)
+		}
+	}
+	return names
+}
+
+func (scenario conformanceScenario) hasRole(role string) bool {
+	for _, r := range scenario.roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RunConformance runs conformance scenarios against a client implementation.
+// An error is returned if the session cannot be run. The failures of the
+// client under test are reported in the results.
+func RunConformance(config ConformanceConfig) ([]ConformanceResult, error) {
+	scenarios, err := selectConformanceScenarios(config)
+	if err != nil {
+		return nil, err
+	}
+
+	network, address, err := ParseListenAddress(config.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	log.WithFields(log.Fields{
+		"network": network,
+		"address": listener.Addr(),
+	}).Info("Listening incoming connections")
+
+	timeout := time.Duration(config.MillisecondsTimeout *
+		float64(time.Millisecond))
+	results := []ConformanceResult{}
+	for _, scenario := range scenarios {
+		log.WithFields(log.Fields{
+			"scenario":    scenario.name,
+			"description": scenario.description,
+		}).Info("Waiting for the client under test")
+
+		err = runConformanceScenario(scenario, listener, config.Role,
+			config.ClientCommand, timeout)
+		if err == nil {
+			log.WithFields(log.Fields{
+				"scenario": scenario.name,
+			}).Info("Scenario passed")
+		} else {
+			log.WithFields(log.Fields{
+				"scenario": scenario.name,
+				"err":      err,
+			}).Warn("Scenario failed")
+		}
+		results = append(results, ConformanceResult{scenario.name, err})
+	}
+	return results, nil
+}
+
+func selectConformanceScenarios(config ConformanceConfig) (
+	[]conformanceScenario, error) {
+	validRole := false
+	for _, role := range conformanceRoles {
+		validRole = validRole || role == config.Role
+	}
+	if !validRole {
+		return nil, fmt.Errorf("Invalid role '%v': not in %v", config.Role,
+			conformanceRoles)
+	}
+
+	scenarios := []conformanceScenario{}
+	for _, scenario := range conformanceScenarios {
+		if !scenario.hasRole(config.Role) {
+			continue
+		}
+		selected := len(config.Scenarios) == 0
+		for _, name := range config.Scenarios {
+			selected = selected || name == scenario.name
+		}
+		if selected {
+			scenarios = append(scenarios, scenario)
+		}
+	}
+
+	for _, name := range config.Scenarios {
+		found := false
+		for _, scenario := range scenarios {
+			found = found || name == scenario.name
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown scenario '%v' for role %v. "+
+				"Available scenarios: %v", name, config.Role,
+				ConformanceScenarios(config.Role))
+		}
+	}
+	return scenarios, nil
+}
+
+func runConformanceScenario(scenario conformanceScenario,
+	listener net.Listener, role, clientCommand string,
+	timeout time.Duration) error {
+	if clientCommand != "" {
+		args := strings.Fields(clientCommand)
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		err := cmd.Start()
+		if err != nil {
+			return fmt.Errorf("Cannot run the client command: %v", err)
+		}
+		defer stopConformanceClient(cmd, timeout)
+	}
+
+	// Users who start their client by hand may take their time.
+	acceptDuration := time.Duration(0)
+	if clientCommand != "" {
+		acceptDuration = timeout
+	}
+	conn, err := acceptTimeout(listener, acceptDuration)
+	if err != nil {
+		return fmt.Errorf("No connection from the client: %v", err)
+	}
+
+	client := &Client{
+		Conn:             conn,
+		reader:           bufio.NewReader(conn),
+		writer:           bufio.NewWriter(conn),
+		state:            CLIENT_UNLOGGED,
+		incomingMessages: make(chan ClientMessage),
+		done:             make(chan struct{}),
+	}
+	defer conn.Close()
+	defer close(client.done)
+	go readClientMessages(client)
+
+	return scenario.run(&conformanceClient{client, role, timeout})
+}
+
+// stopConformanceClient gives the client process some time to exit,
+// then kills it.
+func stopConformanceClient(cmd *exec.Cmd, timeout time.Duration) {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case <-exited:
+	case <-time.After(timeout):
+		log.Warn("The client process did not exit. Killing it")
+		cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// acceptTimeout accepts a connection. 0 means no timeout.
+func acceptTimeout(listener net.Listener, timeout time.Duration) (
+	net.Conn, error) {
+	if l, ok := listener.(interface{ SetDeadline(time.Time) error }); ok {
+		deadline := time.Time{}
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		l.SetDeadline(deadline)
+	}
+	return listener.Accept()
+}
+
+// largeConformanceObject returns an object whose JSON encoding takes
+// around 4 MiB.
+func largeConformanceObject() map[string]interface{} {
+	return map[string]interface{}{
+		"payload": strings.Repeat("netorcai", 512*1024),
+	}
+}
+
+func (c *conformanceClient) read() (map[string]interface{}, error) {
+	select {
+	case msg := <-c.client.incomingMessages:
+		return msg.content, msg.err
+	case <-time.After(c.timeout):
+		return nil, fmt.Errorf("No message received after %v", c.timeout)
+	}
+}
+
+func (c *conformanceClient) send(msg interface{}) error {
+	content, err := json.Marshal(msg)
+	if err == nil {
+		err = sendMessage(c.client, content)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot send message: %v", err)
+	}
+	return nil
+}
+
+func (c *conformanceClient) expectLogin() error {
+	msg, err := c.read()
+	if err != nil {
+		return fmt.Errorf("Cannot read LOGIN: %v", err)
+	}
+
+	login, err := protocol.ReadLoginMessage(msg)
+	if err != nil {
+		return fmt.Errorf("Invalid LOGIN: %v", err)
+	}
+
+	expectedRoles := map[string][]string{
+		"player":        {"player", "special player"},
+		"visualization": {"visualization"},
+		"game-logic":    {"game logic"},
+	}[c.role]
+	for _, role := range expectedRoles {
+		if login.Role == role {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid LOGIN: role is '%v' while %v is tested",
+		login.Role, c.role)
+}
+
+func (c *conformanceClient) login() error {
+	err := c.expectLogin()
+	if err != nil {
+		return err
+	}
+	return c.send(protocol.MessageLoginAck{
+		MessageType:         "LOGIN_ACK",
+		MetaprotocolVersion: Version,
+	})
+}
+
+func (c *conformanceClient) sendKick(reason string) error {
+	return c.send(protocol.MessageKick{
+		MessageType: "KICK",
+		KickReason:  reason,
+	})
+}
+
+func (c *conformanceClient) sendGameEnds(status string) error {
+	return c.send(protocol.MessageGameEnds{
+		MessageType:    "GAME_ENDS",
+		Status:         status,
+		WinnerPlayerID: -1,
+		GameState:      map[string]interface{}{},
+		NbEpisodes:     1,
+	})
+}
+
+func (c *conformanceClient) sendDoInit(nbPlayers, nbSpecialPlayers,
+	nbTurnsMax int, ep episodeInfo) error {
+	return c.send(protocol.MessageDoInit{
+		MessageType:      "DO_INIT",
+		NbPlayers:        nbPlayers,
+		NbSpecialPlayers: nbSpecialPlayers,
+		NbTurnsMax:       nbTurnsMax,
+		Episode:          ep.episode,
+		NbEpisodes:       ep.nbEpisodes,
+		Game:             ep.game,
+	})
+}
+
+func (c *conformanceClient) expectDisconnection() error {
+	return c.expectDisconnectionIgnoring()
+}
+
+// expectDisconnectionIgnoring waits for the client to close its socket.
+// Receiving a message whose type is not in ignoredTypes is an error.
+func (c *conformanceClient) expectDisconnectionIgnoring(
+	ignoredTypes ...string) error {
+	for {
+		msg, err := c.read()
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "Remote endpoint closed?") {
+				return nil
+			}
+			return fmt.Errorf("Client did not disconnect: %v", err)
+		}
+
+		messageType, _ := protocol.ReadString(msg, "message_type")
+		ignored := false
+		for _, ignoredType := range ignoredTypes {
+			ignored = ignored || messageType == ignoredType
+		}
+		if !ignored {
+			return fmt.Errorf("Client did not disconnect: "+
+				"unexpected message '%v' received", messageType)
+		}
+	}
+}
+
+// conformanceEpisodes returns the episodes of a session of nbGames games
+// of nbEpisodes episodes, in the order they are played.
+func conformanceEpisodes(nbGames, nbEpisodes int) []episodeInfo {
+	episodes := []episodeInfo{}
+	for game := 0; game < nbGames; game++ {
+		for episode := 0; episode < nbEpisodes; episode++ {
+			episodes = append(episodes, episodeInfo{
+				id:         len(episodes),
+				episode:    episode,
+				nbEpisodes: nbEpisodes,
+				game:       game,
+				lastGame:   game == nbGames-1,
+			})
+		}
+	}
+	return episodes
+}
+
+// playSession plays nbGames games of nbEpisodes episodes as a player or
+// visualization sees them. The client logs in once and must stay logged in
+// until the last GAME_ENDS.
+// A TURN is sent for each turn number in turnNumbers, in every episode.
+func (c *conformanceClient) playSession(nbGames, nbEpisodes int,
+	turnNumbers []int, gameState map[string]interface{}) error {
+	err := c.login()
+	if err != nil {
+		return err
+	}
+
+	for _, ep := range conformanceEpisodes(nbGames, nbEpisodes) {
+		err = c.playEpisode(turnNumbers, gameState, ep)
+		if err != nil {
+			if ep.id > 0 {
+				return fmt.Errorf("Episode %v of game %v: %v", ep.episode,
+					ep.game, err)
+			}
+			return err
+		}
+	}
+	return c.expectDisconnection()
+}
+
+// playEpisode plays an episode from its GAME_STARTS to its GAME_ENDS.
+func (c *conformanceClient) playEpisode(turnNumbers []int,
+	gameState map[string]interface{}, ep episodeInfo) error {
+	playersInfo := []*protocol.PlayerInformation{}
+	if c.role == "visualization" {
+		playersInfo = append(playersInfo, &protocol.PlayerInformation{
+			PlayerID:      0,
+			Nickname:      "conformance",
+			RemoteAddress: "127.0.0.1:4242",
+			IsConnected:   true,
+		})
+	}
+
+	nbTurnsMax := turnNumbers[len(turnNumbers)-1] + 2
+	err := c.send(protocol.MessageGameStarts{
+		MessageType:      "GAME_STARTS",
+		PlayerID:         0,
+		PlayersInfo:      playersInfo,
+		NbPlayers:        1,
+		NbSpecialPlayers: 0,
+		NbTurnsMax:       nbTurnsMax,
+		DelayFirstTurn:   50,
+		DelayTurns:       50,
+		InitialGameState: gameState,
+		Episode:          ep.episode,
+		NbEpisodes:       ep.nbEpisodes,
+		Game:             ep.game,
+	})
+	if err != nil {
+		return err
+	}
+
+	lastTurnNumber := -1
+	for _, turnNumber := range turnNumbers {
+		err = c.send(protocol.MessageTurn{
+			MessageType:  "TURN",
+			TurnNumber:   turnNumber,
+			Actionable:   c.role == "player",
+			GameState:    gameState,
+			PlayersInfo:  playersInfo,
+			SkippedTurns: turnNumber - lastTurnNumber - 1,
+		})
+		lastTurnNumber = turnNumber
+		if err != nil {
+			return err
+		}
+
+		msg, err := c.read()
+		if err != nil {
+			return fmt.Errorf("Cannot read TURN_ACK: %v", err)
+		}
+		turnAck, err := protocol.ReadTurnAckMessage(msg, turnNumber)
+		if err != nil {
+			return fmt.Errorf("Invalid TURN_ACK: %v", err)
+		}
+		if c.role == "visualization" && len(turnAck.Actions) > 0 {
+			return fmt.Errorf("Invalid TURN_ACK: " +
+				"a visualization must not send actions")
+		}
+	}
+
+	return c.send(protocol.MessageGameEnds{
+		MessageType:    "GAME_ENDS",
+		Status:         GAME_ENDS_FINISHED,
+		WinnerPlayerID: -1,
+		GameState:      gameState,
+		Episode:        ep.episode,
+		NbEpisodes:     ep.nbEpisodes,
+		Game:           ep.game,
+		NextGame:       ep.isLast() && !ep.lastGame,
+	})
+}
+
+// runSession runs nbGames games of nbEpisodes episodes with 2 players, as
+// the game logic sees them. The game logic logs in once and receives a
+// DO_INIT for each episode. Both players send actions on every turn.
+func (c *conformanceClient) runSession(nbGames, nbEpisodes, nbTurnsMax int,
+	actions []interface{}) error {
+	err := c.login()
+	if err != nil {
+		return err
+	}
+
+	for _, ep := range conformanceEpisodes(nbGames, nbEpisodes) {
+		err = c.runEpisode(nbTurnsMax, actions, ep)
+		if err != nil {
+			if ep.id > 0 {
+				return fmt.Errorf("Episode %v of game %v: %v", ep.episode,
+					ep.game, err)
+			}
+			return err
+		}
+	}
+
+	err = c.sendKick(protocol.KickReasonGameFinished)
+	if err != nil {
+		return err
+	}
+	return c.expectDisconnection()
+}
+
+// runEpisode runs an episode from its DO_INIT to its last DO_TURN_ACK.
+func (c *conformanceClient) runEpisode(nbTurnsMax int,
+	actions []interface{}, ep episodeInfo) error {
+	nbPlayers := 2
+	err := c.sendDoInit(nbPlayers, 0, nbTurnsMax, ep)
+	if err != nil {
+		return err
+	}
+
+	msg, err := c.read()
+	if err != nil {
+		return fmt.Errorf("Cannot read DO_INIT_ACK: %v", err)
+	}
+	_, err = protocol.ReadDoInitAckMessage(msg)
+	if err != nil {
+		return fmt.Errorf("Invalid DO_INIT_ACK: %v", err)
+	}
+
+	for turnNumber := 0; turnNumber < nbTurnsMax; turnNumber++ {
+		playerActions := []protocol.MessageDoTurnPlayerAction{}
+		if turnNumber > 0 {
+			for playerID := 0; playerID < nbPlayers; playerID++ {
+				playerActions = append(playerActions,
+					protocol.MessageDoTurnPlayerAction{
+						PlayerID:   playerID,
+						TurnNumber: turnNumber - 1,
+						Actions:    actions,
+					})
+			}
+		}
+
+		err = c.send(protocol.MessageDoTurn{
+			MessageType:   "DO_TURN",
+			PlayerActions: playerActions,
+		})
+		if err != nil {
+			return err
+		}
+
+		msg, err = c.read()
+		if err != nil {
+			return fmt.Errorf("Cannot read DO_TURN_ACK: %v", err)
+		}
+		_, err = protocol.ReadDoTurnAckMessage(msg, nbPlayers)
+		if err != nil {
+			return fmt.Errorf("Invalid DO_TURN_ACK: %v", err)
+		}
+	}
+	return nil
+}
@@ -1,12 +1,15 @@
 package netorcai
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/mpoquet/go-prompt"
+	"github.com/netorcai/netorcai/protocol"
 	log "github.com/sirupsen/logrus"
 	"net"
 	"sync"
+	"time"
 )
 
 // Game state
@@ -25,12 +28,36 @@ const (
 	CLIENT_KICKED   = iota
 )
 
+// Game end status
+const (
+	GAME_ENDS_FINISHED           = "finished"
+	GAME_ENDS_GAME_LOGIC_TIMEOUT = "game logic timeout"
+	GAME_ENDS_ABORTED            = "aborted"
+)
+
+// Turn order
+const (
+	TURN_ORDER_SIMULTANEOUS = iota
+	TURN_ORDER_ROUND_ROBIN  = iota
+)
+
+// Turn skipping policy
+const (
+	SKIP_POLICY_DROP_OLD = iota
+	SKIP_POLICY_QUEUE    = iota
+	SKIP_POLICY_BLOCK    = iota
+)
+
+// GlobalState is owned by the coordinator goroutine (see coordinatorEvent).
+// Listeners are set before any goroutine is started and never modified.
 type GlobalState struct {
-	Mutex     sync.Mutex
 	WaitGroup sync.WaitGroup
 
-	Listener net.Listener
-	prompt   *prompt.Prompt
+	events             chan coordinatorEvent
+	coordinatorStopped chan struct{}
+
+	Listeners []net.Listener
+	prompt    *prompt.Prompt
 
 	GameState int
 
@@ -43,74 +70,66 @@ type GlobalState struct {
 	NbSpecialPlayersMax         int
 	NbVisusMax                  int
 	NbTurnsMax                  int
+	NbEpisodes                  int
+	NbGames                     int
+	NbGamesPlayed               int
 	Autostart                   bool
 	Fast                        bool
+	TurnOrder                   int
+	VisuFeedback                bool
+	PlayerSkipPolicy            SkipPolicy
+	SpecialPlayerSkipPolicy     SkipPolicy
+	VisuSkipPolicy              SkipPolicy
+	VisuMaxFrameRate            float64
+	VisuDeltas                  bool
+	KeyframeInterval            int
+	DisableCompression          bool
 	MillisecondsBeforeFirstTurn float64
 	MillisecondsBetweenTurns    float64
+	MillisecondsInitTimeout     float64
+	MillisecondsTurnTimeout     float64
+
+	// The results of all the players that have logged in, in login order.
+	results []*SessionResult
+	// The clients that are being sent LOGIN_ACK (see loginEvent)
+	pendingLogins []pendingLogin
+	// The game starts that wait for pendingLogins
+	pendingStarts []startEvent
+
+	// The ID of the last game state payload. Only the game logic goroutine
+	// generates IDs (see gameSettings.lastPayloadID), atomically.
+	lastGameStatePayloadID uint64
 }
 
-// Debugging helpers
-const (
-	debugGlobalStateMutex = false
-)
-
-func LockGlobalStateMutex(gs *GlobalState, reason, who string) {
-	if debugGlobalStateMutex {
-		log.WithFields(log.Fields{
-			"reason": reason,
-			"who":    who,
-		}).Info("Desire global state mutex")
-	}
-	gs.Mutex.Lock()
-	if debugGlobalStateMutex {
-		log.WithFields(log.Fields{
-			"reason": reason,
-			"who":    who,
-		}).Info("Got global state mutex")
-	}
-}
-
-func UnlockGlobalStateMutex(gs *GlobalState, reason, who string) {
-	if debugGlobalStateMutex {
-		log.WithFields(log.Fields{
-			"reason": reason,
-			"who":    who,
-		}).Info("Release global state mutex")
-	}
-	gs.Mutex.Unlock()
-}
-
-func areAllExpectedClientsConnected(gs *GlobalState) bool {
-	return (len(gs.Players) == gs.NbPlayersMax) &&
-		(len(gs.SpecialPlayers) == gs.NbSpecialPlayersMax) &&
-		(len(gs.Visus) == gs.NbVisusMax) &&
-		(len(gs.GameLogic) == 1)
-}
-
-func autostart(gs *GlobalState) {
-	if gs.Autostart && areAllExpectedClientsConnected(gs) {
-		log.Info("Automatic starting conditions are met")
-		gs.GameState = GAME_RUNNING
-		gs.GameLogic[0].start <- 1
-	}
-}
-
-func handleClient(client *Client, globalState *GlobalState,
-	gameLogicExit chan int) {
+// handleClient handles a client from its connection to its disconnection.
+// The client is kicked when ctx is done.
+// shutdownCtx is done when the server is gracefully shut down.
+func handleClient(ctx, shutdownCtx context.Context, client *Client,
+	globalState *GlobalState, gameLogicExit chan int) {
 	log.WithFields(log.Fields{
 		"remote address": client.Conn.RemoteAddr(),
 	}).Debug("New connection")
 
 	defer globalState.WaitGroup.Done()
 	defer client.Conn.Close()
+	defer close(client.done)
 	// This is to send a shutdown on the socket before closing it.
 	// Combined with a SO_LINGER<0 (default for go sockets),
 	// this should avoid loss of data sent by netorcai on client sockets.
-	defer client.Conn.(*net.TCPConn).CloseWrite()
+	if conn, ok := client.Conn.(interface{ CloseWrite() error }); ok {
+		defer conn.CloseWrite()
+	}
+	defer logTrafficStats(client)
 
 	go readClientMessages(client)
 
-	msg := <-client.incomingMessages
+	var msg ClientMessage
+	select {
+	case msg = <-client.incomingMessages:
+	case <-ctx.Done():
+		Kick(client, "netorcai abort")
+		return
+	}
 	if msg.err != nil {
 		log.WithFields(log.Fields{
 			"err":            msg.err,
@@ -120,7 +139,7 @@ func handleClient(client *Client, globalState *GlobalState,
 		return
 	}
 
-	loginMessage, err := readLoginMessage(msg.content)
+	loginMessage, err := protocol.ReadLoginMessage(msg.content)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err":            err,
@@ -129,135 +148,41 @@ func handleClient(client *Client, globalState *GlobalState,
 		Kick(client, fmt.Sprintf("Invalid first message: %v", err.Error()))
 		return
 	}
-	client.nickname = loginMessage.nickname
-
-	LockGlobalStateMutex(globalState, "New client", "Login manager")
-	switch loginMessage.role {
-	case "player", "special player":
-		isSpecial := loginMessage.role == "special player"
-		if globalState.GameState != GAME_NOT_RUNNING {
-			UnlockGlobalStateMutex(globalState, "New client", "Login manager")
-			Kick(client, "LOGIN denied: Game has been started")
-		} else if !isSpecial && len(globalState.Players) >= globalState.NbPlayersMax {
-			UnlockGlobalStateMutex(globalState, "New client", "Login manager")
-			Kick(client, "LOGIN denied: Maximum number of players reached")
-		} else if isSpecial && len(globalState.SpecialPlayers) >= globalState.NbSpecialPlayersMax {
-			UnlockGlobalStateMutex(globalState, "New client", "Login manager")
-			Kick(client, "LOGIN denied: Maximum number of special players reached")
-		} else {
-			err = sendLoginACK(client)
-			if err != nil {
-				UnlockGlobalStateMutex(globalState, "New client", "Login manager")
-				Kick(client, "LOGIN denied: Could not send LOGIN_ACK")
-			} else {
-				pvClient := &PlayerOrVisuClient{
-					client:          client,
-					playerID:        -1,
-					isPlayer:        true,
-					isSpecialPlayer: isSpecial,
-					gameStarts:      make(chan MessageGameStarts),
-					newTurn:         make(chan MessageTurn, 100),
-					gameEnds:        make(chan MessageGameEnds, 1),
-					playerInfo:      nil,
-				}
-
-				if !isSpecial {
-					globalState.Players = append(globalState.Players, pvClient)
-				} else {
-					globalState.SpecialPlayers = append(globalState.SpecialPlayers, pvClient)
-				}
-
-				log.WithFields(log.Fields{
-					"nickname":             client.nickname,
-					"remote address":       client.Conn.RemoteAddr(),
-					"player count":         len(globalState.Players),
-					"special player count": len(globalState.SpecialPlayers),
-					"special":              isSpecial,
-				}).Info("New player accepted")
-				client.state = CLIENT_LOGGED
-
-				UnlockGlobalStateMutex(globalState, "New client", "Login manager")
+	client.nickname = loginMessage.Nickname
 
-				// Automatically start the game if conditions are met
-				autostart(globalState)
-
-				// Player behavior is handled in dedicated function.
-				handlePlayerOrVisu(pvClient, globalState)
-			}
-		}
-	case "visualization":
-		if len(globalState.Visus) >= globalState.NbVisusMax {
-			UnlockGlobalStateMutex(globalState, "New client", "Login manager")
-			Kick(client, "LOGIN denied: Maximum number of visus reached")
-		} else {
-			err = sendLoginACK(client)
-			if err != nil {
-				UnlockGlobalStateMutex(globalState, "New client", "Login manager")
-				Kick(client, "LOGIN denied: Could not send LOGIN_ACK")
-			} else {
-				pvClient := &PlayerOrVisuClient{
-					client:     client,
-					playerID:   -1,
-					isPlayer:   false,
-					gameStarts: make(chan MessageGameStarts),
-					newTurn:    make(chan MessageTurn, 100),
-					gameEnds:   make(chan MessageGameEnds, 1),
-				}
-
-				globalState.Visus = append(globalState.Visus, pvClient)
-
-				log.WithFields(log.Fields{
-					"nickname":       client.nickname,
-					"remote address": client.Conn.RemoteAddr(),
-					"visu count":     len(globalState.Visus),
-				}).Info("New visualization accepted")
-				client.state = CLIENT_LOGGED
-
-				UnlockGlobalStateMutex(globalState, "New client", "Login manager")
-
-				// Automatically start the game if conditions are met
-				autostart(globalState)
-
-				// Visu behavior is handled in dedicated function.
-				handlePlayerOrVisu(pvClient, globalState)
-			}
-		}
-	case "game logic":
-		if globalState.GameState != GAME_NOT_RUNNING {
-			UnlockGlobalStateMutex(globalState, "New client", "Login manager")
-			Kick(client, "LOGIN denied: Game has been started")
-		} else if len(globalState.GameLogic) >= 1 {
-			UnlockGlobalStateMutex(globalState, "New client", "Login manager")
-			Kick(client, "LOGIN denied: A game logic is already logged in")
-		} else {
-			err = sendLoginACK(client)
-			if err != nil {
-				UnlockGlobalStateMutex(globalState, "New client", "Login manager")
-				Kick(client, "LOGIN denied: Could not send LOGIN_ACK")
-			} else {
-				glClient := &GameLogicClient{
-					client:             client,
-					playerAction:       make(chan MessageDoTurnPlayerAction, 1),
-					playerDisconnected: make(chan int, 1),
-					start:              make(chan int, 1),
-				}
-
-				globalState.GameLogic = append(globalState.GameLogic, glClient)
-
-				log.WithFields(log.Fields{
-					"nickname":       client.nickname,
-					"remote address": client.Conn.RemoteAddr(),
-				}).Info("Game logic accepted")
-
-				UnlockGlobalStateMutex(globalState, "New client", "Login manager")
-
-				// Automatically start the game if conditions are met
-				autostart(globalState)
+	event := newLoginEvent(client, loginMessage)
+	if !submit(globalState, event) {
+		Kick(client, "netorcai abort")
+		return
+	}
+	reservation := <-event.reply
+	if reservation.denial != "" {
+		Kick(client, "LOGIN denied: "+reservation.denial)
+		return
+	}
 
-				// Game logic behavior is handled in dedicated function
-				handleGameLogic(glClient, globalState, gameLogicExit)
-			}
-		}
+	err = sendLoginACK(client, reservation.compression,
+		loginMessage.Serialization)
+	if err != nil {
+		submit(globalState, loginRollbackEvent{client: client})
+		Kick(client, "LOGIN denied: Could not send LOGIN_ACK")
+		return
+	}
+	commit := newLoginCommitEvent(client, loginMessage)
+	if !submit(globalState, commit) {
+		Kick(client, "netorcai abort")
+		return
+	}
+	result := <-commit.reply
+	client.state = CLIENT_LOGGED
+
+	if result.pvClient != nil {
+		// Player/visu behavior is handled in dedicated function.
+		handlePlayerOrVisu(ctx, result.pvClient, globalState)
+	} else {
+		// Game logic behavior is handled in dedicated function
+		handleGameLogic(ctx, shutdownCtx, result.glClient, globalState,
+			gameLogicExit)
 	}
 }
 
@@ -273,67 +198,76 @@ func Kick(client *Client, reason string) {
 		"reason":         reason,
 	}).Warn("Kicking client")
 
-	msg := MessageKick{
+	msg := protocol.MessageKick{
 		MessageType: "KICK",
 		KickReason:  reason,
 	}
 
-	content, err := json.Marshal(msg)
+	content, err := marshalMessage(client, msg)
 	if err == nil {
 		_ = sendMessage(client, content)
 	}
 }
 
-func sendLoginACK(client *Client) error {
-	msg := MessageLoginAck{
+// loginAckTimeout bounds the time spent sending LOGIN_ACK, as the slot of
+// the client is reserved meanwhile.
+const loginAckTimeout = 3 * time.Second
+
+// sendLoginACK accepts the client. LOGIN_ACK is never compressed and is
+// always serialized in JSON, but the following messages use the given
+// compression algorithm and serialization format (if any).
+// It fails if LOGIN_ACK cannot be written within loginAckTimeout.
+func sendLoginACK(client *Client, compression, serialization string) error {
+	msg := protocol.MessageLoginAck{
 		MessageType:         "LOGIN_ACK",
 		MetaprotocolVersion: Version,
+		Compression:         compression,
+	}
+	if serialization != protocol.SerializationJSON {
+		msg.Serialization = serialization
 	}
 
 	content, err := json.Marshal(msg)
-	if err == nil {
-		err = sendMessage(client, content)
+	if err != nil {
+		return err
 	}
-	return err
-}
-
-func Cleanup() {
-	LockGlobalStateMutex(globalGS, "Cleanup", "Main")
-	log.Warn("Closing listening socket.")
-	globalGS.Listener.Close()
-
-	nonGlClients := append([]*PlayerOrVisuClient(nil), globalGS.Players...)
-	nonGlClients = append(nonGlClients, globalGS.SpecialPlayers...)
-	nonGlClients = append(nonGlClients, globalGS.Visus...)
-	nbClients := len(nonGlClients) + len(globalGS.GameLogic)
 
-	if nbClients > 0 {
-		log.Warn("Sending KICK messages to clients")
-
-		kickChan := make(chan int)
-		for _, client := range nonGlClients {
-			go func(c *Client) {
-				c.canTerminate <- "netorcai abort"
-				kickChan <- 0
-			}(client.client)
-		}
-
-		for _, client := range globalGS.GameLogic {
-			go func(c *Client) {
-				c.canTerminate <- "netorcai abort"
-				kickChan <- 0
-			}(client.client)
-		}
-
-		for i := 0; i < nbClients; i++ {
-			<-kickChan
-		}
+	// The client may send compressed or MessagePack messages as soon as it
+	// receives LOGIN_ACK, so they are enabled before sending it.
+	// LOGIN_ACK is too small to be compressed anyway.
+	client.setCompression(compression)
+	client.setSerialization(serialization)
+	err = client.Conn.SetWriteDeadline(time.Now().Add(loginAckTimeout))
+	if err == nil {
+		err = sendContent(client, content, true)
 	}
-
-	if globalGS.prompt != nil {
-		log.Warn("Cleaning prompt state.")
-		globalGS.prompt.TearDown()
+	if err != nil {
+		client.setCompression("")
+		client.setSerialization("")
+		return err
 	}
+	return client.Conn.SetWriteDeadline(time.Time{})
+}
 
-	UnlockGlobalStateMutex(globalGS, "Cleanup", "Main")
+// logTrafficStats logs how many bytes have been exchanged with a client,
+// before and after compression.
+func logTrafficStats(client *Client) {
+	stats := client.Stats()
+	entry := log.WithFields(log.Fields{
+		"remote address":              client.Conn.RemoteAddr(),
+		"nickname":                    client.nickname,
+		"compression":                 client.getCompression(),
+		"serialization":               client.getSerialization(),
+		"messages sent":               stats.MessagesSent,
+		"bytes sent":                  stats.BytesSent,
+		"uncompressed bytes sent":     stats.UncompressedBytesSent,
+		"messages received":           stats.MessagesReceived,
+		"bytes received":              stats.BytesReceived,
+		"uncompressed bytes received": stats.UncompressedBytesReceived,
+	})
+	if client.getCompression() != "" {
+		entry.Info("Client traffic")
+	} else {
+		entry.Debug("Client traffic")
+	}
 }
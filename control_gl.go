@@ -1,208 +1,515 @@
 package netorcai
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"github.com/netorcai/netorcai/protocol"
 	log "github.com/sirupsen/logrus"
 	"math/rand"
 	"sort"
+	"sync"
 	"time"
 )
 
 type GameLogicClient struct {
 	client *Client
 	// Messages to aggregate from player clients
-	playerAction chan MessageDoTurnPlayerAction
+	playerAction chan playerAction
 	// Control messages
-	start              chan int
-	playerDisconnected chan int
+	start chan int
+	// Players that have left during a fast game
+	disconnections *playerDisconnections
+	// Notified when a client with the block skip policy acknowledges a
+	// TURN or leaves. Never blocks the sender.
+	turnAcked chan struct{}
 }
 
-func waitGameLogicFinition(glClient *GameLogicClient) {
+// A playerAction holds the actions of a player slot on a TURN.
+type playerAction struct {
+	protocol.MessageDoTurnPlayerAction
+	// The episode of the TURN (see episodeInfo.id)
+	episode int
+}
+
+// An episodeInfo tells which episode of which game of the session is played.
+type episodeInfo struct {
+	// The index of the episode in the session, which tags the TURNs and
+	// actions of the episode. It increases across games, unlike episode.
+	id         int
+	episode    int
+	nbEpisodes int
+	game       int
+	// Whether the game is the last one of the session
+	lastGame bool
+}
+
+// isLast returns whether the episode is the last one of its game.
+func (ep episodeInfo) isLast() bool {
+	return ep.episode == ep.nbEpisodes-1
+}
+
+// endsSession returns whether clients leave after the episode.
+func (ep episodeInfo) endsSession() bool {
+	return ep.isLast() && ep.lastGame
+}
+
+// playerDisconnections holds the players that have left during the game,
+// until the game logic goroutine handles their departure.
+// Reporting a disconnection never blocks, so that any number of players can
+// leave at once, whatever the game logic goroutine is doing.
+type playerDisconnections struct {
+	mutex   sync.Mutex
+	players []*PlayerOrVisuClient
+	// Notified when a player leaves
+	notify chan struct{}
+}
+
+func newPlayerDisconnections() *playerDisconnections {
+	return &playerDisconnections{
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (disconnections *playerDisconnections) push(player *PlayerOrVisuClient) {
+	disconnections.mutex.Lock()
+	disconnections.players = append(disconnections.players, player)
+	disconnections.mutex.Unlock()
+
+	select {
+	case disconnections.notify <- struct{}{}:
+	default:
+		// A notification is already pending.
+	}
+}
+
+// popAll removes and returns the players that have left since the last call.
+func (disconnections *playerDisconnections) popAll() []*PlayerOrVisuClient {
+	disconnections.mutex.Lock()
+	defer disconnections.mutex.Unlock()
+	players := disconnections.players
+	disconnections.players = nil
+	return players
+}
+
+// copyPlayersInfo returns a copy of the players information, to be sent to
+// visualizations by their own goroutines. The original is modified by the
+// game logic goroutine when players leave.
+func copyPlayersInfo(
+	playersInfo []*protocol.PlayerInformation) []*protocol.PlayerInformation {
+	playersInfoCopy := make([]*protocol.PlayerInformation, 0,
+		len(playersInfo))
+	for _, info := range playersInfo {
+		infoCopy := *info
+		playersInfoCopy = append(playersInfoCopy, &infoCopy)
+	}
+	return playersInfoCopy
+}
+
+// handlePlayerDisconnections marks the players that have left as
+// disconnected in the players information, which is owned by the game logic
+// goroutine. The IDs of their slots are returned.
+func handlePlayerDisconnections(glClient *GameLogicClient,
+	playersInfo []*protocol.PlayerInformation) []int {
+	playerIDs := []int{}
+	for _, player := range glClient.disconnections.popAll() {
+		for _, playerID := range player.playerIDs {
+			playersInfo[playerID].IsConnected = false
+		}
+		playerIDs = append(playerIDs, player.playerIDs...)
+	}
+	return playerIDs
+}
+
+func waitGameLogicFinition(ctx context.Context, glClient *GameLogicClient) {
 	// As the GL coroutine is central, it does not finish directly.
 	// It waits for the main coroutine to be OK with it first.
 	// (making sure that all other clients have been kicked first).
 	for {
 		select {
-		case kickReason := <-glClient.client.canTerminate:
-			Kick(glClient.client, kickReason)
+		case <-ctx.Done():
+			Kick(glClient.client, "netorcai abort")
 			return
 		case <-glClient.playerAction:
-		case <-glClient.playerDisconnected:
 		case <-glClient.client.incomingMessages:
 		}
 	}
 }
 
-func handleGameLogic(glClient *GameLogicClient, globalState *GlobalState,
-	onexit chan int) {
-	// Wait for the game to start
-	select {
-	case <-glClient.start:
-		log.Info("Starting game")
-	case kickReason := <-glClient.client.canTerminate:
-		Kick(glClient.client, kickReason)
-		return
-	case msg := <-glClient.client.incomingMessages:
-		LockGlobalStateMutex(globalState, "GL first message", "GL")
-		if msg.err == nil {
-			Kick(glClient.client, "Received a game logic message but the game has not started")
-		} else {
-			Kick(glClient.client, fmt.Sprintf("Game logic error. %v", msg.err.Error()))
+// handleGameLogic runs the games of the session. The game logic is kicked
+// when ctx is done. When shutdownCtx is done, the current turn is finished
+// then the game is aborted: all clients receive a GAME_ENDS with the aborted
+// status.
+func handleGameLogic(ctx, shutdownCtx context.Context,
+	glClient *GameLogicClient, globalState *GlobalState, onexit chan int) {
+	// The number of episodes played in the previous games
+	nbEpisodesPlayed := 0
+	for {
+		// Wait for the game to start
+		select {
+		case <-glClient.start:
+			log.Info("Starting game")
+		case <-ctx.Done():
+			Kick(glClient.client, "netorcai abort")
+			return
+		case msg := <-glClient.client.incomingMessages:
+			if msg.err == nil {
+				Kick(glClient.client, "Received a game logic message but the game has not started")
+			} else {
+				Kick(glClient.client, fmt.Sprintf("Game logic error. %v", msg.err.Error()))
+			}
+			onexit <- EXIT_GAME_LOGIC_KICKED
+			waitGameLogicFinition(ctx, glClient)
+			return
+		case <-glClient.playerAction:
+			// Late actions of the previous game
+			continue
 		}
-		UnlockGlobalStateMutex(globalState, "GL first message", "GL")
-		onexit <- 1
-		waitGameLogicFinition(glClient)
-		return
-	}
-
-	LockGlobalStateMutex(globalState, "Game init: copy players/visus and game parameters", "GL")
-	players := append([]*PlayerOrVisuClient(nil), globalState.Players...)
-	specialPlayers := append([]*PlayerOrVisuClient(nil), globalState.SpecialPlayers...)
-	allPlayers := append(players, specialPlayers...)
-	visus := append([]*PlayerOrVisuClient(nil), globalState.Visus...)
-	nbTurnsMax := globalState.NbTurnsMax
-	msBeforeFirstTurn := globalState.MillisecondsBeforeFirstTurn
-	msBetweenTurns := globalState.MillisecondsBetweenTurns
-	fast := globalState.Fast
-	UnlockGlobalStateMutex(globalState, "Game init: copy players/visus and game parameters", "GL")
-
-	// Generate randomized player identifiers
-	initialNbPlayers := len(players)
+
+		// Copy players/visus and game parameters
+		event := gameSettingsEvent{reply: make(chan gameSettings, 1)}
+		if !submit(globalState, event) {
+			Kick(glClient.client, "netorcai abort")
+			return
+		}
+		settings := <-event.reply
+
+		episodesWon, finished := runGame(ctx, shutdownCtx, glClient, onexit,
+			settings, nbEpisodesPlayed)
+		if !finished {
+			return
+		}
+		nbEpisodesPlayed += settings.nbEpisodes
+
+		// Clients stay for the next game, which starts as the first one
+		submit(globalState, gameEndEvent{
+			players:     append(settings.players, settings.specialPlayers...),
+			episodesWon: episodesWon,
+		})
+		if settings.lastGame {
+			break
+		}
+	}
+
+	// Leave the program
+	Kick(glClient.client, protocol.KickReasonGameFinished)
+	onexit <- EXIT_SUCCESS
+	waitGameLogicFinition(ctx, glClient)
+}
+
+// runGame plays the episodes of a game. It returns the number of episodes
+// won by each player, and whether the game has finished normally.
+// Otherwise, the game logic has been kicked and the exit code has been set.
+func runGame(ctx, shutdownCtx context.Context, glClient *GameLogicClient,
+	onexit chan int, settings gameSettings,
+	nbEpisodesPlayed int) (map[*PlayerOrVisuClient]int, bool) {
+	players := settings.players
+	specialPlayers := settings.specialPlayers
+	allPlayers := append(append([]*PlayerOrVisuClient(nil), players...),
+		specialPlayers...)
+	visus := settings.visus
+
+	// Generate randomized player identifiers, which are shuffled again on
+	// each game of the session. Each slot of an agent has its own identifier.
+	initialNbPlayers := nbPlayerSlots(players)
 	initialNbSpecialPlayers := len(specialPlayers)
 	initialTotalNbPlayers := initialNbPlayers + initialNbSpecialPlayers
-	playerIDs := rand.Perm(len(players))
+	playerIDs := rand.Perm(initialNbPlayers)
+	owners := make([]*PlayerOrVisuClient, initialTotalNbPlayers)
 	for splayerIndex, splayer := range specialPlayers {
+		splayer.playerIDs = []int{splayerIndex}
 		splayer.playerID = splayerIndex
+		owners[splayerIndex] = splayer
 	}
-	for playerIndex, player := range players {
-		player.playerID = playerIDs[playerIndex] + initialNbSpecialPlayers
+	for _, player := range players {
+		player.playerIDs = make([]int, 0, player.nbSlots)
+		for _, playerID := range playerIDs[:player.nbSlots] {
+			player.playerIDs = append(player.playerIDs,
+				playerID+initialNbSpecialPlayers)
+			owners[playerID+initialNbSpecialPlayers] = player
+		}
+		playerIDs = playerIDs[player.nbSlots:]
+		sort.Ints(player.playerIDs)
+		player.playerID = player.playerIDs[0]
 	}
 
-	// Generate player information
-	playersInfo := []*PlayerInformation{}
+	// Generate player information, indexed by player_id
+	playersInfo := make([]*protocol.PlayerInformation, initialTotalNbPlayers)
 	for _, player := range allPlayers {
-		info := &PlayerInformation{
-			PlayerID:      player.playerID,
-			Nickname:      player.client.nickname,
-			RemoteAddress: player.client.Conn.RemoteAddr().String(),
-			IsConnected:   true,
+		for _, playerID := range player.playerIDs {
+			playersInfo[playerID] = &protocol.PlayerInformation{
+				PlayerID:      playerID,
+				Nickname:      player.client.nickname,
+				RemoteAddress: player.client.Conn.RemoteAddr().String(),
+				IsConnected:   true,
+			}
+		}
+	}
+
+	episodesWon := make(map[*PlayerOrVisuClient]int)
+	for episode := 0; episode < settings.nbEpisodes; episode++ {
+		winnerPlayerID, finished := runEpisode(ctx, shutdownCtx, glClient,
+			onexit, settings, allPlayers, visus, playersInfo, episodeInfo{
+				id:         nbEpisodesPlayed + episode,
+				episode:    episode,
+				nbEpisodes: settings.nbEpisodes,
+				game:       settings.game,
+				lastGame:   settings.lastGame,
+			})
+		if !finished {
+			return nil, false
+		}
+		if winnerPlayerID >= 0 && winnerPlayerID < len(owners) {
+			episodesWon[owners[winnerPlayerID]]++
 		}
-		player.playerInfo = info
-		playersInfo = append(playersInfo, info)
 	}
+	return episodesWon, true
+}
 
-	// Sort player information by player_id
-	sort.Slice(playersInfo, func(i, j int) bool {
-		return playersInfo[i].PlayerID < playersInfo[j].PlayerID
-	})
+// runEpisode initializes the game logic then plays an episode with the
+// clients. It returns the winner of the episode (-1 if there is none) and
+// whether the episode has finished normally.
+// Otherwise, the game logic has been kicked and the exit code has been set.
+func runEpisode(ctx, shutdownCtx context.Context,
+	glClient *GameLogicClient, onexit chan int, settings gameSettings,
+	allPlayers, visus []*PlayerOrVisuClient,
+	playersInfo []*protocol.PlayerInformation, ep episodeInfo) (int, bool) {
+	initialNbSpecialPlayers := len(settings.specialPlayers)
+	initialTotalNbPlayers := len(playersInfo)
+	initialNbPlayers := initialTotalNbPlayers - initialNbSpecialPlayers
+
+	// Only the first episode of the game waits before its first turn
+	msBeforeFirstTurn := settings.msBeforeFirstTurn
+	if ep.episode > 0 {
+		msBeforeFirstTurn = 0
+	}
+
+	// Players may have left during the previous episodes
+	handlePlayerDisconnections(glClient, playersInfo)
 
 	// Send DO_INIT
-	err := sendDoInit(glClient, initialNbPlayers, initialNbSpecialPlayers, nbTurnsMax)
+	err := sendDoInit(glClient, initialNbPlayers, initialNbSpecialPlayers,
+		settings.nbTurnsMax, ep)
 
 	if err != nil {
 		Kick(glClient.client, fmt.Sprintf("Cannot send DO_INIT. %v",
 			err.Error()))
-		onexit <- 1
-		waitGameLogicFinition(glClient)
-		return
+		onexit <- EXIT_GAME_LOGIC_KICKED
+		waitGameLogicFinition(ctx, glClient)
+		return -1, false
 	}
 
 	// Wait for first turn (DO_INIT_ACK)
 	var msg ClientMessage
-	select {
-	case kickReason := <-glClient.client.canTerminate:
-		Kick(glClient.client, kickReason)
-		return
-	case msg = <-glClient.client.incomingMessages:
-		if msg.err != nil {
-			Kick(glClient.client,
-				fmt.Sprintf("Cannot read DO_INIT_ACK. %v", msg.err.Error()))
-			onexit <- 1
-			waitGameLogicFinition(glClient)
-			return
+	initTimeout := glTimeout(settings.msInitTimeout)
+	for doInitAckReceived := false; !doInitAckReceived; {
+		select {
+		case <-ctx.Done():
+			Kick(glClient.client, "netorcai abort")
+			return -1, false
+		case <-shutdownCtx.Done():
+			handleGlAbort(glClient, map[string]interface{}{}, allPlayers,
+				visus, ep)
+			onexit <- EXIT_SIGNAL
+			waitGameLogicFinition(ctx, glClient)
+			return -1, false
+		case <-glClient.playerAction:
+			// Late actions of the previous episode
+		case msg = <-glClient.client.incomingMessages:
+			if msg.err != nil {
+				Kick(glClient.client,
+					fmt.Sprintf("Cannot read DO_INIT_ACK. %v", msg.err.Error()))
+				onexit <- EXIT_GAME_LOGIC_KICKED
+				waitGameLogicFinition(ctx, glClient)
+				return -1, false
+			}
+			doInitAckReceived = true
+		case <-initTimeout:
+			handleGlTimeout(glClient, fmt.Sprintf(
+				"Did not receive DO_INIT_ACK after %v seconds.",
+				settings.msInitTimeout/1000), map[string]interface{}{},
+				allPlayers, visus, ep)
+			onexit <- EXIT_GAME_LOGIC_TIMEOUT
+			waitGameLogicFinition(ctx, glClient)
+			return -1, false
 		}
-	case <-time.After(3 * time.Second):
-		Kick(glClient.client, "Did not receive DO_INIT_ACK after 3 seconds.")
-		onexit <- 1
-		waitGameLogicFinition(glClient)
-		return
 	}
 
-	doTurnAckMsg, err := readDoInitAckMessage(msg.content)
+	doTurnAckMsg, err := protocol.ReadDoInitAckMessage(msg.content)
 	if err != nil {
 		Kick(glClient.client,
 			fmt.Sprintf("Invalid DO_INIT_ACK message. %v", err.Error()))
-		onexit <- 1
-		waitGameLogicFinition(glClient)
-		return
+		onexit <- EXIT_GAME_LOGIC_KICKED
+		waitGameLogicFinition(ctx, glClient)
+		return -1, false
 	}
 
 	// Send GAME_STARTS to all clients
 	for _, player := range allPlayers {
-		player.gameStarts <- MessageGameStarts{
+		gameStarts := protocol.MessageGameStarts{
 			MessageType:      "GAME_STARTS",
 			PlayerID:         player.playerID,
-			PlayersInfo:      []*PlayerInformation{},
+			PlayersInfo:      []*protocol.PlayerInformation{},
 			NbPlayers:        initialNbPlayers,
 			NbSpecialPlayers: initialNbSpecialPlayers,
-			NbTurnsMax:       nbTurnsMax,
+			NbTurnsMax:       settings.nbTurnsMax,
 			DelayFirstTurn:   msBeforeFirstTurn,
-			DelayTurns:       msBetweenTurns,
+			DelayTurns:       settings.msBetweenTurns,
 			InitialGameState: doTurnAckMsg.InitialGameState,
+			Episode:          ep.episode,
+			NbEpisodes:       ep.nbEpisodes,
+			Game:             ep.game,
 		}
+		if player.isAgent {
+			gameStarts.PlayerIDs = player.playerIDs
+		}
+		player.games.push(gameEvent{gameStarts: &gameStarts,
+			actionSchema: doTurnAckMsg.ActionSchema, episode: ep.id})
 	}
 
+	playersInfoCopy := copyPlayersInfo(playersInfo)
 	for _, visu := range visus {
-		visu.gameStarts <- MessageGameStarts{
+		gameStarts := protocol.MessageGameStarts{
 			MessageType:      "GAME_STARTS",
 			PlayerID:         visu.playerID,
-			PlayersInfo:      playersInfo,
+			PlayersInfo:      playersInfoCopy,
 			NbPlayers:        initialNbPlayers,
 			NbSpecialPlayers: initialNbSpecialPlayers,
-			NbTurnsMax:       nbTurnsMax,
+			NbTurnsMax:       settings.nbTurnsMax,
 			DelayFirstTurn:   msBeforeFirstTurn,
-			DelayTurns:       msBetweenTurns,
+			DelayTurns:       settings.msBetweenTurns,
 			InitialGameState: doTurnAckMsg.InitialGameState,
+			Episode:          ep.episode,
+			NbEpisodes:       ep.nbEpisodes,
+			Game:             ep.game,
 		}
+		visu.games.push(gameEvent{gameStarts: &gameStarts, episode: ep.id})
 	}
 
-	if fast {
-		gameLogicGameControlFast(glClient, onexit,
-			initialTotalNbPlayers, nbTurnsMax,
-			allPlayers, visus, playersInfo)
-	} else {
-		gameLogicGameControlTimers(glClient, onexit,
-			initialTotalNbPlayers, nbTurnsMax,
-			allPlayers, visus, playersInfo,
-			msBeforeFirstTurn, msBetweenTurns)
+	if settings.fast {
+		return gameLogicGameControlFast(ctx, shutdownCtx, glClient, onexit,
+			initialTotalNbPlayers, settings.nbTurnsMax, settings.turnOrder,
+			settings.visuFeedback, allPlayers, visus, playersInfo,
+			doTurnAckMsg.InitialGameState, settings.msTurnTimeout,
+			settings.lastPayloadID, ep)
+	}
+	return gameLogicGameControlTimers(ctx, shutdownCtx, glClient, onexit,
+		initialTotalNbPlayers, settings.nbTurnsMax, settings.turnOrder,
+		settings.visuFeedback, allPlayers, visus, playersInfo,
+		doTurnAckMsg.InitialGameState, msBeforeFirstTurn,
+		settings.msBetweenTurns, settings.msTurnTimeout,
+		settings.lastPayloadID, ep)
+}
+
+// Returns a channel that fires after the given number of milliseconds.
+// The returned channel is nil (and never fires) if the timeout is disabled.
+func glTimeout(milliseconds float64) <-chan time.Time {
+	if milliseconds <= 0 {
+		return nil
 	}
+	return time.After(time.Duration(milliseconds * float64(time.Millisecond)))
 }
 
-func gameLogicGameControlTimers(glClient *GameLogicClient,
-	onexit chan int,
-	initialTotalNbPlayers, nbTurnsMax int,
+func gameLogicGameControlTimers(ctx, shutdownCtx context.Context,
+	glClient *GameLogicClient, onexit chan int,
+	initialTotalNbPlayers, nbTurnsMax, turnOrder int, visuFeedback bool,
 	allPlayers, visus []*PlayerOrVisuClient,
-	playersInfo []*PlayerInformation,
-	msBeforeFirstTurn, msBetweenTurns float64) {
+	playersInfo []*protocol.PlayerInformation,
+	initialGameState map[string]interface{},
+	msBeforeFirstTurn, msBetweenTurns, msTurnTimeout float64,
+	lastPayloadID *uint64, ep episodeInfo) (int, bool) {
 	// Wait before really starting the game
 	log.WithFields(log.Fields{
 		"duration (ms)": msBeforeFirstTurn,
 	}).Debug("Sleeping before first turn")
-	time.Sleep(time.Duration(msBeforeFirstTurn) * time.Millisecond)
+	firstTurnTimer := time.NewTimer(
+		time.Duration(msBeforeFirstTurn) * time.Millisecond)
+	defer firstTurnTimer.Stop()
+	select {
+	case <-ctx.Done():
+		Kick(glClient.client, "netorcai abort")
+		return -1, false
+	case <-shutdownCtx.Done():
+		// No turn is being computed: the game can be aborted now.
+		handleGlAbort(glClient, initialGameState, allPlayers, visus, ep)
+		onexit <- EXIT_SIGNAL
+		waitGameLogicFinition(ctx, glClient)
+		return -1, false
+	case <-firstTurnTimer.C:
+	}
 
 	// Order the game logic to compute a TURN (without any action)
 	turnNumber := 0
-	playerActions := make([]MessageDoTurnPlayerAction, 0)
+	lastGameState := initialGameState
+	playerActions := make([]protocol.MessageDoTurnPlayerAction, 0)
 	sendDoTurn(glClient, playerActions)
+	doTurnAckTimeout := glTimeout(msTurnTimeout)
+	waitingDoTurnAck := true
+	var nextDoTurn <-chan time.Time
+	lastTurnNumberSent := -1
+	// Whether DO_TURN waits for clients with the block skip policy
+	doTurnBlocked := false
+
+	shutdownDone := shutdownCtx.Done()
+	aborting := false
 
 	for {
 		select {
-		case kickReason := <-glClient.client.canTerminate:
-			Kick(glClient.client, kickReason)
-			return
-		case action := <-glClient.playerAction:
+		case <-ctx.Done():
+			Kick(glClient.client, "netorcai abort")
+			return -1, false
+		case <-shutdownDone:
+			shutdownDone = nil
+			if !waitingDoTurnAck {
+				handleGlAbort(glClient, lastGameState, allPlayers, visus, ep)
+				onexit <- EXIT_SIGNAL
+				waitGameLogicFinition(ctx, glClient)
+				return -1, false
+			}
+			// Finish the current turn before aborting.
+			aborting = true
+			log.Info("Waiting for the current turn to finish before aborting")
+		case <-doTurnAckTimeout:
+			handleGlTimeout(glClient, fmt.Sprintf(
+				"Did not receive DO_TURN_ACK after %v seconds.",
+				msTurnTimeout/1000), lastGameState, allPlayers, visus, ep)
+			onexit <- EXIT_GAME_LOGIC_TIMEOUT
+			waitGameLogicFinition(ctx, glClient)
+			return -1, false
+		case <-nextDoTurn:
+			nextDoTurn = nil
+			if isAnyClientBehind(ep.id, lastTurnNumberSent, allPlayers,
+				visus) {
+				log.Debug("Waiting for blocking clients before next DO_TURN")
+				doTurnBlocked = true
+				break
+			}
+
+			// Order the game logic to compute a new TURN
+			sendDoTurn(glClient, playerActions)
+			playerActions = playerActions[:0]
+			doTurnAckTimeout = glTimeout(msTurnTimeout)
+			waitingDoTurnAck = true
+		case <-glClient.turnAcked:
+			// A blocking client acknowledged a TURN or left.
+			if !doTurnBlocked || isAnyClientBehind(ep.id,
+				lastTurnNumberSent, allPlayers, visus) {
+				break
+			}
+
+			// Order the game logic to compute a new TURN
+			doTurnBlocked = false
+			sendDoTurn(glClient, playerActions)
+			playerActions = playerActions[:0]
+			doTurnAckTimeout = glTimeout(msTurnTimeout)
+			waitingDoTurnAck = true
+		case received := <-glClient.playerAction:
 			// A client sent its actions.
+			if received.episode != ep.id {
+				// Late actions of the previous episode
+				break
+			}
+			action := received.MessageDoTurnPlayerAction
+
 			// Replace the current message from this player if it exists,
 			// and place it at the end of the array.
 			// This may happen if the client was late in a previous turn but
@@ -221,40 +528,91 @@ func gameLogicGameControlTimers(glClient *GameLogicClient,
 				// Append the action into the actions array
 				playerActions = append(playerActions, action)
 			}
+		case <-glClient.disconnections.notify:
+			handlePlayerDisconnections(glClient, playersInfo)
 
 		case msg := <-glClient.client.incomingMessages:
 			// New message received from the game logic
-			doTurnAckMsg, err := handleGLDoTurnAckReception(glClient, msg, initialTotalNbPlayers)
+			doTurnAckMsg, payload, err := handleGLDoTurnAckReception(
+				glClient, msg, initialTotalNbPlayers, lastGameState,
+				lastPayloadID)
 			if err != nil {
-				onexit <- 1
-				waitGameLogicFinition(glClient)
-				return
+				onexit <- EXIT_GAME_LOGIC_KICKED
+				waitGameLogicFinition(ctx, glClient)
+				return -1, false
 			}
+			doTurnAckTimeout = nil
+			waitingDoTurnAck = false
+			lastGameState = doTurnAckMsg.GameState
 
 			turnNumber = turnNumber + 1
-			if turnNumber < nbTurnsMax {
-				handleGlForwardTurnToClients(doTurnAckMsg, turnNumber, allPlayers, visus, playersInfo)
+			if turnNumber < nbTurnsMax && aborting {
+				handleGlAbort(glClient, lastGameState, allPlayers, visus, ep)
+				onexit <- EXIT_SIGNAL
+				waitGameLogicFinition(ctx, glClient)
+				return -1, false
+			} else if turnNumber < nbTurnsMax {
+				activePlayers := computeActivePlayers(turnOrder, turnNumber-1,
+					initialTotalNbPlayers, doTurnAckMsg.ActivePlayers)
+				handleGlForwardTurnToClients(doTurnAckMsg, payload, turnNumber,
+					activePlayers, visuFeedback, allPlayers, visus, playersInfo,
+					ep)
+				lastTurnNumberSent = turnNumber - 1
 
 				// Trigger a new DO_TURN in some time
-				go func() {
-					log.WithFields(log.Fields{
-						"duration (ms)": msBetweenTurns,
-					}).Debug("Sleeping before next turn")
-					time.Sleep(time.Duration(msBetweenTurns) * time.Millisecond)
-
-					sendDoTurn(glClient, playerActions)
-					playerActions = playerActions[:0]
-				}()
+				log.WithFields(log.Fields{
+					"duration (ms)": msBetweenTurns,
+				}).Debug("Sleeping before next turn")
+				nextDoTurn = time.After(time.Duration(msBetweenTurns) * time.Millisecond)
 			} else {
-				handleGlGameFinished(glClient, doTurnAckMsg, allPlayers, visus, playersInfo)
-				onexit <- 0
-				waitGameLogicFinition(glClient)
-				return
+				handleGlGameFinished(doTurnAckMsg, allPlayers, visus,
+					playersInfo, ep)
+				return doTurnAckMsg.WinnerPlayerID, true
 			}
 		}
 	}
 }
 
+// Returns the set of players that can act on a given turn.
+// The players chosen by the game logic (if any) take precedence over the
+// turn order.
+func computeActivePlayers(turnOrder, turnNumber,
+	initialTotalNbPlayers int, glActivePlayers []int) map[int]bool {
+	activePlayers := make(map[int]bool)
+	if glActivePlayers != nil {
+		for _, playerID := range glActivePlayers {
+			activePlayers[playerID] = true
+		}
+		return activePlayers
+	}
+
+	switch turnOrder {
+	case TURN_ORDER_ROUND_ROBIN:
+		if initialTotalNbPlayers > 0 {
+			activePlayers[turnNumber%initialTotalNbPlayers] = true
+		}
+	default:
+		for playerID := 0; playerID < initialTotalNbPlayers; playerID++ {
+			activePlayers[playerID] = true
+		}
+	}
+	return activePlayers
+}
+
+// isAnyClientBehind returns whether a client with the block skip policy
+// has not acknowledged the TURN of the given episode whose number is
+// turnNumber yet.
+func isAnyClientBehind(episode, turnNumber int,
+	allPlayers, visus []*PlayerOrVisuClient) bool {
+	for _, pvClient := range append(append([]*PlayerOrVisuClient(nil),
+		allPlayers...), visus...) {
+		if pvClient.isBehind(episode, turnNumber) {
+			return true
+		}
+	}
+	return false
+}
+
 func areAllValuesTrue(playerIDToBoolMap map[int]bool) bool {
 	for _, v := range playerIDToBoolMap {
 		if !v {
@@ -264,68 +622,152 @@ func areAllValuesTrue(playerIDToBoolMap map[int]bool) bool {
 	return true
 }
 
-func gameLogicGameControlFast(glClient *GameLogicClient,
-	onexit chan int,
-	initialTotalNbPlayers, nbTurnsMax int,
+func gameLogicGameControlFast(ctx, shutdownCtx context.Context,
+	glClient *GameLogicClient, onexit chan int,
+	initialTotalNbPlayers, nbTurnsMax, turnOrder int, visuFeedback bool,
 	allPlayers, visus []*PlayerOrVisuClient,
-	playersInfo []*PlayerInformation) {
+	playersInfo []*protocol.PlayerInformation,
+	initialGameState map[string]interface{},
+	msTurnTimeout float64, lastPayloadID *uint64,
+	ep episodeInfo) (int, bool) {
 
 	// Order the game logic to compute a TURN right away (without any action)
 	turnNumber := 0
-	playerActions := make([]MessageDoTurnPlayerAction, 0)
+	lastGameState := initialGameState
+	playerActions := make([]protocol.MessageDoTurnPlayerAction, 0)
 	sendDoTurn(glClient, playerActions)
 
 	connectedPlayers := make(map[int]int) // keys are playerID. values are not used
-	for playerID := 0; playerID < initialTotalNbPlayers; playerID++ {
-		connectedPlayers[playerID] = 1
+	for _, info := range playersInfo {
+		if info.IsConnected {
+			connectedPlayers[info.PlayerID] = 1
+		}
 	}
 
+	shutdownDone := shutdownCtx.Done()
+	aborting := false
+
 	for {
 		// Wait for GL's DO_TURN_ACK
-		var doTurnAckMsg MessageDoTurnAck
+		var doTurnAckMsg protocol.MessageDoTurnAck
+		var payload *gameStatePayload
 		var err error
-		select {
-		case kickReason := <-glClient.client.canTerminate:
-			Kick(glClient.client, kickReason)
-			return
-		case msg := <-glClient.client.incomingMessages:
-			doTurnAckMsg, err = handleGLDoTurnAckReception(glClient, msg, initialTotalNbPlayers)
-			if err != nil {
-				onexit <- 1
-				waitGameLogicFinition(glClient)
-				return
+		doTurnAckTimeout := glTimeout(msTurnTimeout)
+		for doTurnAckReceived := false; !doTurnAckReceived; {
+			select {
+			case <-ctx.Done():
+				Kick(glClient.client, "netorcai abort")
+				return -1, false
+			case <-shutdownDone:
+				// Finish the current turn before aborting.
+				shutdownDone = nil
+				aborting = true
+				log.Info("Waiting for the current turn to finish before aborting")
+			case <-doTurnAckTimeout:
+				handleGlTimeout(glClient, fmt.Sprintf(
+					"Did not receive DO_TURN_ACK after %v seconds.",
+					msTurnTimeout/1000), lastGameState, allPlayers, visus, ep)
+				onexit <- EXIT_GAME_LOGIC_TIMEOUT
+				waitGameLogicFinition(ctx, glClient)
+				return -1, false
+			case msg := <-glClient.client.incomingMessages:
+				doTurnAckMsg, payload, err = handleGLDoTurnAckReception(
+					glClient, msg, initialTotalNbPlayers, lastGameState,
+					lastPayloadID)
+				if err != nil {
+					onexit <- EXIT_GAME_LOGIC_KICKED
+					waitGameLogicFinition(ctx, glClient)
+					return -1, false
+				}
+				lastGameState = doTurnAckMsg.GameState
+				doTurnAckReceived = true
+			case <-glClient.disconnections.notify:
+				for _, playerID := range handlePlayerDisconnections(glClient,
+					playersInfo) {
+					delete(connectedPlayers, playerID)
+				}
 			}
 		}
 
 		turnNumber = turnNumber + 1
 		if turnNumber >= nbTurnsMax {
-			handleGlGameFinished(glClient, doTurnAckMsg, allPlayers, visus, playersInfo)
-			onexit <- 0
-			waitGameLogicFinition(glClient)
-			return
+			handleGlGameFinished(doTurnAckMsg, allPlayers, visus,
+				playersInfo, ep)
+			return doTurnAckMsg.WinnerPlayerID, true
+		} else if aborting {
+			handleGlAbort(glClient, lastGameState, allPlayers, visus, ep)
+			onexit <- EXIT_SIGNAL
+			waitGameLogicFinition(ctx, glClient)
+			return -1, false
 		}
 
 		// Forward the new turn to clients
-		handleGlForwardTurnToClients(doTurnAckMsg, turnNumber, allPlayers, visus, playersInfo)
+		activePlayers := computeActivePlayers(turnOrder, turnNumber-1,
+			initialTotalNbPlayers, doTurnAckMsg.ActivePlayers)
+		handleGlForwardTurnToClients(doTurnAckMsg, payload, turnNumber,
+			activePlayers, visuFeedback, allPlayers, visus, playersInfo, ep)
 
-		// Wait TURN_ACK (or socket failure) from all players.
+		// Wait TURN_ACK (or socket failure) from all active players.
 		actionReceived := make(map[int]bool)
 		for playerID, _ := range connectedPlayers {
-			actionReceived[playerID] = false
+			if activePlayers[playerID] {
+				actionReceived[playerID] = false
+			}
 		}
 		for !areAllValuesTrue(actionReceived) {
 			select {
-			case kickReason := <-glClient.client.canTerminate:
-				Kick(glClient.client, kickReason)
-				return
-			case action := <-glClient.playerAction:
+			case <-ctx.Done():
+				Kick(glClient.client, "netorcai abort")
+				return -1, false
+			case <-shutdownDone:
+				// The current turn is over: the game can be aborted now.
+				handleGlAbort(glClient, lastGameState, allPlayers, visus, ep)
+				onexit <- EXIT_SIGNAL
+				waitGameLogicFinition(ctx, glClient)
+				return -1, false
+			case received := <-glClient.playerAction:
+				if received.episode != ep.id {
+					// Late actions of the previous episode
+					break
+				}
+				action := received.MessageDoTurnPlayerAction
 				actionReceived[action.PlayerID] = true
 				if _, isConnected := connectedPlayers[action.PlayerID]; isConnected {
 					playerActions = append(playerActions, action)
 				}
-			case disconnectedPlayerID := <-glClient.playerDisconnected:
-				actionReceived[disconnectedPlayerID] = true
-				delete(connectedPlayers, disconnectedPlayerID)
+			case <-glClient.disconnections.notify:
+				for _, playerID := range handlePlayerDisconnections(glClient,
+					playersInfo) {
+					actionReceived[playerID] = true
+					delete(connectedPlayers, playerID)
+				}
+			}
+		}
+
+		// Wait for the clients with the block skip policy, such as
+		// visualizations or non-active players.
+		for isAnyClientBehind(ep.id, turnNumber-1, allPlayers, visus) {
+			select {
+			case <-ctx.Done():
+				Kick(glClient.client, "netorcai abort")
+				return -1, false
+			case <-shutdownDone:
+				handleGlAbort(glClient, lastGameState, allPlayers, visus, ep)
+				onexit <- EXIT_SIGNAL
+				waitGameLogicFinition(ctx, glClient)
+				return -1, false
+			case <-glClient.turnAcked:
+			case received := <-glClient.playerAction:
+				action := received.MessageDoTurnPlayerAction
+				_, isConnected := connectedPlayers[action.PlayerID]
+				if received.episode == ep.id && isConnected {
+					playerActions = append(playerActions, action)
+				}
+			case <-glClient.disconnections.notify:
+				for _, playerID := range handlePlayerDisconnections(glClient,
+					playersInfo) {
+					delete(connectedPlayers, playerID)
+				}
 			}
 		}
 
@@ -335,95 +777,186 @@ func gameLogicGameControlFast(glClient *GameLogicClient,
 	}
 }
 
+// handleGLDoTurnAckReception reads a DO_TURN_ACK. It also returns the new
+// game state as a payload that can be shared by the TURNs of all clients.
 func handleGLDoTurnAckReception(glClient *GameLogicClient,
-	msg ClientMessage, initialTotalNbPlayers int) (MessageDoTurnAck, error) {
+	msg ClientMessage, initialTotalNbPlayers int,
+	lastGameState map[string]interface{}, lastPayloadID *uint64) (
+	protocol.MessageDoTurnAck, *gameStatePayload, error) {
 
 	if msg.err != nil {
 		Kick(glClient.client, fmt.Sprintf("Cannot read DO_TURN_ACK. %v", msg.err.Error()))
-		return MessageDoTurnAck{}, msg.err
+		return protocol.MessageDoTurnAck{}, nil, msg.err
 	}
 
-	doTurnAckMsg, err := readDoTurnAckMessage(msg.content, initialTotalNbPlayers)
+	doTurnAckMsg, err := protocol.ReadDoTurnAckMessage(msg.content, initialTotalNbPlayers)
+	if err != nil {
+		Kick(glClient.client, fmt.Sprintf("Invalid DO_TURN_ACK message. %v", err.Error()))
+		return protocol.MessageDoTurnAck{}, nil, err
+	}
+
+	// The game logic may only send the changes of the game state.
+	// Otherwise, the game state is forwarded as sent by the game logic.
+	var rawGameState []byte
+	if doTurnAckMsg.GameState != nil {
+		rawGameState = msg.rawGameState
+	}
+	err = doTurnAckMsg.ApplyGameStatePatch(lastGameState)
 	if err != nil {
 		Kick(glClient.client, fmt.Sprintf("Invalid DO_TURN_ACK message. %v", err.Error()))
-		return MessageDoTurnAck{}, err
+		return protocol.MessageDoTurnAck{}, nil, err
 	}
 
 	log.Debug("GL received a new DO_TURN_ACK (from socket)")
-	return doTurnAckMsg, nil
+	return doTurnAckMsg, newGameStatePayload(lastPayloadID,
+		doTurnAckMsg.GameState, rawGameState), nil
 }
 
-func handleGlForwardTurnToClients(doTurnAckMsg MessageDoTurnAck, turnNumber int,
+func handleGlForwardTurnToClients(doTurnAckMsg protocol.MessageDoTurnAck,
+	payload *gameStatePayload, turnNumber int,
+	activePlayers map[int]bool, visuFeedback bool,
 	allPlayers, visus []*PlayerOrVisuClient,
-	playersInfo []*PlayerInformation) {
+	playersInfo []*protocol.PlayerInformation, ep episodeInfo) {
+
+	// Each player only receives the feedback that targets it
+	playerFeedback := make(map[int][]protocol.PlayerFeedback)
+	for _, feedback := range doTurnAckMsg.Feedback {
+		playerFeedback[feedback.PlayerID] = append(
+			playerFeedback[feedback.PlayerID], feedback)
+	}
 
 	for _, player := range allPlayers {
-		player.newTurn <- MessageTurn{
+		turn := protocol.MessageTurn{
 			MessageType: "TURN",
 			TurnNumber:  turnNumber - 1,
-			GameState:   doTurnAckMsg.GameState,
-			PlayersInfo: []*PlayerInformation{},
+			Actionable:  activePlayers[player.playerID],
+			PlayersInfo: []*protocol.PlayerInformation{},
+			Feedback:    playerFeedback[player.playerID],
+		}
+		if player.isAgent {
+			// An agent receives the feedback of all its slots, and can act
+			// if any of them can.
+			turn.Feedback = nil
+			for _, playerID := range player.playerIDs {
+				if activePlayers[playerID] {
+					turn.ActionablePlayerIDs = append(
+						turn.ActionablePlayerIDs, playerID)
+				}
+				turn.Feedback = append(turn.Feedback,
+					playerFeedback[playerID]...)
+			}
+			turn.Actionable = len(turn.ActionablePlayerIDs) > 0
 		}
+		player.turns.push(pendingTurn{turn, payload, ep.id})
 	}
+
+	var allFeedback []protocol.PlayerFeedback
+	if visuFeedback {
+		allFeedback = doTurnAckMsg.Feedback
+	}
+	playersInfoCopy := copyPlayersInfo(playersInfo)
 	for _, visu := range visus {
-		visu.newTurn <- MessageTurn{
+		visu.turns.push(pendingTurn{protocol.MessageTurn{
 			MessageType: "TURN",
 			TurnNumber:  turnNumber - 1,
-			GameState:   doTurnAckMsg.GameState,
-			PlayersInfo: playersInfo,
-		}
+			Actionable:  false,
+			PlayersInfo: playersInfoCopy,
+			Feedback:    allFeedback,
+		}, payload, ep.id})
 	}
 }
 
-func handleGlGameFinished(glClient *GameLogicClient,
-	doTurnAckMsg MessageDoTurnAck,
+// handleGlGameFinished ends the episode for all clients. They leave after
+// the last episode of the session.
+func handleGlGameFinished(doTurnAckMsg protocol.MessageDoTurnAck,
 	allPlayers, visus []*PlayerOrVisuClient,
-	playersInfo []*PlayerInformation) {
+	playersInfo []*protocol.PlayerInformation, ep episodeInfo) {
 
 	if doTurnAckMsg.WinnerPlayerID != -1 {
 		log.WithFields(log.Fields{
 			"winner player ID":      doTurnAckMsg.WinnerPlayerID,
 			"winner nickname":       playersInfo[doTurnAckMsg.WinnerPlayerID].Nickname,
 			"winner remote address": playersInfo[doTurnAckMsg.WinnerPlayerID].RemoteAddress,
+			"episode":               ep.episode,
+			"game":                  ep.game,
 		}).Info("Game is finished")
 	} else {
-		log.Info("Game is finished (no winner!)")
+		log.WithFields(log.Fields{
+			"episode": ep.episode,
+			"game":    ep.game,
+		}).Info("Game is finished (no winner!)")
 	}
 
 	// Send GAME_ENDS to all clients
-	for _, player := range allPlayers {
-		player.gameEnds <- MessageGameEnds{
-			MessageType:    "GAME_ENDS",
-			WinnerPlayerID: doTurnAckMsg.WinnerPlayerID,
-			GameState:      doTurnAckMsg.GameState,
-		}
+	sendGameEndsToClients(GAME_ENDS_FINISHED, doTurnAckMsg.WinnerPlayerID,
+		doTurnAckMsg.GameState, allPlayers, visus, ep, ep.endsSession())
+}
+
+func handleGlTimeout(glClient *GameLogicClient, reason string,
+	lastGameState map[string]interface{},
+	allPlayers, visus []*PlayerOrVisuClient, ep episodeInfo) {
+	log.WithFields(log.Fields{
+		"reason": reason,
+	}).Warn("Game logic timeout")
+
+	// End the game for all clients (without any winner)
+	sendGameEndsToClients(GAME_ENDS_GAME_LOGIC_TIMEOUT, -1, lastGameState,
+		allPlayers, visus, ep, true)
+
+	Kick(glClient.client, reason)
+}
+
+func handleGlAbort(glClient *GameLogicClient,
+	lastGameState map[string]interface{},
+	allPlayers, visus []*PlayerOrVisuClient, ep episodeInfo) {
+	log.Warn("Aborting game")
+
+	// End the game for all clients (without any winner)
+	sendGameEndsToClients(GAME_ENDS_ABORTED, -1, lastGameState,
+		allPlayers, visus, ep, true)
+
+	Kick(glClient.client, "netorcai abort")
+}
+
+// sendGameEndsToClients ends the episode for all clients, which leave if
+// last is set.
+func sendGameEndsToClients(status string, winnerPlayerID int,
+	gameState map[string]interface{},
+	allPlayers, visus []*PlayerOrVisuClient, ep episodeInfo, last bool) {
+	gameEnds := protocol.MessageGameEnds{
+		MessageType:    "GAME_ENDS",
+		Status:         status,
+		WinnerPlayerID: winnerPlayerID,
+		GameState:      gameState,
+		Episode:        ep.episode,
+		NbEpisodes:     ep.nbEpisodes,
+		Game:           ep.game,
+		NextGame:       !last && ep.isLast(),
 	}
-	for _, visu := range visus {
-		visu.gameEnds <- MessageGameEnds{
-			MessageType:    "GAME_ENDS",
-			WinnerPlayerID: doTurnAckMsg.WinnerPlayerID,
-			GameState:      doTurnAckMsg.GameState,
-		}
+	for _, pvClient := range append(append([]*PlayerOrVisuClient(nil),
+		allPlayers...), visus...) {
+		pvClient.games.push(gameEvent{gameEnds: &gameEnds, last: last})
 	}
-
-	// Leave the program
-	Kick(glClient.client, "Game is finished")
 }
 
-func sendDoInit(client *GameLogicClient, nbPlayers, nbSpecialPlayers, nbTurnsMax int) error {
-	msg := MessageDoInit{
+func sendDoInit(client *GameLogicClient, nbPlayers, nbSpecialPlayers,
+	nbTurnsMax int, ep episodeInfo) error {
+	msg := protocol.MessageDoInit{
 		MessageType:      "DO_INIT",
 		NbPlayers:        nbPlayers,
 		NbSpecialPlayers: nbSpecialPlayers,
 		NbTurnsMax:       nbTurnsMax,
+		Episode:          ep.episode,
+		NbEpisodes:       ep.nbEpisodes,
+		Game:             ep.game,
 	}
 
-	content, err := json.Marshal(msg)
+	content, err := marshalMessage(client.client, msg)
 	if err == nil {
 		log.WithFields(log.Fields{
 			"nickname":       client.client.nickname,
 			"remote address": client.client.Conn.RemoteAddr(),
-			"content":        string(content),
+			"content":        printableContent(client.client, content),
 		}).Debug("Sending DO_INIT to game logic")
 		err = sendMessage(client.client, content)
 	}
@@ -431,18 +964,18 @@ func sendDoInit(client *GameLogicClient, nbPlayers, nbSpecialPlayers, nbTurnsMax
 }
 
 func sendDoTurn(client *GameLogicClient,
-	playerActions []MessageDoTurnPlayerAction) error {
-	msg := MessageDoTurn{
+	playerActions []protocol.MessageDoTurnPlayerAction) error {
+	msg := protocol.MessageDoTurn{
 		MessageType:   "DO_TURN",
 		PlayerActions: playerActions,
 	}
 
-	content, err := json.Marshal(msg)
+	content, err := marshalMessage(client.client, msg)
 	if err == nil {
 		log.WithFields(log.Fields{
 			"nickname":       client.client.nickname,
 			"remote address": client.client.Conn.RemoteAddr(),
-			"content":        string(content),
+			"content":        printableContent(client.client, content),
 		}).Debug("Sending DO_TURN to game logic")
 		err = sendMessage(client.client, content)
 	}
@@ -1,99 +1,314 @@
 package netorcai
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"github.com/netorcai/netorcai/protocol"
 	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// SkipPolicy defines what happens to the TURNs that a client receives
+// while it is still thinking about a previous TURN.
+type SkipPolicy struct {
+	// SKIP_POLICY_DROP_OLD: Only the latest TURN is buffered.
+	// SKIP_POLICY_QUEUE: Up to QueueSize TURNs are buffered,
+	// older ones are dropped.
+	// SKIP_POLICY_BLOCK: The game waits for the client.
+	Kind      int
+	QueueSize int
+}
+
+// ParseSkipPolicy reads a policy such as "drop-old", "queue:10" or "block".
+func ParseSkipPolicy(policy string) (SkipPolicy, error) {
+	switch {
+	case policy == "drop-old":
+		return SkipPolicy{Kind: SKIP_POLICY_DROP_OLD}, nil
+	case policy == "block":
+		return SkipPolicy{Kind: SKIP_POLICY_BLOCK}, nil
+	case strings.HasPrefix(policy, "queue:"):
+		queueSize, err := strconv.Atoi(strings.TrimPrefix(policy, "queue:"))
+		if err != nil || queueSize < 1 || queueSize > 100 {
+			return SkipPolicy{}, fmt.Errorf("Queue size of '%v' is not "+
+				"in [1,100]", policy)
+		}
+		return SkipPolicy{Kind: SKIP_POLICY_QUEUE, QueueSize: queueSize}, nil
+	default:
+		return SkipPolicy{}, fmt.Errorf("'%v' is not in "+
+			"{drop-old, queue:<N>, block}", policy)
+	}
+}
+
+func (policy SkipPolicy) check() error {
+	switch policy.Kind {
+	case SKIP_POLICY_DROP_OLD, SKIP_POLICY_BLOCK:
+		return nil
+	case SKIP_POLICY_QUEUE:
+		return checkIntInRange("QueueSize", policy.QueueSize, 1, 100)
+	default:
+		return fmt.Errorf("Unknown skip policy kind %v", policy.Kind)
+	}
+}
+
+// The maximum number of buffered TURNs, or 0 if unbounded.
+func (policy SkipPolicy) bufferSize() int {
+	switch policy.Kind {
+	case SKIP_POLICY_QUEUE:
+		return policy.QueueSize
+	case SKIP_POLICY_BLOCK:
+		return 0
+	default:
+		return 1
+	}
+}
+
 type PlayerOrVisuClient struct {
 	client          *Client
 	playerID        int
 	isPlayer        bool
 	isSpecialPlayer bool
-	gameStarts      chan MessageGameStarts
-	newTurn         chan MessageTurn
-	gameEnds        chan MessageGameEnds
-	playerInfo      *PlayerInformation
+	// Agents are players that control several player slots.
+	isAgent bool
+	// Number of player slots (1 for players and special players, 0 for
+	// visualizations) and their IDs, set when the game starts.
+	nbSlots      int
+	playerIDs    []int
+	games        *gameEventQueue
+	turns        *turnMailbox
+	skipPolicy   SkipPolicy
+	maxFrameRate float64 // TURNs per second, unlimited if 0
+	// game_state_encoding, full if empty
+	encoding         string
+	keyframeInterval int
+
+	// Cumulative results of a player over the session, owned by the
+	// coordinator. nil for visualizations.
+	result *SessionResult
+
+	// Read by the game logic goroutine to block the game
+	// (SKIP_POLICY_BLOCK only).
+	turnAckMutex     sync.Mutex
+	lastEpisodeAcked int
+	lastTurnAcked    int
+	hasLeft          bool
+}
+
+// A gameEvent tells a player or visu goroutine that an episode starts or
+// ends. Exactly one of gameStarts and gameEnds is set.
+type gameEvent struct {
+	gameStarts *protocol.MessageGameStarts
+	gameEnds   *protocol.MessageGameEnds
+	// The action schema of the episode that starts, nil if the game logic
+	// did not declare it.
+	actionSchema *protocol.ActionSchema
+	// The episode that starts (see episodeInfo.id).
+	episode int
+	// Whether the client leaves after this GAME_ENDS.
+	last bool
+}
+
+// gameEventQueue holds the game events issued by the game logic goroutine
+// that have not been handled by a client goroutine yet. As the turn
+// mailbox, pushing an event never blocks, even if the client has left.
+type gameEventQueue struct {
+	mutex  sync.Mutex
+	events []gameEvent
+	// Notified when an event is pushed
+	notify chan struct{}
 }
 
-func waitPlayerOrVisuFinition(pvClient *PlayerOrVisuClient) {
+func newGameEventQueue() *gameEventQueue {
+	return &gameEventQueue{
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (queue *gameEventQueue) push(event gameEvent) {
+	queue.mutex.Lock()
+	queue.events = append(queue.events, event)
+	queue.mutex.Unlock()
+
+	select {
+	case queue.notify <- struct{}{}:
+	default:
+		// A notification is already pending.
+	}
+}
+
+// popAll removes and returns the events pushed since the last call,
+// in the order they have been pushed.
+func (queue *gameEventQueue) popAll() []gameEvent {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	events := queue.events
+	queue.events = nil
+	return events
+}
+
+// turnMailbox holds the TURNs issued by the game logic goroutine that have
+// not been sent to a client yet. Pushing a TURN never blocks, so that slow
+// clients cannot stall the game.
+type turnMailbox struct {
+	mutex   sync.Mutex
+	turns   []pendingTurn
+	maxSize int // unbounded if 0
+	// Notified when a TURN is pushed
+	notify chan struct{}
+}
+
+// A pendingTurn is a TURN whose game state has not been serialized yet.
+type pendingTurn struct {
+	protocol.MessageTurn
+	gameState *gameStatePayload
+	episode   int
+}
+
+func newTurnMailbox(skipPolicy SkipPolicy) *turnMailbox {
+	return &turnMailbox{
+		turns:   make([]pendingTurn, 0, 1),
+		maxSize: skipPolicy.bufferSize(),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// push adds a TURN to the mailbox. If the mailbox is full, its oldest TURN
+// is dropped and counted in the skipped_turns of the next one.
+func (mailbox *turnMailbox) push(turn pendingTurn) {
+	mailbox.mutex.Lock()
+	mailbox.turns = append(mailbox.turns, turn)
+	if mailbox.maxSize > 0 && len(mailbox.turns) > mailbox.maxSize {
+		dropped := mailbox.turns[0]
+		next := &mailbox.turns[1]
+		if dropped.episode == next.episode {
+			next.SkippedTurns += dropped.SkippedTurns + 1
+			// Feedback is not game state: it must not be lost.
+			next.Feedback = mergeFeedback(dropped.Feedback, next.Feedback)
+		}
+
+		copy(mailbox.turns, mailbox.turns[1:])
+		mailbox.turns = mailbox.turns[:len(mailbox.turns)-1]
+	}
+	mailbox.mutex.Unlock()
+
+	select {
+	case mailbox.notify <- struct{}{}:
+	default:
+		// A notification is already pending.
+	}
+}
+
+// pop removes the oldest TURN from the mailbox.
+func (mailbox *turnMailbox) pop() (pendingTurn, bool) {
+	mailbox.mutex.Lock()
+	defer mailbox.mutex.Unlock()
+	if len(mailbox.turns) == 0 {
+		return pendingTurn{}, false
+	}
+
+	turn := mailbox.turns[0]
+	copy(mailbox.turns, mailbox.turns[1:])
+	mailbox.turns = mailbox.turns[:len(mailbox.turns)-1]
+	return turn, true
+}
+
+// hasTurn returns whether the oldest TURN of the mailbox belongs to the
+// given episode. The TURNs of previous episodes are dropped.
+func (mailbox *turnMailbox) hasTurn(episode int) bool {
+	mailbox.mutex.Lock()
+	defer mailbox.mutex.Unlock()
+	for len(mailbox.turns) > 0 && mailbox.turns[0].episode < episode {
+		copy(mailbox.turns, mailbox.turns[1:])
+		mailbox.turns = mailbox.turns[:len(mailbox.turns)-1]
+	}
+	return len(mailbox.turns) > 0 && mailbox.turns[0].episode == episode
+}
+
+func waitPlayerOrVisuFinition(ctx context.Context,
+	pvClient *PlayerOrVisuClient) {
 	for {
 		select {
-		case kickReason := <-pvClient.client.canTerminate:
-			Kick(pvClient.client, kickReason)
+		case <-ctx.Done():
+			abortPlayerOrVisu(pvClient)
 			return
 		case <-pvClient.client.incomingMessages:
 		}
 	}
 }
 
-func handlePlayerOrVisu(pvClient *PlayerOrVisuClient,
+// abortPlayerOrVisu kicks a client when netorcai aborts.
+// A final GAME_ENDS that has already been issued by the game logic goroutine
+// (e.g., during a graceful shutdown) is sent to the client first.
+func abortPlayerOrVisu(pvClient *PlayerOrVisuClient) {
+	events := pvClient.games.popAll()
+	if len(events) > 0 && events[len(events)-1].last &&
+		pvClient.client.state != CLIENT_KICKED {
+		sendGameEnds(pvClient.client, *events[len(events)-1].gameEnds)
+	}
+	Kick(pvClient.client, "netorcai abort")
+}
+
+func handlePlayerOrVisu(ctx context.Context, pvClient *PlayerOrVisuClient,
 	globalState *GlobalState) {
-	turnBuffer := make([]MessageTurn, 0)
+	// The episode of the last GAME_STARTS sent, whose TURNs can be sent.
+	episode := -1
 	lastTurnNumberSent := -1
+	lastTurnActionable := false
+	// The slots of an agent, and those that can act on the last TURN sent.
+	var agentPlayerIDs []int
+	var lastActionablePlayerIDs []int
+	// The turn number of a TURN whose episode has ended while the client was
+	// thinking about it, -1 if there is none. The client still acknowledges
+	// it before any other message.
+	lateTurnAck := -1
+	// The action schema of the episode (nil if there is none), and the
+	// actions it rejected, reported in the next TURN.
+	var actionSchema *protocol.ActionSchema
+	invalidActions := []protocol.InvalidAction{}
+	// The game state known by the client, from which deltas are computed,
+	// and the ID of its payload (0 for the initial game state).
+	var lastGameStateSent map[string]interface{}
+	var lastPayloadSent uint64
+	// Number of TURNs sent since the last whole game state (GAME_STARTS
+	// or a TURN without patch).
+	turnsSinceKeyframe := 0
+	// Frame rate limitation
+	var minFrameInterval time.Duration
+	if pvClient.maxFrameRate > 0 {
+		minFrameInterval = time.Duration(float64(time.Second) /
+			pvClient.maxFrameRate)
+	}
+	var lastTurnSentAt time.Time
+	var nextFrame <-chan time.Time
 	var glClient *GameLogicClient
 
-	for {
-		select {
-		case kickReason := <-pvClient.client.canTerminate:
-			Kick(pvClient.client, kickReason)
-			return
-		case gameStarts := <-pvClient.gameStarts:
-			// A game start has been received.
-			err := sendGameStarts(pvClient.client, gameStarts)
-			if err != nil {
-				KickLoggedPlayerOrVisu(pvClient, globalState,
-					fmt.Sprintf("Cannot send GAME_STARTS. %v", err.Error()))
-				return
-			}
-			pvClient.client.state = CLIENT_READY
-
-			// Set glClient from the global state now
-			LockGlobalStateMutex(globalState, "Local copy of GL pointer", "client")
-			glClient = globalState.GameLogic[0]
-			UnlockGlobalStateMutex(globalState, "Local copy of GL pointer", "client")
-		case gameEnds := <-pvClient.gameEnds:
-			// A game end has been received.
-			err := sendGameEnds(pvClient.client, gameEnds)
-			if err != nil {
-				KickLoggedPlayerOrVisu(pvClient, globalState,
-					fmt.Sprintf("Cannot send GAME_ENDS. %v", err.Error()))
-				return
+	if pvClient.skipPolicy.Kind == SKIP_POLICY_BLOCK {
+		defer func() {
+			pvClient.turnAckMutex.Lock()
+			pvClient.hasLeft = true
+			pvClient.turnAckMutex.Unlock()
+			if glClient != nil {
+				notifyTurnAcked(glClient)
 			}
+		}()
+	}
 
-			// Leave the client
-			Kick(pvClient.client, "Game is finished")
-			waitPlayerOrVisuFinition(pvClient)
+	for {
+		select {
+		case <-ctx.Done():
+			abortPlayerOrVisu(pvClient)
 			return
-		case turn := <-pvClient.newTurn:
+		case <-pvClient.games.notify:
+			// An episode starts or ends (handled below).
+		case <-pvClient.turns.notify:
 			// A new turn has been received.
 			log.WithFields(log.Fields{
 				"playerID": pvClient.playerID,
 			}).Debug("Client received a new TURN (from GL goroutine)")
-
-			if pvClient.client.state == CLIENT_READY {
-				// The client is ready, the message can be sent right now.
-				lastTurnNumberSent = turn.TurnNumber
-				err := sendTurn(pvClient.client, turn)
-				if err != nil {
-					KickLoggedPlayerOrVisu(pvClient, globalState,
-						fmt.Sprintf("Cannot send TURN. %v", err.Error()))
-					return
-				}
-				pvClient.client.state = CLIENT_THINKING
-			} else if pvClient.client.state == CLIENT_THINKING {
-				// The client is still computing something (its decisions for
-				// a player, or just updating its display for a visualization).
-				// The turn message is therefore buffered.
-				if len(turnBuffer) > 0 {
-					// Update the turn buffer with the new message.
-					turnBuffer[0] = turn
-				} else {
-					// Put the new message into the turn buffer.
-					turnBuffer = append(turnBuffer, turn)
-				}
-			}
+		case <-nextFrame:
+			nextFrame = nil
 		case msg := <-pvClient.client.incomingMessages:
 			// A new message has been received from the player socket.
 			if msg.err != nil {
@@ -101,8 +316,34 @@ func handlePlayerOrVisu(pvClient *PlayerOrVisuClient,
 					fmt.Sprintf("Cannot read TURN_ACK. %v", msg.err.Error()))
 				return
 			}
-			turnAckMsg, err := readTurnAckMessage(msg.content,
-				lastTurnNumberSent)
+
+			if lateTurnAck != -1 {
+				// The acknowledgement of a TURN of a finished episode.
+				err := readLateTurnAck(pvClient, msg.content, lateTurnAck)
+				if err != nil {
+					KickLoggedPlayerOrVisu(pvClient, globalState,
+						fmt.Sprintf("Invalid TURN_ACK received. %v",
+							err.Error()))
+					return
+				}
+				lateTurnAck = -1
+				break
+			}
+
+			var turnAckMsg protocol.MessageTurnAck
+			var agentTurnAckMsg protocol.MessageAgentTurnAck
+			var err error
+			if pvClient.isAgent {
+				agentTurnAckMsg, err = protocol.ReadAgentTurnAckMessage(
+					msg.content, lastTurnNumberSent)
+				if err == nil {
+					err = checkAgentPlayerIDs(agentTurnAckMsg, agentPlayerIDs)
+				}
+				turnAckMsg.TurnNumber = agentTurnAckMsg.TurnNumber
+			} else {
+				turnAckMsg, err = protocol.ReadTurnAckMessage(msg.content,
+					lastTurnNumberSent)
+			}
 			if err != nil {
 				KickLoggedPlayerOrVisu(pvClient, globalState,
 					fmt.Sprintf("Invalid TURN_ACK received. %v",
@@ -121,144 +362,319 @@ func handlePlayerOrVisu(pvClient *PlayerOrVisuClient,
 				return
 			}
 
-			if pvClient.isPlayer {
-				// Forward the player actions to the game logic
-				glClient.playerAction <- MessageDoTurnPlayerAction{
-					PlayerID:   pvClient.playerID,
-					TurnNumber: turnAckMsg.turnNumber,
-					Actions:    turnAckMsg.actions,
+			if pvClient.isPlayer && lastTurnActionable {
+				// The actions of each slot that can act, an agent may only
+				// control one of them.
+				actions := map[int][]interface{}{
+					pvClient.playerID: turnAckMsg.Actions,
+				}
+				actionablePlayerIDs := []int{pvClient.playerID}
+				if pvClient.isAgent {
+					actions = make(map[int][]interface{})
+					for _, slotActions := range agentTurnAckMsg.PlayerActions {
+						actions[slotActions.PlayerID] = slotActions.Actions
+					}
+					actionablePlayerIDs = lastActionablePlayerIDs
+				}
+
+				for _, playerID := range actionablePlayerIDs {
+					slotActions := actions[playerID]
+					if slotActions == nil {
+						slotActions = []interface{}{}
+					}
+
+					// Drop the actions that do not follow the action schema
+					if actionSchema != nil {
+						var rejected []protocol.InvalidAction
+						slotActions, rejected = filterActions(actionSchema,
+							protocol.MessageTurnAck{
+								TurnNumber: turnAckMsg.TurnNumber,
+								Actions:    slotActions,
+							})
+						if len(rejected) > 0 {
+							log.WithFields(log.Fields{
+								"playerID":           playerID,
+								"turn number":        turnAckMsg.TurnNumber,
+								"nb invalid actions": len(rejected),
+							}).Debug("Dropping invalid player actions")
+							if pvClient.isAgent {
+								for index := range rejected {
+									rejectedPlayerID := playerID
+									rejected[index].PlayerID = &rejectedPlayerID
+								}
+							}
+							invalidActions = append(invalidActions,
+								rejected...)
+						}
+					}
+
+					// Forward the player actions to the game logic
+					select {
+					case glClient.playerAction <- playerAction{
+						MessageDoTurnPlayerAction: protocol.MessageDoTurnPlayerAction{
+							PlayerID:   playerID,
+							TurnNumber: turnAckMsg.TurnNumber,
+							Actions:    slotActions,
+						},
+						episode: episode,
+					}:
+					case <-ctx.Done():
+						abortPlayerOrVisu(pvClient)
+						return
+					}
 				}
 			}
 
-			// If a TURN is buffered, send it right now.
-			if len(turnBuffer) > 0 {
-				lastTurnNumberSent = turnBuffer[0].TurnNumber
-				err := sendTurn(pvClient.client, turnBuffer[0])
+			if pvClient.skipPolicy.Kind == SKIP_POLICY_BLOCK {
+				// Let the game logic goroutine go on
+				pvClient.turnAckMutex.Lock()
+				pvClient.lastEpisodeAcked = episode
+				pvClient.lastTurnAcked = turnAckMsg.TurnNumber
+				pvClient.turnAckMutex.Unlock()
+				notifyTurnAcked(glClient)
+			}
+
+			pvClient.client.state = CLIENT_READY
+		}
+
+		// Episodes start and end in order. The TURNs of an episode are only
+		// sent after its GAME_STARTS, and those left when it ends are dropped.
+		for _, event := range pvClient.games.popAll() {
+			if event.gameStarts != nil {
+				err := sendGameStarts(pvClient.client, *event.gameStarts)
 				if err != nil {
 					KickLoggedPlayerOrVisu(pvClient, globalState,
-						fmt.Sprintf("Cannot send TURN. %v", err.Error()))
+						fmt.Sprintf("Cannot send GAME_STARTS. %v",
+							err.Error()))
 					return
 				}
-
-				// Empty turn buffer
-				turnBuffer = turnBuffer[:0]
-				pvClient.client.state = CLIENT_THINKING
-			} else {
 				pvClient.client.state = CLIENT_READY
-			}
-		}
-	}
-}
-
-func KickLoggedPlayerOrVisu(pvClient *PlayerOrVisuClient,
-	gs *GlobalState, reason string) {
-	// Remove the client from the global state
-	LockGlobalStateMutex(gs, "Kick player or visu", "player/visu")
+				episode = event.episode
+				agentPlayerIDs = event.gameStarts.PlayerIDs
+				actionSchema = event.actionSchema
+				lastTurnNumberSent = -1
+				lastTurnActionable = false
+				invalidActions = []protocol.InvalidAction{}
+				lastGameStateSent = event.gameStarts.InitialGameState
+				lastPayloadSent = 0
+				turnsSinceKeyframe = 0
 
-	if pvClient.isPlayer {
-		// Mark the player as disconnected
-		if pvClient.playerInfo != nil {
-			pvClient.playerInfo.IsConnected = false
-		}
+				if pvClient.skipPolicy.Kind == SKIP_POLICY_BLOCK {
+					pvClient.turnAckMutex.Lock()
+					pvClient.lastEpisodeAcked = episode
+					pvClient.lastTurnAcked = -1
+					pvClient.turnAckMutex.Unlock()
+				}
 
-		if pvClient.isSpecialPlayer {
-			// Locate the player in the array
-			playerIndex := -1
-			for index, player := range gs.SpecialPlayers {
-				if player.client == pvClient.client {
-					playerIndex = index
-					break
+				// Set glClient from the global state now
+				gameLogic := gameLogicEvent{reply: make(chan *GameLogicClient, 1)}
+				if submit(globalState, gameLogic) {
+					glClient = <-gameLogic.reply
 				}
+				continue
 			}
 
-			if gs.GameState == GAME_RUNNING && gs.Fast {
-				gs.GameLogic[0].playerDisconnected <- pvClient.playerID
+			// A game end has been received.
+			err := sendGameEnds(pvClient.client, *event.gameEnds)
+			if err != nil {
+				KickLoggedPlayerOrVisu(pvClient, globalState,
+					fmt.Sprintf("Cannot send GAME_ENDS. %v", err.Error()))
+				return
 			}
 
-			if playerIndex != -1 {
-				// Remove the player by placing it at the end of the slice,
-				// then reducing the slice length
-				gs.SpecialPlayers[len(gs.SpecialPlayers)-1], gs.SpecialPlayers[playerIndex] = gs.SpecialPlayers[playerIndex], gs.SpecialPlayers[len(gs.SpecialPlayers)-1]
-				gs.SpecialPlayers = gs.SpecialPlayers[:len(gs.SpecialPlayers)-1]
-			}
-		} else {
-			// Locate the player in the array
-			playerIndex := -1
-			for index, player := range gs.Players {
-				if player.client == pvClient.client {
-					playerIndex = index
-					break
-				}
+			if event.last {
+				// Leave the client
+				Kick(pvClient.client, protocol.KickReasonGameFinished)
+				waitPlayerOrVisuFinition(ctx, pvClient)
+				return
 			}
 
-			if gs.GameState == GAME_RUNNING && gs.Fast {
-				gs.GameLogic[0].playerDisconnected <- pvClient.playerID
+			// Wait for the next episode
+			if pvClient.client.state == CLIENT_THINKING {
+				lateTurnAck = lastTurnNumberSent
 			}
+			pvClient.client.state = CLIENT_LOGGED
+		}
+
+		// Send the next TURN if the client is ready for it.
+		// Otherwise, TURNs stay in the mailbox, which applies the skip policy.
+		if pvClient.client.state != CLIENT_READY || nextFrame != nil ||
+			!pvClient.turns.hasTurn(episode) {
+			continue
+		}
 
-			if playerIndex != -1 {
-				// Remove the player by placing it at the end of the slice,
-				// then reducing the slice length
-				gs.Players[len(gs.Players)-1], gs.Players[playerIndex] = gs.Players[playerIndex], gs.Players[len(gs.Players)-1]
-				gs.Players = gs.Players[:len(gs.Players)-1]
+		if wait := minFrameInterval - time.Since(lastTurnSentAt); wait > 0 {
+			nextFrame = time.After(wait)
+			continue
+		}
+
+		turn, _ := pvClient.turns.pop()
+		lastTurnNumberSent = turn.TurnNumber
+		lastTurnActionable = turn.Actionable
+		lastActionablePlayerIDs = turn.ActionablePlayerIDs
+		turn.InvalidActions = invalidActions
+		invalidActions = []protocol.InvalidAction{}
+		turnsSinceKeyframe++
+		encoding := pvClient.encoding
+		if turnsSinceKeyframe == pvClient.keyframeInterval {
+			encoding = "full"
+		}
+		gameState, err := turn.gameState.serialize(
+			pvClient.client.getSerialization(), encoding, lastGameStateSent,
+			lastPayloadSent)
+		if err == nil {
+			if gameState.field == "game_state" {
+				turnsSinceKeyframe = 0
 			}
+			lastGameStateSent = turn.gameState.gameState
+			lastPayloadSent = turn.gameState.id
+			err = sendTurn(pvClient.client, turn.MessageTurn, gameState)
+		}
+		if err != nil {
+			KickLoggedPlayerOrVisu(pvClient, globalState,
+				fmt.Sprintf("Cannot send TURN. %v", err.Error()))
+			return
 		}
+		lastTurnSentAt = time.Now()
+		pvClient.client.state = CLIENT_THINKING
+	}
+}
+
+// readLateTurnAck reads the TURN_ACK of a TURN whose episode has ended.
+// Its actions are ignored.
+func readLateTurnAck(pvClient *PlayerOrVisuClient,
+	content map[string]interface{}, turnNumber int) error {
+	var err error
+	if pvClient.isAgent {
+		_, err = protocol.ReadAgentTurnAckMessage(content, turnNumber)
 	} else {
-		// Locate the visu in the array
-		visuIndex := -1
-		for index, visu := range gs.Visus {
-			if visu.client == pvClient.client {
-				visuIndex = index
-				break
-			}
+		_, err = protocol.ReadTurnAckMessage(content, turnNumber)
+	}
+	return err
+}
+
+// checkAgentPlayerIDs checks that an agent only sends the actions of the
+// slots it controls, at most once per slot.
+func checkAgentPlayerIDs(turnAck protocol.MessageAgentTurnAck,
+	agentPlayerIDs []int) error {
+	controlled := make(map[int]bool)
+	for _, playerID := range agentPlayerIDs {
+		controlled[playerID] = true
+	}
+
+	seen := make(map[int]bool)
+	for _, slotActions := range turnAck.PlayerActions {
+		if !controlled[slotActions.PlayerID] {
+			return fmt.Errorf("player_id %v is not controlled by the agent",
+				slotActions.PlayerID)
+		} else if seen[slotActions.PlayerID] {
+			return fmt.Errorf("Several actions for player_id %v",
+				slotActions.PlayerID)
 		}
+		seen[slotActions.PlayerID] = true
+	}
+	return nil
+}
+
+// isBehind returns whether a client with the block skip policy has not
+// acknowledged the TURN of the given episode whose number is turnNumber yet.
+func (pvClient *PlayerOrVisuClient) isBehind(episode, turnNumber int) bool {
+	if pvClient.skipPolicy.Kind != SKIP_POLICY_BLOCK {
+		return false
+	}
+
+	pvClient.turnAckMutex.Lock()
+	defer pvClient.turnAckMutex.Unlock()
+	return !pvClient.hasLeft && (pvClient.lastEpisodeAcked < episode ||
+		(pvClient.lastEpisodeAcked == episode &&
+			pvClient.lastTurnAcked < turnNumber))
+}
 
-		if visuIndex != -1 {
-			// Remove the visu by placing it at the end of the slice,
-			// then reducing the slice length
-			gs.Visus[len(gs.Visus)-1], gs.Visus[visuIndex] = gs.Visus[visuIndex], gs.Visus[len(gs.Visus)-1]
-			gs.Visus = gs.Visus[:len(gs.Visus)-1]
+func notifyTurnAcked(glClient *GameLogicClient) {
+	select {
+	case glClient.turnAcked <- struct{}{}:
+	default:
+		// A notification is already pending.
+	}
+}
+
+// filterActions splits the actions of a TURN_ACK into those that follow
+// the action schema and a description of those that do not.
+func filterActions(schema *protocol.ActionSchema,
+	turnAck protocol.MessageTurnAck) ([]interface{}, []protocol.InvalidAction) {
+	valid := make([]interface{}, 0, len(turnAck.Actions))
+	invalid := []protocol.InvalidAction{}
+	for index, action := range turnAck.Actions {
+		err := schema.Validate(action)
+		if err != nil {
+			invalid = append(invalid, protocol.InvalidAction{
+				TurnNumber: turnAck.TurnNumber,
+				Index:      index,
+				Error:      err.Error(),
+			})
+		} else {
+			valid = append(valid, action)
 		}
 	}
+	return valid, invalid
+}
 
-	UnlockGlobalStateMutex(gs, "Kick player or visu", "player/visu")
+// mergeFeedback returns a new slice, as feedback slices are shared between
+// the TURN messages of all visualizations.
+func mergeFeedback(older, newer []protocol.PlayerFeedback) []protocol.PlayerFeedback {
+	if len(older) == 0 {
+		return newer
+	}
+	merged := make([]protocol.PlayerFeedback, 0, len(older)+len(newer))
+	merged = append(merged, older...)
+	return append(merged, newer...)
+}
+
+func KickLoggedPlayerOrVisu(pvClient *PlayerOrVisuClient,
+	gs *GlobalState, reason string) {
+	// Remove the client from the global state
+	submit(gs, leaveEvent{pvClient: pvClient})
 
 	// Kick the client
 	Kick(pvClient.client, reason)
 }
 
-func sendGameStarts(client *Client, msg MessageGameStarts) error {
-	content, err := json.Marshal(msg)
+func sendGameStarts(client *Client, msg protocol.MessageGameStarts) error {
+	content, err := marshalMessage(client, msg)
 	if err == nil {
 		log.WithFields(log.Fields{
 			"nickname":       client.nickname,
 			"remote address": client.Conn.RemoteAddr(),
-			"content":        string(content),
+			"content":        printableContent(client, content),
 		}).Debug("Sending GAME_STARTS to client")
 		err = sendMessage(client, content)
 	}
 	return err
 }
 
-func sendTurn(client *Client, msg MessageTurn) error {
-	content, err := json.Marshal(msg)
+// sendTurn sends a TURN whose game state has already been serialized.
+func sendTurn(client *Client, msg protocol.MessageTurn,
+	gameState serializedPayload) error {
+	content, err := protocol.MarshalTurn(msg, client.getSerialization(),
+		gameState.field, gameState.content)
 	if err == nil {
 		log.WithFields(log.Fields{
 			"nickname":       client.nickname,
 			"remote address": client.Conn.RemoteAddr(),
-			"content":        string(content),
+			"content":        printableContent(client, content),
 		}).Debug("Sending TURN to client")
 		err = sendMessage(client, content)
 	}
 	return err
 }
 
-func sendGameEnds(client *Client, msg MessageGameEnds) error {
-	content, err := json.Marshal(msg)
+func sendGameEnds(client *Client, msg protocol.MessageGameEnds) error {
+	content, err := marshalMessage(client, msg)
 	if err == nil {
 		log.WithFields(log.Fields{
 			"nickname":       client.nickname,
 			"remote address": client.Conn.RemoteAddr(),
-			"content":        string(content),
+			"content":        printableContent(client, content),
 		}).Debug("Sending GAME_ENDS to client")
 		err = sendMessage(client, content)
 	}
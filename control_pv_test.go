@@ -0,0 +1,30 @@
+package netorcai
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseSkipPolicy(t *testing.T) {
+	policy, err := ParseSkipPolicy("drop-old")
+	assert.NoError(t, err, "Error on drop-old")
+	assert.Equal(t, SkipPolicy{Kind: SKIP_POLICY_DROP_OLD}, policy)
+	assert.Equal(t, 1, policy.bufferSize())
+
+	policy, err = ParseSkipPolicy("queue:10")
+	assert.NoError(t, err, "Error on queue:10")
+	assert.Equal(t, SkipPolicy{Kind: SKIP_POLICY_QUEUE, QueueSize: 10},
+		policy)
+	assert.Equal(t, 10, policy.bufferSize())
+
+	policy, err = ParseSkipPolicy("block")
+	assert.NoError(t, err, "Error on block")
+	assert.Equal(t, SkipPolicy{Kind: SKIP_POLICY_BLOCK}, policy)
+	assert.Equal(t, 0, policy.bufferSize())
+
+	for _, invalid := range []string{"", "drop", "queue", "queue:",
+		"queue:0", "queue:101", "queue:-1", "queue:meh"} {
+		_, err = ParseSkipPolicy(invalid)
+		assert.Error(t, err, "No error on '%v'", invalid)
+	}
+}
@@ -0,0 +1,659 @@
+package netorcai
+
+import (
+	"context"
+	"fmt"
+	"github.com/mpoquet/go-prompt"
+	"github.com/netorcai/netorcai/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// The global state is owned by the coordinator goroutine.
+// Other goroutines never access it directly: they submit events to the
+// coordinator, which applies them one at a time. Applying an event must
+// never block, so that the coordinator is always available.
+type coordinatorEvent interface {
+	apply(gs *GlobalState)
+}
+
+// runCoordinator applies the submitted events until stopCoordinator is called.
+func runCoordinator(gs *GlobalState) {
+	for {
+		select {
+		case event := <-gs.events:
+			event.apply(gs)
+		case <-gs.coordinatorStopped:
+			return
+		}
+	}
+}
+
+// stopCoordinator stops the coordinator. Events submitted afterwards are
+// ignored.
+func stopCoordinator(gs *GlobalState) {
+	close(gs.coordinatorStopped)
+}
+
+// submit sends an event to the coordinator. Once submit returns true, the
+// event has been applied (or is being applied) and will reply, if it does.
+// false is returned if the coordinator has stopped.
+func submit(gs *GlobalState, event coordinatorEvent) bool {
+	select {
+	case gs.events <- event:
+		return true
+	case <-gs.coordinatorStopped:
+		return false
+	}
+}
+
+// nbPlayerSlots returns the number of player slots of players, as an agent
+// controls several of them.
+func nbPlayerSlots(players []*PlayerOrVisuClient) int {
+	nbSlots := 0
+	for _, player := range players {
+		nbSlots += player.nbSlots
+	}
+	return nbSlots
+}
+
+func areAllExpectedClientsConnected(gs *GlobalState) bool {
+	return (nbPlayerSlots(gs.Players) == gs.NbPlayersMax) &&
+		(len(gs.SpecialPlayers) == gs.NbSpecialPlayersMax) &&
+		(len(gs.Visus) == gs.NbVisusMax) &&
+		(len(gs.GameLogic) == 1)
+}
+
+func autostart(gs *GlobalState) {
+	if gs.Autostart && gs.GameState == GAME_NOT_RUNNING &&
+		areAllExpectedClientsConnected(gs) {
+		log.Info("Automatic starting conditions are met")
+		gs.GameState = GAME_RUNNING
+		gs.GameLogic[0].start <- 1
+	}
+}
+
+// Logging a client in takes three steps, so that the coordinator never
+// waits for the client to read LOGIN_ACK.
+// First, a loginEvent reserves a slot for the client or denies it.
+// Then, the client goroutine sends LOGIN_ACK.
+// Finally, a loginCommitEvent adds the client to the global state, or a
+// loginRollbackEvent releases its slot if LOGIN_ACK could not be sent.
+// Game starts wait for the pending logins, so that every client that has
+// received LOGIN_ACK takes part in the game.
+type loginEvent struct {
+	client *Client
+	login  protocol.MessageLogin
+	reply  chan loginReservation
+}
+
+type loginReservation struct {
+	// The compression algorithm to announce in LOGIN_ACK
+	compression string
+	// Why the client has been denied ("" if its slot is reserved).
+	denial string
+}
+
+// A pendingLogin is a client whose slot is reserved while it is sent
+// LOGIN_ACK.
+type pendingLogin struct {
+	client *Client
+	login  protocol.MessageLogin
+}
+
+func newLoginEvent(client *Client, login protocol.MessageLogin) loginEvent {
+	return loginEvent{
+		client: client,
+		login:  login,
+		reply:  make(chan loginReservation, 1),
+	}
+}
+
+// reservedSlots returns the number of player slots, special players, visus
+// and game logics reserved by the pending logins.
+func reservedSlots(gs *GlobalState) (nbPlayerSlots, nbSpecialPlayers,
+	nbVisus, nbGameLogics int) {
+	for _, pending := range gs.pendingLogins {
+		switch pending.login.Role {
+		case "player":
+			nbPlayerSlots++
+		case "agent":
+			nbPlayerSlots += pending.login.NbSlots
+		case "special player":
+			nbSpecialPlayers++
+		case "visualization":
+			nbVisus++
+		default:
+			nbGameLogics++
+		}
+	}
+	return nbPlayerSlots, nbSpecialPlayers, nbVisus, nbGameLogics
+}
+
+func (event loginEvent) apply(gs *GlobalState) {
+	denial := event.check(gs)
+	if denial != "" {
+		event.reply <- loginReservation{denial: denial}
+		return
+	}
+
+	gs.pendingLogins = append(gs.pendingLogins,
+		pendingLogin{client: event.client, login: event.login})
+	compression := ""
+	if !gs.DisableCompression {
+		compression = protocol.ChooseCompression(event.login.Compression)
+	}
+	event.reply <- loginReservation{compression: compression}
+}
+
+// check returns why the client cannot log in, or "" if it can.
+func (event loginEvent) check(gs *GlobalState) string {
+	isSpecial := event.login.Role == "special player"
+	nbSlots := 1
+	if event.login.Role == "agent" {
+		nbSlots = event.login.NbSlots
+	}
+	reservedPlayerSlots, reservedSpecialPlayers, reservedVisus,
+		reservedGameLogics := reservedSlots(gs)
+	switch event.login.Role {
+	case "player", "special player", "agent":
+		if gs.GameState != GAME_NOT_RUNNING {
+			return "Game has been started"
+		} else if !isSpecial && nbPlayerSlots(gs.Players)+
+			reservedPlayerSlots+nbSlots > gs.NbPlayersMax {
+			return "Maximum number of players reached"
+		} else if isSpecial && len(gs.SpecialPlayers)+
+			reservedSpecialPlayers >= gs.NbSpecialPlayersMax {
+			return "Maximum number of special players reached"
+		}
+	case "visualization":
+		if len(gs.Visus)+reservedVisus >= gs.NbVisusMax {
+			return "Maximum number of visus reached"
+		}
+	case "game logic":
+		if gs.GameState != GAME_NOT_RUNNING {
+			return "Game has been started"
+		} else if len(gs.GameLogic)+reservedGameLogics >= 1 {
+			return "A game logic is already logged in"
+		}
+	}
+	return ""
+}
+
+// releaseLogin releases the slot reserved for client, if any.
+func releaseLogin(gs *GlobalState, client *Client) {
+	for index, pending := range gs.pendingLogins {
+		if pending.client == client {
+			gs.pendingLogins = append(gs.pendingLogins[:index],
+				gs.pendingLogins[index+1:]...)
+			return
+		}
+	}
+}
+
+// A loginCommitEvent adds a client that has received LOGIN_ACK to the
+// global state. Its player/visu or game logic handle is replied.
+type loginCommitEvent struct {
+	client *Client
+	login  protocol.MessageLogin
+	reply  chan loginResult
+}
+
+type loginResult struct {
+	pvClient *PlayerOrVisuClient
+	glClient *GameLogicClient
+}
+
+func newLoginCommitEvent(client *Client,
+	login protocol.MessageLogin) loginCommitEvent {
+	return loginCommitEvent{
+		client: client,
+		login:  login,
+		reply:  make(chan loginResult, 1),
+	}
+}
+
+func (event loginCommitEvent) apply(gs *GlobalState) {
+	releaseLogin(gs, event.client)
+	result := event.accept(gs)
+	autostart(gs)
+	event.reply <- result
+	applyPendingStarts(gs)
+}
+
+func (event loginCommitEvent) accept(gs *GlobalState) loginResult {
+	client := event.client
+	isSpecial := event.login.Role == "special player"
+	isAgent := event.login.Role == "agent"
+	nbSlots := 1
+	if isAgent {
+		nbSlots = event.login.NbSlots
+	}
+
+	switch event.login.Role {
+	case "player", "special player", "agent":
+		pvClient := &PlayerOrVisuClient{
+			client:           client,
+			playerID:         -1,
+			result:           newSessionResult(gs, client),
+			isPlayer:         true,
+			isSpecialPlayer:  isSpecial,
+			isAgent:          isAgent,
+			nbSlots:          nbSlots,
+			skipPolicy:       gs.PlayerSkipPolicy,
+			encoding:         event.login.GameStateEncoding,
+			keyframeInterval: gs.KeyframeInterval,
+			games:            newGameEventQueue(),
+			lastTurnAcked:    -1,
+		}
+		if isSpecial {
+			pvClient.skipPolicy = gs.SpecialPlayerSkipPolicy
+		}
+		pvClient.turns = newTurnMailbox(pvClient.skipPolicy)
+
+		if !isSpecial {
+			gs.Players = append(gs.Players, pvClient)
+		} else {
+			gs.SpecialPlayers = append(gs.SpecialPlayers, pvClient)
+		}
+
+		log.WithFields(log.Fields{
+			"nickname":             client.nickname,
+			"remote address":       client.Conn.RemoteAddr(),
+			"player count":         nbPlayerSlots(gs.Players),
+			"special player count": len(gs.SpecialPlayers),
+			"special":              isSpecial,
+			"slot count":           nbSlots,
+		}).Info("New player accepted")
+		return loginResult{pvClient: pvClient}
+	case "visualization":
+		pvClient := &PlayerOrVisuClient{
+			client:           client,
+			playerID:         -1,
+			isPlayer:         false,
+			skipPolicy:       gs.VisuSkipPolicy,
+			maxFrameRate:     gs.VisuMaxFrameRate,
+			encoding:         event.login.GameStateEncoding,
+			keyframeInterval: gs.KeyframeInterval,
+			games:            newGameEventQueue(),
+			turns:            newTurnMailbox(gs.VisuSkipPolicy),
+			lastTurnAcked:    -1,
+		}
+		if pvClient.encoding == "" && gs.VisuDeltas {
+			pvClient.encoding = "merge-patch"
+		}
+
+		gs.Visus = append(gs.Visus, pvClient)
+
+		log.WithFields(log.Fields{
+			"nickname":       client.nickname,
+			"remote address": client.Conn.RemoteAddr(),
+			"visu count":     len(gs.Visus),
+		}).Info("New visualization accepted")
+		return loginResult{pvClient: pvClient}
+	default:
+		glClient := &GameLogicClient{
+			client:         client,
+			playerAction:   make(chan playerAction, 1),
+			disconnections: newPlayerDisconnections(),
+			turnAcked:      make(chan struct{}, 1),
+			start:          make(chan int, 1),
+		}
+
+		gs.GameLogic = append(gs.GameLogic, glClient)
+
+		log.WithFields(log.Fields{
+			"nickname":       client.nickname,
+			"remote address": client.Conn.RemoteAddr(),
+		}).Info("Game logic accepted")
+		return loginResult{glClient: glClient}
+	}
+}
+
+// A loginRollbackEvent releases the slot of a client that could not be
+// sent LOGIN_ACK.
+type loginRollbackEvent struct {
+	client *Client
+}
+
+func (event loginRollbackEvent) apply(gs *GlobalState) {
+	releaseLogin(gs, event.client)
+	applyPendingStarts(gs)
+}
+
+// A leaveEvent removes a logged player or visu from the global state.
+// During the game, the game logic goroutine is told that the player has
+// left, so that it marks it as disconnected and stops waiting for its
+// actions (in fast mode).
+type leaveEvent struct {
+	pvClient *PlayerOrVisuClient
+}
+
+// removePlayerOrVisu removes pvClient from clients, if it is there.
+func removePlayerOrVisu(clients []*PlayerOrVisuClient,
+	pvClient *PlayerOrVisuClient) []*PlayerOrVisuClient {
+	for index, client := range clients {
+		if client == pvClient {
+			// Remove the client by placing it at the end of the slice,
+			// then reducing the slice length
+			last := len(clients) - 1
+			clients[index], clients[last] = clients[last], clients[index]
+			return clients[:last]
+		}
+	}
+	return clients
+}
+
+func (event leaveEvent) apply(gs *GlobalState) {
+	pvClient := event.pvClient
+	if pvClient.isPlayer {
+		if gs.GameState == GAME_RUNNING {
+			gs.GameLogic[0].disconnections.push(pvClient)
+		}
+
+		if pvClient.isSpecialPlayer {
+			gs.SpecialPlayers = removePlayerOrVisu(gs.SpecialPlayers,
+				pvClient)
+		} else {
+			gs.Players = removePlayerOrVisu(gs.Players, pvClient)
+		}
+	} else {
+		gs.Visus = removePlayerOrVisu(gs.Visus, pvClient)
+	}
+}
+
+// A startEvent starts the game, on the prompt or Server API request.
+// It waits for the pending logins (see loginEvent).
+type startEvent struct {
+	reply chan error
+}
+
+func (event startEvent) apply(gs *GlobalState) {
+	if len(gs.pendingLogins) > 0 {
+		gs.pendingStarts = append(gs.pendingStarts, event)
+		return
+	}
+	if gs.GameState != GAME_NOT_RUNNING {
+		event.reply <- fmt.Errorf("Game has already been started")
+		return
+	}
+	if len(gs.GameLogic) != 1 {
+		event.reply <- fmt.Errorf("Cannot start: Game logic not connected")
+		return
+	}
+
+	gs.GameState = GAME_RUNNING
+	gs.GameLogic[0].start <- 1
+	event.reply <- nil
+}
+
+// applyPendingStarts applies the start events that were waiting for the
+// pending logins, once there is none left.
+func applyPendingStarts(gs *GlobalState) {
+	if len(gs.pendingLogins) > 0 {
+		return
+	}
+	starts := gs.pendingStarts
+	gs.pendingStarts = nil
+	for _, start := range starts {
+		start.apply(gs)
+	}
+}
+
+func startGame(gs *GlobalState) error {
+	event := startEvent{reply: make(chan error, 1)}
+	if !submit(gs, event) {
+		return fmt.Errorf("Server has stopped")
+	}
+	return <-event.reply
+}
+
+// gameSettings is what the game logic goroutine needs from the global state
+// to run a game: the clients and the game parameters when the game starts.
+type gameSettings struct {
+	players        []*PlayerOrVisuClient
+	specialPlayers []*PlayerOrVisuClient
+	visus          []*PlayerOrVisuClient
+
+	nbTurnsMax        int
+	nbEpisodes        int
+	game              int
+	lastGame          bool
+	msBeforeFirstTurn float64
+	msBetweenTurns    float64
+	fast              bool
+	turnOrder         int
+	visuFeedback      bool
+	msInitTimeout     float64
+	msTurnTimeout     float64
+	// The ID of the last game state payload of the server
+	lastPayloadID *uint64
+}
+
+// A gameSettingsEvent replies the settings of the game that starts.
+type gameSettingsEvent struct {
+	reply chan gameSettings
+}
+
+func (event gameSettingsEvent) apply(gs *GlobalState) {
+	// The players that have left since the game started are not part of it.
+	gs.GameLogic[0].disconnections.popAll()
+
+	event.reply <- gameSettings{
+		players:           append([]*PlayerOrVisuClient(nil), gs.Players...),
+		specialPlayers:    append([]*PlayerOrVisuClient(nil), gs.SpecialPlayers...),
+		visus:             append([]*PlayerOrVisuClient(nil), gs.Visus...),
+		nbTurnsMax:        gs.NbTurnsMax,
+		nbEpisodes:        gs.NbEpisodes,
+		game:              gs.NbGamesPlayed,
+		lastGame:          gs.NbGames != 0 && gs.NbGamesPlayed+1 >= gs.NbGames,
+		msBeforeFirstTurn: gs.MillisecondsBeforeFirstTurn,
+		msBetweenTurns:    gs.MillisecondsBetweenTurns,
+		fast:              gs.Fast,
+		turnOrder:         gs.TurnOrder,
+		visuFeedback:      gs.VisuFeedback,
+		msInitTimeout:     gs.MillisecondsInitTimeout,
+		msTurnTimeout:     gs.MillisecondsTurnTimeout,
+		lastPayloadID:     &gs.lastGameStatePayloadID,
+	}
+}
+
+// A gameEndEvent records the results of a finished game. The game state goes
+// back to GAME_NOT_RUNNING if the session goes on, so that the next game can
+// start as the first one did.
+type gameEndEvent struct {
+	players []*PlayerOrVisuClient
+	// The number of episodes won by each player
+	episodesWon map[*PlayerOrVisuClient]int
+}
+
+func (event gameEndEvent) apply(gs *GlobalState) {
+	gs.NbGamesPlayed++
+	for _, player := range event.players {
+		player.result.NbGames++
+		player.result.NbEpisodesWon += event.episodesWon[player]
+	}
+
+	// A single game has no session to summarize: its results are the
+	// GAME_ENDS of its last episode.
+	if gs.NbGames != 1 {
+		for _, result := range gs.results {
+			if result.NbGames > 0 {
+				log.WithFields(log.Fields{
+					"nickname":       result.Nickname,
+					"remote address": result.RemoteAddress,
+					"games":          result.NbGames,
+					"episodes won":   result.NbEpisodesWon,
+				}).Info("Session results")
+			}
+		}
+	}
+
+	if gs.NbGames != 0 && gs.NbGamesPlayed >= gs.NbGames {
+		gs.GameState = GAME_FINISHED
+		return
+	}
+	gs.GameState = GAME_NOT_RUNNING
+	autostart(gs)
+}
+
+// SessionResult holds the cumulative results of a player over the games of
+// a session (see Config.NbGames). Players keep their results if they leave.
+type SessionResult struct {
+	Nickname      string
+	RemoteAddress string
+	// The number of games played
+	NbGames int
+	// The number of episodes won, over all games
+	NbEpisodesWon int
+}
+
+// newSessionResult registers the results of a new player.
+func newSessionResult(gs *GlobalState, client *Client) *SessionResult {
+	result := &SessionResult{
+		Nickname:      client.nickname,
+		RemoteAddress: client.Conn.RemoteAddr().String(),
+	}
+	gs.results = append(gs.results, result)
+	return result
+}
+
+// A resultsEvent replies a copy of the session results.
+type resultsEvent struct {
+	reply chan []SessionResult
+}
+
+func (event resultsEvent) apply(gs *GlobalState) {
+	results := make([]SessionResult, 0, len(gs.results))
+	for _, result := range gs.results {
+		results = append(results, *result)
+	}
+	event.reply <- results
+}
+
+// A gameLogicEvent replies the logged game logic (nil if there is none).
+type gameLogicEvent struct {
+	reply chan *GameLogicClient
+}
+
+func (event gameLogicEvent) apply(gs *GlobalState) {
+	if len(gs.GameLogic) == 0 {
+		event.reply <- nil
+		return
+	}
+	event.reply <- gs.GameLogic[0]
+}
+
+// A gameStateEvent replies whether the game is running (see GAME_*).
+type gameStateEvent struct {
+	reply chan int
+}
+
+func (event gameStateEvent) apply(gs *GlobalState) {
+	event.reply <- gs.GameState
+}
+
+// A readVariablesEvent replies the values of the given prompt variables.
+type readVariablesEvent struct {
+	names []string
+	reply chan []interface{}
+}
+
+func (event readVariablesEvent) apply(gs *GlobalState) {
+	values := make([]interface{}, 0, len(event.names))
+	for _, name := range event.names {
+		switch name {
+		case "nb-turns-max":
+			values = append(values, gs.NbTurnsMax)
+		case "nb-players-max":
+			values = append(values, gs.NbPlayersMax)
+		case "nb-splayers-max":
+			values = append(values, gs.NbSpecialPlayersMax)
+		case "nb-visus-max":
+			values = append(values, gs.NbVisusMax)
+		case "delay-first-turn":
+			values = append(values, gs.MillisecondsBeforeFirstTurn)
+		case "delay-turns":
+			values = append(values, gs.MillisecondsBetweenTurns)
+		case "gl-init-timeout":
+			values = append(values, gs.MillisecondsInitTimeout)
+		case "gl-turn-timeout":
+			values = append(values, gs.MillisecondsTurnTimeout)
+		default:
+			values = append(values, nil)
+		}
+	}
+	event.reply <- values
+}
+
+// A setVariableEvent sets a prompt variable. The value has already been
+// checked by the prompt: it is an int for nb-* variables and a float64 for
+// the others.
+type setVariableEvent struct {
+	name  string
+	value interface{}
+}
+
+func (event setVariableEvent) apply(gs *GlobalState) {
+	switch event.name {
+	case "nb-turns-max":
+		gs.NbTurnsMax = event.value.(int)
+	case "nb-players-max":
+		gs.NbPlayersMax = event.value.(int)
+	case "nb-splayers-max":
+		gs.NbSpecialPlayersMax = event.value.(int)
+	case "nb-visus-max":
+		gs.NbVisusMax = event.value.(int)
+	case "delay-first-turn":
+		gs.MillisecondsBeforeFirstTurn = event.value.(float64)
+	case "delay-turns":
+		gs.MillisecondsBetweenTurns = event.value.(float64)
+	case "gl-init-timeout":
+		gs.MillisecondsInitTimeout = event.value.(float64)
+	case "gl-turn-timeout":
+		gs.MillisecondsTurnTimeout = event.value.(float64)
+	}
+}
+
+// A promptEvent registers the interactive prompt, to restore the terminal
+// state on cleanup.
+type promptEvent struct {
+	prompt *prompt.Prompt
+}
+
+func (event promptEvent) apply(gs *GlobalState) {
+	gs.prompt = event.prompt
+}
+
+// A cleanupEvent calls abort, which makes all client goroutines kick their
+// client and return, then closes the listening sockets.
+type cleanupEvent struct {
+	abort context.CancelFunc
+	done  chan struct{}
+}
+
+func (event cleanupEvent) apply(gs *GlobalState) {
+	nbClients := len(gs.Players) + len(gs.SpecialPlayers) + len(gs.Visus) +
+		len(gs.GameLogic)
+	if nbClients > 0 {
+		log.Warn("Sending KICK messages to clients")
+	}
+	event.abort()
+
+	log.Warn("Closing listening sockets.")
+	for _, listener := range gs.Listeners {
+		listener.Close()
+	}
+
+	if gs.prompt != nil {
+		log.Warn("Cleaning prompt state.")
+		gs.prompt.TearDown()
+	}
+	close(event.done)
+}
+
+func cleanup(gs *GlobalState, abort context.CancelFunc) {
+	event := cleanupEvent{abort: abort, done: make(chan struct{})}
+	if submit(gs, event) {
+		<-event.done
+	}
+}
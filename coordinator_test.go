@@ -0,0 +1,308 @@
+package netorcai
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func newTestGlobalState() *GlobalState {
+	return &GlobalState{
+		events:             make(chan coordinatorEvent),
+		coordinatorStopped: make(chan struct{}),
+		GameState:          GAME_NOT_RUNNING,
+		NbPlayersMax:       1,
+		NbVisusMax:         1,
+		NbTurnsMax:         100,
+		NbEpisodes:         1,
+		NbGames:            1,
+	}
+}
+
+// newTestClient returns a client whose messages are written to output.
+// Its connection stays open, as write deadlines are set on it.
+func newTestClient(output *bytes.Buffer) *Client {
+	conn, _ := net.Pipe()
+	return &Client{Conn: conn, writer: bufio.NewWriter(output)}
+}
+
+// A testLogin is the outcome of a login: the client handle, or why the
+// client has been denied.
+type testLogin struct {
+	loginResult
+	denial string
+}
+
+func login(t *testing.T, gs *GlobalState, role string) testLogin {
+	return loginMessage(t, gs,
+		protocol.MessageLogin{Nickname: "bot", Role: role})
+}
+
+// loginMessage logs a client in as its goroutine does: its slot is
+// reserved, it is sent LOGIN_ACK, then it is committed.
+func loginMessage(t *testing.T, gs *GlobalState,
+	msg protocol.MessageLogin) testLogin {
+	role := msg.Role
+	var output bytes.Buffer
+	client := newTestClient(&output)
+	client.nickname = msg.Nickname
+	event := newLoginEvent(client, msg)
+	event.apply(gs)
+	reservation := <-event.reply
+	if reservation.denial != "" {
+		assert.Empty(t, output.String(), "Message sent to denied %v", role)
+		return testLogin{denial: reservation.denial}
+	}
+
+	err := sendLoginACK(client, reservation.compression, msg.Serialization)
+	assert.NoError(t, err, "Cannot send LOGIN_ACK to %v", role)
+	assert.Contains(t, output.String(), "LOGIN_ACK",
+		"LOGIN_ACK not sent to %v", role)
+	commit := newLoginCommitEvent(client, msg)
+	commit.apply(gs)
+	return testLogin{loginResult: <-commit.reply}
+}
+
+func TestCoordinatorLogin(t *testing.T) {
+	gs := newTestGlobalState()
+
+	assert.NotNil(t, login(t, gs, "player").pvClient)
+	assert.Equal(t, "Maximum number of players reached",
+		login(t, gs, "player").denial)
+	assert.Equal(t, "Maximum number of special players reached",
+		login(t, gs, "special player").denial)
+	assert.NotNil(t, login(t, gs, "visualization").pvClient)
+	assert.Equal(t, "Maximum number of visus reached",
+		login(t, gs, "visualization").denial)
+	glClient := login(t, gs, "game logic").glClient
+	assert.NotNil(t, glClient)
+	assert.Equal(t, "A game logic is already logged in",
+		login(t, gs, "game logic").denial)
+	assert.Len(t, gs.Players, 1)
+	assert.Len(t, gs.Visus, 1)
+	assert.Len(t, gs.GameLogic, 1)
+	assert.Equal(t, GAME_NOT_RUNNING, gs.GameState, "Game autostarted")
+
+	start := startEvent{reply: make(chan error, 1)}
+	start.apply(gs)
+	assert.NoError(t, <-start.reply, "Cannot start game")
+	assert.Equal(t, GAME_RUNNING, gs.GameState)
+	assert.Len(t, glClient.start, 1, "Game logic not started")
+
+	start.apply(gs)
+	assert.Error(t, <-start.reply, "Game started twice")
+	gs.NbPlayersMax = 2
+	assert.Equal(t, "Game has been started", login(t, gs, "player").denial)
+}
+
+func TestCoordinatorLoginPending(t *testing.T) {
+	gs := newTestGlobalState()
+	player := protocol.MessageLogin{Nickname: "bot", Role: "player"}
+	gameLogic := protocol.MessageLogin{Nickname: "gl", Role: "game logic"}
+
+	// A pending login holds its slot until it is committed or rolled back.
+	pending := newTestClient(&bytes.Buffer{})
+	reserve := newLoginEvent(pending, player)
+	reserve.apply(gs)
+	assert.Empty(t, (<-reserve.reply).denial, "Player denied")
+	assert.Empty(t, gs.Players, "Player added before LOGIN_ACK")
+	assert.Equal(t, "Maximum number of players reached",
+		login(t, gs, "player").denial)
+	loginRollbackEvent{client: pending}.apply(gs)
+	assert.Empty(t, gs.pendingLogins, "Slot not released")
+
+	glClient := loginMessage(t, gs, gameLogic).glClient
+	reserve.apply(gs)
+	assert.Empty(t, (<-reserve.reply).denial, "Player denied")
+
+	// The game starts once the player that is sent LOGIN_ACK is added.
+	start := startEvent{reply: make(chan error, 1)}
+	start.apply(gs)
+	assert.Empty(t, start.reply, "Game started during a login")
+	assert.Equal(t, GAME_NOT_RUNNING, gs.GameState)
+	commit := newLoginCommitEvent(pending, player)
+	commit.apply(gs)
+	assert.NotNil(t, (<-commit.reply).pvClient)
+	assert.NoError(t, <-start.reply, "Cannot start game")
+	assert.Equal(t, GAME_RUNNING, gs.GameState)
+	assert.Len(t, gs.Players, 1)
+	assert.Len(t, glClient.start, 1, "Game logic not started")
+	assert.Empty(t, gs.pendingLogins, "Slot not released")
+}
+
+func TestCoordinatorLoginAgent(t *testing.T) {
+	gs := newTestGlobalState()
+	gs.NbPlayersMax = 4
+	gs.Autostart = true
+	gs.NbVisusMax = 0
+	glClient := login(t, gs, "game logic").glClient
+
+	agent := protocol.MessageLogin{Nickname: "bot", Role: "agent", NbSlots: 3}
+	assert.True(t, loginMessage(t, gs, agent).pvClient.isAgent)
+	assert.Equal(t, "Maximum number of players reached",
+		loginMessage(t, gs, agent).denial)
+	assert.Equal(t, GAME_NOT_RUNNING, gs.GameState, "Game autostarted")
+
+	// The agent slots count as players.
+	login(t, gs, "player")
+	assert.Equal(t, 4, nbPlayerSlots(gs.Players))
+	assert.Equal(t, GAME_RUNNING, gs.GameState, "Game not autostarted")
+	assert.Len(t, glClient.start, 1, "Game logic not started")
+}
+
+func TestCoordinatorStartWithoutGameLogic(t *testing.T) {
+	gs := newTestGlobalState()
+	start := startEvent{reply: make(chan error, 1)}
+	start.apply(gs)
+	assert.Error(t, <-start.reply, "Game started without game logic")
+	assert.Equal(t, GAME_NOT_RUNNING, gs.GameState)
+}
+
+func TestCoordinatorAutostart(t *testing.T) {
+	gs := newTestGlobalState()
+	gs.Autostart = true
+	gs.NbVisusMax = 0
+
+	glClient := login(t, gs, "game logic").glClient
+	assert.Equal(t, GAME_NOT_RUNNING, gs.GameState, "Game autostarted")
+	login(t, gs, "player")
+	assert.Equal(t, GAME_RUNNING, gs.GameState, "Game not autostarted")
+	assert.Len(t, glClient.start, 1, "Game logic not started")
+}
+
+func TestCoordinatorLeave(t *testing.T) {
+	gs := newTestGlobalState()
+	gs.NbPlayersMax = 2
+
+	player := login(t, gs, "player").pvClient
+	otherPlayer := login(t, gs, "player").pvClient
+	visu := login(t, gs, "visualization").pvClient
+	glClient := login(t, gs, "game logic").glClient
+
+	// The game logic is not told about players that leave before the game.
+	leaveEvent{pvClient: otherPlayer}.apply(gs)
+	assert.Empty(t, glClient.disconnections.popAll())
+	assert.Equal(t, []*PlayerOrVisuClient{player}, gs.Players)
+
+	gs.GameState = GAME_RUNNING
+	leaveEvent{pvClient: player}.apply(gs)
+	assert.Equal(t, []*PlayerOrVisuClient{player},
+		glClient.disconnections.popAll(), "Game logic not notified")
+	assert.Empty(t, gs.Players)
+
+	leaveEvent{pvClient: visu}.apply(gs)
+	assert.Empty(t, glClient.disconnections.popAll())
+	assert.Empty(t, gs.Visus)
+}
+
+func TestCoordinatorGames(t *testing.T) {
+	gs := newTestGlobalState()
+	gs.NbPlayersMax = 2
+	gs.NbVisusMax = 0
+	gs.NbGames = 2
+	gs.Autostart = true
+
+	player := login(t, gs, "player").pvClient
+	otherPlayer := login(t, gs, "player").pvClient
+	glClient := login(t, gs, "game logic").glClient
+	assert.Equal(t, GAME_RUNNING, gs.GameState, "Game not autostarted")
+	<-glClient.start
+
+	settings := gameSettingsEvent{reply: make(chan gameSettings, 1)}
+	settings.apply(gs)
+	first := <-settings.reply
+	assert.Equal(t, 0, first.game)
+	assert.False(t, first.lastGame)
+
+	// The next game starts with the clients that stayed.
+	gameEndEvent{players: first.players,
+		episodesWon: map[*PlayerOrVisuClient]int{player: 1}}.apply(gs)
+	assert.Equal(t, GAME_RUNNING, gs.GameState, "Next game not autostarted")
+	assert.Len(t, glClient.start, 1, "Game logic not started again")
+	<-glClient.start
+
+	settings.apply(gs)
+	second := <-settings.reply
+	assert.Equal(t, 1, second.game)
+	assert.True(t, second.lastGame)
+
+	gameEndEvent{players: second.players,
+		episodesWon: map[*PlayerOrVisuClient]int{otherPlayer: 1}}.apply(gs)
+	assert.Equal(t, GAME_FINISHED, gs.GameState)
+	assert.Empty(t, glClient.start, "Game started after the last one")
+
+	results := resultsEvent{reply: make(chan []SessionResult, 1)}
+	results.apply(gs)
+	assert.Equal(t, []SessionResult{
+		{Nickname: "bot", RemoteAddress: "pipe", NbGames: 2, NbEpisodesWon: 1},
+		{Nickname: "bot", RemoteAddress: "pipe", NbGames: 2, NbEpisodesWon: 1},
+	}, <-results.reply)
+}
+
+func TestCoordinatorSingleGame(t *testing.T) {
+	gs := newTestGlobalState()
+	gs.Autostart = true
+	gs.NbVisusMax = 0
+	player := login(t, gs, "player").pvClient
+	glClient := login(t, gs, "game logic").glClient
+	<-glClient.start
+
+	gameEndEvent{players: []*PlayerOrVisuClient{player}}.apply(gs)
+	assert.Equal(t, GAME_FINISHED, gs.GameState)
+	assert.Empty(t, glClient.start, "Game started after the only one")
+	assert.Equal(t, 1, player.result.NbGames)
+}
+
+func TestCoordinatorGamesLoop(t *testing.T) {
+	gs := newTestGlobalState()
+	gs.NbGames = 0
+	glClient := login(t, gs, "game logic").glClient
+	player := login(t, gs, "player").pvClient
+
+	for game := 0; game < 3; game++ {
+		start := startEvent{reply: make(chan error, 1)}
+		start.apply(gs)
+		assert.NoError(t, <-start.reply, "Cannot start game %v", game)
+		<-glClient.start
+		settings := gameSettingsEvent{reply: make(chan gameSettings, 1)}
+		settings.apply(gs)
+		assert.False(t, (<-settings.reply).lastGame)
+		gameEndEvent{players: []*PlayerOrVisuClient{player}}.apply(gs)
+		assert.Equal(t, GAME_NOT_RUNNING, gs.GameState)
+	}
+	assert.Equal(t, 3, player.result.NbGames)
+	assert.Equal(t, 0, player.result.NbEpisodesWon)
+
+	// Players that leave between games are not told to the game logic.
+	leaveEvent{pvClient: player}.apply(gs)
+	assert.Empty(t, glClient.disconnections.popAll())
+}
+
+func TestCoordinatorVariables(t *testing.T) {
+	gs := newTestGlobalState()
+	setVariableEvent{name: "nb-players-max", value: 8}.apply(gs)
+	setVariableEvent{name: "delay-turns", value: 50.0}.apply(gs)
+
+	read := readVariablesEvent{names: []string{"nb-players-max",
+		"delay-turns", "nb-turns-max"}, reply: make(chan []interface{}, 1)}
+	read.apply(gs)
+	assert.Equal(t, []interface{}{8, 50.0, 100}, <-read.reply)
+}
+
+func TestCoordinatorStopped(t *testing.T) {
+	gs := newTestGlobalState()
+	go runCoordinator(gs)
+	assert.EqualError(t, startGame(gs),
+		"Cannot start: Game logic not connected")
+	stopCoordinator(gs)
+
+	// Events are ignored once the coordinator has stopped.
+	gs = newTestGlobalState()
+	stopCoordinator(gs)
+	assert.False(t, submit(gs, gameStateEvent{reply: make(chan int, 1)}),
+		"Event submitted to a stopped coordinator")
+	assert.EqualError(t, startGame(gs), "Server has stopped")
+}
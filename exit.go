@@ -0,0 +1,38 @@
+package netorcai
+
+// Process exit codes.
+// They are part of netorcai's command-line interface, see the documentation.
+const (
+	EXIT_SUCCESS            = 0 // Game finished, or quit from the prompt
+	EXIT_BAD_ARGUMENTS      = 1 // Invalid command-line arguments
+	EXIT_GAME_LOGIC_TIMEOUT = 2 // The game logic did not answer in time
+	EXIT_GAME_LOGIC_KICKED  = 3 // The game logic failed or was kicked
+	EXIT_LISTEN_FAILURE     = 4 // Cannot listen (or accept) connections
+	EXIT_SIGNAL             = 5 // SIGINT or SIGTERM received
+	EXIT_PROMPT_CLOSED      = 6 // The interactive prompt has been closed
+	EXIT_CONFORMANCE_FAILED = 7 // A conformance scenario failed
+)
+
+// Returns a short human-readable description of a process exit code.
+func ExitReason(exitCode int) string {
+	switch exitCode {
+	case EXIT_SUCCESS:
+		return "success"
+	case EXIT_BAD_ARGUMENTS:
+		return "bad arguments"
+	case EXIT_GAME_LOGIC_TIMEOUT:
+		return "game logic timeout"
+	case EXIT_GAME_LOGIC_KICKED:
+		return "game logic kicked"
+	case EXIT_LISTEN_FAILURE:
+		return "listen failure"
+	case EXIT_SIGNAL:
+		return "signal received"
+	case EXIT_PROMPT_CLOSED:
+		return "prompt closed"
+	case EXIT_CONFORMANCE_FAILED:
+		return "conformance failed"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,64 @@
+package netorcai
+
+import (
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	log "github.com/sirupsen/logrus"
+	"net"
+)
+
+// mockGameLogic is a game logic without any game rule, that lets player
+// authors test their protocol loop against a lone netorcai.
+// Its game state is a copy of baseState with two additional fields:
+// turn_number (the number of DO_TURN received) and player_actions
+// (the actions received in the last DO_TURN). There is never any winner.
+type mockGameLogic struct {
+	baseState  map[string]interface{}
+	turnNumber int
+}
+
+func (gl *mockGameLogic) gameState(
+	playerActions []protocol.MessageDoTurnPlayerAction) map[string]interface{} {
+	state := make(map[string]interface{})
+	for key, value := range gl.baseState {
+		state[key] = value
+	}
+	state["turn_number"] = gl.turnNumber
+	state["player_actions"] = playerActions
+	return state
+}
+
+func (gl *mockGameLogic) Init(nbPlayers, nbSpecialPlayers,
+	nbTurnsMax int) map[string]interface{} {
+	gl.turnNumber = 0
+	return gl.gameState([]protocol.MessageDoTurnPlayerAction{})
+}
+
+func (gl *mockGameLogic) Turn(
+	playerActions []protocol.MessageDoTurnPlayerAction) (
+	map[string]interface{}, int) {
+	gl.turnNumber++
+	return gl.gameState(playerActions), -1
+}
+
+// runMockGameLogic connects a mock game logic to netorcai on addr.
+func runMockGameLogic(addr net.Addr, baseState map[string]interface{}) {
+	c := &client.Client{}
+	err := c.ConnectAddress(addr.Network(), addr.String())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":     err,
+			"address": addr,
+		}).Warn("Mock game logic cannot connect")
+		return
+	}
+	defer c.Disconnect()
+
+	err = client.RunGameLogic(c, "mockgl",
+		&mockGameLogic{baseState: baseState})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Warn("Mock game logic stopped")
+	}
+}
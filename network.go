@@ -2,13 +2,17 @@ package netorcai
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
-	"encoding/json"
 	"fmt"
+	"github.com/netorcai/netorcai/protocol"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"net"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 type Client struct {
@@ -18,47 +22,206 @@ type Client struct {
 	reader           *bufio.Reader
 	writer           *bufio.Writer
 	incomingMessages chan ClientMessage
-	canTerminate     chan string
+	// Closed when the client is no longer handled by netorcai.
+	done chan struct{}
+	// Protects compression, serialization and stats, which are used by
+	// both the reading and the writing goroutines.
+	mutex sync.Mutex
+	// Compression algorithm negotiated at LOGIN ("" if none).
+	compression string
+	// Serialization format negotiated at LOGIN ("" for JSON).
+	serialization string
+	stats         protocol.TrafficStats
+}
+
+func (client *Client) setCompression(compression string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.compression = compression
+}
+
+func (client *Client) getCompression() string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.compression
+}
+
+func (client *Client) setSerialization(serialization string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.serialization = serialization
+}
+
+func (client *Client) getSerialization() string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.serialization
+}
+
+func (client *Client) usesMessagePack() bool {
+	return client.getSerialization() == protocol.SerializationMessagePack
+}
+
+// Stats returns the traffic statistics of the client.
+func (client *Client) Stats() protocol.TrafficStats {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.stats
 }
 
 type ClientMessage struct {
 	content map[string]interface{}
-	err     error
+	// Serialized game_state.all_clients of JSON messages, as sent.
+	// nil for MessagePack messages.
+	rawGameState []byte
+	err          error
 }
 
-func RunServer(port int, globalState *GlobalState, onexit,
-	gameLogicExit chan int) {
-	defer globalState.WaitGroup.Done()
-	// Listen all incoming TCP connections on the specified port
-	listenAddress := ":" + strconv.Itoa(port)
-	globalState.Mutex.Lock()
-	var err error
-	globalState.Listener, err = net.Listen("tcp", listenAddress)
-	globalState.Mutex.Unlock()
-	if err != nil {
+// ParseListenAddress splits a listen address such as "tcp://:4242",
+// "tcp6://[::1]:4242" or "unix:///tmp/netorcai.sock" into the network and
+// address expected by net.Listen.
+func ParseListenAddress(listenAddress string) (network, address string,
+	err error) {
+	parts := strings.SplitN(listenAddress, "://", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("Missing network prefix (e.g. tcp://)")
+	}
+	network, address = parts[0], parts[1]
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return "", "", fmt.Errorf("Invalid port '%v'", port)
+		}
+	case "unix":
+		if address == "" {
+			return "", "", fmt.Errorf("Empty unix socket path")
+		}
+	default:
+		return "", "", fmt.Errorf("Unsupported network '%v'", network)
+	}
+
+	return network, address, nil
+}
+
+// listenUnix listens on a Unix socket.
+// A socket file left behind by a netorcai that has not been stopped
+// cleanly is removed first, unless something still accepts connections on it.
+// The permissions of the socket file are set to mode if it is not 0.
+// Otherwise, they depend on the process umask.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil &&
+		info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+		} else {
+			log.WithFields(log.Fields{
+				"path": path,
+			}).Warn("Removing stale unix socket")
+			os.Remove(path)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err == nil && mode != 0 {
+		if err = os.Chmod(path, mode); err != nil {
+			listener.Close()
+		}
+	}
+	return listener, err
+}
+
+// listen opens all the listening sockets. It either opens them all or none.
+// It must be called before the goroutines that use the global state start.
+func listen(listenAddresses []string, unixSocketMode os.FileMode,
+	globalState *GlobalState) error {
+	for _, listenAddress := range listenAddresses {
+		network, address, err := ParseListenAddress(listenAddress)
+		var listener net.Listener
+		if err == nil && network == "unix" {
+			listener, err = listenUnix(address, unixSocketMode)
+		} else if err == nil {
+			listener, err = net.Listen(network, address)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":            err,
+				"listen address": listenAddress,
+			}).Error("Cannot listen incoming connections")
+			for _, listener := range globalState.Listeners {
+				listener.Close()
+			}
+			globalState.Listeners = nil
+			return err
+		}
+		globalState.Listeners = append(globalState.Listeners, listener)
+	}
+
+	for _, listener := range globalState.Listeners {
 		log.WithFields(log.Fields{
-			"err":            err,
-			"network":        "tcp",
-			"listen address": listenAddress,
-		}).Error("Cannot listen incoming connections")
-		onexit <- 1
-		return
+			"network": listener.Addr().Network(),
+			"address": listener.Addr().String(),
+		}).Info("Listening incoming connections")
 	}
+	return nil
+}
 
-	log.WithFields(log.Fields{
-		"port": port,
-	}).Info("Listening incoming connections")
-	defer globalState.Listener.Close()
+// serve accepts incoming connections on all the listening sockets,
+// until they are closed or until shutdownCtx is done.
+// Clients are aborted when ctx is done.
+func serve(ctx, shutdownCtx context.Context, globalState *GlobalState,
+	onexit, gameLogicExit chan int) {
+	defer globalState.WaitGroup.Done()
+
+	listeners := globalState.Listeners
+
+	// Stop accepting new clients as soon as the server is shut down.
+	go func() {
+		<-shutdownCtx.Done()
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, listener := range listeners {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			acceptConnections(ctx, shutdownCtx, listener, globalState,
+				onexit, gameLogicExit)
+		}(listener)
+	}
+	wg.Wait()
+}
+
+func acceptConnections(ctx, shutdownCtx context.Context,
+	listener net.Listener, globalState *GlobalState,
+	onexit, gameLogicExit chan int) {
+	defer listener.Close()
 
 	for {
 		// Wait for an incoming connection.
 		client := &Client{}
-		client.Conn, err = globalState.Listener.Accept()
+		var err error
+		client.Conn, err = listener.Accept()
 		if err != nil {
+			if shutdownCtx.Err() != nil {
+				// The listener has been closed on purpose.
+				return
+			}
 			log.WithFields(log.Fields{
 				"err": err,
 			}).Warn("Could not accept incoming connection. Aborting server.")
-			onexit <- 1
+			// Several listeners may fail at once (e.g., on cleanup).
+			select {
+			case onexit <- EXIT_LISTEN_FAILURE:
+			default:
+			}
 			return
 		} else {
 			// Handle connections in a new goroutine.
@@ -66,10 +229,11 @@ func RunServer(port int, globalState *GlobalState, onexit,
 			client.writer = bufio.NewWriter(client.Conn)
 			client.state = CLIENT_UNLOGGED
 			client.incomingMessages = make(chan ClientMessage)
-			client.canTerminate = make(chan string, 1)
+			client.done = make(chan struct{})
 
 			globalState.WaitGroup.Add(1)
-			go handleClient(client, globalState, gameLogicExit)
+			go handleClient(ctx, shutdownCtx, client, globalState,
+				gameLogicExit)
 		}
 	}
 }
@@ -81,7 +245,7 @@ func readClientMessage(client *Client, maximumAllowedSize uint32, errorFormatOnT
 	_, err := io.ReadFull(client.reader, contentSizeBuf)
 	if err != nil {
 		msg.err = fmt.Errorf("Remote endpoint closed? Read error: %v", err)
-		client.incomingMessages <- msg
+		deliverClientMessage(client, msg)
 		return false
 	}
 
@@ -89,7 +253,7 @@ func readClientMessage(client *Client, maximumAllowedSize uint32, errorFormatOnT
 	contentSize := binary.LittleEndian.Uint32(contentSizeBuf)
 	if contentSize > maximumAllowedSize {
 		msg.err = fmt.Errorf(errorFormatOnTooBigMessage, contentSize)
-		client.incomingMessages <- msg
+		deliverClientMessage(client, msg)
 		return false
 	}
 
@@ -98,68 +262,180 @@ func readClientMessage(client *Client, maximumAllowedSize uint32, errorFormatOnT
 	_, err = io.ReadFull(client.reader, contentBuf)
 	if err != nil {
 		msg.err = fmt.Errorf("Remote endpoint closed? Read error: %v", err)
-		client.incomingMessages <- msg
+		deliverClientMessage(client, msg)
 		return false
 	}
 
+	// Compressed messages can only be received once compression has been
+	// negotiated, which excludes the first message.
+	// The same goes for MessagePack messages.
+	isMessagePack := client.usesMessagePack()
+	uncompressedSize := contentSize
+	if client.getCompression() != "" &&
+		protocol.IsCompressedMessage(contentBuf) {
+		contentBuf, err = protocol.DecompressMessage(contentBuf)
+		if err != nil {
+			msg.err = err
+			deliverClientMessage(client, msg)
+			return false
+		}
+		uncompressedSize = uint32(len(contentBuf))
+		if !isMessagePack {
+			uncompressedSize++ // as if sent uncompressed, with its \n
+		}
+	}
+
+	client.mutex.Lock()
+	client.stats.MessagesReceived++
+	client.stats.BytesReceived += int64(contentSize)
+	client.stats.UncompressedBytesReceived += int64(uncompressedSize)
+	client.mutex.Unlock()
+
 	log.WithFields(log.Fields{
-		"remote address": client.Conn.RemoteAddr(),
-		"nickname":       client.nickname,
-		"content size":   contentSize,
-		"content":        string(contentBuf),
+		"remote address":    client.Conn.RemoteAddr(),
+		"nickname":          client.nickname,
+		"content size":      contentSize,
+		"uncompressed size": uncompressedSize,
+		"content":           printableContent(client, contentBuf),
 	}).Debug("New message received")
 	// Read message content
-	err = json.Unmarshal(contentBuf, &msg.content)
+	if isMessagePack {
+		msg.content, err = protocol.UnmarshalMessagePack(contentBuf)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Debug("Invalid MessagePack message received")
+			msg.err = fmt.Errorf("Invalid MessagePack message received: %v",
+				err)
+			deliverClientMessage(client, msg)
+			return false
+		}
+		return deliverClientMessage(client, msg)
+	}
+
+	msg.content, msg.rawGameState, err = protocol.UnmarshalJSONMessage(
+		contentBuf)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err":             err,
 			"message content": string(contentBuf),
 		}).Debug("Non-JSON message received")
 		msg.err = fmt.Errorf("Non-JSON message received")
-		client.incomingMessages <- msg
+		deliverClientMessage(client, msg)
 		return false
 	}
 
-	client.incomingMessages <- msg
-	return true
+	return deliverClientMessage(client, msg)
+}
+
+// deliverClientMessage forwards msg to the goroutine that handles client.
+// It returns false if this goroutine has finished.
+func deliverClientMessage(client *Client, msg ClientMessage) bool {
+	select {
+	case client.incomingMessages <- msg:
+		return true
+	case <-client.done:
+		return false
+	}
 }
 
 func readClientMessages(client *Client) {
 	if readClientMessage(client, 1023, "Received message size of first message is too big: %v does not fit in 10 bits") {
-		for readClientMessage(client, 16777215, "Received message size is too big: %v does not fit in 24 bits") {
+		for readClientMessage(client, protocol.MaxMessageSize, "Received message size is too big: %v does not fit in 24 bits") {
 		}
 	}
 }
 
+// marshalMessage serializes msg in the format negotiated with client.
+func marshalMessage(client *Client, msg interface{}) ([]byte, error) {
+	return marshalValue(client.getSerialization(), msg)
+}
+
+// printableContent returns a message content of client in a form that can
+// be logged.
+func printableContent(client *Client, content []byte) string {
+	if client.usesMessagePack() {
+		return fmt.Sprintf("<%v bytes of MessagePack>", len(content))
+	}
+	return string(content)
+}
+
+// sendMessage sends a message content serialized by marshalMessage.
 func sendMessage(client *Client, content []byte) error {
+	return sendContent(client, content, !client.usesMessagePack())
+}
+
+// sendContent sends a message content to client. JSON contents are
+// terminated by a "\n" character. The content is compressed if compression
+// has been negotiated and if the message is big enough.
+func sendContent(client *Client, content []byte, isJSON bool) error {
+	uncompressedSize := len(content)
+	if isJSON {
+		uncompressedSize++ // +1 for \n
+	}
+	compressed := false
+	if client.getCompression() != "" &&
+		len(content) >= protocol.MinCompressedMessageSize {
+		compressedContent, err := protocol.CompressMessage(content)
+		if err != nil {
+			return err
+		}
+		content = compressedContent
+		compressed = true
+	}
+
+	err := writeFrame(client.writer, content, isJSON && !compressed)
+	if err != nil {
+		return err
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.stats.MessagesSent++
+	client.stats.UncompressedBytesSent += int64(uncompressedSize)
+	if compressed {
+		client.stats.BytesSent += int64(len(content))
+	} else {
+		client.stats.BytesSent += int64(uncompressedSize)
+	}
+	return nil
+}
+
+// writeFrame writes a size-prefixed message content on writer, then flushes
+// it, with a terminating "\n" character if newline is set.
+func writeFrame(writer *bufio.Writer, content []byte, newline bool) error {
 	// Check content size
-	contentSize := len(content)
-	if contentSize >= 16777215 {
+	frameSize := len(content)
+	if newline {
+		frameSize++
+	}
+	if frameSize > protocol.MaxMessageSize {
 		return fmt.Errorf("content too big: size does not fit in 24 bits")
 	}
 
 	// Write content size on socket
-	var contentSizeUint32 uint32 = uint32(contentSize) + 1 // +1 for \n
 	contentSizeBuf := make([]byte, 4)
-	binary.LittleEndian.PutUint32(contentSizeBuf, contentSizeUint32)
-	_, err := client.writer.Write(contentSizeBuf)
+	binary.LittleEndian.PutUint32(contentSizeBuf, uint32(frameSize))
+	_, err := writer.Write(contentSizeBuf)
 	if err != nil {
 		return fmt.Errorf("Remote endpoint closed? Write error: %v", err)
 	}
 
 	// Write content on socket
-	_, err = client.writer.Write(content)
+	_, err = writer.Write(content)
 	if err != nil {
 		return fmt.Errorf("Remote endpoint closed? Write error: %v", err)
 	}
 
-	// Write terminating "\n" character on socket
-	err = client.writer.WriteByte(0x0A)
-	if err != nil {
-		return fmt.Errorf("Remote endpoint closed? Write error: %v", err)
+	if newline {
+		// Write terminating "\n" character on socket
+		err = writer.WriteByte(0x0A)
+		if err != nil {
+			return fmt.Errorf("Remote endpoint closed? Write error: %v", err)
+		}
 	}
 
 	// Flush socket
-	client.writer.Flush()
+	writer.Flush()
 	return nil
 }
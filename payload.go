@@ -0,0 +1,144 @@
+package netorcai
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/netorcai/netorcai/protocol"
+	"sync"
+	"sync/atomic"
+)
+
+// A gameStatePayload is the game state of a TURN, shared by the TURNs of
+// all clients. Its serializations (whole game state or patches, in JSON or
+// in MessagePack) are computed once, by the first client that needs them,
+// then spliced into the TURN messages of all the clients that need them.
+type gameStatePayload struct {
+	// Identifies the game state in the patches that apply to it.
+	// 0 stands for the initial game state of GAME_STARTS.
+	id        uint64
+	gameState map[string]interface{}
+	// JSON serialization of the game state sent by the game logic,
+	// nil if the game logic sent a patch or did not use JSON.
+	rawJSON []byte
+
+	mutex      sync.Mutex
+	serialized map[payloadKey]serializedPayload
+}
+
+type payloadKey struct {
+	serialization string
+	// game_state_encoding, "full" for the whole game state
+	encoding string
+	// Patches only: ID of the game state the patch applies to
+	baseID uint64
+}
+
+type serializedPayload struct {
+	// game_state, game_state_patch or game_state_json_patch
+	field   string
+	content []byte
+}
+
+// newGameStatePayload creates a payload whose ID follows lastID, which is
+// updated.
+func newGameStatePayload(lastID *uint64, gameState map[string]interface{},
+	rawJSON []byte) *gameStatePayload {
+	return &gameStatePayload{
+		id:         atomic.AddUint64(lastID, 1),
+		gameState:  gameState,
+		rawJSON:    rawJSON,
+		serialized: make(map[payloadKey]serializedPayload),
+	}
+}
+
+// serialize returns the game state field of a TURN for a client, according
+// to its serialization format and to its game_state_encoding.
+// Patches apply to the game state previously sent to the client,
+// baseGameState, whose payload ID is baseID.
+// The whole game state is sent if the changes cannot be expressed by a
+// merge patch.
+func (payload *gameStatePayload) serialize(serialization, encoding string,
+	baseGameState map[string]interface{}, baseID uint64) (
+	serializedPayload, error) {
+	if serialization != protocol.SerializationMessagePack {
+		serialization = protocol.SerializationJSON
+	}
+	if encoding == "" {
+		encoding = "full"
+	}
+	key := payloadKey{serialization: serialization, encoding: encoding}
+	if encoding != "full" {
+		key.baseID = baseID
+	}
+
+	payload.mutex.Lock()
+	defer payload.mutex.Unlock()
+	if serialized, exists := payload.serialized[key]; exists {
+		return serialized, nil
+	}
+
+	var serialized serializedPayload
+	var err error
+	switch encoding {
+	case "merge-patch":
+		patch, canPatch := protocol.MergePatch(baseGameState,
+			payload.gameState)
+		if canPatch {
+			serialized.field = "game_state_patch"
+			serialized.content, err = marshalValue(serialization, patch)
+			break
+		}
+		serialized, err = payload.serializeWhole(serialization)
+	case "json-patch":
+		serialized.field = "game_state_json_patch"
+		serialized.content, err = marshalValue(serialization,
+			protocol.JSONPatch(baseGameState, payload.gameState))
+	default:
+		serialized, err = payload.serializeWhole(serialization)
+	}
+	if err != nil {
+		return serializedPayload{}, err
+	}
+
+	payload.serialized[key] = serialized
+	return serialized, nil
+}
+
+// serializeWhole returns the whole game state.
+// payload.mutex must be held.
+func (payload *gameStatePayload) serializeWhole(serialization string) (
+	serializedPayload, error) {
+	key := payloadKey{serialization: serialization, encoding: "full"}
+	if serialized, exists := payload.serialized[key]; exists {
+		return serialized, nil
+	}
+
+	serialized := serializedPayload{field: "game_state"}
+	if serialization != protocol.SerializationMessagePack &&
+		payload.rawJSON != nil {
+		// The game logic may have sent indented JSON.
+		var buffer bytes.Buffer
+		err := json.Compact(&buffer, payload.rawJSON)
+		if err != nil {
+			return serializedPayload{}, err
+		}
+		serialized.content = buffer.Bytes()
+	} else {
+		var err error
+		serialized.content, err = marshalValue(serialization,
+			payload.gameState)
+		if err != nil {
+			return serializedPayload{}, err
+		}
+	}
+
+	payload.serialized[key] = serialized
+	return serialized, nil
+}
+
+func marshalValue(serialization string, v interface{}) ([]byte, error) {
+	if serialization == protocol.SerializationMessagePack {
+		return protocol.MarshalMessagePack(v)
+	}
+	return json.Marshal(v)
+}
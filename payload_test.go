@@ -0,0 +1,63 @@
+package netorcai
+
+import (
+	"encoding/json"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGameStatePayloadSerialize(t *testing.T) {
+	rawJSON := []byte("{\n  \"turn\": 2,\n  \"cells\": [0, 1]\n}")
+	var gameState map[string]interface{}
+	err := json.Unmarshal(rawJSON, &gameState)
+	assert.NoError(t, err, "Cannot decode JSON in test")
+	previousGameState := map[string]interface{}{"turn": 1.0,
+		"cells": []interface{}{0.0, 1.0}}
+	var lastID uint64
+	payload := newGameStatePayload(&lastID, gameState, rawJSON)
+	assert.Equal(t, uint64(1), payload.id)
+
+	// The JSON game state is forwarded as sent by the game logic, compacted.
+	full, err := payload.serialize("json", "", nil, 0)
+	assert.NoError(t, err, "Cannot serialize game state")
+	assert.Equal(t, "game_state", full.field)
+	assert.Equal(t, `{"turn":2,"cells":[0,1]}`, string(full.content))
+
+	// Serializations are shared by all the clients that need them.
+	other, err := payload.serialize("", "full", nil, 0)
+	assert.NoError(t, err, "Cannot serialize game state")
+	assert.True(t, &full.content[0] == &other.content[0],
+		"Game state serialized twice")
+
+	patch, err := payload.serialize("json", "merge-patch", previousGameState,
+		1)
+	assert.NoError(t, err, "Cannot serialize merge patch")
+	assert.Equal(t, "game_state_patch", patch.field)
+	assert.Equal(t, `{"turn":2}`, string(patch.content))
+
+	patch, err = payload.serialize("msgpack", "json-patch", previousGameState,
+		1)
+	assert.NoError(t, err, "Cannot serialize JSON patch")
+	assert.Equal(t, "game_state_json_patch", patch.field)
+	expected, err := protocol.MarshalMessagePack(
+		protocol.JSONPatch(previousGameState, gameState))
+	assert.NoError(t, err, "Cannot encode MessagePack in test")
+	assert.Equal(t, expected, patch.content)
+
+	// Patches depend on the game state they apply to.
+	patch, err = payload.serialize("json", "merge-patch",
+		map[string]interface{}{"turn": 2.0, "cells": []interface{}{}}, 2)
+	assert.NoError(t, err, "Cannot serialize merge patch")
+	assert.Equal(t, `{"cells":[0,1]}`, string(patch.content))
+
+	// Merge patches cannot set null values: the whole game state is sent.
+	payload = newGameStatePayload(&lastID,
+		map[string]interface{}{"turn": nil}, nil)
+	assert.Equal(t, uint64(2), payload.id)
+	patch, err = payload.serialize("json", "merge-patch", previousGameState,
+		1)
+	assert.NoError(t, err, "Cannot serialize game state")
+	assert.Equal(t, "game_state", patch.field)
+	assert.Equal(t, `{"turn":null}`, string(patch.content))
+}
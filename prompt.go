@@ -10,11 +10,6 @@ import (
 	"strings"
 )
 
-var (
-	globalGS        *GlobalState
-	globalShellExit chan int
-)
-
 func stringInSlice(searchedValue string, slice []string) bool {
 	for _, value := range slice {
 		if value == searchedValue {
@@ -24,10 +19,11 @@ func stringInSlice(searchedValue string, slice []string) bool {
 	return false
 }
 
-func executor(line string) {
+func executor(gs *GlobalState, onexit chan int, line string) {
 	line = strings.TrimSpace(line)
 	rStart, _ := regexp.Compile(`\Astart\z`)
 	rQuit, _ := regexp.Compile(`\Aquit\z`)
+	rResults, _ := regexp.Compile(`\Aresults\z`)
 	rPrint, _ := regexp.Compile(`\Aprint\s+(?P<variable>\S+)\z`)
 	rSet, _ := regexp.Compile(`\Aset\s+(?P<variable>\S+)(?P<sep>\s|=)(?P<value>\S+)\z`)
 
@@ -38,25 +34,28 @@ func executor(line string) {
 		"nb-visus-max",
 		"delay-first-turn",
 		"delay-turns",
+		"gl-init-timeout",
+		"gl-turn-timeout",
 	}
 
 	acceptedPrintVariables := append(acceptedSetVariables, "all")
 
 	if rStart.MatchString(line) {
-		LockGlobalStateMutex(globalGS, "got start command", "Prompt")
-		if globalGS.GameState == GAME_NOT_RUNNING {
-			if len(globalGS.GameLogic) == 1 {
-				globalGS.GameState = GAME_RUNNING
-				globalGS.GameLogic[0].start <- 1
-			} else {
-				fmt.Printf("Cannot start: Game logic not connected\n")
-			}
-		} else {
-			fmt.Printf("Game has already been started\n")
+		err := startGame(gs)
+		if err != nil {
+			fmt.Printf("%v\n", err)
 		}
-		UnlockGlobalStateMutex(globalGS, "got start command", "Prompt")
 	} else if rQuit.MatchString(line) {
-		globalShellExit <- 0
+		onexit <- EXIT_SUCCESS
+	} else if rResults.MatchString(line) {
+		event := resultsEvent{reply: make(chan []SessionResult, 1)}
+		if submit(gs, event) {
+			for _, result := range <-event.reply {
+				fmt.Printf("%v (%v): games=%v episodes-won=%v\n",
+					result.Nickname, result.RemoteAddress, result.NbGames,
+					result.NbEpisodesWon)
+			}
+		}
 	} else if rPrint.MatchString(line) {
 		m := rPrint.FindStringSubmatch(line)
 		names := rPrint.SubexpNames()
@@ -66,34 +65,16 @@ func executor(line string) {
 		}
 
 		if stringInSlice(matches["variable"], acceptedPrintVariables) {
-			switch matches["variable"] {
-			case "nb-turns-max":
-				fmt.Printf("%v=%v\n", "nb-turns-max", globalGS.NbTurnsMax)
-			case "nb-players-max":
-				fmt.Printf("%v=%v\n", "nb-players-max",
-					globalGS.NbPlayersMax)
-			case "nb-splayers-max":
-				fmt.Printf("%v=%v\n", "nb-splayers-max",
-					globalGS.NbSpecialPlayersMax)
-			case "nb-visus-max":
-				fmt.Printf("%v=%v\n", "nb-visus-max", globalGS.NbVisusMax)
-			case "delay-first-turn":
-				fmt.Printf("%v=%v\n", "delay-first-turn",
-					globalGS.MillisecondsBeforeFirstTurn)
-			case "delay-turns":
-				fmt.Printf("%v=%v\n", "delay-turns",
-					globalGS.MillisecondsBetweenTurns)
-			case "all":
-				fmt.Printf("%v=%v\n", "nb-turns-max", globalGS.NbTurnsMax)
-				fmt.Printf("%v=%v\n", "nb-players-max",
-					globalGS.NbPlayersMax)
-				fmt.Printf("%v=%v\n", "nb-splayers-max",
-					globalGS.NbSpecialPlayersMax)
-				fmt.Printf("%v=%v\n", "nb-visus-max", globalGS.NbVisusMax)
-				fmt.Printf("%v=%v\n", "delay-first-turn",
-					globalGS.MillisecondsBeforeFirstTurn)
-				fmt.Printf("%v=%v\n", "delay-turns",
-					globalGS.MillisecondsBetweenTurns)
+			names := []string{matches["variable"]}
+			if matches["variable"] == "all" {
+				names = acceptedSetVariables
+			}
+			event := readVariablesEvent{names: names,
+				reply: make(chan []interface{}, 1)}
+			if submit(gs, event) {
+				for index, value := range <-event.reply {
+					fmt.Printf("%v=%v\n", names[index], value)
+				}
 			}
 		} else {
 			fmt.Printf("Bad VARIABLE=%v. Accepted values: %v\n",
@@ -120,7 +101,8 @@ func executor(line string) {
 						matches["value"], errInt.Error())
 				} else {
 					if intValue >= 1 && intValue <= 65535 {
-						globalGS.NbTurnsMax = int(intValue)
+						submit(gs, setVariableEvent{name: matches["variable"],
+							value: int(intValue)})
 					} else {
 						fmt.Printf("Bad VALUE=%v: Not in [1,65535]\n",
 							intValue)
@@ -132,7 +114,8 @@ func executor(line string) {
 						matches["value"], errInt.Error())
 				} else {
 					if intValue >= 1 && intValue <= 1024 {
-						globalGS.NbPlayersMax = int(intValue)
+						submit(gs, setVariableEvent{name: matches["variable"],
+							value: int(intValue)})
 					} else {
 						fmt.Printf("Bad VALUE=%v: Not in [1,1024]\n",
 							intValue)
@@ -144,7 +127,8 @@ func executor(line string) {
 						matches["value"], errInt.Error())
 				} else {
 					if intValue >= 0 && intValue <= 1024 {
-						globalGS.NbSpecialPlayersMax = int(intValue)
+						submit(gs, setVariableEvent{name: matches["variable"],
+							value: int(intValue)})
 					} else {
 						fmt.Printf("Bad VALUE=%v: Not in [0,1024]\n",
 							intValue)
@@ -156,7 +140,8 @@ func executor(line string) {
 						matches["value"], errInt.Error())
 				} else {
 					if intValue >= 0 && intValue <= 1024 {
-						globalGS.NbVisusMax = int(intValue)
+						submit(gs, setVariableEvent{name: matches["variable"],
+							value: int(intValue)})
 					} else {
 						fmt.Printf("Bad VALUE=%v: Not in [0,1024]\n",
 							intValue)
@@ -168,7 +153,8 @@ func executor(line string) {
 						matches["value"], errFloat.Error())
 				} else {
 					if floatValue >= 50 && floatValue <= 10000 {
-						globalGS.MillisecondsBeforeFirstTurn = floatValue
+						submit(gs, setVariableEvent{name: matches["variable"],
+							value: floatValue})
 					} else {
 						fmt.Printf("Bad VALUE=%v: Not in [50,10000]\n",
 							floatValue)
@@ -180,12 +166,39 @@ func executor(line string) {
 						matches["value"], errFloat.Error())
 				} else {
 					if floatValue >= 50 && floatValue <= 10000 {
-						globalGS.MillisecondsBetweenTurns = floatValue
+						submit(gs, setVariableEvent{name: matches["variable"],
+							value: floatValue})
 					} else {
 						fmt.Printf("Bad VALUE=%v: Not in [50,10000]\n",
 							floatValue)
 					}
 				}
+			case "gl-init-timeout":
+				if errFloat != nil {
+					fmt.Printf("Bad VALUE=%v. %v\n",
+						matches["value"], errFloat.Error())
+				} else {
+					if floatValue >= 0 && floatValue <= 3600000 {
+						submit(gs, setVariableEvent{name: matches["variable"],
+							value: floatValue})
+					} else {
+						fmt.Printf("Bad VALUE=%v: Not in [0,3600000]\n",
+							floatValue)
+					}
+				}
+			case "gl-turn-timeout":
+				if errFloat != nil {
+					fmt.Printf("Bad VALUE=%v. %v\n",
+						matches["value"], errFloat.Error())
+				} else {
+					if floatValue >= 0 && floatValue <= 3600000 {
+						submit(gs, setVariableEvent{name: matches["variable"],
+							value: floatValue})
+					} else {
+						fmt.Printf("Bad VALUE=%v: Not in [0,3600000]\n",
+							floatValue)
+					}
+				}
 			}
 		} else {
 			fmt.Printf("Bad VARIABLE=%v. Accepted values: %v\n",
@@ -197,6 +210,8 @@ func executor(line string) {
 			fmt.Println("expected syntax: start")
 		} else if strings.HasPrefix(line, "quit") {
 			fmt.Println("expected syntax: quit")
+		} else if strings.HasPrefix(line, "results") {
+			fmt.Println("expected syntax: results")
 		} else if strings.HasPrefix(line, "print") {
 			fmt.Println("expected syntax: print VARIABLE")
 		} else if strings.HasPrefix(line, "set") {
@@ -211,6 +226,7 @@ func completer(d prompt.Document) []prompt.Suggest {
 		{Text: "start", Description: "Start the game"},
 		{Text: "print", Description: "Print value of variable"},
 		{Text: "set", Description: "Set value of variable"},
+		{Text: "results", Description: "Print the results of the session"},
 		{Text: "quit", Description: "Quit netorcai"},
 	}
 
@@ -221,6 +237,8 @@ func completer(d prompt.Document) []prompt.Suggest {
 		{Text: "nb-visus-max", Description: "Maximum number of visualizations"},
 		{Text: "delay-first-turn", Description: "Time (ms) before 1st turn"},
 		{Text: "delay-turns", Description: "Time (ms) between turns"},
+		{Text: "gl-init-timeout", Description: "Max time (ms) for DO_INIT_ACK"},
+		{Text: "gl-turn-timeout", Description: "Max time (ms) for DO_TURN_ACK"},
 	}
 
 	printSuggestions := append(setSuggestions, prompt.Suggest{Text: "all",
@@ -241,37 +259,32 @@ func completer(d prompt.Document) []prompt.Suggest {
 	}
 }
 
-func RunPrompt(gs *GlobalState, onexit chan int, interactive bool) {
-	globalGS = gs
-	globalShellExit = onexit
-
+func runPrompt(gs *GlobalState, onexit chan int, interactive bool) {
 	if interactive {
-		interactivePrompt(onexit)
+		interactivePrompt(gs, onexit)
 	} else {
-		nonInteractivePrompt(onexit)
+		nonInteractivePrompt(gs, onexit)
 	}
-
 }
 
-func interactivePrompt(onexit chan int) {
-	LockGlobalStateMutex(globalGS, "Creating prompt", "Prompt")
-	globalGS.prompt = prompt.New(
-		executor,
+func interactivePrompt(gs *GlobalState, onexit chan int) {
+	p := prompt.New(
+		func(line string) { executor(gs, onexit, line) },
 		completer,
 		prompt.OptionPrefix(">>> "),
 		prompt.OptionTitle(""),
 	)
-	UnlockGlobalStateMutex(globalGS, "Creating prompt", "Prompt")
+	submit(gs, promptEvent{prompt: p})
 
-	globalGS.prompt.Run()
-	onexit <- 1
+	p.Run()
+	onexit <- EXIT_PROMPT_CLOSED
 }
 
-func nonInteractivePrompt(onexit chan int) {
+func nonInteractivePrompt(gs *GlobalState, onexit chan int) {
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
 		line, _ := reader.ReadString('\n')
-		executor(line)
+		executor(gs, onexit, line)
 	}
 }
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Messages smaller than this (in bytes) are not worth compressing.
+const MinCompressedMessageSize = 512
+
+// Maximum size of a message content, compressed or not.
+const MaxMessageSize = 16777215
+
+// SupportedCompressions lists the compression algorithms that can be
+// negotiated at LOGIN, by order of preference.
+var SupportedCompressions = []string{"gzip"}
+
+// ChooseCompression returns the first supported compression algorithm of
+// the algorithms offered by a client, or "" if there is none.
+// Unknown algorithms are ignored, so that clients can offer algorithms
+// that only newer netorcai versions support.
+func ChooseCompression(offered []string) string {
+	for _, algorithm := range offered {
+		for _, supported := range SupportedCompressions {
+			if algorithm == supported {
+				return algorithm
+			}
+		}
+	}
+	return ""
+}
+
+// CompressMessage returns the gzip compression of a message content.
+func CompressMessage(content []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, err := writer.Write(content)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot compress message: %v", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// IsCompressedMessage returns whether a message content is compressed.
+// Compressed contents start with the gzip magic number, which cannot start
+// a JSON text.
+func IsCompressedMessage(content []byte) bool {
+	return len(content) >= 2 && content[0] == 0x1f && content[1] == 0x8b
+}
+
+// DecompressMessage returns the decompressed content of a compressed
+// message. An error is returned if the decompressed content is bigger than
+// MaxMessageSize.
+func DecompressMessage(content []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid compressed message: %v", err)
+	}
+
+	// Read one more byte than allowed to detect too big contents.
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader,
+		MaxMessageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid compressed message: %v", err)
+	}
+	if len(decompressed) > MaxMessageSize {
+		return nil, fmt.Errorf("Decompressed message size is too big: " +
+			"it does not fit in 24 bits")
+	}
+	return decompressed, nil
+}
+
+// TrafficStats counts the messages exchanged with a peer, and their sizes
+// (in bytes) before and after compression.
+type TrafficStats struct {
+	MessagesSent              int64
+	BytesSent                 int64
+	UncompressedBytesSent     int64
+	MessagesReceived          int64
+	BytesReceived             int64
+	UncompressedBytesReceived int64
+}
@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestChooseCompression(t *testing.T) {
+	assert.Equal(t, "", ChooseCompression(nil))
+	assert.Equal(t, "", ChooseCompression([]string{"zstd", "lz4"}))
+	assert.Equal(t, "gzip", ChooseCompression([]string{"gzip"}))
+	assert.Equal(t, "gzip", ChooseCompression([]string{"zstd", "gzip"}))
+}
+
+func TestCompressMessage(t *testing.T) {
+	content := []byte(`{"message_type": "TURN", "game_state": {"cells": [` +
+		string(bytes.Repeat([]byte("0, "), 1000)) + `0]}}`)
+
+	compressed, err := CompressMessage(content)
+	assert.NoError(t, err, "Cannot compress message")
+	assert.True(t, IsCompressedMessage(compressed),
+		"Compressed message not detected")
+	assert.False(t, IsCompressedMessage(content),
+		"JSON message detected as compressed")
+	assert.True(t, len(compressed) < len(content),
+		"Repetitive message not smaller once compressed")
+
+	decompressed, err := DecompressMessage(compressed)
+	assert.NoError(t, err, "Cannot decompress message")
+	assert.Equal(t, content, decompressed)
+
+	_, err = DecompressMessage(compressed[:len(compressed)/2])
+	assert.Error(t, err, "No error on truncated compressed message")
+
+	_, err = DecompressMessage([]byte{0x1f, 0x8b, 0, 0})
+	assert.Error(t, err, "No error on invalid compressed message")
+}
+
+func TestDecompressMessageTooBig(t *testing.T) {
+	compressed, err := CompressMessage(make([]byte, MaxMessageSize+1))
+	assert.NoError(t, err, "Cannot compress message")
+	assert.True(t, len(compressed) < MaxMessageSize,
+		"Zeros not smaller once compressed")
+
+	_, err = DecompressMessage(compressed)
+	assert.Error(t, err, "No error on too big decompressed message")
+
+	compressed, err = CompressMessage(make([]byte, MaxMessageSize))
+	assert.NoError(t, err, "Cannot compress message")
+	_, err = DecompressMessage(compressed)
+	assert.NoError(t, err, "Error on maximum-sized decompressed message")
+}
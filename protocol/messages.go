@@ -0,0 +1,1256 @@
+// Package protocol defines the messages of the netorcai metaprotocol and
+// strict functions to decode them. It is shared by netorcai and its Go
+// client library.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+type MessageLogin struct {
+	MessageType         string `json:"message_type"`
+	Nickname            string `json:"nickname"`
+	Role                string `json:"role"`
+	MetaprotocolVersion string `json:"metaprotocol_version"`
+	// How the game state is sent in TURN messages.
+	// Empty if the client did not choose.
+	GameStateEncoding string `json:"game_state_encoding,omitempty"`
+	// Compression algorithms supported by the client, by order of
+	// preference. Empty if the client does not support compression.
+	Compression []string `json:"compression,omitempty"`
+	// Serialization format of the messages that follow LOGIN_ACK.
+	// Empty if the client did not choose (JSON).
+	Serialization string `json:"serialization,omitempty"`
+	// Number of player slots controlled by an agent.
+	// 0 for other roles.
+	NbSlots int `json:"nb_slots,omitempty"`
+}
+
+type MessageLoginAck struct {
+	MessageType         string `json:"message_type"`
+	MetaprotocolVersion string `json:"metaprotocol_version"`
+	// Compression algorithm used by both sides after LOGIN_ACK.
+	// Empty if messages are not compressed.
+	Compression string `json:"compression,omitempty"`
+	// Serialization format used by both sides after LOGIN_ACK.
+	// Empty if messages are serialized in JSON.
+	Serialization string `json:"serialization,omitempty"`
+}
+
+// Quite an immutable PlayerOrVisuClient generated at game start
+type PlayerInformation struct {
+	PlayerID      int    `json:"player_id"`
+	Nickname      string `json:"nickname"`
+	RemoteAddress string `json:"remote_address"`
+	IsConnected   bool   `json:"is_connected"`
+}
+
+type MessageGameStarts struct {
+	MessageType      string                 `json:"message_type"`
+	PlayerID         int                    `json:"player_id"`
+	NbPlayers        int                    `json:"nb_players"`
+	NbSpecialPlayers int                    `json:"nb_special_players"`
+	NbTurnsMax       int                    `json:"nb_turns_max"`
+	DelayFirstTurn   float64                `json:"milliseconds_before_first_turn"`
+	DelayTurns       float64                `json:"milliseconds_between_turns"`
+	InitialGameState map[string]interface{} `json:"initial_game_state"`
+	PlayersInfo      []*PlayerInformation   `json:"players_info"`
+	Episode          int                    `json:"episode"`
+	NbEpisodes       int                    `json:"nb_episodes"`
+	Game             int                    `json:"game"`
+	// The player IDs of the slots of an agent, nil for other roles.
+	PlayerIDs []int `json:"player_ids,omitempty"`
+}
+
+type MessageGameEnds struct {
+	MessageType    string                 `json:"message_type"`
+	Status         string                 `json:"status"`
+	WinnerPlayerID int                    `json:"winner_player_id"`
+	GameState      map[string]interface{} `json:"game_state"`
+	Episode        int                    `json:"episode"`
+	NbEpisodes     int                    `json:"nb_episodes"`
+	Game           int                    `json:"game"`
+	// Whether the client stays logged in for another game of the session.
+	NextGame bool `json:"next_game"`
+}
+
+// An action of a TURN_ACK that did not follow the game logic action schema.
+type InvalidAction struct {
+	TurnNumber int    `json:"turn_number"`
+	Index      int    `json:"index"`
+	Error      string `json:"error"`
+	// The slot whose actions are invalid, only set for agents.
+	PlayerID *int `json:"player_id,omitempty"`
+}
+
+// A game-dependent message from the game logic to one player.
+type PlayerFeedback struct {
+	PlayerID int         `json:"player_id"`
+	Content  interface{} `json:"content"`
+}
+
+type MessageTurn struct {
+	MessageType string                 `json:"message_type"`
+	TurnNumber  int                    `json:"turn_number"`
+	Actionable  bool                   `json:"actionable"`
+	GameState   map[string]interface{} `json:"game_state"`
+	// If set, game_state is not sent: this merge patch of the game state
+	// of the previous TURN (or GAME_STARTS) is sent instead.
+	GameStatePatch map[string]interface{} `json:"game_state_patch"`
+	// The same as GameStatePatch, as a JSON Patch.
+	GameStateJSONPatch []JSONPatchOperation `json:"game_state_json_patch"`
+	PlayersInfo        []*PlayerInformation `json:"players_info"`
+	SkippedTurns       int                  `json:"skipped_turns"`
+	InvalidActions     []InvalidAction      `json:"invalid_actions,omitempty"`
+	Feedback           []PlayerFeedback     `json:"feedback,omitempty"`
+	// The slots of an agent that can act on this turn, nil for other roles.
+	ActionablePlayerIDs []int `json:"actionable_player_ids,omitempty"`
+}
+
+// MarshalJSON only sends one of game_state, game_state_patch and
+// game_state_json_patch. Empty patches are sent, as they mean that the
+// game state has not changed.
+func (msg MessageTurn) MarshalJSON() ([]byte, error) {
+	return json.Marshal(msg.marshalValue())
+}
+
+func (msg MessageTurn) marshalValue() interface{} {
+	type turn MessageTurn // Without the MarshalJSON method
+	// The fields below shadow (and omit) the fields of turn
+	if msg.GameStatePatch != nil {
+		return struct {
+			turn
+			GameState          *struct{} `json:"game_state,omitempty"`
+			GameStateJSONPatch *struct{} `json:"game_state_json_patch,omitempty"`
+		}{turn: turn(msg)}
+	} else if msg.GameStateJSONPatch != nil {
+		return struct {
+			turn
+			GameState      *struct{} `json:"game_state,omitempty"`
+			GameStatePatch *struct{} `json:"game_state_patch,omitempty"`
+		}{turn: turn(msg)}
+	}
+
+	return struct {
+		turn
+		GameStatePatch     *struct{} `json:"game_state_patch,omitempty"`
+		GameStateJSONPatch *struct{} `json:"game_state_json_patch,omitempty"`
+	}{turn: turn(msg)}
+}
+
+// MarshalTurn serializes msg in the given serialization format, with a
+// game state field (game_state, game_state_patch or game_state_json_patch)
+// whose value has already been serialized in the same format.
+// This allows a game state to be serialized once for all the clients.
+// The game state fields of msg are ignored.
+func MarshalTurn(msg MessageTurn, serialization, field string,
+	value []byte) ([]byte, error) {
+	type turn MessageTurn // Without the MarshalJSON method
+	// The fields below shadow (and omit) the fields of turn
+	withoutGameState := struct {
+		turn
+		GameState          *struct{} `json:"game_state,omitempty"`
+		GameStatePatch     *struct{} `json:"game_state_patch,omitempty"`
+		GameStateJSONPatch *struct{} `json:"game_state_json_patch,omitempty"`
+	}{turn: turn(msg)}
+
+	if serialization == SerializationMessagePack {
+		content, err := MarshalMessagePack(withoutGameState)
+		if err != nil {
+			return nil, err
+		}
+		return spliceMessagePackMap(content, field, value)
+	}
+
+	content, err := json.Marshal(withoutGameState)
+	if err != nil {
+		return nil, err
+	}
+	// content is a non-empty JSON object: the field is inserted first.
+	key, _ := json.Marshal(field)
+	spliced := make([]byte, 0, len(content)+len(key)+len(value)+2)
+	spliced = append(spliced, '{')
+	spliced = append(spliced, key...)
+	spliced = append(spliced, ':')
+	spliced = append(spliced, value...)
+	spliced = append(spliced, ',')
+	return append(spliced, content[1:]...), nil
+}
+
+// UnmarshalJSONMessage decodes a JSON message.
+// The all_clients member of its game_state is also returned as sent (nil if
+// there is no such member), so that the game state of a DO_TURN_ACK can be
+// forwarded without being decoded or serialized again.
+func UnmarshalJSONMessage(data []byte) (map[string]interface{},
+	json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rawAllClients json.RawMessage
+	content := make(map[string]interface{}, len(fields))
+	for key, rawValue := range fields {
+		var gameStateFields map[string]json.RawMessage
+		if key == "game_state" &&
+			json.Unmarshal(rawValue, &gameStateFields) == nil &&
+			gameStateFields != nil {
+			gameState := make(map[string]interface{}, len(gameStateFields))
+			for gameStateKey, rawGameStateValue := range gameStateFields {
+				var value interface{}
+				err = json.Unmarshal(rawGameStateValue, &value)
+				if err != nil {
+					return nil, nil, err
+				}
+				gameState[gameStateKey] = value
+				if gameStateKey == "all_clients" {
+					rawAllClients = rawGameStateValue
+				}
+			}
+			content[key] = gameState
+			continue
+		}
+
+		var value interface{}
+		err = json.Unmarshal(rawValue, &value)
+		if err != nil {
+			return nil, nil, err
+		}
+		content[key] = value
+	}
+	return content, rawAllClients, nil
+}
+
+type MessageTurnAck struct {
+	MessageType string        `json:"message_type"`
+	TurnNumber  int           `json:"turn_number"`
+	Actions     []interface{} `json:"actions"`
+}
+
+// The actions of one slot of an agent.
+type AgentPlayerActions struct {
+	PlayerID int           `json:"player_id"`
+	Actions  []interface{} `json:"actions"`
+}
+
+// The TURN_ACK of an agent, which carries the actions of each of its slots.
+type MessageAgentTurnAck struct {
+	MessageType   string               `json:"message_type"`
+	TurnNumber    int                  `json:"turn_number"`
+	PlayerActions []AgentPlayerActions `json:"player_actions"`
+}
+
+type MessageDoInit struct {
+	MessageType      string `json:"message_type"`
+	NbPlayers        int    `json:"nb_players"`
+	NbSpecialPlayers int    `json:"nb_special_players"`
+	NbTurnsMax       int    `json:"nb_turns_max"`
+	Episode          int    `json:"episode"`
+	NbEpisodes       int    `json:"nb_episodes"`
+	Game             int    `json:"game"`
+}
+
+type MessageDoInitAck struct {
+	InitialGameState map[string]interface{}
+	ActionSchema     *ActionSchema // nil if the game logic did not set it
+}
+
+type MessageDoTurnPlayerAction struct {
+	PlayerID   int           `json:"player_id"`
+	TurnNumber int           `json:"turn_number"`
+	Actions    []interface{} `json:"actions"`
+}
+
+type MessageDoTurn struct {
+	MessageType   string                      `json:"message_type"`
+	PlayerActions []MessageDoTurnPlayerAction `json:"player_actions"`
+}
+
+type MessageDoTurnAck struct {
+	WinnerPlayerID int
+	GameState      map[string]interface{} // nil if a patch has been sent
+	// Patches of the previous game state, nil if the game logic did not
+	// send them (see ApplyGameStatePatch).
+	GameStatePatch     map[string]interface{}
+	GameStateJSONPatch []JSONPatchOperation
+	ActivePlayers      []int            // nil if the game logic did not set them
+	Feedback           []PlayerFeedback // nil if the game logic did not set it
+}
+
+// KickReasonGameFinished is the kick_reason of the KICK sent when the game
+// (or the session) has finished normally. Other reasons are failures.
+const KickReasonGameFinished = "Game is finished"
+
+type MessageKick struct {
+	MessageType string `json:"message_type"`
+	KickReason  string `json:"kick_reason"`
+}
+
+func CheckMessageType(data map[string]interface{}, expectedMessageType string) error {
+	messageType, err := ReadString(data, "message_type")
+	if err != nil {
+		return err
+	}
+
+	if messageType != expectedMessageType {
+		return fmt.Errorf("Received '%v' message type, "+
+			"while %v was expected", messageType, expectedMessageType)
+	}
+
+	return nil
+}
+
+func ReadLoginMessage(data map[string]interface{}) (MessageLogin, error) {
+	readMessage := MessageLogin{MessageType: "LOGIN"}
+
+	// Check message type
+	err := CheckMessageType(data, "LOGIN")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read nickname
+	readMessage.Nickname, err = ReadString(data, "nickname")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Check nickname
+	r, _ := regexp.Compile(`\A\S{1,10}\z`)
+	if !r.MatchString(readMessage.Nickname) {
+		return readMessage, fmt.Errorf("Invalid nickname")
+	}
+
+	// Read role
+	readMessage.Role, err = ReadString(data, "role")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Check role
+	switch readMessage.Role {
+	case "player", "special player", "agent",
+		"visualization",
+		"game logic":
+	default:
+		return readMessage, fmt.Errorf("Invalid role '%v'",
+			readMessage.Role)
+	}
+
+	// Read metaprotocol version
+	readMessage.MetaprotocolVersion, err = ReadString(data, "metaprotocol_version")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Check metaprotocol version
+	r, _ = regexp.Compile(`\A(?P<Major>\d+)\.(?P<Minor>\d+)\.(?P<Patch>\d+)\z`)
+	match := r.FindStringSubmatch(readMessage.MetaprotocolVersion)
+	if match == nil {
+		return readMessage, fmt.Errorf("Invalid metaprotocol version: Not MAJOR.MINOR.PATCH")
+	}
+
+	varMap := make(map[string]int)
+	for i, name := range r.SubexpNames() {
+		if i > 0 && i <= len(match) {
+			varMap[name], _ = strconv.Atoi(match[i])
+		}
+	}
+
+	if varMap["Major"] != VersionMajor {
+		return readMessage, fmt.Errorf(
+			"Metaprotocol version mismatch. Major version must be identical but client asks for '%s' while netorcai uses '%s'.",
+			readMessage.MetaprotocolVersion, Version)
+	}
+
+	// Read game state encoding (optional)
+	if _, exists := data["game_state_encoding"]; exists {
+		readMessage.GameStateEncoding, err = ReadString(data,
+			"game_state_encoding")
+		if err != nil {
+			return readMessage, err
+		}
+
+		switch readMessage.GameStateEncoding {
+		case "full", "merge-patch", "json-patch":
+		default:
+			return readMessage, fmt.Errorf("Invalid game_state_encoding '%v'",
+				readMessage.GameStateEncoding)
+		}
+	}
+
+	// Read compression algorithms (optional)
+	if _, exists := data["compression"]; exists {
+		algorithms, err := ReadArray(data, "compression")
+		if err != nil {
+			return readMessage, err
+		}
+
+		for _, algorithm := range algorithms {
+			name, ok := algorithm.(string)
+			if !ok {
+				return readMessage, fmt.Errorf("Non-string value in " +
+					"compression")
+			}
+			readMessage.Compression = append(readMessage.Compression, name)
+		}
+	}
+
+	// Read serialization format (optional)
+	if _, exists := data["serialization"]; exists {
+		readMessage.Serialization, err = ReadString(data, "serialization")
+		if err != nil {
+			return readMessage, err
+		}
+
+		switch readMessage.Serialization {
+		case SerializationJSON, SerializationMessagePack:
+		default:
+			return readMessage, fmt.Errorf("Invalid serialization '%v'",
+				readMessage.Serialization)
+		}
+	}
+
+	// Read the number of slots of an agent (optional)
+	if readMessage.Role == "agent" {
+		readMessage.NbSlots = 1
+		if _, exists := data["nb_slots"]; exists {
+			readMessage.NbSlots, err = ReadInt(data, "nb_slots")
+			if err != nil {
+				return readMessage, err
+			}
+
+			if readMessage.NbSlots < 1 || readMessage.NbSlots > 1024 {
+				return readMessage, fmt.Errorf("Invalid nb_slots: "+
+					"%v is not in [1, 1024]", readMessage.NbSlots)
+			}
+		}
+	}
+
+	return readMessage, nil
+}
+
+func ReadTurnAckMessage(data map[string]interface{}, expectedTurnNumber int) (
+	MessageTurnAck, error) {
+	readMessage := MessageTurnAck{MessageType: "TURN_ACK"}
+
+	// Check message type
+	err := CheckMessageType(data, "TURN_ACK")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read turn number
+	readMessage.TurnNumber, err = ReadInt(data, "turn_number")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Check turn number
+	if readMessage.TurnNumber != expectedTurnNumber {
+		return readMessage, fmt.Errorf("Invalid value (turn_number=%v): "+
+			"expecting %v", readMessage.TurnNumber, expectedTurnNumber)
+	}
+
+	// Read actions
+	readMessage.Actions, err = ReadArray(data, "actions")
+	if err != nil {
+		return readMessage, err
+	}
+
+	return readMessage, nil
+}
+
+// ReadAgentTurnAckMessage reads the TURN_ACK of an agent. Whether the
+// agent controls the slots of player_actions is not checked.
+func ReadAgentTurnAckMessage(data map[string]interface{},
+	expectedTurnNumber int) (MessageAgentTurnAck, error) {
+	readMessage := MessageAgentTurnAck{MessageType: "TURN_ACK",
+		PlayerActions: []AgentPlayerActions{}}
+
+	// Check message type
+	err := CheckMessageType(data, "TURN_ACK")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read turn number
+	readMessage.TurnNumber, err = ReadInt(data, "turn_number")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Check turn number
+	if readMessage.TurnNumber != expectedTurnNumber {
+		return readMessage, fmt.Errorf("Invalid value (turn_number=%v): "+
+			"expecting %v", readMessage.TurnNumber, expectedTurnNumber)
+	}
+
+	// Read the actions of each slot
+	array, err := ReadArray(data, "player_actions")
+	if err != nil {
+		return readMessage, err
+	}
+
+	for index, value := range array {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return readMessage, fmt.Errorf("Invalid player_actions: "+
+				"Non-object value at index %v", index)
+		}
+
+		var actions AgentPlayerActions
+		actions.PlayerID, err = ReadInt(object, "player_id")
+		if err != nil {
+			return readMessage, fmt.Errorf("Invalid player_actions: %v", err)
+		}
+
+		actions.Actions, err = ReadArray(object, "actions")
+		if err != nil {
+			return readMessage, fmt.Errorf("Invalid player_actions: %v", err)
+		}
+		readMessage.PlayerActions = append(readMessage.PlayerActions, actions)
+	}
+
+	return readMessage, nil
+}
+
+func ReadDoInitAckMessage(data map[string]interface{}) (
+	MessageDoInitAck, error) {
+	var readMessage MessageDoInitAck
+
+	// Check message type
+	err := CheckMessageType(data, "DO_INIT_ACK")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read game state
+	gameState, err := ReadObject(data, "initial_game_state")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read game state -> all clients
+	readMessage.InitialGameState, err = ReadObject(gameState, "all_clients")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read action schema (optional)
+	if _, exists := data["action_schema"]; exists {
+		schema, err := ReadObject(data, "action_schema")
+		if err != nil {
+			return readMessage, err
+		}
+
+		readMessage.ActionSchema, err = CompileActionSchema(schema)
+		if err != nil {
+			return readMessage, fmt.Errorf("Invalid action_schema: %v", err)
+		}
+	}
+
+	return readMessage, nil
+}
+
+func ReadDoTurnAckMessage(data map[string]interface{}, nbPlayers int) (
+	MessageDoTurnAck, error) {
+	var readMessage MessageDoTurnAck
+
+	// Check message type
+	err := CheckMessageType(data, "DO_TURN_ACK")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read winner player id
+	readMessage.WinnerPlayerID, err = ReadInt(data, "winner_player_id")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Check player id
+	if readMessage.WinnerPlayerID < -1 ||
+		readMessage.WinnerPlayerID >= nbPlayers {
+		return readMessage, fmt.Errorf("Invalid winner_player_id: "+
+			"Not in [-1, %v[", nbPlayers)
+	}
+
+	// Read game state, or its patch
+	if _, exists := data["game_state_patch"]; exists {
+		readMessage.GameStatePatch, err = ReadObject(data, "game_state_patch")
+		if err != nil {
+			return readMessage, err
+		}
+	} else if _, exists := data["game_state_json_patch"]; exists {
+		readMessage.GameStateJSONPatch, err = readJSONPatch(data,
+			"game_state_json_patch")
+		if err != nil {
+			return readMessage, err
+		}
+	} else {
+		gameState, err := ReadObject(data, "game_state")
+		if err != nil {
+			return readMessage, err
+		}
+
+		// Read game state -> all clients
+		readMessage.GameState, err = ReadObject(gameState, "all_clients")
+		if err != nil {
+			return readMessage, err
+		}
+	}
+
+	// Read active players (optional)
+	if _, exists := data["active_players"]; exists {
+		activePlayers, err := ReadArray(data, "active_players")
+		if err != nil {
+			return readMessage, err
+		}
+
+		readMessage.ActivePlayers = make([]int, 0, len(activePlayers))
+		for index, value := range activePlayers {
+			playerID, isNumber := value.(float64)
+			if !isNumber || playerID != float64(int(playerID)) {
+				return readMessage, fmt.Errorf("Invalid active_players: "+
+					"Non-integral value at index %v", index)
+			}
+
+			if int(playerID) < 0 || int(playerID) >= nbPlayers {
+				return readMessage, fmt.Errorf("Invalid active_players: "+
+					"Value at index %v not in [0, %v[", index, nbPlayers)
+			}
+			readMessage.ActivePlayers = append(readMessage.ActivePlayers,
+				int(playerID))
+		}
+	}
+
+	// Read feedback (optional)
+	if _, exists := data["feedback"]; exists {
+		readMessage.Feedback, err = readFeedback(data, nbPlayers)
+		if err != nil {
+			return readMessage, err
+		}
+	}
+
+	return readMessage, nil
+}
+
+// ApplyGameStatePatch sets GameState by applying the patch sent by the game
+// logic (if any) to the game state of the previous DO_TURN_ACK (or of
+// DO_INIT_ACK). As the game_state sent by the game logic, patches apply to
+// an object that contains the game state in its all_clients field.
+func (msg *MessageDoTurnAck) ApplyGameStatePatch(
+	previousGameState map[string]interface{}) error {
+	previous := map[string]interface{}{"all_clients": previousGameState}
+	var patched interface{}
+	if msg.GameStatePatch != nil {
+		patched = ApplyMergePatch(previous, msg.GameStatePatch)
+	} else if msg.GameStateJSONPatch != nil {
+		var err error
+		patched, err = ApplyJSONPatch(previous, msg.GameStateJSONPatch)
+		if err != nil {
+			return fmt.Errorf("Invalid game_state_json_patch: %v", err)
+		}
+	} else {
+		return nil
+	}
+
+	gameState, isObject := patched.(map[string]interface{})
+	if !isObject {
+		return fmt.Errorf("Patched game state is not an object")
+	}
+
+	allClients, err := ReadObject(gameState, "all_clients")
+	if err != nil {
+		return fmt.Errorf("Patched game state is invalid: %v", err)
+	}
+	msg.GameState = allClients
+	return nil
+}
+
+func readFeedback(data map[string]interface{}, nbPlayers int) (
+	[]PlayerFeedback, error) {
+	feedback := []PlayerFeedback{}
+	array, err := ReadArray(data, "feedback")
+	if err != nil {
+		return feedback, err
+	}
+
+	for index, value := range array {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return feedback, fmt.Errorf("Invalid feedback: "+
+				"Non-object value at index %v", index)
+		}
+
+		var playerFeedback PlayerFeedback
+		playerFeedback.PlayerID, err = ReadInt(object, "player_id")
+		if err != nil {
+			return feedback, fmt.Errorf("Invalid feedback: %v", err)
+		}
+
+		if playerFeedback.PlayerID < 0 || playerFeedback.PlayerID >= nbPlayers {
+			return feedback, fmt.Errorf("Invalid feedback: "+
+				"player_id at index %v not in [0, %v[", index, nbPlayers)
+		}
+
+		content, exists := object["content"]
+		if !exists {
+			return feedback, fmt.Errorf("Invalid feedback: " +
+				"Field 'content' is missing")
+		}
+		playerFeedback.Content = content
+		feedback = append(feedback, playerFeedback)
+	}
+
+	return feedback, nil
+}
+
+func readPlayersInfo(data map[string]interface{}) ([]*PlayerInformation,
+	error) {
+	playersInfo := []*PlayerInformation{}
+	array, err := ReadArray(data, "players_info")
+	if err != nil {
+		return playersInfo, err
+	}
+
+	for index, value := range array {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return playersInfo, fmt.Errorf("Invalid players_info: "+
+				"Non-object value at index %v", index)
+		}
+
+		var info PlayerInformation
+		info.PlayerID, err = ReadInt(object, "player_id")
+		if err != nil {
+			return playersInfo, fmt.Errorf("Invalid players_info: %v", err)
+		}
+		info.Nickname, err = ReadString(object, "nickname")
+		if err != nil {
+			return playersInfo, fmt.Errorf("Invalid players_info: %v", err)
+		}
+		info.RemoteAddress, err = ReadString(object, "remote_address")
+		if err != nil {
+			return playersInfo, fmt.Errorf("Invalid players_info: %v", err)
+		}
+		info.IsConnected, err = ReadBool(object, "is_connected")
+		if err != nil {
+			return playersInfo, fmt.Errorf("Invalid players_info: %v", err)
+		}
+		playersInfo = append(playersInfo, &info)
+	}
+
+	return playersInfo, nil
+}
+
+func ReadLoginAckMessage(data map[string]interface{}) (
+	MessageLoginAck, error) {
+	readMessage := MessageLoginAck{MessageType: "LOGIN_ACK"}
+
+	// Check message type
+	err := CheckMessageType(data, "LOGIN_ACK")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read metaprotocol version
+	readMessage.MetaprotocolVersion, err = ReadString(data,
+		"metaprotocol_version")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read compression algorithm (optional)
+	if _, exists := data["compression"]; exists {
+		readMessage.Compression, err = ReadString(data, "compression")
+		if err != nil {
+			return readMessage, err
+		}
+	}
+
+	// Read serialization format (optional)
+	if _, exists := data["serialization"]; exists {
+		readMessage.Serialization, err = ReadString(data, "serialization")
+		if err != nil {
+			return readMessage, err
+		}
+	}
+
+	return readMessage, nil
+}
+
+func ReadKickMessage(data map[string]interface{}) (MessageKick, error) {
+	readMessage := MessageKick{MessageType: "KICK"}
+
+	// Check message type
+	err := CheckMessageType(data, "KICK")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read kick reason
+	readMessage.KickReason, err = ReadString(data, "kick_reason")
+	if err != nil {
+		return readMessage, err
+	}
+
+	return readMessage, nil
+}
+
+func ReadGameStartsMessage(data map[string]interface{}) (
+	MessageGameStarts, error) {
+	readMessage := MessageGameStarts{MessageType: "GAME_STARTS"}
+
+	// Check message type
+	err := CheckMessageType(data, "GAME_STARTS")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read player id
+	readMessage.PlayerID, err = ReadInt(data, "player_id")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read the player ids of an agent (optional)
+	if _, exists := data["player_ids"]; exists {
+		readMessage.PlayerIDs, err = readIntArray(data, "player_ids")
+		if err != nil {
+			return readMessage, err
+		}
+	}
+
+	// Read episode
+	readMessage.Episode, readMessage.NbEpisodes, err = readEpisode(data)
+	if err != nil {
+		return readMessage, err
+	}
+
+	readMessage.Game, err = readGame(data)
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read players info
+	readMessage.PlayersInfo, err = readPlayersInfo(data)
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read game parameters
+	readMessage.NbPlayers, err = ReadInt(data, "nb_players")
+	if err != nil {
+		return readMessage, err
+	}
+
+	readMessage.NbSpecialPlayers, err = ReadInt(data, "nb_special_players")
+	if err != nil {
+		return readMessage, err
+	}
+
+	readMessage.NbTurnsMax, err = ReadInt(data, "nb_turns_max")
+	if err != nil {
+		return readMessage, err
+	}
+
+	readMessage.DelayFirstTurn, err = ReadFloat(data,
+		"milliseconds_before_first_turn")
+	if err != nil {
+		return readMessage, err
+	}
+
+	readMessage.DelayTurns, err = ReadFloat(data,
+		"milliseconds_between_turns")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read initial game state
+	readMessage.InitialGameState, err = ReadObject(data,
+		"initial_game_state")
+	if err != nil {
+		return readMessage, err
+	}
+
+	return readMessage, nil
+}
+
+func ReadTurnMessage(data map[string]interface{}) (MessageTurn, error) {
+	readMessage := MessageTurn{MessageType: "TURN"}
+
+	// Check message type
+	err := CheckMessageType(data, "TURN")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read turn number
+	readMessage.TurnNumber, err = ReadInt(data, "turn_number")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read actionable
+	readMessage.Actionable, err = ReadBool(data, "actionable")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read the actionable player ids of an agent (optional)
+	if _, exists := data["actionable_player_ids"]; exists {
+		readMessage.ActionablePlayerIDs, err = readIntArray(data,
+			"actionable_player_ids")
+		if err != nil {
+			return readMessage, err
+		}
+	}
+
+	// Read skipped turns
+	readMessage.SkippedTurns, err = ReadInt(data, "skipped_turns")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read game state, or its patch
+	if _, exists := data["game_state_patch"]; exists {
+		readMessage.GameStatePatch, err = ReadObject(data, "game_state_patch")
+	} else if _, exists := data["game_state_json_patch"]; exists {
+		readMessage.GameStateJSONPatch, err = readJSONPatch(data,
+			"game_state_json_patch")
+	} else {
+		readMessage.GameState, err = ReadObject(data, "game_state")
+	}
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read players info
+	readMessage.PlayersInfo, err = readPlayersInfo(data)
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read invalid actions (optional)
+	if _, exists := data["invalid_actions"]; exists {
+		readMessage.InvalidActions, err = readInvalidActions(data)
+		if err != nil {
+			return readMessage, err
+		}
+	}
+
+	// Read feedback (optional)
+	if _, exists := data["feedback"]; exists {
+		// The player count is unknown to clients.
+		readMessage.Feedback, err = readFeedback(data, math.MaxInt32)
+		if err != nil {
+			return readMessage, err
+		}
+	}
+
+	return readMessage, nil
+}
+
+func readInvalidActions(data map[string]interface{}) ([]InvalidAction,
+	error) {
+	invalidActions := []InvalidAction{}
+	array, err := ReadArray(data, "invalid_actions")
+	if err != nil {
+		return invalidActions, err
+	}
+
+	for index, value := range array {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return invalidActions, fmt.Errorf("Invalid invalid_actions: "+
+				"Non-object value at index %v", index)
+		}
+
+		var invalidAction InvalidAction
+		invalidAction.TurnNumber, err = ReadInt(object, "turn_number")
+		if err != nil {
+			return invalidActions, fmt.Errorf("Invalid invalid_actions: %v",
+				err)
+		}
+		invalidAction.Index, err = ReadInt(object, "index")
+		if err != nil {
+			return invalidActions, fmt.Errorf("Invalid invalid_actions: %v",
+				err)
+		}
+		invalidAction.Error, err = ReadString(object, "error")
+		if err != nil {
+			return invalidActions, fmt.Errorf("Invalid invalid_actions: %v",
+				err)
+		}
+		if _, exists := object["player_id"]; exists {
+			playerID, err := ReadInt(object, "player_id")
+			if err != nil {
+				return invalidActions, fmt.Errorf(
+					"Invalid invalid_actions: %v", err)
+			}
+			invalidAction.PlayerID = &playerID
+		}
+		invalidActions = append(invalidActions, invalidAction)
+	}
+
+	return invalidActions, nil
+}
+
+func ReadGameEndsMessage(data map[string]interface{}) (
+	MessageGameEnds, error) {
+	readMessage := MessageGameEnds{MessageType: "GAME_ENDS"}
+
+	// Check message type
+	err := CheckMessageType(data, "GAME_ENDS")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read status
+	readMessage.Status, err = ReadString(data, "status")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read winner player id
+	readMessage.WinnerPlayerID, err = ReadInt(data, "winner_player_id")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read game state
+	readMessage.GameState, err = ReadObject(data, "game_state")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read episode
+	readMessage.Episode, readMessage.NbEpisodes, err = readEpisode(data)
+	if err != nil {
+		return readMessage, err
+	}
+
+	readMessage.Game, err = readGame(data)
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read whether another game follows (optional)
+	if _, exists := data["next_game"]; exists {
+		readMessage.NextGame, err = ReadBool(data, "next_game")
+		if err != nil {
+			return readMessage, err
+		}
+	}
+
+	return readMessage, nil
+}
+
+func ReadDoInitMessage(data map[string]interface{}) (MessageDoInit, error) {
+	readMessage := MessageDoInit{MessageType: "DO_INIT"}
+
+	// Check message type
+	err := CheckMessageType(data, "DO_INIT")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read game parameters
+	readMessage.NbPlayers, err = ReadInt(data, "nb_players")
+	if err != nil {
+		return readMessage, err
+	}
+
+	readMessage.NbSpecialPlayers, err = ReadInt(data, "nb_special_players")
+	if err != nil {
+		return readMessage, err
+	}
+
+	readMessage.NbTurnsMax, err = ReadInt(data, "nb_turns_max")
+	if err != nil {
+		return readMessage, err
+	}
+
+	if readMessage.NbPlayers < 0 || readMessage.NbSpecialPlayers < 0 ||
+		readMessage.NbTurnsMax < 0 {
+		return readMessage, fmt.Errorf("Invalid DO_INIT: negative value")
+	}
+
+	// Read episode
+	readMessage.Episode, readMessage.NbEpisodes, err = readEpisode(data)
+	if err != nil {
+		return readMessage, err
+	}
+
+	readMessage.Game, err = readGame(data)
+	if err != nil {
+		return readMessage, err
+	}
+
+	return readMessage, nil
+}
+
+// readEpisode reads the episode and nb_episodes fields. They are optional,
+// as they are not sent by older netorcai versions: a single episode is
+// assumed then.
+func readEpisode(data map[string]interface{}) (episode, nbEpisodes int,
+	err error) {
+	nbEpisodes = 1
+	if _, exists := data["nb_episodes"]; exists {
+		nbEpisodes, err = ReadInt(data, "nb_episodes")
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if _, exists := data["episode"]; exists {
+		episode, err = ReadInt(data, "episode")
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if nbEpisodes < 1 || episode < 0 || episode >= nbEpisodes {
+		return 0, 0, fmt.Errorf("Invalid episode: %v is not in [0, %v[",
+			episode, nbEpisodes)
+	}
+	return episode, nbEpisodes, nil
+}
+
+// readGame reads the optional game field, the index of the game in the
+// session (0 if absent).
+func readGame(data map[string]interface{}) (int, error) {
+	if _, exists := data["game"]; !exists {
+		return 0, nil
+	}
+	game, err := ReadInt(data, "game")
+	if err == nil && game < 0 {
+		err = fmt.Errorf("Invalid game: %v is negative", game)
+	}
+	return game, err
+}
+
+func readIntArray(data map[string]interface{}, field string) ([]int, error) {
+	array, err := ReadArray(data, field)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]int, 0, len(array))
+	for index, value := range array {
+		number, isNumber := value.(float64)
+		if !isNumber || number != float64(int(number)) {
+			return nil, fmt.Errorf("Invalid %v: "+
+				"Non-integral value at index %v", field, index)
+		}
+		values = append(values, int(number))
+	}
+	return values, nil
+}
+
+func ReadDoTurnMessage(data map[string]interface{}, nbPlayers int) (
+	MessageDoTurn, error) {
+	readMessage := MessageDoTurn{MessageType: "DO_TURN",
+		PlayerActions: []MessageDoTurnPlayerAction{}}
+
+	// Check message type
+	err := CheckMessageType(data, "DO_TURN")
+	if err != nil {
+		return readMessage, err
+	}
+
+	// Read player actions
+	array, err := ReadArray(data, "player_actions")
+	if err != nil {
+		return readMessage, err
+	}
+
+	for index, value := range array {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return readMessage, fmt.Errorf("Invalid player_actions: "+
+				"Non-object value at index %v", index)
+		}
+
+		var action MessageDoTurnPlayerAction
+		action.PlayerID, err = ReadInt(object, "player_id")
+		if err != nil {
+			return readMessage, fmt.Errorf("Invalid player_actions: %v", err)
+		}
+
+		if action.PlayerID < 0 || action.PlayerID >= nbPlayers {
+			return readMessage, fmt.Errorf("Invalid player_actions: "+
+				"player_id at index %v not in [0, %v[", index, nbPlayers)
+		}
+
+		action.TurnNumber, err = ReadInt(object, "turn_number")
+		if err != nil {
+			return readMessage, fmt.Errorf("Invalid player_actions: %v", err)
+		}
+
+		action.Actions, err = ReadArray(object, "actions")
+		if err != nil {
+			return readMessage, fmt.Errorf("Invalid player_actions: %v", err)
+		}
+		readMessage.PlayerActions = append(readMessage.PlayerActions, action)
+	}
+
+	return readMessage, nil
+}
+
+func readJSONPatch(data map[string]interface{}, field string) (
+	[]JSONPatchOperation, error) {
+	patch := []JSONPatchOperation{}
+	array, err := ReadArray(data, field)
+	if err != nil {
+		return patch, err
+	}
+
+	for index, value := range array {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return patch, fmt.Errorf("Invalid %v: "+
+				"Non-object value at index %v", field, index)
+		}
+
+		var op JSONPatchOperation
+		op.Op, err = ReadString(object, "op")
+		if err == nil {
+			op.Path, err = ReadString(object, "path")
+		}
+		if err == nil {
+			_, err = parsePointer(op.Path)
+		}
+		if err == nil {
+			switch op.Op {
+			case "add", "replace", "test":
+				var exists bool
+				op.Value, exists = object["value"]
+				if !exists {
+					err = fmt.Errorf("Field 'value' is missing")
+				}
+			case "move", "copy":
+				op.From, err = ReadString(object, "from")
+				if err == nil {
+					_, err = parsePointer(op.From)
+				}
+			case "remove":
+			default:
+				err = fmt.Errorf("Unknown operation '%v'", op.Op)
+			}
+		}
+		if err != nil {
+			return patch, fmt.Errorf("Invalid %v: Operation at index %v: %v",
+				field, index, err)
+		}
+		patch = append(patch, op)
+	}
+
+	return patch, nil
+}
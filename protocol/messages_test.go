@@ -0,0 +1,459 @@
+package protocol
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func decode(t *testing.T, str string) map[string]interface{} {
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(str), &data)
+	assert.NoError(t, err, "Invalid JSON in test")
+	return data
+}
+
+func TestReadInt(t *testing.T) {
+	data := decode(t, `{"int": 42, "float": 4.2, "string": "42"}`)
+
+	value, err := ReadInt(data, "int")
+	assert.NoError(t, err, "Error on integral value")
+	assert.Equal(t, 42, value)
+
+	_, err = ReadInt(data, "float")
+	assert.Error(t, err, "No error on non-integral value")
+
+	_, err = ReadInt(data, "string")
+	assert.Error(t, err, "No error on string value")
+
+	_, err = ReadInt(data, "missing")
+	assert.Error(t, err, "No error on missing field")
+}
+
+func TestReadGameStartsMessage(t *testing.T) {
+	data := decode(t, `{"message_type": "GAME_STARTS", "player_id": 1,
+		"players_info": [{"player_id": 1, "nickname": "bot",
+		                  "remote_address": "127.0.0.1:4242",
+		                  "is_connected": true}],
+		"nb_players": 2, "nb_special_players": 0, "nb_turns_max": 10,
+		"milliseconds_before_first_turn": 1000,
+		"milliseconds_between_turns": 500.5,
+		"initial_game_state": {"meh": 0}}`)
+
+	msg, err := ReadGameStartsMessage(data)
+	assert.NoError(t, err, "Valid GAME_STARTS not decoded")
+	assert.Equal(t, 1, msg.PlayerID)
+	assert.Equal(t, 2, msg.NbPlayers)
+	assert.Equal(t, 10, msg.NbTurnsMax)
+	assert.Equal(t, 500.5, msg.DelayTurns)
+	assert.Equal(t, []*PlayerInformation{{PlayerID: 1, Nickname: "bot",
+		RemoteAddress: "127.0.0.1:4242", IsConnected: true}}, msg.PlayersInfo)
+	assert.Equal(t, map[string]interface{}{"meh": 0.0}, msg.InitialGameState)
+
+	delete(data, "nb_turns_max")
+	_, err = ReadGameStartsMessage(data)
+	assert.Error(t, err, "No error on missing nb_turns_max")
+
+	data = decode(t, `{"message_type": "TURN"}`)
+	_, err = ReadGameStartsMessage(data)
+	assert.Error(t, err, "No error on bad message type")
+}
+
+func TestReadTurnMessage(t *testing.T) {
+	data := decode(t, `{"message_type": "TURN", "turn_number": 3,
+		"actionable": true, "skipped_turns": 0, "game_state": {}, "players_info": []}`)
+
+	msg, err := ReadTurnMessage(data)
+	assert.NoError(t, err, "Valid TURN not decoded")
+	assert.Equal(t, 3, msg.TurnNumber)
+	assert.True(t, msg.Actionable)
+
+	data["actionable"] = "yes"
+	_, err = ReadTurnMessage(data)
+	assert.Error(t, err, "No error on non-bool actionable")
+
+	data["actionable"] = true
+	delete(data, "skipped_turns")
+	_, err = ReadTurnMessage(data)
+	assert.Error(t, err, "No error on missing skipped_turns")
+
+	data = decode(t, `{"message_type": "TURN", "turn_number": 3,
+		"actionable": true, "skipped_turns": 0, "game_state": {}, "players_info": [0]}`)
+	_, err = ReadTurnMessage(data)
+	assert.Error(t, err, "No error on non-object players_info element")
+}
+
+func TestReadTurnMessageInvalidActions(t *testing.T) {
+	data := decode(t, `{"message_type": "TURN", "turn_number": 3,
+		"actionable": true, "skipped_turns": 0, "game_state": {}, "players_info": [],
+		"invalid_actions": [{"turn_number": 2, "index": 1, "error": "meh"}]}`)
+
+	msg, err := ReadTurnMessage(data)
+	assert.NoError(t, err, "Valid TURN not decoded")
+	assert.Equal(t, []InvalidAction{{TurnNumber: 2, Index: 1, Error: "meh"}},
+		msg.InvalidActions)
+
+	data = decode(t, `{"message_type": "TURN", "turn_number": 3,
+		"actionable": true, "skipped_turns": 0, "game_state": {}, "players_info": [],
+		"invalid_actions": [{"turn_number": 2, "index": 1}]}`)
+	_, err = ReadTurnMessage(data)
+	assert.Error(t, err, "No error on missing invalid_actions error")
+}
+
+func TestTurnMessageGameStatePatch(t *testing.T) {
+	msg := MessageTurn{MessageType: "TURN", TurnNumber: 3,
+		GameState:      map[string]interface{}{"turn": 3},
+		GameStatePatch: map[string]interface{}{"turn": 3},
+		PlayersInfo:    []*PlayerInformation{}}
+	content, err := json.Marshal(msg)
+	assert.NoError(t, err, "Cannot marshal TURN")
+
+	data := decode(t, string(content))
+	assert.NotContains(t, data, "game_state", "game_state sent with its patch")
+
+	readMsg, err := ReadTurnMessage(data)
+	assert.NoError(t, err, "Valid TURN not decoded")
+	assert.Nil(t, readMsg.GameState, "Unexpected game state")
+	assert.Equal(t, map[string]interface{}{"turn": 3.0},
+		readMsg.GameStatePatch)
+
+	msg.GameStatePatch = nil
+	content, err = json.Marshal(msg)
+	assert.NoError(t, err, "Cannot marshal TURN")
+	data = decode(t, string(content))
+	assert.NotContains(t, data, "game_state_patch", "Unexpected patch")
+
+	readMsg, err = ReadTurnMessage(data)
+	assert.NoError(t, err, "Valid TURN not decoded")
+	assert.Equal(t, map[string]interface{}{"turn": 3.0}, readMsg.GameState)
+}
+
+func TestTurnMessageGameStateJSONPatch(t *testing.T) {
+	msg := MessageTurn{MessageType: "TURN", TurnNumber: 3,
+		GameState:          map[string]interface{}{"turn": 3},
+		GameStateJSONPatch: []JSONPatchOperation{},
+		PlayersInfo:        []*PlayerInformation{}}
+	content, err := json.Marshal(msg)
+	assert.NoError(t, err, "Cannot marshal TURN")
+
+	// Empty patches are sent, as the game state is not.
+	data := decode(t, string(content))
+	assert.NotContains(t, data, "game_state", "game_state sent with its patch")
+	assert.NotContains(t, data, "game_state_patch", "Unexpected merge patch")
+
+	readMsg, err := ReadTurnMessage(data)
+	assert.NoError(t, err, "Valid TURN not decoded")
+	assert.Equal(t, []JSONPatchOperation{}, readMsg.GameStateJSONPatch)
+
+	data["game_state_json_patch"] = decode(t,
+		`{"patch": [{"op": "add", "path": "/turn"}]}`)["patch"]
+	_, err = ReadTurnMessage(data)
+	assert.Error(t, err, "No error on add operation without value")
+
+	data["game_state_json_patch"] = decode(t,
+		`{"patch": [{"op": "copy", "path": "/turn", "from": "turn"}]}`)["patch"]
+	_, err = ReadTurnMessage(data)
+	assert.Error(t, err, "No error on invalid pointer")
+
+	data["game_state_json_patch"] = decode(t,
+		`{"patch": [{"op": "merge", "path": "/turn"}]}`)["patch"]
+	_, err = ReadTurnMessage(data)
+	assert.Error(t, err, "No error on unknown operation")
+}
+
+func TestReadGameEndsMessage(t *testing.T) {
+	data := decode(t, `{"message_type": "GAME_ENDS", "status": "finished",
+		"winner_player_id": -1, "game_state": {}}`)
+
+	msg, err := ReadGameEndsMessage(data)
+	assert.NoError(t, err, "Valid GAME_ENDS not decoded")
+	assert.Equal(t, "finished", msg.Status)
+	assert.Equal(t, -1, msg.WinnerPlayerID)
+
+	delete(data, "status")
+	_, err = ReadGameEndsMessage(data)
+	assert.Error(t, err, "No error on missing status")
+}
+
+func TestReadGameEndsMessageEpisode(t *testing.T) {
+	data := decode(t, `{"message_type": "GAME_ENDS", "status": "finished",
+		"winner_player_id": -1, "game_state": {}}`)
+	msg, err := ReadGameEndsMessage(data)
+	assert.NoError(t, err, "Valid GAME_ENDS not decoded")
+	assert.Equal(t, 0, msg.Episode)
+	assert.Equal(t, 1, msg.NbEpisodes)
+
+	data["episode"] = 2.0
+	data["nb_episodes"] = 3.0
+	msg, err = ReadGameEndsMessage(data)
+	assert.NoError(t, err, "Valid GAME_ENDS not decoded")
+	assert.Equal(t, 2, msg.Episode)
+	assert.Equal(t, 3, msg.NbEpisodes)
+
+	data["episode"] = 3.0
+	_, err = ReadGameEndsMessage(data)
+	assert.Error(t, err, "No error on episode out of range")
+
+	data["episode"] = 0.0
+	data["nb_episodes"] = 0.0
+	_, err = ReadGameEndsMessage(data)
+	assert.Error(t, err, "No error on invalid nb_episodes")
+}
+
+func TestReadDoInitAckMessage(t *testing.T) {
+	data := decode(t, `{"message_type": "DO_INIT_ACK",
+		"initial_game_state": {"all_clients": {"meh": 0}}}`)
+
+	msg, err := ReadDoInitAckMessage(data)
+	assert.NoError(t, err, "Valid DO_INIT_ACK not decoded")
+	assert.Equal(t, map[string]interface{}{"meh": 0.0}, msg.InitialGameState)
+	assert.Nil(t, msg.ActionSchema, "Unexpected action schema")
+
+	data["action_schema"] = decode(t, `{"type": "object"}`)
+	msg, err = ReadDoInitAckMessage(data)
+	assert.NoError(t, err, "Valid DO_INIT_ACK with schema not decoded")
+	assert.NotNil(t, msg.ActionSchema, "Action schema not decoded")
+
+	data["action_schema"] = decode(t, `{"$ref": "#/definitions/move"}`)
+	_, err = ReadDoInitAckMessage(data)
+	assert.Error(t, err, "No error on unsupported schema keyword")
+
+	data["action_schema"] = true
+	_, err = ReadDoInitAckMessage(data)
+	assert.Error(t, err, "No error on non-object action_schema")
+}
+
+func TestReadDoTurnMessage(t *testing.T) {
+	data := decode(t, `{"message_type": "DO_TURN", "player_actions": [
+		{"player_id": 1, "turn_number": 0, "actions": [{"move": "up"}]}]}`)
+
+	msg, err := ReadDoTurnMessage(data, 2)
+	assert.NoError(t, err, "Valid DO_TURN not decoded")
+	assert.Equal(t, []MessageDoTurnPlayerAction{{PlayerID: 1, TurnNumber: 0,
+		Actions: []interface{}{map[string]interface{}{"move": "up"}}}},
+		msg.PlayerActions)
+
+	_, err = ReadDoTurnMessage(data, 1)
+	assert.Error(t, err, "No error on out-of-range player_id")
+}
+
+func TestReadDoTurnAckMessageFeedback(t *testing.T) {
+	data := decode(t, `{"message_type": "DO_TURN_ACK", "winner_player_id": -1,
+		"game_state": {"all_clients": {}},
+		"feedback": [{"player_id": 1, "content": {"error": "wall"}}]}`)
+
+	msg, err := ReadDoTurnAckMessage(data, 2)
+	assert.NoError(t, err, "Valid DO_TURN_ACK not decoded")
+	assert.Equal(t, []PlayerFeedback{{PlayerID: 1,
+		Content: map[string]interface{}{"error": "wall"}}}, msg.Feedback)
+
+	_, err = ReadDoTurnAckMessage(data, 1)
+	assert.Error(t, err, "No error on out-of-range feedback player_id")
+
+	delete(data, "feedback")
+	msg, err = ReadDoTurnAckMessage(data, 2)
+	assert.NoError(t, err, "Valid DO_TURN_ACK not decoded")
+	assert.Nil(t, msg.Feedback, "Unexpected feedback")
+
+	data = decode(t, `{"message_type": "DO_TURN_ACK", "winner_player_id": -1,
+		"game_state": {"all_clients": {}}, "feedback": [{"player_id": 0}]}`)
+	_, err = ReadDoTurnAckMessage(data, 2)
+	assert.Error(t, err, "No error on missing feedback content")
+}
+
+func TestReadDoTurnAckMessagePatch(t *testing.T) {
+	previous := map[string]interface{}{"turn": 1.0, "board": []interface{}{}}
+
+	data := decode(t, `{"message_type": "DO_TURN_ACK", "winner_player_id": -1,
+		"game_state_patch": {"all_clients": {"turn": 2, "board": null}}}`)
+	msg, err := ReadDoTurnAckMessage(data, 2)
+	assert.NoError(t, err, "Valid DO_TURN_ACK not decoded")
+	assert.Nil(t, msg.GameState, "Unexpected game state")
+	err = msg.ApplyGameStatePatch(previous)
+	assert.NoError(t, err, "Valid merge patch not applied")
+	assert.Equal(t, map[string]interface{}{"turn": 2.0}, msg.GameState)
+
+	data = decode(t, `{"message_type": "DO_TURN_ACK", "winner_player_id": -1,
+		"game_state_json_patch": [
+			{"op": "add", "path": "/all_clients/board/-", "value": 0}]}`)
+	msg, err = ReadDoTurnAckMessage(data, 2)
+	assert.NoError(t, err, "Valid DO_TURN_ACK not decoded")
+	err = msg.ApplyGameStatePatch(previous)
+	assert.NoError(t, err, "Valid JSON patch not applied")
+	assert.Equal(t, map[string]interface{}{"turn": 1.0,
+		"board": []interface{}{0.0}}, msg.GameState)
+	assert.Equal(t, []interface{}{}, previous["board"],
+		"Previous game state has been modified")
+
+	data = decode(t, `{"message_type": "DO_TURN_ACK", "winner_player_id": -1,
+		"game_state_json_patch": [{"op": "remove", "path": "/missing"}]}`)
+	msg, err = ReadDoTurnAckMessage(data, 2)
+	assert.NoError(t, err, "Valid DO_TURN_ACK not decoded")
+	err = msg.ApplyGameStatePatch(previous)
+	assert.Error(t, err, "No error on inapplicable patch")
+
+	data = decode(t, `{"message_type": "DO_TURN_ACK", "winner_player_id": -1,
+		"game_state_patch": {"all_clients": null}}`)
+	msg, err = ReadDoTurnAckMessage(data, 2)
+	assert.NoError(t, err, "Valid DO_TURN_ACK not decoded")
+	err = msg.ApplyGameStatePatch(previous)
+	assert.Error(t, err, "No error on removed all_clients")
+}
+
+func TestReadLoginMessageGameStateEncoding(t *testing.T) {
+	data := decode(t, `{"message_type": "LOGIN", "nickname": "bot",
+		"role": "visualization", "metaprotocol_version": "`+Version+`"}`)
+	msg, err := ReadLoginMessage(data)
+	assert.NoError(t, err, "Valid LOGIN not decoded")
+	assert.Equal(t, "", msg.GameStateEncoding)
+
+	for _, encoding := range []string{"full", "merge-patch", "json-patch"} {
+		data["game_state_encoding"] = encoding
+		msg, err = ReadLoginMessage(data)
+		assert.NoError(t, err, "Valid LOGIN not decoded")
+		assert.Equal(t, encoding, msg.GameStateEncoding)
+	}
+
+	data["game_state_encoding"] = "gzip"
+	_, err = ReadLoginMessage(data)
+	assert.Error(t, err, "No error on unknown game_state_encoding")
+}
+
+func TestReadLoginMessageCompression(t *testing.T) {
+	data := decode(t, `{"message_type": "LOGIN", "nickname": "bot",
+		"role": "player", "metaprotocol_version": "`+Version+`"}`)
+	msg, err := ReadLoginMessage(data)
+	assert.NoError(t, err, "Valid LOGIN not decoded")
+	assert.Empty(t, msg.Compression)
+
+	data = decode(t, `{"message_type": "LOGIN", "nickname": "bot",
+		"role": "player", "metaprotocol_version": "`+Version+`",
+		"compression": ["zstd", "gzip"]}`)
+	msg, err = ReadLoginMessage(data)
+	assert.NoError(t, err, "Valid LOGIN not decoded")
+	assert.Equal(t, []string{"zstd", "gzip"}, msg.Compression)
+
+	data["compression"] = "gzip"
+	_, err = ReadLoginMessage(data)
+	assert.Error(t, err, "No error on non-array compression")
+
+	data["compression"] = []interface{}{"gzip", 4}
+	_, err = ReadLoginMessage(data)
+	assert.Error(t, err, "No error on non-string compression algorithm")
+}
+
+func TestReadLoginMessageSerialization(t *testing.T) {
+	data := decode(t, `{"message_type": "LOGIN", "nickname": "bot",
+		"role": "game logic", "metaprotocol_version": "`+Version+`"}`)
+	msg, err := ReadLoginMessage(data)
+	assert.NoError(t, err, "Valid LOGIN not decoded")
+	assert.Equal(t, "", msg.Serialization)
+
+	for _, serialization := range []string{"json", "msgpack"} {
+		data["serialization"] = serialization
+		msg, err = ReadLoginMessage(data)
+		assert.NoError(t, err, "Valid LOGIN not decoded")
+		assert.Equal(t, serialization, msg.Serialization)
+	}
+
+	data["serialization"] = "cbor"
+	_, err = ReadLoginMessage(data)
+	assert.Error(t, err, "No error on unknown serialization")
+}
+
+func TestReadLoginMessageAgent(t *testing.T) {
+	data := decode(t, `{"message_type": "LOGIN", "nickname": "bot",
+		"role": "agent", "metaprotocol_version": "`+Version+`"}`)
+	msg, err := ReadLoginMessage(data)
+	assert.NoError(t, err, "Valid LOGIN not decoded")
+	assert.Equal(t, 1, msg.NbSlots)
+
+	data["nb_slots"] = 4.0
+	msg, err = ReadLoginMessage(data)
+	assert.NoError(t, err, "Valid LOGIN not decoded")
+	assert.Equal(t, 4, msg.NbSlots)
+
+	for _, nbSlots := range []float64{0, 1025} {
+		data["nb_slots"] = nbSlots
+		_, err = ReadLoginMessage(data)
+		assert.Error(t, err, "No error on nb_slots=%v", nbSlots)
+	}
+}
+
+func TestReadAgentTurnAckMessage(t *testing.T) {
+	data := decode(t, `{"message_type": "TURN_ACK", "turn_number": 3,
+		"player_actions": [{"player_id": 1, "actions": ["up"]},
+		                   {"player_id": 2, "actions": []}]}`)
+	msg, err := ReadAgentTurnAckMessage(data, 3)
+	assert.NoError(t, err, "Valid TURN_ACK not decoded")
+	assert.Equal(t, []AgentPlayerActions{
+		{PlayerID: 1, Actions: []interface{}{"up"}},
+		{PlayerID: 2, Actions: []interface{}{}},
+	}, msg.PlayerActions)
+
+	_, err = ReadAgentTurnAckMessage(data, 4)
+	assert.Error(t, err, "No error on unexpected turn_number")
+
+	data["player_actions"] = decode(t, `{"v": [{"player_id": 1}]}`)["v"]
+	_, err = ReadAgentTurnAckMessage(data, 3)
+	assert.Error(t, err, "No error on missing actions")
+
+	data["player_actions"] = decode(t, `{"v": [1]}`)["v"]
+	_, err = ReadAgentTurnAckMessage(data, 3)
+	assert.Error(t, err, "No error on non-object player actions")
+}
+
+func TestReadLoginAckMessage(t *testing.T) {
+	msg, err := ReadLoginAckMessage(decode(t, `{"message_type": "LOGIN_ACK",
+		"metaprotocol_version": "`+Version+`"}`))
+	assert.NoError(t, err, "Valid LOGIN_ACK not decoded")
+	assert.Equal(t, "", msg.Compression)
+
+	msg, err = ReadLoginAckMessage(decode(t, `{"message_type": "LOGIN_ACK",
+		"metaprotocol_version": "`+Version+`", "compression": "gzip"}`))
+	assert.NoError(t, err, "Valid LOGIN_ACK not decoded")
+	assert.Equal(t, "gzip", msg.Compression)
+
+	msg, err = ReadLoginAckMessage(decode(t, `{"message_type": "LOGIN_ACK",
+		"metaprotocol_version": "`+Version+`", "serialization": "msgpack"}`))
+	assert.NoError(t, err, "Valid LOGIN_ACK not decoded")
+	assert.Equal(t, "msgpack", msg.Serialization)
+
+	_, err = ReadLoginAckMessage(decode(t, `{"message_type": "LOGIN_ACK",
+		"metaprotocol_version": "`+Version+`", "compression": 1}`))
+	assert.Error(t, err, "No error on non-string compression")
+}
+
+func TestReadKickMessage(t *testing.T) {
+	msg, err := ReadKickMessage(decode(t,
+		`{"message_type": "KICK", "kick_reason": "meh"}`))
+	assert.NoError(t, err, "Valid KICK not decoded")
+	assert.Equal(t, "meh", msg.KickReason)
+
+	_, err = ReadKickMessage(decode(t, `{"message_type": "KICK"}`))
+	assert.Error(t, err, "No error on missing kick_reason")
+}
+
+func TestUnmarshalJSONMessage(t *testing.T) {
+	content, rawAllClients, err := UnmarshalJSONMessage([]byte(
+		`{"message_type": "DO_TURN_ACK", "winner_player_id": -1,
+		"game_state": {"all_clients": {"a": [1]}}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a": [1]}`, string(rawAllClients))
+	assert.Equal(t, decode(t, `{"message_type": "DO_TURN_ACK",
+		"winner_player_id": -1, "game_state": {"all_clients": {"a": [1]}}}`),
+		content)
+
+	content, rawAllClients, err = UnmarshalJSONMessage([]byte(
+		`{"message_type": "DO_TURN_ACK", "game_state": null,
+		"game_state_patch": {}}`))
+	assert.NoError(t, err)
+	assert.Nil(t, rawAllClients)
+	assert.Nil(t, content["game_state"])
+	assert.Equal(t, map[string]interface{}{}, content["game_state_patch"])
+
+	_, _, err = UnmarshalJSONMessage([]byte(`[`))
+	assert.Error(t, err)
+	_, _, err = UnmarshalJSONMessage([]byte(`[1]`))
+	assert.Error(t, err)
+}
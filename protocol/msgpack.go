@@ -0,0 +1,651 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Serialization formats that can be negotiated at LOGIN.
+const (
+	SerializationJSON        = "json"
+	SerializationMessagePack = "msgpack"
+)
+
+// Nesting depth above which MessagePack values are rejected,
+// the same as encoding/json.
+const maxMessagePackDepth = 10000
+
+// A valueMarshaler is serialized as the value returned by marshalValue.
+// This allows types with a custom MarshalJSON method to be serialized the
+// same way in JSON and in MessagePack.
+type valueMarshaler interface {
+	marshalValue() interface{}
+}
+
+// MarshalMessagePack returns the MessagePack encoding of v.
+// Values are encoded as encoding/json would: structs are encoded as maps
+// whose keys are the JSON field names, and integral numbers are encoded as
+// integers.
+func MarshalMessagePack(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := encodeMessagePack(&buffer, reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// UnmarshalMessagePack decodes a MessagePack map.
+// Values are decoded as encoding/json would decode the equivalent JSON
+// text: numbers are float64, arrays are []interface{} and maps are
+// map[string]interface{}.
+func UnmarshalMessagePack(data []byte) (map[string]interface{}, error) {
+	decoder := messagePackDecoder{data: data}
+	value, err := decoder.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if decoder.offset != len(data) {
+		return nil, fmt.Errorf("Trailing data after MessagePack value")
+	}
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("MessagePack value is not a map")
+	}
+	return object, nil
+}
+
+var (
+	valueMarshalerType = reflect.TypeOf((*valueMarshaler)(nil)).Elem()
+	jsonNumberType     = reflect.TypeOf(json.Number(""))
+)
+
+func encodeMessagePack(buffer *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buffer.WriteByte(0xc0)
+		return nil
+	}
+	if v.Type().Implements(valueMarshalerType) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			buffer.WriteByte(0xc0)
+			return nil
+		}
+		marshaler := v.Interface().(valueMarshaler)
+		return encodeMessagePack(buffer, reflect.ValueOf(
+			marshaler.marshalValue()))
+	}
+	if v.Type() == jsonNumberType {
+		return encodeMessagePackNumber(buffer, v.String())
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			buffer.WriteByte(0xc3)
+		} else {
+			buffer.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		encodeMessagePackInt(buffer, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		encodeMessagePackUint(buffer, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return encodeMessagePackFloat(buffer, v.Float())
+	case reflect.String:
+		encodeMessagePackString(buffer, v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			buffer.WriteByte(0xc0)
+			return nil
+		}
+		return encodeMessagePackArray(buffer, v)
+	case reflect.Array:
+		return encodeMessagePackArray(buffer, v)
+	case reflect.Map:
+		if v.IsNil() {
+			buffer.WriteByte(0xc0)
+			return nil
+		}
+		return encodeMessagePackMap(buffer, v)
+	case reflect.Struct:
+		return encodeMessagePackStruct(buffer, v)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			buffer.WriteByte(0xc0)
+			return nil
+		}
+		return encodeMessagePack(buffer, v.Elem())
+	default:
+		return fmt.Errorf("Cannot encode %v in MessagePack", v.Type())
+	}
+	return nil
+}
+
+func encodeMessagePackInt(buffer *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0:
+		encodeMessagePackUint(buffer, uint64(i))
+	case i >= -32:
+		buffer.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		buffer.Write([]byte{0xd0, byte(i)})
+	case i >= math.MinInt16:
+		buffer.WriteByte(0xd1)
+		writeBigEndian(buffer, uint64(i), 2)
+	case i >= math.MinInt32:
+		buffer.WriteByte(0xd2)
+		writeBigEndian(buffer, uint64(i), 4)
+	default:
+		buffer.WriteByte(0xd3)
+		writeBigEndian(buffer, uint64(i), 8)
+	}
+}
+
+func encodeMessagePackUint(buffer *bytes.Buffer, u uint64) {
+	switch {
+	case u <= 0x7f:
+		buffer.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		buffer.Write([]byte{0xcc, byte(u)})
+	case u <= math.MaxUint16:
+		buffer.WriteByte(0xcd)
+		writeBigEndian(buffer, u, 2)
+	case u <= math.MaxUint32:
+		buffer.WriteByte(0xce)
+		writeBigEndian(buffer, u, 4)
+	default:
+		buffer.WriteByte(0xcf)
+		writeBigEndian(buffer, u, 8)
+	}
+}
+
+// encodeMessagePackFloat encodes integral numbers as integers, as they
+// would have been written in JSON.
+func encodeMessagePackFloat(buffer *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("Cannot encode non-finite number %v", f)
+	}
+	if f == math.Trunc(f) && f >= -(1<<63) && f < (1<<63) &&
+		!(f == 0 && math.Signbit(f)) {
+		encodeMessagePackInt(buffer, int64(f))
+		return nil
+	}
+	buffer.WriteByte(0xcb)
+	writeBigEndian(buffer, math.Float64bits(f), 8)
+	return nil
+}
+
+func encodeMessagePackNumber(buffer *bytes.Buffer, number string) error {
+	if i, err := strconv.ParseInt(number, 10, 64); err == nil {
+		encodeMessagePackInt(buffer, i)
+		return nil
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid number '%v'", number)
+	}
+	return encodeMessagePackFloat(buffer, f)
+}
+
+func encodeMessagePackString(buffer *bytes.Buffer, s string) {
+	encodeMessagePackHeader(buffer, len(s), 0xa0, 32, 0xd9, 0xda, 0xdb)
+	buffer.WriteString(s)
+}
+
+func encodeMessagePackArray(buffer *bytes.Buffer, v reflect.Value) error {
+	encodeMessagePackHeader(buffer, v.Len(), 0x90, 16, 0, 0xdc, 0xdd)
+	for i := 0; i < v.Len(); i++ {
+		err := encodeMessagePack(buffer, v.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeMessagePackMap sorts map keys, like encoding/json.
+func encodeMessagePackMap(buffer *bytes.Buffer, v reflect.Value) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("Cannot encode %v in MessagePack: "+
+			"map keys must be strings", v.Type())
+	}
+
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	encodeMessagePackHeader(buffer, len(keys), 0x80, 16, 0, 0xde, 0xdf)
+	for _, key := range keys {
+		encodeMessagePackString(buffer, key.String())
+		err := encodeMessagePack(buffer, v.MapIndex(key))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeMessagePackStruct(buffer *bytes.Buffer, v reflect.Value) error {
+	type encodedField struct {
+		name  string
+		value reflect.Value
+	}
+	var encodedFields []encodedField
+	for _, field := range cachedStructFields(v.Type()) {
+		value, ok := fieldByIndex(v, field.index)
+		if !ok || (field.omitEmpty && isEmptyValue(value)) {
+			continue
+		}
+		encodedFields = append(encodedFields, encodedField{field.name, value})
+	}
+
+	encodeMessagePackHeader(buffer, len(encodedFields), 0x80, 16, 0, 0xde,
+		0xdf)
+	for _, field := range encodedFields {
+		encodeMessagePackString(buffer, field.name)
+		err := encodeMessagePack(buffer, field.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeMessagePackHeader writes the type and length of a string, array or
+// map. A zero code means that the format does not exist for this type.
+func encodeMessagePackHeader(buffer *bytes.Buffer, length int,
+	fixCode byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case length < fixMax:
+		buffer.WriteByte(fixCode | byte(length))
+	case code8 != 0 && length <= math.MaxUint8:
+		buffer.Write([]byte{code8, byte(length)})
+	case length <= math.MaxUint16:
+		buffer.WriteByte(code16)
+		writeBigEndian(buffer, uint64(length), 2)
+	default:
+		buffer.WriteByte(code32)
+		writeBigEndian(buffer, uint64(length), 4)
+	}
+}
+
+func writeBigEndian(buffer *bytes.Buffer, u uint64, size int) {
+	var bytes [8]byte
+	binary.BigEndian.PutUint64(bytes[:], u)
+	buffer.Write(bytes[8-size:])
+}
+
+// A structField is a struct field, as seen by encoding/json.
+type structField struct {
+	name      string
+	index     []int
+	tagged    bool
+	omitEmpty bool
+}
+
+var structFieldsCache = struct {
+	sync.Mutex
+	fields map[reflect.Type][]structField
+}{fields: make(map[reflect.Type][]structField)}
+
+func cachedStructFields(t reflect.Type) []structField {
+	structFieldsCache.Lock()
+	defer structFieldsCache.Unlock()
+
+	fields, exists := structFieldsCache.fields[t]
+	if !exists {
+		fields = structFields(t)
+		structFieldsCache.fields[t] = fields
+	}
+	return fields
+}
+
+// structFields returns the fields of a struct that encoding/json encodes.
+// Fields of embedded structs are promoted, unless they are shadowed by a
+// shallower field of the same name.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	collectStructFields(t, nil, &fields)
+
+	// Fields are collected by index order, which puts deeper fields of an
+	// embedded struct before the shallower fields that follow it.
+	byName := make(map[string][]structField)
+	var names []string
+	for _, field := range fields {
+		if _, exists := byName[field.name]; !exists {
+			names = append(names, field.name)
+		}
+		byName[field.name] = append(byName[field.name], field)
+	}
+
+	var result []structField
+	for _, name := range names {
+		if field, ok := dominantField(byName[name]); ok {
+			result = append(result, field)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return lessIndex(result[i].index, result[j].index)
+	})
+	return result
+}
+
+func collectStructFields(t reflect.Type, index []int,
+	fields *[]structField) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldIndex := append(append([]int(nil), index...), i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, options = tag[:comma], tag[comma+1:]
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			collectStructFields(fieldType, fieldIndex, fields)
+			continue
+		}
+		if field.PkgPath != "" {
+			// Unexported field
+			continue
+		}
+
+		tagged := name != ""
+		if !tagged {
+			name = field.Name
+		}
+		*fields = append(*fields, structField{
+			name:      name,
+			index:     fieldIndex,
+			tagged:    tagged,
+			omitEmpty: strings.Contains(","+options+",", ",omitempty,"),
+		})
+	}
+}
+
+// dominantField applies the rules of encoding/json to fields of the same
+// name: the shallowest field wins, tagged fields win over untagged ones,
+// and the name is dropped if this is still ambiguous.
+func dominantField(fields []structField) (structField, bool) {
+	depth := len(fields[0].index)
+	for _, field := range fields {
+		if len(field.index) < depth {
+			depth = len(field.index)
+		}
+	}
+
+	var candidates []structField
+	for _, field := range fields {
+		if len(field.index) == depth {
+			candidates = append(candidates, field)
+		}
+	}
+	if len(candidates) > 1 {
+		var tagged []structField
+		for _, field := range candidates {
+			if field.tagged {
+				tagged = append(tagged, field)
+			}
+		}
+		candidates = tagged
+	}
+	if len(candidates) != 1 {
+		return structField{}, false
+	}
+	return candidates[0], true
+}
+
+func lessIndex(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// fieldByIndex returns the field of v at index, or false if it is in a nil
+// embedded struct.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for depth, i := range index {
+		if depth > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// spliceMessagePackMap inserts a member, whose value is already encoded,
+// into an encoded MessagePack map.
+func spliceMessagePackMap(content []byte, key string, value []byte) ([]byte,
+	error) {
+	decoder := messagePackDecoder{data: content}
+	code, err := decoder.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	var length uint64
+	switch {
+	case code >= 0x80 && code <= 0x8f:
+		length = uint64(code & 0x0f)
+	case code == 0xde:
+		length, err = decoder.readUint(2)
+	case code == 0xdf:
+		length, err = decoder.readUint(4)
+	default:
+		return nil, fmt.Errorf("MessagePack value is not a map")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	buffer.Grow(len(content) + len(key) + len(value) + 10)
+	encodeMessagePackHeader(&buffer, int(length)+1, 0x80, 16, 0, 0xde, 0xdf)
+	encodeMessagePackString(&buffer, key)
+	buffer.Write(value)
+	buffer.Write(content[decoder.offset:])
+	return buffer.Bytes(), nil
+}
+
+type messagePackDecoder struct {
+	data   []byte
+	offset int
+}
+
+func (d *messagePackDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxMessagePackDepth {
+		return nil, fmt.Errorf("MessagePack value is nested too deeply")
+	}
+
+	code, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case code <= 0x7f:
+		return float64(code), nil
+	case code <= 0x8f:
+		return d.decodeMap(int(code&0x0f), depth)
+	case code <= 0x9f:
+		return d.decodeArray(int(code&0x0f), depth)
+	case code <= 0xbf:
+		return d.decodeString(int(code & 0x1f))
+	case code >= 0xe0:
+		return float64(int8(code)), nil
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		u, err := d.readUint(4)
+		return checkFinite(float64(math.Float32frombits(uint32(u))), err)
+	case 0xcb:
+		u, err := d.readUint(8)
+		return checkFinite(math.Float64frombits(u), err)
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.readUint(1 << (code - 0xcc))
+		return float64(u), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		u, err := d.readUint(size)
+		// Sign extension
+		shift := uint(64 - 8*size)
+		return float64(int64(u<<shift) >> shift), err
+	case 0xd9, 0xda, 0xdb:
+		length, err := d.readUint(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(int(length))
+	case 0xdc, 0xdd:
+		length, err := d.readUint(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(int(length), depth)
+	case 0xde, 0xdf:
+		length, err := d.readUint(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(int(length), depth)
+	}
+
+	// Binaries and extensions have no JSON equivalent.
+	return nil, fmt.Errorf("Unsupported MessagePack type 0x%02x", code)
+}
+
+func checkFinite(f float64, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("Unsupported non-finite number %v", f)
+	}
+	return f, nil
+}
+
+func (d *messagePackDecoder) decodeString(length int) (interface{}, error) {
+	if length > len(d.data)-d.offset {
+		return nil, fmt.Errorf("Truncated MessagePack value")
+	}
+	s := string(d.data[d.offset : d.offset+length])
+	d.offset += length
+	return s, nil
+}
+
+func (d *messagePackDecoder) decodeArray(length, depth int) (interface{},
+	error) {
+	// Each element takes at least one byte.
+	if length > len(d.data)-d.offset {
+		return nil, fmt.Errorf("Truncated MessagePack value")
+	}
+
+	array := make([]interface{}, length)
+	for i := range array {
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array[i] = value
+	}
+	return array, nil
+}
+
+func (d *messagePackDecoder) decodeMap(length, depth int) (interface{},
+	error) {
+	// Each key and each value takes at least one byte.
+	if length > (len(d.data)-d.offset)/2 {
+		return nil, fmt.Errorf("Truncated MessagePack value")
+	}
+
+	object := make(map[string]interface{}, length)
+	for i := 0; i < length; i++ {
+		key, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("Non-string MessagePack map key")
+		}
+
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		object[name] = value
+	}
+	return object, nil
+}
+
+func (d *messagePackDecoder) readByte() (byte, error) {
+	if d.offset >= len(d.data) {
+		return 0, fmt.Errorf("Truncated MessagePack value")
+	}
+	d.offset++
+	return d.data[d.offset-1], nil
+}
+
+func (d *messagePackDecoder) readUint(size int) (uint64, error) {
+	if size > len(d.data)-d.offset {
+		return 0, fmt.Errorf("Truncated MessagePack value")
+	}
+	var u uint64
+	for _, b := range d.data[d.offset : d.offset+size] {
+		u = u<<8 | uint64(b)
+	}
+	d.offset += size
+	return u, nil
+}
@@ -0,0 +1,197 @@
+package protocol
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"strings"
+	"testing"
+)
+
+// assertSameAsJSON checks that v is decoded the same way from MessagePack
+// and from JSON.
+func assertSameAsJSON(t *testing.T, v interface{}) {
+	content, err := json.Marshal(v)
+	assert.NoError(t, err, "Cannot encode JSON in test")
+	var expected map[string]interface{}
+	err = json.Unmarshal(content, &expected)
+	assert.NoError(t, err, "Cannot decode JSON in test")
+
+	content, err = MarshalMessagePack(v)
+	assert.NoError(t, err, "Cannot encode MessagePack")
+	decoded, err := UnmarshalMessagePack(content)
+	assert.NoError(t, err, "Cannot decode MessagePack")
+	assert.Equal(t, expected, decoded)
+}
+
+func TestMessagePackSameAsJSON(t *testing.T) {
+	gameState := decode(t, `{"turn": 3, "ratio": -0.25, "big": 1e300,
+		"negative": -100000, "empty": {}, "none": null, "ok": true,
+		"cells": [[0, 1], [], ["a", false]], "name": "été"}`)
+
+	assertSameAsJSON(t, gameState)
+	assertSameAsJSON(t, MessageLogin{MessageType: "LOGIN", Nickname: "bot",
+		Role: "player", MetaprotocolVersion: Version})
+	assertSameAsJSON(t, MessageGameStarts{MessageType: "GAME_STARTS",
+		PlayerID: 1, NbPlayers: 2, NbTurnsMax: 100,
+		PlayersInfo:      []*PlayerInformation{{PlayerID: 0, Nickname: "bot"}},
+		InitialGameState: gameState})
+
+	turn := MessageTurn{MessageType: "TURN", TurnNumber: 2,
+		GameState: gameState,
+		Feedback:  []PlayerFeedback{{PlayerID: 0, Content: "meh"}}}
+	assertSameAsJSON(t, turn)
+	turn.GameStatePatch = map[string]interface{}{}
+	assertSameAsJSON(t, turn)
+	turn.GameStatePatch = nil
+	turn.GameStateJSONPatch = []JSONPatchOperation{
+		{Op: "add", Path: "/a", Value: nil},
+		{Op: "move", Path: "/b", From: "/a"},
+	}
+	assertSameAsJSON(t, turn)
+}
+
+func TestMarshalMessagePack(t *testing.T) {
+	// Example from the MessagePack website
+	content, err := MarshalMessagePack(map[string]interface{}{
+		"compact": true, "schema": 0})
+	assert.NoError(t, err, "Cannot encode MessagePack")
+	assert.Equal(t, []byte("\x82\xa7compact\xc3\xa6schema\x00"), content)
+
+	numbers := []struct {
+		value    interface{}
+		expected string
+	}{
+		{3.0, "\x03"},
+		{-1, "\xff"},
+		{-33, "\xd0\xdf"},
+		{200, "\xcc\xc8"},
+		{-200.0, "\xd1\xff\x38"},
+		{70000, "\xce\x00\x01\x11\x70"},
+		{uint64(math.MaxUint64), "\xcf\xff\xff\xff\xff\xff\xff\xff\xff"},
+		{1.5, "\xcb\x3f\xf8\x00\x00\x00\x00\x00\x00"},
+		{json.Number("12"), "\x0c"},
+	}
+	for _, number := range numbers {
+		content, err = MarshalMessagePack(number.value)
+		assert.NoError(t, err, "Cannot encode %v", number.value)
+		assert.Equal(t, []byte(number.expected), content,
+			"Unexpected encoding of %v", number.value)
+	}
+
+	content, err = MarshalMessagePack(strings.Repeat("a", 40))
+	assert.NoError(t, err, "Cannot encode string")
+	assert.Equal(t, []byte("\xd9\x28"), content[:2], "Unexpected str8")
+
+	_, err = MarshalMessagePack(math.NaN())
+	assert.Error(t, err, "No error on NaN")
+	_, err = MarshalMessagePack(map[int]int{1: 1})
+	assert.Error(t, err, "No error on non-string map key")
+	_, err = MarshalMessagePack(make(chan int))
+	assert.Error(t, err, "No error on channel")
+}
+
+func TestUnmarshalMessagePack(t *testing.T) {
+	msg, err := UnmarshalMessagePack([]byte(
+		"\x83\xa1a\xd3\xff\xff\xff\xff\xff\xff\xff\xfe\xa1b\xca\x3f\xc0\x00\x00" +
+			"\xa1c\xdc\x00\x02\xc0\xc2"))
+	assert.NoError(t, err, "Valid MessagePack not decoded")
+	assert.Equal(t, map[string]interface{}{"a": -2.0, "b": 1.5,
+		"c": []interface{}{nil, false}}, msg)
+
+	invalid := map[string]string{
+		"empty":            "",
+		"not a map":        "\x92\x01\x02",
+		"truncated map":    "\x81\xa1a",
+		"truncated string": "\x81\xa3ab",
+		"truncated int":    "\x81\xa1a\xcd\x01",
+		"huge array":       "\x81\xa1a\xdd\xff\xff\xff\xff",
+		"trailing data":    "\x80\x00",
+		"non-string key":   "\x81\x01\x02",
+		"binary":           "\x81\xa1a\xc4\x01\x00",
+		"extension":        "\x81\xa1a\xd4\x01\x00",
+		"unused code":      "\x81\xa1a\xc1",
+		"NaN":              "\x81\xa1a\xcb\x7f\xf8\x00\x00\x00\x00\x00\x01",
+		"infinity":         "\x81\xa1a\xca\x7f\x80\x00\x00",
+		"too deep": "\x81\xa1a" + strings.Repeat("\x91",
+			maxMessagePackDepth) + "\x00",
+	}
+	for name, content := range invalid {
+		_, err = UnmarshalMessagePack([]byte(content))
+		assert.Error(t, err, "No error on %v", name)
+	}
+}
+
+func TestMarshalTurn(t *testing.T) {
+	gameState := decode(t, `{"turn": 3, "cells": [0, 1, 0]}`)
+	turn := MessageTurn{MessageType: "TURN", TurnNumber: 3, Actionable: true,
+		PlayersInfo: []*PlayerInformation{},
+		Feedback:    []PlayerFeedback{{PlayerID: 0, Content: "meh"}}}
+	withGameState := turn
+	withGameState.GameState = gameState
+	withPatch := turn
+	withPatch.GameStatePatch = map[string]interface{}{"turn": 3}
+
+	for _, serialization := range []string{"json", "msgpack"} {
+		marshal := json.Marshal
+		unmarshal := func(content []byte) (map[string]interface{}, error) {
+			var msg map[string]interface{}
+			err := json.Unmarshal(content, &msg)
+			return msg, err
+		}
+		if serialization == "msgpack" {
+			marshal = MarshalMessagePack
+			unmarshal = UnmarshalMessagePack
+		}
+
+		expected := []struct {
+			field string
+			value interface{}
+			msg   MessageTurn
+		}{
+			{"game_state", gameState, withGameState},
+			{"game_state_patch", withPatch.GameStatePatch, withPatch},
+		}
+		for _, e := range expected {
+			value, err := marshal(e.value)
+			assert.NoError(t, err, "Cannot serialize value in test")
+			// The game state fields of the TURN are ignored.
+			content, err := MarshalTurn(withGameState, serialization, e.field,
+				value)
+			assert.NoError(t, err, "Cannot marshal TURN")
+			spliced, err := unmarshal(content)
+			assert.NoError(t, err, "Invalid spliced %v TURN", serialization)
+
+			content, err = marshal(e.msg)
+			assert.NoError(t, err, "Cannot serialize TURN in test")
+			reference, err := unmarshal(content)
+			assert.NoError(t, err, "Cannot deserialize TURN in test")
+			assert.Equal(t, reference, spliced, "Unexpected %v TURN",
+				serialization)
+		}
+	}
+}
+
+func TestSpliceMessagePackMap(t *testing.T) {
+	// Maps of 15 members need a bigger header once spliced.
+	object := map[string]interface{}{}
+	for _, key := range strings.Split("abcdefghijklmno", "") {
+		object[key] = 0
+	}
+	content, err := MarshalMessagePack(object)
+	assert.NoError(t, err, "Cannot encode MessagePack")
+	assert.Equal(t, byte(0x8f), content[0])
+
+	content, err = spliceMessagePackMap(content, "z", []byte{0xc3})
+	assert.NoError(t, err, "Cannot splice MessagePack map")
+	assert.Equal(t, []byte{0xde, 0x00, 0x10}, content[:3])
+	decoded, err := UnmarshalMessagePack(content)
+	assert.NoError(t, err, "Invalid spliced MessagePack map")
+	assert.Len(t, decoded, 16)
+	assert.Equal(t, true, decoded["z"])
+
+	_, err = spliceMessagePackMap([]byte{0x90}, "z", []byte{0xc3})
+	assert.Error(t, err, "No error on non-map")
+	_, err = spliceMessagePackMap([]byte{0xde, 0x00}, "z", []byte{0xc3})
+	assert.Error(t, err, "No error on truncated map")
+}
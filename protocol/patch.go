@@ -0,0 +1,424 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MergePatch returns the JSON merge patch (RFC 7396) that transforms the
+// from object into the to object.
+// As merge patches use null to remove fields, false is returned if the
+// to object contains null values that a patch cannot express.
+func MergePatch(from, to map[string]interface{}) (map[string]interface{},
+	bool) {
+	patch := make(map[string]interface{})
+	for key := range from {
+		if _, exists := to[key]; !exists {
+			patch[key] = nil
+		}
+	}
+
+	for key, toValue := range to {
+		fromValue, exists := from[key]
+		if exists && jsonEqual(fromValue, toValue) {
+			continue
+		}
+
+		fromObject, fromIsObject := fromValue.(map[string]interface{})
+		toObject, toIsObject := toValue.(map[string]interface{})
+		if exists && fromIsObject && toIsObject {
+			subpatch, ok := MergePatch(fromObject, toObject)
+			if !ok {
+				return nil, false
+			}
+			patch[key] = subpatch
+			continue
+		}
+
+		if containsNullMember(toValue) {
+			return nil, false
+		}
+		patch[key] = toValue
+	}
+
+	return patch, true
+}
+
+// containsNullMember returns whether value is null or is an object with
+// a null value at any depth (array elements are not merged, so their null
+// values can be expressed).
+func containsNullMember(value interface{}) bool {
+	switch typedValue := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		for _, member := range typedValue {
+			if containsNullMember(member) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ApplyMergePatch returns the result of applying a JSON merge patch
+// (RFC 7396) to target. target is not modified.
+func ApplyMergePatch(target, patch interface{}) interface{} {
+	patchObject, isObject := patch.(map[string]interface{})
+	if !isObject {
+		return patch
+	}
+
+	result := make(map[string]interface{})
+	if targetObject, isObject := target.(map[string]interface{}); isObject {
+		for key, value := range targetObject {
+			result[key] = value
+		}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(result, key)
+		} else {
+			result[key] = ApplyMergePatch(result[key], value)
+		}
+	}
+	return result
+}
+
+// JSONPatchOperation is an operation of a JSON Patch (RFC 6902).
+type JSONPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`  // move and copy only
+	Value interface{} `json:"value,omitempty"` // add, replace and test only
+}
+
+// MarshalJSON keeps null values, which are meaningful in add, replace and
+// test operations.
+func (op JSONPatchOperation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(op.marshalValue())
+}
+
+func (op JSONPatchOperation) marshalValue() interface{} {
+	type operation JSONPatchOperation // Without the MarshalJSON method
+	if !operationHasValue(op.Op) {
+		return operation(op)
+	}
+
+	return struct {
+		operation
+		// Shadows the value field of operation, without omitempty
+		Value interface{} `json:"value"`
+	}{operation: operation(op), Value: op.Value}
+}
+
+func operationHasValue(op string) bool {
+	return op == "add" || op == "replace" || op == "test"
+}
+
+// JSONPatch returns the JSON Patch (RFC 6902) that transforms the from
+// object into the to object. Objects are diffed member by member, while
+// arrays and other values are replaced as a whole.
+func JSONPatch(from, to map[string]interface{}) []JSONPatchOperation {
+	patch := []JSONPatchOperation{}
+	appendObjectDiff(&patch, "", from, to)
+	return patch
+}
+
+func appendObjectDiff(patch *[]JSONPatchOperation, path string,
+	from, to map[string]interface{}) {
+	for _, key := range sortedKeys(from) {
+		if _, exists := to[key]; !exists {
+			*patch = append(*patch, JSONPatchOperation{Op: "remove",
+				Path: path + "/" + escapePointerToken(key)})
+		}
+	}
+
+	for _, key := range sortedKeys(to) {
+		memberPath := path + "/" + escapePointerToken(key)
+		fromValue, exists := from[key]
+		toValue := to[key]
+		if !exists {
+			*patch = append(*patch, JSONPatchOperation{Op: "add",
+				Path: memberPath, Value: toValue})
+			continue
+		}
+		if jsonEqual(fromValue, toValue) {
+			continue
+		}
+
+		fromObject, fromIsObject := fromValue.(map[string]interface{})
+		toObject, toIsObject := toValue.(map[string]interface{})
+		if fromIsObject && toIsObject {
+			appendObjectDiff(patch, memberPath, fromObject, toObject)
+		} else {
+			*patch = append(*patch, JSONPatchOperation{Op: "replace",
+				Path: memberPath, Value: toValue})
+		}
+	}
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escapePointerToken(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1),
+		"/", "~1", -1)
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("Invalid JSON pointer '%v': "+
+			"Does not start with '/'", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for index, token := range tokens {
+		tokens[index] = strings.Replace(strings.Replace(token, "~1", "/", -1),
+			"~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// parseArrayIndex reads an array index token, which must be in [0,max].
+func parseArrayIndex(token string, max int) (int, error) {
+	if !arrayIndexRegexp.MatchString(token) {
+		return 0, fmt.Errorf("Invalid array index '%v'", token)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index > max {
+		return 0, fmt.Errorf("Array index %v out of bounds", token)
+	}
+	return index, nil
+}
+
+var arrayIndexRegexp = regexp.MustCompile(`\A(0|[1-9][0-9]*)\z`)
+
+// ApplyJSONPatch returns the result of applying a JSON Patch (RFC 6902) to
+// target. target is not modified. An error is returned if an operation
+// cannot be applied, or if a test operation fails.
+func ApplyJSONPatch(target interface{}, patch []JSONPatchOperation) (
+	interface{}, error) {
+	document := copyJSON(target)
+	for index, op := range patch {
+		var err error
+		document, err = applyJSONPatchOperation(document, op)
+		if err != nil {
+			return nil, fmt.Errorf("Operation %v (%v %v): %v", index, op.Op,
+				op.Path, err.Error())
+		}
+	}
+	return document, nil
+}
+
+func applyJSONPatchOperation(document interface{}, op JSONPatchOperation) (
+	interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		return addValue(document, path, copyJSON(op.Value))
+	case "remove":
+		document, _, err = removeValue(document, path)
+		return document, err
+	case "replace":
+		if len(path) == 0 {
+			return copyJSON(op.Value), nil
+		}
+		document, _, err = removeValue(document, path)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(document, path, copyJSON(op.Value))
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if op.Op == "move" {
+			if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("Cannot move a value into itself")
+			}
+			document, value, err = removeValue(document, from)
+		} else {
+			value, err = getValue(document, from)
+			value = copyJSON(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return addValue(document, path, value)
+	case "test":
+		value, err := getValue(document, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(value, op.Value) {
+			return nil, fmt.Errorf("Test failed")
+		}
+		return document, nil
+	default:
+		return nil, fmt.Errorf("Unknown operation '%v'", op.Op)
+	}
+}
+
+func getValue(document interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := document.(type) {
+		case map[string]interface{}:
+			value, exists := node[token]
+			if !exists {
+				return nil, fmt.Errorf("Member '%v' does not exist", token)
+			}
+			document = value
+		case []interface{}:
+			index, err := parseArrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			document = node[index]
+		default:
+			return nil, fmt.Errorf("Cannot reference '%v' in a scalar", token)
+		}
+	}
+	return document, nil
+}
+
+// addValue adds value at path in document, and returns the new document.
+func addValue(document interface{}, path []string,
+	value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	token := path[0]
+	switch node := document.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			node[token] = value
+			return node, nil
+		}
+		child, exists := node[token]
+		if !exists {
+			return nil, fmt.Errorf("Member '%v' does not exist", token)
+		}
+		child, err := addValue(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+		return node, nil
+	case []interface{}:
+		if len(path) == 1 {
+			index := len(node)
+			if token != "-" {
+				var err error
+				index, err = parseArrayIndex(token, len(node))
+				if err != nil {
+					return nil, err
+				}
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		index, err := parseArrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		node[index], err = addValue(node[index], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return node, nil
+	default:
+		return nil, fmt.Errorf("Cannot reference '%v' in a scalar", token)
+	}
+}
+
+// removeValue removes the value at path from document, and returns the new
+// document and the removed value.
+func removeValue(document interface{}, path []string) (interface{},
+	interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("Cannot remove the whole document")
+	}
+
+	token := path[0]
+	switch node := document.(type) {
+	case map[string]interface{}:
+		child, exists := node[token]
+		if !exists {
+			return nil, nil, fmt.Errorf("Member '%v' does not exist", token)
+		}
+		if len(path) == 1 {
+			delete(node, token)
+			return node, child, nil
+		}
+		child, removed, err := removeValue(child, path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		node[token] = child
+		return node, removed, nil
+	case []interface{}:
+		index, err := parseArrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(path) == 1 {
+			removed := node[index]
+			return append(node[:index], node[index+1:]...), removed, nil
+		}
+		child, removed, err := removeValue(node[index], path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		node[index] = child
+		return node, removed, nil
+	default:
+		return nil, nil, fmt.Errorf("Cannot reference '%v' in a scalar",
+			token)
+	}
+}
+
+// copyJSON returns a deep copy of a decoded JSON value.
+func copyJSON(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(typedValue))
+		for key, member := range typedValue {
+			object[key] = copyJSON(member)
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(typedValue))
+		for index, element := range typedValue {
+			array[index] = copyJSON(element)
+		}
+		return array
+	default:
+		return value
+	}
+}
@@ -0,0 +1,136 @@
+package protocol
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	from := decode(t, `{"turn": 1, "removed": 0, "same": [1, 2],
+		"board": {"width": 3, "cells": [0, 0, 0], "owner": {"id": 0}}}`)
+	to := decode(t, `{"turn": 2, "same": [1, 2], "added": "meh",
+		"board": {"width": 3, "cells": [0, 1, 0], "owner": 4}}`)
+
+	patch, ok := MergePatch(from, to)
+	assert.True(t, ok, "Valid patch not computed")
+	assert.Equal(t, decode(t, `{"turn": 2, "removed": null, "added": "meh",
+		"board": {"cells": [0, 1, 0], "owner": 4}}`), patch)
+	assert.Equal(t, to, ApplyMergePatch(from, patch))
+	assert.Equal(t, 1.0, from["turn"], "from has been modified")
+
+	patch, ok = MergePatch(to, to)
+	assert.True(t, ok, "Empty patch not computed")
+	assert.Empty(t, patch, "Unexpected patch between equal objects")
+
+	_, ok = MergePatch(from, decode(t, `{"turn": null}`))
+	assert.False(t, ok, "Patch computed with a null member")
+
+	_, ok = MergePatch(from, decode(t, `{"board": {"owner": null}}`))
+	assert.False(t, ok, "Patch computed with a nested null member")
+
+	patch, ok = MergePatch(from, decode(t, `{"cells": [null]}`))
+	assert.True(t, ok, "Patch not computed with a null array element")
+	assert.Equal(t, decode(t, `{"cells": [null]}`),
+		ApplyMergePatch(from, patch))
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	// Examples from RFC 7396.
+	target := decode(t, `{"title": "Goodbye!",
+		"author": {"givenName": "John", "familyName": "Doe"},
+		"tags": ["example", "sample"], "content": "This will be unchanged"}`)
+	patch := decode(t, `{"title": "Hello!", "phoneNumber": "+01-123-456-7890",
+		"author": {"familyName": null}, "tags": ["example"]}`)
+	assert.Equal(t, decode(t, `{"title": "Hello!",
+		"author": {"givenName": "John"}, "tags": ["example"],
+		"content": "This will be unchanged",
+		"phoneNumber": "+01-123-456-7890"}`),
+		ApplyMergePatch(target, patch))
+
+	assert.Equal(t, decode(t, `{"a": {"bb": {}}}`),
+		ApplyMergePatch(decode(t, `{}`),
+			decode(t, `{"a": {"bb": {"ccc": null}}}`)))
+	assert.Equal(t, "bar", ApplyMergePatch(decode(t, `{"a": "foo"}`), "bar"))
+}
+
+func decodeJSONPatch(t *testing.T, str string) []JSONPatchOperation {
+	patch, err := readJSONPatch(decode(t, `{"patch": `+str+`}`), "patch")
+	assert.NoError(t, err, "Invalid JSON Patch in test")
+	return patch
+}
+
+func TestJSONPatch(t *testing.T) {
+	from := decode(t, `{"turn": 1, "removed": 0, "same": [1, 2],
+		"board": {"width": 3, "cells": [0, 0, 0], "owner": {"id": 0}},
+		"a/b~c": 0}`)
+	to := decode(t, `{"turn": 2, "same": [1, 2], "added": null,
+		"board": {"width": 3, "cells": [0, 1, 0], "owner": null},
+		"a/b~c": 1}`)
+
+	patch := JSONPatch(from, to)
+	assert.Equal(t, decodeJSONPatch(t, `[
+		{"op": "remove", "path": "/removed"},
+		{"op": "replace", "path": "/a~1b~0c", "value": 1},
+		{"op": "add", "path": "/added", "value": null},
+		{"op": "replace", "path": "/board/cells", "value": [0, 1, 0]},
+		{"op": "replace", "path": "/board/owner", "value": null},
+		{"op": "replace", "path": "/turn", "value": 2}]`), patch)
+
+	patched, err := ApplyJSONPatch(from, patch)
+	assert.NoError(t, err, "Cannot apply generated patch")
+	assert.Equal(t, to, patched)
+	assert.Equal(t, 1.0, from["turn"], "from has been modified")
+
+	assert.Empty(t, JSONPatch(to, to), "Unexpected patch between equal objects")
+
+	// null values are kept when encoded
+	content, err := json.Marshal(patch)
+	assert.NoError(t, err, "Cannot marshal patch")
+	assert.Equal(t, patch, decodeJSONPatch(t, string(content)))
+	assert.NotContains(t, string(content), `"path":"/removed","value"`,
+		"Value sent in remove operation")
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	// Examples from RFC 6902.
+	target := decode(t, `{"foo": ["bar", "baz"], "biscuits": [
+		{"name": "Digestive"}, {"name": "Choco Leibniz"}]}`)
+	patched, err := ApplyJSONPatch(target, decodeJSONPatch(t, `[
+		{"op": "add", "path": "/foo/1", "value": "qux"},
+		{"op": "add", "path": "/foo/-", "value": "end"},
+		{"op": "remove", "path": "/foo/0"},
+		{"op": "replace", "path": "/foo/0", "value": "quux"},
+		{"op": "copy", "from": "/biscuits/0", "path": "/best_biscuit"},
+		{"op": "move", "from": "/biscuits/1/name", "path": "/favorite"},
+		{"op": "test", "path": "/best_biscuit/name", "value": "Digestive"}]`))
+	assert.NoError(t, err, "Cannot apply valid patch")
+	assert.Equal(t, decode(t, `{"foo": ["quux", "baz", "end"],
+		"biscuits": [{"name": "Digestive"}, {}],
+		"best_biscuit": {"name": "Digestive"}, "favorite": "Choco Leibniz"}`),
+		patched)
+	assert.Equal(t, decode(t, `{"foo": ["bar", "baz"], "biscuits": [
+		{"name": "Digestive"}, {"name": "Choco Leibniz"}]}`), target,
+		"target has been modified")
+
+	invalidPatches := []string{
+		`[{"op": "test", "path": "/foo/0", "value": "baz"}]`,
+		`[{"op": "remove", "path": "/missing"}]`,
+		`[{"op": "replace", "path": "/foo/2", "value": 0}]`,
+		`[{"op": "add", "path": "/foo/3", "value": 0}]`,
+		`[{"op": "add", "path": "/foo/01", "value": 0}]`,
+		`[{"op": "add", "path": "/missing/a", "value": 0}]`,
+		`[{"op": "add", "path": "/foo/0/a", "value": 0}]`,
+		`[{"op": "move", "from": "/biscuits", "path": "/biscuits/0"}]`,
+		`[{"op": "remove", "path": ""}]`,
+	}
+	for _, invalidPatch := range invalidPatches {
+		_, err = ApplyJSONPatch(target, decodeJSONPatch(t, invalidPatch))
+		assert.Error(t, err, "No error on %v", invalidPatch)
+	}
+
+	patched, err = ApplyJSONPatch(target, decodeJSONPatch(t,
+		`[{"op": "replace", "path": "", "value": [0]}]`))
+	assert.NoError(t, err, "Cannot replace the whole document")
+	assert.Equal(t, []interface{}{0.0}, patched)
+}
@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"fmt"
+)
+
+func ReadString(data map[string]interface{}, field string) (string, error) {
+	value, exists := data[field]
+	if !exists {
+		return "", fmt.Errorf("Field '%v' is missing", field)
+	}
+
+	switch value.(type) {
+	default:
+		return "", fmt.Errorf("Non-string value for field '%v'", field)
+	case string:
+		return value.(string), nil
+	}
+}
+
+func ReadInt(data map[string]interface{}, field string) (int, error) {
+	value, exists := data[field]
+	if !exists {
+		return 0, fmt.Errorf("Field '%v' is missing", field)
+	}
+
+	switch value.(type) {
+	default:
+		return 0, fmt.Errorf("Non-integral value for field '%v'", field)
+	case float64:
+		floatValue := value.(float64)
+		if floatValue != float64(int(floatValue)) {
+			return 0, fmt.Errorf("Non-integral value for field '%v'", field)
+		}
+		return int(floatValue), nil
+	}
+}
+
+func ReadFloat(data map[string]interface{}, field string) (float64, error) {
+	value, exists := data[field]
+	if !exists {
+		return 0, fmt.Errorf("Field '%v' is missing", field)
+	}
+
+	switch value.(type) {
+	default:
+		return 0, fmt.Errorf("Non-number value for field '%v'", field)
+	case float64:
+		return value.(float64), nil
+	}
+}
+
+func ReadBool(data map[string]interface{}, field string) (bool, error) {
+	value, exists := data[field]
+	if !exists {
+		return false, fmt.Errorf("Field '%v' is missing", field)
+	}
+
+	switch value.(type) {
+	default:
+		return false, fmt.Errorf("Non-bool value for field '%v'", field)
+	case bool:
+		return value.(bool), nil
+	}
+}
+
+func ReadObject(data map[string]interface{}, field string) (map[string]interface{}, error) {
+	value, exists := data[field]
+	if !exists {
+		return make(map[string]interface{}),
+			fmt.Errorf("Field '%v' is missing", field)
+	}
+
+	switch value.(type) {
+	default:
+		return make(map[string]interface{}),
+			fmt.Errorf("Non-object value for field '%v'", field)
+	case map[string]interface{}:
+		return value.(map[string]interface{}), nil
+	}
+}
+
+func ReadArray(data map[string]interface{}, field string) ([]interface{},
+	error) {
+	value, exists := data[field]
+	if !exists {
+		return make([]interface{}, 0),
+			fmt.Errorf("Field '%v' is missing", field)
+	}
+
+	switch value.(type) {
+	default:
+		return make([]interface{}, 0),
+			fmt.Errorf("Non-array value for field '%v'", field)
+	case []interface{}:
+		return value.([]interface{}), nil
+	}
+}
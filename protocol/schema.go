@@ -0,0 +1,514 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ActionSchema is a compiled JSON Schema that player actions must follow.
+// Only a subset of JSON Schema is supported, so that game logics do not
+// believe that their actions are checked when they are not:
+// unsupported keywords are rejected at compilation.
+type ActionSchema struct {
+	types                []string
+	enum                 []interface{}
+	constValue           interface{}
+	hasConst             bool
+	properties           map[string]*ActionSchema
+	required             []string
+	additionalProperties *ActionSchema
+	noAdditionalProps    bool
+	items                *ActionSchema
+	minItems             int
+	maxItems             int
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	minLength            int
+	maxLength            int
+	pattern              *regexp.Regexp
+	allOf                []*ActionSchema
+	anyOf                []*ActionSchema
+	oneOf                []*ActionSchema
+	not                  *ActionSchema
+}
+
+// Keywords that do not constrain values.
+var schemaAnnotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+}
+
+var schemaTypes = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"object":  true,
+	"array":   true,
+	"number":  true,
+	"integer": true,
+	"string":  true,
+}
+
+// CompileActionSchema checks that schema only uses supported keywords
+// and returns its compiled form.
+func CompileActionSchema(schema map[string]interface{}) (*ActionSchema,
+	error) {
+	return compileSchema(schema, "#")
+}
+
+func compileSchema(schema map[string]interface{}, path string) (
+	*ActionSchema, error) {
+	compiled := &ActionSchema{minItems: -1, maxItems: -1, minLength: -1,
+		maxLength: -1}
+
+	// Iterate in a deterministic order to report the same error every time.
+	keywords := make([]string, 0, len(schema))
+	for keyword := range schema {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+
+	for _, keyword := range keywords {
+		value := schema[keyword]
+		keywordPath := path + "/" + keyword
+		var err error
+
+		switch keyword {
+		case "type":
+			compiled.types, err = compileTypes(value, keywordPath)
+		case "enum":
+			array, isArray := value.([]interface{})
+			if !isArray {
+				err = fmt.Errorf("%v: Non-array value", keywordPath)
+			}
+			compiled.enum = array
+		case "const":
+			compiled.constValue = value
+			compiled.hasConst = true
+		case "properties":
+			compiled.properties, err = compileProperties(value, keywordPath)
+		case "required":
+			compiled.required, err = compileStrings(value, keywordPath)
+		case "additionalProperties":
+			if boolean, isBool := value.(bool); isBool {
+				compiled.noAdditionalProps = !boolean
+			} else {
+				compiled.additionalProperties, err = compileSubschema(value,
+					keywordPath)
+			}
+		case "items":
+			compiled.items, err = compileSubschema(value, keywordPath)
+		case "minItems":
+			compiled.minItems, err = compileCount(value, keywordPath)
+		case "maxItems":
+			compiled.maxItems, err = compileCount(value, keywordPath)
+		case "minLength":
+			compiled.minLength, err = compileCount(value, keywordPath)
+		case "maxLength":
+			compiled.maxLength, err = compileCount(value, keywordPath)
+		case "minimum":
+			compiled.minimum, err = compileNumber(value, keywordPath)
+		case "maximum":
+			compiled.maximum, err = compileNumber(value, keywordPath)
+		case "exclusiveMinimum":
+			compiled.exclusiveMinimum, err = compileNumber(value, keywordPath)
+		case "exclusiveMaximum":
+			compiled.exclusiveMaximum, err = compileNumber(value, keywordPath)
+		case "pattern":
+			str, isString := value.(string)
+			if !isString {
+				err = fmt.Errorf("%v: Non-string value", keywordPath)
+			} else {
+				compiled.pattern, err = regexp.Compile(str)
+				if err != nil {
+					err = fmt.Errorf("%v: %v", keywordPath, err)
+				}
+			}
+		case "allOf":
+			compiled.allOf, err = compileSubschemas(value, keywordPath)
+		case "anyOf":
+			compiled.anyOf, err = compileSubschemas(value, keywordPath)
+		case "oneOf":
+			compiled.oneOf, err = compileSubschemas(value, keywordPath)
+		case "not":
+			compiled.not, err = compileSubschema(value, keywordPath)
+		default:
+			if !schemaAnnotations[keyword] {
+				err = fmt.Errorf("%v: Unsupported keyword", keywordPath)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return compiled, nil
+}
+
+func compileSubschema(value interface{}, path string) (*ActionSchema,
+	error) {
+	object, isObject := value.(map[string]interface{})
+	if !isObject {
+		return nil, fmt.Errorf("%v: Non-object value", path)
+	}
+	return compileSchema(object, path)
+}
+
+func compileSubschemas(value interface{}, path string) ([]*ActionSchema,
+	error) {
+	array, isArray := value.([]interface{})
+	if !isArray || len(array) == 0 {
+		return nil, fmt.Errorf("%v: Not a non-empty array", path)
+	}
+
+	schemas := make([]*ActionSchema, 0, len(array))
+	for index, subschema := range array {
+		compiled, err := compileSubschema(subschema,
+			fmt.Sprintf("%v/%v", path, index))
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, compiled)
+	}
+	return schemas, nil
+}
+
+func compileProperties(value interface{}, path string) (
+	map[string]*ActionSchema, error) {
+	object, isObject := value.(map[string]interface{})
+	if !isObject {
+		return nil, fmt.Errorf("%v: Non-object value", path)
+	}
+
+	properties := make(map[string]*ActionSchema)
+	for name, subschema := range object {
+		compiled, err := compileSubschema(subschema, path+"/"+name)
+		if err != nil {
+			return nil, err
+		}
+		properties[name] = compiled
+	}
+	return properties, nil
+}
+
+func compileStrings(value interface{}, path string) ([]string, error) {
+	array, isArray := value.([]interface{})
+	if !isArray {
+		return nil, fmt.Errorf("%v: Non-array value", path)
+	}
+
+	strs := make([]string, 0, len(array))
+	for index, element := range array {
+		str, isString := element.(string)
+		if !isString {
+			return nil, fmt.Errorf("%v: Non-string value at index %v",
+				path, index)
+		}
+		strs = append(strs, str)
+	}
+	return strs, nil
+}
+
+func compileTypes(value interface{}, path string) ([]string, error) {
+	var types []string
+	if str, isString := value.(string); isString {
+		types = []string{str}
+	} else {
+		var err error
+		types, err = compileStrings(value, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, t := range types {
+		if !schemaTypes[t] {
+			return nil, fmt.Errorf("%v: Unknown type '%v'", path, t)
+		}
+	}
+	return types, nil
+}
+
+func compileCount(value interface{}, path string) (int, error) {
+	number, isNumber := value.(float64)
+	if !isNumber || number != math.Trunc(number) || number < 0 {
+		return 0, fmt.Errorf("%v: Not a non-negative integer", path)
+	}
+	return int(number), nil
+}
+
+func compileNumber(value interface{}, path string) (*float64, error) {
+	number, isNumber := value.(float64)
+	if !isNumber {
+		return nil, fmt.Errorf("%v: Non-number value", path)
+	}
+	return &number, nil
+}
+
+// Validate returns an error that describes why value (a decoded JSON value)
+// does not follow the schema, or nil if it does.
+func (s *ActionSchema) Validate(value interface{}) error {
+	return s.validate(value, "")
+}
+
+func (s *ActionSchema) validate(value interface{}, path string) error {
+	if len(s.types) > 0 && !matchesOneType(value, s.types) {
+		return fmt.Errorf("%v: Expected %v, got %v", pathOrRoot(path),
+			strings.Join(s.types, " or "), jsonType(value))
+	}
+
+	if s.enum != nil {
+		found := false
+		for _, candidate := range s.enum {
+			if jsonEqual(value, candidate) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%v: Value not in enum", pathOrRoot(path))
+		}
+	}
+
+	if s.hasConst && !jsonEqual(value, s.constValue) {
+		return fmt.Errorf("%v: Value differs from const", pathOrRoot(path))
+	}
+
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		err := s.validateObject(typedValue, path)
+		if err != nil {
+			return err
+		}
+	case []interface{}:
+		err := s.validateArray(typedValue, path)
+		if err != nil {
+			return err
+		}
+	case float64:
+		err := s.validateNumber(typedValue, path)
+		if err != nil {
+			return err
+		}
+	case string:
+		err := s.validateString(typedValue, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, subschema := range s.allOf {
+		err := subschema.validate(value, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.anyOf != nil {
+		matched := false
+		for _, subschema := range s.anyOf {
+			if subschema.validate(value, path) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%v: No anyOf schema matches",
+				pathOrRoot(path))
+		}
+	}
+
+	if s.oneOf != nil {
+		nbMatches := 0
+		for _, subschema := range s.oneOf {
+			if subschema.validate(value, path) == nil {
+				nbMatches++
+			}
+		}
+		if nbMatches != 1 {
+			return fmt.Errorf("%v: %v oneOf schemas match instead of 1",
+				pathOrRoot(path), nbMatches)
+		}
+	}
+
+	if s.not != nil && s.not.validate(value, path) == nil {
+		return fmt.Errorf("%v: Value matches the not schema",
+			pathOrRoot(path))
+	}
+
+	return nil
+}
+
+func (s *ActionSchema) validateObject(object map[string]interface{},
+	path string) error {
+	for _, name := range s.required {
+		if _, exists := object[name]; !exists {
+			return fmt.Errorf("%v: Field '%v' is missing", pathOrRoot(path),
+				name)
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		subschema, isProperty := s.properties[name]
+		if !isProperty {
+			if s.noAdditionalProps {
+				return fmt.Errorf("%v: Unexpected field '%v'",
+					pathOrRoot(path), name)
+			}
+			subschema = s.additionalProperties
+		}
+
+		if subschema != nil {
+			err := subschema.validate(object[name], path+"/"+name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ActionSchema) validateArray(array []interface{}, path string) error {
+	if s.minItems >= 0 && len(array) < s.minItems {
+		return fmt.Errorf("%v: Less than %v items", pathOrRoot(path),
+			s.minItems)
+	}
+	if s.maxItems >= 0 && len(array) > s.maxItems {
+		return fmt.Errorf("%v: More than %v items", pathOrRoot(path),
+			s.maxItems)
+	}
+
+	if s.items != nil {
+		for index, element := range array {
+			err := s.items.validate(element, fmt.Sprintf("%v/%v", path,
+				index))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ActionSchema) validateNumber(number float64, path string) error {
+	if s.minimum != nil && number < *s.minimum {
+		return fmt.Errorf("%v: Value below minimum %v", pathOrRoot(path),
+			*s.minimum)
+	}
+	if s.maximum != nil && number > *s.maximum {
+		return fmt.Errorf("%v: Value above maximum %v", pathOrRoot(path),
+			*s.maximum)
+	}
+	if s.exclusiveMinimum != nil && number <= *s.exclusiveMinimum {
+		return fmt.Errorf("%v: Value not above %v", pathOrRoot(path),
+			*s.exclusiveMinimum)
+	}
+	if s.exclusiveMaximum != nil && number >= *s.exclusiveMaximum {
+		return fmt.Errorf("%v: Value not below %v", pathOrRoot(path),
+			*s.exclusiveMaximum)
+	}
+	return nil
+}
+
+func (s *ActionSchema) validateString(str string, path string) error {
+	length := utf8.RuneCountInString(str)
+	if s.minLength >= 0 && length < s.minLength {
+		return fmt.Errorf("%v: Shorter than %v characters", pathOrRoot(path),
+			s.minLength)
+	}
+	if s.maxLength >= 0 && length > s.maxLength {
+		return fmt.Errorf("%v: Longer than %v characters", pathOrRoot(path),
+			s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		return fmt.Errorf("%v: Value does not match pattern '%v'",
+			pathOrRoot(path), s.pattern.String())
+	}
+	return nil
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "Action"
+	}
+	return "Action" + path
+}
+
+func jsonType(value interface{}) string {
+	switch typedValue := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case float64:
+		if typedValue == math.Trunc(typedValue) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	default:
+		return "unknown"
+	}
+}
+
+func matchesOneType(value interface{}, types []string) bool {
+	valueType := jsonType(value)
+	for _, t := range types {
+		if t == valueType || (t == "number" && valueType == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonEqual(a, b interface{}) bool {
+	switch typedA := a.(type) {
+	case map[string]interface{}:
+		typedB, isObject := b.(map[string]interface{})
+		if !isObject || len(typedA) != len(typedB) {
+			return false
+		}
+		for key, valueA := range typedA {
+			valueB, exists := typedB[key]
+			if !exists || !jsonEqual(valueA, valueB) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		typedB, isArray := b.([]interface{})
+		if !isArray || len(typedA) != len(typedB) {
+			return false
+		}
+		for index := range typedA {
+			if !jsonEqual(typedA[index], typedB[index]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func compile(t *testing.T, str string) *ActionSchema {
+	schema, err := CompileActionSchema(decode(t, str))
+	assert.NoError(t, err, "Valid schema not compiled")
+	return schema
+}
+
+func decodeValue(t *testing.T, str string) interface{} {
+	var value interface{}
+	err := json.Unmarshal([]byte(str), &value)
+	assert.NoError(t, err, "Invalid JSON in test")
+	return value
+}
+
+func TestCompileActionSchema(t *testing.T) {
+	invalidSchemas := []string{
+		`{"type": "meh"}`,
+		`{"type": 4}`,
+		`{"enum": "up"}`,
+		`{"required": [0]}`,
+		`{"minItems": -1}`,
+		`{"maxLength": 1.5}`,
+		`{"minimum": "0"}`,
+		`{"pattern": "("}`,
+		`{"anyOf": []}`,
+		`{"items": [{"type": "string"}]}`,
+		`{"properties": {"move": 0}}`,
+		`{"$ref": "#/definitions/move"}`,
+		`{"if": {"type": "object"}}`,
+	}
+	for _, str := range invalidSchemas {
+		_, err := CompileActionSchema(decode(t, str))
+		assert.Error(t, err, "No error on invalid schema %v", str)
+	}
+
+	compile(t, `{"$schema": "http://json-schema.org/draft-07/schema#",
+		"title": "Action", "description": "An action"}`)
+}
+
+func TestActionSchemaValidate(t *testing.T) {
+	schema := compile(t, `{
+		"type": "object",
+		"properties": {
+			"move": {"enum": ["up", "down", "left", "right"]},
+			"speed": {"type": "integer", "minimum": 1, "maximum": 3},
+			"say": {"type": "string", "maxLength": 5, "pattern": "^[a-z]*$"},
+			"path": {"type": "array", "items": {"type": "number"},
+			         "minItems": 1}
+		},
+		"required": ["move"],
+		"additionalProperties": false
+	}`)
+
+	validActions := []string{
+		`{"move": "up"}`,
+		`{"move": "left", "speed": 3, "say": "hello"}`,
+		`{"move": "down", "path": [0.5, 1]}`,
+	}
+	for _, str := range validActions {
+		assert.NoError(t, schema.Validate(decodeValue(t, str)),
+			"Error on valid action %v", str)
+	}
+
+	invalidActions := []string{
+		`[]`,
+		`{}`,
+		`{"move": "jump"}`,
+		`{"move": "up", "speed": 1.5}`,
+		`{"move": "up", "speed": 4}`,
+		`{"move": "up", "say": "hello!"}`,
+		`{"move": "up", "say": "HEY"}`,
+		`{"move": "up", "path": []}`,
+		`{"move": "up", "path": ["north"]}`,
+		`{"move": "up", "fly": true}`,
+	}
+	for _, str := range invalidActions {
+		assert.Error(t, schema.Validate(decodeValue(t, str)),
+			"No error on invalid action %v", str)
+	}
+
+	err := schema.Validate(decodeValue(t, `{"move": "up", "speed": 4}`))
+	assert.Contains(t, err.Error(), "Action/speed",
+		"Error does not locate the invalid value")
+}
+
+func TestActionSchemaCombinations(t *testing.T) {
+	schema := compile(t, `{"oneOf": [
+		{"type": "object", "properties": {"move": {"const": "up"}},
+		 "required": ["move"]},
+		{"type": "object", "properties": {"shoot": {"type": "boolean"}},
+		 "required": ["shoot"]}
+	]}`)
+	assert.NoError(t, schema.Validate(decodeValue(t, `{"move": "up"}`)))
+	assert.NoError(t, schema.Validate(decodeValue(t, `{"shoot": true}`)))
+	assert.Error(t, schema.Validate(
+		decodeValue(t, `{"move": "up", "shoot": true}`)),
+		"No error when several oneOf schemas match")
+	assert.Error(t, schema.Validate(decodeValue(t, `{"move": "down"}`)),
+		"No error when no oneOf schema matches")
+
+	schema = compile(t, `{"anyOf": [{"type": "string"}, {"type": "null"}],
+		"not": {"const": "meh"}}`)
+	assert.NoError(t, schema.Validate(decodeValue(t, `null`)))
+	assert.NoError(t, schema.Validate(decodeValue(t, `"up"`)))
+	assert.Error(t, schema.Validate(decodeValue(t, `"meh"`)))
+	assert.Error(t, schema.Validate(decodeValue(t, `0`)))
+}
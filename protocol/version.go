@@ -0,0 +1,10 @@
+package protocol
+
+import (
+	"fmt"
+)
+
+var VersionMajor = 2
+var VersionMinor = 0
+var VersionPatch = 0
+var Version = fmt.Sprintf("%d.%d.%d", VersionMajor, VersionMinor, VersionPatch)
@@ -5,67 +5,6 @@ import (
 	"strconv"
 )
 
-func ReadString(data map[string]interface{}, field string) (string, error) {
-	value, exists := data[field]
-	if !exists {
-		return "", fmt.Errorf("Field '%v' is missing", field)
-	}
-
-	switch value.(type) {
-	default:
-		return "", fmt.Errorf("Non-string value for field '%v'", field)
-	case string:
-		return value.(string), nil
-	}
-}
-
-func ReadInt(data map[string]interface{}, field string) (int, error) {
-	value, exists := data[field]
-	if !exists {
-		return 0, fmt.Errorf("Field '%v' is missing", field)
-	}
-
-	switch value.(type) {
-	default:
-		return 0, fmt.Errorf("Non-integral value for field '%v'", field)
-	case float64:
-		return int(value.(float64)), nil
-	}
-}
-
-func ReadObject(data map[string]interface{}, field string) (map[string]interface{}, error) {
-	value, exists := data[field]
-	if !exists {
-		return make(map[string]interface{}),
-			fmt.Errorf("Field '%v' is missing", field)
-	}
-
-	switch value.(type) {
-	default:
-		return make(map[string]interface{}),
-			fmt.Errorf("Non-object value for field '%v'", field)
-	case map[string]interface{}:
-		return value.(map[string]interface{}), nil
-	}
-}
-
-func ReadArray(data map[string]interface{}, field string) ([]interface{},
-	error) {
-	value, exists := data[field]
-	if !exists {
-		return make([]interface{}, 0),
-			fmt.Errorf("Field '%v' is missing", field)
-	}
-
-	switch value.(type) {
-	default:
-		return make([]interface{}, 0),
-			fmt.Errorf("Non-array value for field '%v'", field)
-	case []interface{}:
-		return value.([]interface{}), nil
-	}
-}
-
 func ReadIntInString(data map[string]interface{}, field string, bitSize,
 	minValue, maxValue int) (int, error) {
 	value, exists := data[field]
@@ -0,0 +1,394 @@
+package netorcai
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"time"
+)
+
+// Config holds the parameters of a netorcai server.
+// Its fields correspond to netorcai's command-line options.
+type Config struct {
+	// Addresses to listen on, such as "tcp://:4242" or
+	// "unix:///tmp/netorcai.sock". Use port 0 to let the system choose a
+	// free port, which can then be retrieved with Server.Port.
+	ListenAddresses []string
+	// Permissions of the unix socket files. 0 means that the process umask
+	// applies.
+	UnixSocketMode os.FileMode
+
+	NbPlayersMax                int
+	NbSpecialPlayersMax         int
+	NbVisusMax                  int
+	NbTurnsMax                  int
+	Autostart                   bool
+	Fast                        bool
+	TurnOrder                   int
+	VisuFeedback                bool
+	MillisecondsBeforeFirstTurn float64
+	MillisecondsBetweenTurns    float64
+	MillisecondsInitTimeout     float64
+	MillisecondsTurnTimeout     float64
+
+	// Number of episodes played in a row by the game logic, with the same
+	// clients. Each episode starts with DO_INIT and GAME_STARTS, and ends
+	// with GAME_ENDS.
+	NbEpisodes int
+
+	// Number of games played in a row, with the clients that stay logged in.
+	// After each game but the last, the game state goes back to
+	// GAME_NOT_RUNNING and the next game starts as the first one did.
+	// 0 means that games are played until netorcai is stopped.
+	NbGames int
+
+	// What happens to the TURNs of each role while a client is still
+	// thinking about a previous TURN. The zero value drops old TURNs.
+	PlayerSkipPolicy        SkipPolicy
+	SpecialPlayerSkipPolicy SkipPolicy
+	VisuSkipPolicy          SkipPolicy
+
+	// Maximum number of TURNs sent to each visualization per second.
+	// 0 means unlimited.
+	VisuMaxFrameRate float64
+	// If set, visualizations receive the changes of the game state (as JSON
+	// merge patches) instead of the whole game state, unless they chose
+	// another game_state_encoding at LOGIN.
+	VisuDeltas bool
+	// Clients that receive changes of the game state also receive the whole
+	// game state every KeyframeInterval TURNs. 0 means never.
+	KeyframeInterval int
+	// If set, messages are never compressed, even if clients support it.
+	DisableCompression bool
+
+	// Maximum time to wait for the current turn to finish when the server
+	// is shut down during a game. 0 means that clients are kicked right away.
+	MillisecondsDrainTimeout float64
+
+	// If set, an in-process game logic without any game rule is connected
+	// to netorcai on Start. Its game states contain the MockGameState
+	// fields, turn_number and the player_actions of the last DO_TURN.
+	MockGameLogic bool
+	MockGameState map[string]interface{}
+}
+
+// DefaultConfig returns the configuration used by netorcai when no
+// command-line option is given.
+func DefaultConfig() Config {
+	return Config{
+		ListenAddresses:             []string{"tcp://:4242"},
+		NbPlayersMax:                4,
+		NbSpecialPlayersMax:         0,
+		NbVisusMax:                  1,
+		NbTurnsMax:                  100,
+		NbEpisodes:                  1,
+		NbGames:                     1,
+		TurnOrder:                   TURN_ORDER_SIMULTANEOUS,
+		MillisecondsBeforeFirstTurn: 1000,
+		MillisecondsBetweenTurns:    1000,
+		MillisecondsInitTimeout:     3000,
+		MillisecondsTurnTimeout:     0,
+		MillisecondsDrainTimeout:    0,
+		KeyframeInterval:            10,
+	}
+}
+
+func checkIntInRange(name string, value, minValue, maxValue int) error {
+	if value < minValue || value > maxValue {
+		return fmt.Errorf("Invalid configuration: %v=%v is not in [%v,%v]",
+			name, value, minValue, maxValue)
+	}
+	return nil
+}
+
+func checkFloatInRange(name string, value, minValue, maxValue float64) error {
+	if value < minValue || value > maxValue {
+		return fmt.Errorf("Invalid configuration: %v=%v is not in [%v,%v]",
+			name, value, minValue, maxValue)
+	}
+	return nil
+}
+
+func (config Config) check() error {
+	if len(config.ListenAddresses) == 0 {
+		return fmt.Errorf("Invalid configuration: No listen address")
+	}
+	for _, listenAddress := range config.ListenAddresses {
+		if _, _, err := ParseListenAddress(listenAddress); err != nil {
+			return fmt.Errorf("Invalid configuration: "+
+				"Listen address '%v' is invalid: %v", listenAddress, err)
+		}
+	}
+	if config.UnixSocketMode&^os.ModePerm != 0 {
+		return fmt.Errorf("Invalid configuration: UnixSocketMode=%v "+
+			"is not a permission mode", config.UnixSocketMode)
+	}
+
+	policies := map[string]SkipPolicy{
+		"PlayerSkipPolicy":        config.PlayerSkipPolicy,
+		"SpecialPlayerSkipPolicy": config.SpecialPlayerSkipPolicy,
+		"VisuSkipPolicy":          config.VisuSkipPolicy,
+	}
+	for name, policy := range policies {
+		if err := policy.check(); err != nil {
+			return fmt.Errorf("Invalid configuration: %v: %v", name, err)
+		}
+	}
+
+	checks := []error{
+		checkIntInRange("NbPlayersMax", config.NbPlayersMax, 0, 1024),
+		checkIntInRange("NbSpecialPlayersMax", config.NbSpecialPlayersMax,
+			0, 1024),
+		checkIntInRange("NbVisusMax", config.NbVisusMax, 0, 1024),
+		checkIntInRange("NbTurnsMax", config.NbTurnsMax, 1, 65535),
+		checkIntInRange("NbEpisodes", config.NbEpisodes, 1, 1000000),
+		checkIntInRange("NbGames", config.NbGames, 0, 1000000),
+		checkIntInRange("TurnOrder", config.TurnOrder,
+			TURN_ORDER_SIMULTANEOUS, TURN_ORDER_ROUND_ROBIN),
+		checkFloatInRange("MillisecondsBeforeFirstTurn",
+			config.MillisecondsBeforeFirstTurn, 50, 10000),
+		checkFloatInRange("MillisecondsBetweenTurns",
+			config.MillisecondsBetweenTurns, 50, 10000),
+		checkFloatInRange("MillisecondsInitTimeout",
+			config.MillisecondsInitTimeout, 0, 3600000),
+		checkFloatInRange("MillisecondsTurnTimeout",
+			config.MillisecondsTurnTimeout, 0, 3600000),
+		checkFloatInRange("MillisecondsDrainTimeout",
+			config.MillisecondsDrainTimeout, 0, 3600000),
+		checkFloatInRange("VisuMaxFrameRate", config.VisuMaxFrameRate,
+			0, 1000),
+		checkIntInRange("KeyframeInterval", config.KeyframeInterval,
+			0, 65535),
+	}
+	for _, err := range checks {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Server is a netorcai instance that can be embedded in another program.
+type Server struct {
+	config      Config
+	globalState *GlobalState
+	started     bool
+
+	serverExit    chan int
+	gameLogicExit chan int
+	shellExit     chan int
+	shutdown      chan int
+
+	// ctx is cancelled to kick all clients.
+	// shutdownCtx is cancelled to finish the game gracefully.
+	ctx              context.Context
+	abort            context.CancelFunc
+	shutdownCtx      context.Context
+	gracefulShutdown context.CancelFunc
+
+	done     chan struct{}
+	exitCode int
+}
+
+// NewServer checks config and creates a server. Nothing is done on the
+// network until Start is called.
+func NewServer(config Config) (*Server, error) {
+	err := config.check()
+	if err != nil {
+		return nil, err
+	}
+
+	gs := &GlobalState{
+		events:                      make(chan coordinatorEvent),
+		coordinatorStopped:          make(chan struct{}),
+		GameState:                   GAME_NOT_RUNNING,
+		NbPlayersMax:                config.NbPlayersMax,
+		NbSpecialPlayersMax:         config.NbSpecialPlayersMax,
+		NbVisusMax:                  config.NbVisusMax,
+		NbTurnsMax:                  config.NbTurnsMax,
+		NbEpisodes:                  config.NbEpisodes,
+		NbGames:                     config.NbGames,
+		Autostart:                   config.Autostart,
+		Fast:                        config.Fast,
+		TurnOrder:                   config.TurnOrder,
+		VisuFeedback:                config.VisuFeedback,
+		PlayerSkipPolicy:            config.PlayerSkipPolicy,
+		SpecialPlayerSkipPolicy:     config.SpecialPlayerSkipPolicy,
+		VisuSkipPolicy:              config.VisuSkipPolicy,
+		VisuMaxFrameRate:            config.VisuMaxFrameRate,
+		VisuDeltas:                  config.VisuDeltas,
+		KeyframeInterval:            config.KeyframeInterval,
+		DisableCompression:          config.DisableCompression,
+		MillisecondsBeforeFirstTurn: config.MillisecondsBeforeFirstTurn,
+		MillisecondsBetweenTurns:    config.MillisecondsBetweenTurns,
+		MillisecondsInitTimeout:     config.MillisecondsInitTimeout,
+		MillisecondsTurnTimeout:     config.MillisecondsTurnTimeout,
+	}
+
+	ctx, abort := context.WithCancel(context.Background())
+	shutdownCtx, gracefulShutdown := context.WithCancel(ctx)
+
+	return &Server{
+		config:           config,
+		globalState:      gs,
+		serverExit:       make(chan int, 1),
+		gameLogicExit:    make(chan int, 1),
+		shellExit:        make(chan int, 1),
+		shutdown:         make(chan int, 1),
+		ctx:              ctx,
+		abort:            abort,
+		shutdownCtx:      shutdownCtx,
+		gracefulShutdown: gracefulShutdown,
+		done:             make(chan struct{}),
+	}, nil
+}
+
+// Start listens on all the configured addresses then accepts clients in
+// background. An error is returned if any address cannot be listened on.
+func (s *Server) Start() error {
+	if s.started {
+		return fmt.Errorf("Server already started")
+	}
+
+	err := listen(s.config.ListenAddresses, s.config.UnixSocketMode,
+		s.globalState)
+	if err != nil {
+		return err
+	}
+	s.started = true
+	go runCoordinator(s.globalState)
+
+	s.globalState.WaitGroup.Add(1)
+	go serve(s.ctx, s.shutdownCtx, s.globalState, s.serverExit,
+		s.gameLogicExit)
+	go s.waitExit()
+
+	if s.config.MockGameLogic {
+		go runMockGameLogic(s.Addrs()[0], s.config.MockGameState)
+	}
+	return nil
+}
+
+func (s *Server) waitExit() {
+	select {
+	case s.exitCode = <-s.serverExit:
+	case s.exitCode = <-s.gameLogicExit:
+		if s.exitCode != EXIT_SUCCESS {
+			log.Warn("Game logic failed. Aborting.")
+		}
+	case s.exitCode = <-s.shellExit:
+		log.Warn("Shell exited. Aborting.")
+	case s.exitCode = <-s.shutdown:
+	case <-s.shutdownCtx.Done():
+		s.exitCode = EXIT_SIGNAL
+		s.drain()
+	}
+
+	cleanup(s.globalState, s.abort)
+	close(s.done)
+
+	// Client goroutines may still submit events while they terminate.
+	s.globalState.WaitGroup.Wait()
+	stopCoordinator(s.globalState)
+}
+
+// drain waits for the game logic goroutine to finish the current game,
+// for at most MillisecondsDrainTimeout.
+func (s *Server) drain() {
+	event := gameStateEvent{reply: make(chan int, 1)}
+	if !submit(s.globalState, event) || <-event.reply != GAME_RUNNING {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"drain timeout (ms)": s.config.MillisecondsDrainTimeout,
+	}).Info("Waiting for the current turn to finish")
+	select {
+	case <-s.gameLogicExit:
+	case <-time.After(time.Duration(s.config.MillisecondsDrainTimeout *
+		float64(time.Millisecond))):
+		log.Warn("Drain timeout reached")
+	}
+}
+
+// Addrs returns the addresses the server listens on.
+func (s *Server) Addrs() []net.Addr {
+	addrs := []net.Addr{}
+	for _, listener := range s.globalState.Listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+// Port returns the port of the first TCP address the server listens on,
+// or 0 if the server does not listen on TCP.
+func (s *Server) Port() int {
+	for _, addr := range s.Addrs() {
+		if tcpAddr, isTCP := addr.(*net.TCPAddr); isTCP {
+			return tcpAddr.Port
+		}
+	}
+	return 0
+}
+
+// StartGame starts the game, as the start command of the prompt does.
+func (s *Server) StartGame() error {
+	if !s.started {
+		return fmt.Errorf("Server not started")
+	}
+	return startGame(s.globalState)
+}
+
+// RunPrompt runs netorcai's prompt on the standard input/output.
+// It returns when the prompt is closed.
+func (s *Server) RunPrompt(interactive bool) {
+	runPrompt(s.globalState, s.shellExit, interactive)
+}
+
+// Wait waits for the server to stop, which happens when the game is over,
+// on fatal errors or after Shutdown. It returns the exit code netorcai would
+// have returned (see EXIT_*), or an error if ctx is done before.
+func (s *Server) Wait(ctx context.Context) (int, error) {
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+
+	// Also wait for the client goroutines to terminate.
+	clientsDone := make(chan struct{})
+	go func() {
+		s.globalState.WaitGroup.Wait()
+		close(clientsDone)
+	}()
+
+	select {
+	case <-clientsDone:
+		return s.exitCode, nil
+	case <-ctx.Done():
+		return s.exitCode, ctx.Err()
+	}
+}
+
+// Shutdown stops the server as if netorcai received SIGTERM, then Wait
+// returns EXIT_SIGNAL. No new client is accepted.
+// If MillisecondsDrainTimeout is 0, all clients are kicked right away.
+// Otherwise, a running game is aborted once its current turn is finished:
+// clients receive a GAME_ENDS with the aborted status before being kicked.
+func (s *Server) Shutdown() {
+	if !s.started {
+		return
+	}
+
+	if s.config.MillisecondsDrainTimeout > 0 {
+		s.gracefulShutdown()
+	} else {
+		select {
+		case s.shutdown <- EXIT_SIGNAL:
+		default:
+		}
+	}
+	<-s.done
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+// moveSchema only accepts moves in the four directions.
+var moveSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"move": map[string]interface{}{
+			"enum": []interface{}{"up", "down", "left", "right"},
+		},
+	},
+	"required":             []interface{}{"move"},
+	"additionalProperties": false,
+}
+
+func TestActionSchema(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=1",
+		"--nb-visus-max=0", "--nb-turns-max=3", "--fast"})
+	defer killallNetorcaiSIGKILL()
+
+	// The player sends a valid and an invalid action on every turn.
+	player := &recordingPlayer{actions: []interface{}{
+		map[string]interface{}{"move": "up"},
+		map[string]interface{}{"move": "jump"},
+	}}
+	gameLogic := &counterGameLogic{winnerPlayerID: -1,
+		actionSchema: moveSchema}
+	err := playGame(t, proc, []gameClient{{role: "player", player: player}},
+		nil, gameLogic, 3000)
+	assert.NoError(t, err, "RunGameLogic failed")
+
+	// Only the valid action is forwarded to the game logic.
+	assert.Len(t, gameLogic.playerActions, 3, "Unexpected number of DO_TURN")
+	assert.Len(t, gameLogic.playerActions[1], 1,
+		"Unexpected number of player actions")
+	assert.Equal(t, []interface{}{map[string]interface{}{"move": "up"}},
+		gameLogic.playerActions[1][0].Actions, "Invalid action forwarded")
+
+	// The invalid action is reported in the next TURN.
+	assert.Len(t, player.turns, 2, "Unexpected number of TURN")
+	assert.Empty(t, player.turns[0].InvalidActions,
+		"Unexpected invalid actions in the first TURN")
+	assert.Len(t, player.turns[1].InvalidActions, 1,
+		"Invalid action not reported")
+	if len(player.turns[1].InvalidActions) == 1 {
+		invalidAction := player.turns[1].InvalidActions[0]
+		assert.Equal(t, player.turns[0].TurnNumber, invalidAction.TurnNumber,
+			"Unexpected invalid action turn number")
+		assert.Equal(t, 1, invalidAction.Index,
+			"Unexpected invalid action index")
+		assert.Regexp(t, `move`, invalidAction.Error,
+			"Unexpected invalid action error")
+	}
+}
+
+func TestActionSchemaInvalid(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=1",
+		"--nb-visus-max=0", "--nb-turns-max=3", "--fast", "--autostart"})
+	defer killallNetorcaiSIGKILL()
+
+	glClient := &client.Client{}
+	err := glClient.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+	err = glClient.SendLogin("game logic", "gl", protocol.Version)
+	assert.NoError(t, err, "Cannot send LOGIN")
+	_, err = glClient.ReadLoginAck()
+	assert.NoError(t, err, "Cannot read LOGIN_ACK")
+
+	bot := &client.Client{}
+	err = bot.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+	playerExit := runPlayerAsync(bot, "player", &recordingPlayer{})
+
+	_, err = glClient.ReadDoInit()
+	assert.NoError(t, err, "Cannot read DO_INIT")
+	err = glClient.SendDoInitAckWithActionSchema(map[string]interface{}{},
+		map[string]interface{}{"$ref": "#/definitions/move"})
+	assert.NoError(t, err, "Cannot send DO_INIT_ACK")
+
+	_, err = waitOutputTimeout(regexp.MustCompile(`Invalid action_schema`),
+		proc.outputControl, 1000, false)
+	assert.NoError(t, err, "Invalid action schema not detected")
+
+	exitCode, err := waitCompletionTimeout(proc.completion, 1000)
+	assert.NoError(t, err, "netorcai did not complete")
+	assert.Equal(t, 3, exitCode, "Unexpected exit code")
+	waitPlayerExit(t, playerExit, 1000)
+}
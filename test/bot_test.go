@@ -0,0 +1,94 @@
+package test
+
+import (
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// runBotGame runs netorcai (fast mode, 3 turns), a netorcai-bot player
+// with botArguments and a Go game logic. It returns the bot exit code.
+func runBotGame(t *testing.T, botArguments []string,
+	gameLogic *counterGameLogic) (proc *NetorcaiProcess, botExitCode int) {
+	proc = runNetorcaiWaitListening(t, []string{"--nb-players-max=1",
+		"--nb-visus-max=0", "--nb-turns-max=3", "--fast", "--autostart"})
+
+	bot, err := runNetorcai("netorcai-bot", botArguments)
+	assert.NoError(t, err, "Cannot start netorcai-bot")
+	defer bot.cmd.Process.Kill()
+	_, err = waitOutputTimeout(regexp.MustCompile(`New player accepted`),
+		proc.outputControl, 1000, false)
+	assert.NoError(t, err, "Bot has not been accepted")
+
+	glClient := &client.Client{}
+	err = glClient.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+	glExit := runGameLogicAsync(glClient, gameLogic)
+
+	botExitCode, err = waitCompletionTimeout(bot.completion, 3000)
+	assert.NoError(t, err, "netorcai-bot did not exit")
+	select {
+	case <-glExit:
+	case <-time.After(3000 * time.Millisecond):
+		assert.Fail(t, "RunGameLogic did not return")
+	}
+	return proc, botExitCode
+}
+
+func TestBotReplay(t *testing.T) {
+	file, err := ioutil.TempFile("", "netorcai-bot-replay")
+	assert.NoError(t, err, "Cannot create replay file")
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`[[{"move": "up"}], [{"move": "down"}]]`)
+	assert.NoError(t, err, "Cannot write replay file")
+	file.Close()
+
+	gameLogic := &counterGameLogic{winnerPlayerID: -1}
+	proc, botExitCode := runBotGame(t, []string{"--replay=" + file.Name()},
+		gameLogic)
+	defer killallNetorcaiSIGKILL()
+	assert.Equal(t, 0, botExitCode, "Unexpected netorcai-bot exit code")
+
+	// The actions of TURN i are in DO_TURN i+1.
+	assert.Len(t, gameLogic.playerActions, 3, "Unexpected number of DO_TURN")
+	for turnNumber, move := range []string{"up", "down"} {
+		playerActions := gameLogic.playerActions[turnNumber+1]
+		assert.Len(t, playerActions, 1, "Unexpected number of player actions")
+		assert.Equal(t, turnNumber, playerActions[0].TurnNumber)
+		assert.Equal(t, []interface{}{map[string]interface{}{"move": move}},
+			playerActions[0].Actions, "Unexpected replayed actions")
+	}
+
+	waitCompletionTimeout(proc.completion, 1000)
+}
+
+func TestBotCrash(t *testing.T) {
+	gameLogic := &counterGameLogic{winnerPlayerID: -1}
+	proc, botExitCode := runBotGame(t, []string{"--crash-at-turn=1"},
+		gameLogic)
+	defer killallNetorcaiSIGKILL()
+	assert.Equal(t, 3, botExitCode, "Unexpected netorcai-bot exit code")
+
+	// The game goes on without the crashed player.
+	assert.Len(t, gameLogic.playerActions, 3, "Unexpected number of DO_TURN")
+	exitCode, err := waitCompletionTimeout(proc.completion, 1000)
+	assert.NoError(t, err, "netorcai did not complete")
+	assert.Equal(t, 0, exitCode, "Unexpected netorcai exit code")
+}
+
+func TestBotMalformedTurnAck(t *testing.T) {
+	gameLogic := &counterGameLogic{winnerPlayerID: -1}
+	proc, botExitCode := runBotGame(t, []string{"--malformed-turn-ack-at=0"},
+		gameLogic)
+	defer killallNetorcaiSIGKILL()
+	assert.Equal(t, 2, botExitCode, "Unexpected netorcai-bot exit code")
+
+	_, err := waitOutputTimeout(regexp.MustCompile(`Invalid TURN_ACK received`),
+		proc.outputControl, 1000, false)
+	assert.NoError(t, err, "The bot has not been kicked for its TURN_ACK")
+	waitCompletionTimeout(proc.completion, 1000)
+}
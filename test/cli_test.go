@@ -1,6 +1,7 @@
 package test
 
 import (
+	"github.com/netorcai/netorcai"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"regexp"
@@ -157,6 +158,24 @@ func TestCLIUnknownArg(t *testing.T) {
 	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
 }
 
+func TestCLIExitReasonLogged(t *testing.T) {
+	args := []string{"--nb-players-max=meh", "--json-logs"}
+	coverFile, expRetCode := handleCoverage(t, netorcai.EXIT_BAD_ARGUMENTS)
+
+	proc, err := runNetorcaiCover(coverFile, args)
+	assert.NoError(t, err, "Cannot start netorcai")
+	defer killallNetorcaiSIGKILL()
+
+	_, err = waitOutputTimeout(regexp.MustCompile(
+		`"exit code":1,"exit reason":"bad arguments"`),
+		proc.outputControl, 1000, false)
+	assert.NoError(t, err, "Cannot read exit reason in netorcai output")
+
+	retCode, err := waitCompletionTimeout(proc.completion, 1000)
+	assert.NoError(t, err, "netorcai did not complete")
+	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
+}
+
 /********************
  * --nb-players-max *
  ********************/
@@ -660,3 +679,49 @@ func TestCLIArgDelayTurnsBig(t *testing.T) {
 	err = killNetorcaiGently(proc, 1000)
 	assert.NoError(t, err, "Netorcai could not be killed gently")
 }
+
+/****************
+ * --turn-order *
+ ****************/
+func TestCLIArgTurnOrderInvalid(t *testing.T) {
+	args := []string{"--turn-order=meh"}
+	coverFile, expRetCode := handleCoverage(t, 1)
+
+	proc, err := runNetorcaiCover(coverFile, args)
+	assert.NoError(t, err, "Cannot start netorcai")
+	defer killallNetorcaiSIGKILL()
+
+	retCode, err := waitCompletionTimeout(proc.completion, 1000)
+	assert.NoError(t, err, "netorcai did not complete")
+	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
+}
+
+func TestCLIArgTurnOrderSimultaneous(t *testing.T) {
+	args := []string{"--turn-order=simultaneous"}
+	coverFile, _ := handleCoverage(t, 0)
+
+	proc, err := runNetorcaiCover(coverFile, args)
+	assert.NoError(t, err, "Cannot start netorcai")
+	defer killallNetorcaiSIGKILL()
+
+	_, err = waitListening(proc.outputControl, 1000)
+	assert.NoError(t, err, "Netorcai is not listening")
+
+	err = killNetorcaiGently(proc, 1000)
+	assert.NoError(t, err, "Netorcai could not be killed gently")
+}
+
+func TestCLIArgTurnOrderRoundRobin(t *testing.T) {
+	args := []string{"--turn-order=round-robin"}
+	coverFile, _ := handleCoverage(t, 0)
+
+	proc, err := runNetorcaiCover(coverFile, args)
+	assert.NoError(t, err, "Cannot start netorcai")
+	defer killallNetorcaiSIGKILL()
+
+	_, err = waitListening(proc.outputControl, 1000)
+	assert.NoError(t, err, "Netorcai is not listening")
+
+	err = killNetorcaiGently(proc, 1000)
+	assert.NoError(t, err, "Netorcai could not be killed gently")
+}
@@ -0,0 +1,196 @@
+package test
+
+import (
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// counterGameLogic counts the turns in its game states.
+// Its optional fields enable the optional features of the client library.
+type counterGameLogic struct {
+	nbPlayers      int
+	turnNumber     int
+	winnerPlayerID int
+	playerActions  [][]protocol.MessageDoTurnPlayerAction
+
+	// The JSON Schema of the player actions, if any.
+	actionSchema map[string]interface{}
+	// Whether player 0 is told how many turns have been done.
+	feedback bool
+	// Whether only the changes of the game states are sent.
+	deltas bool
+	// The number of cells of the game states, which makes them big enough
+	// to be compressed.
+	boardSize int
+}
+
+func (gl *counterGameLogic) Init(nbPlayers, nbSpecialPlayers,
+	nbTurnsMax int) map[string]interface{} {
+	gl.nbPlayers = nbPlayers
+	return gl.gameState()
+}
+
+func (gl *counterGameLogic) Turn(
+	playerActions []protocol.MessageDoTurnPlayerAction) (
+	map[string]interface{}, int) {
+	gl.playerActions = append(gl.playerActions, playerActions)
+	gl.turnNumber++
+	return gl.gameState(), gl.winnerPlayerID
+}
+
+func (gl *counterGameLogic) gameState() map[string]interface{} {
+	gameState := map[string]interface{}{"turn": gl.turnNumber}
+	if gl.boardSize > 0 {
+		cells := make([]interface{}, gl.boardSize)
+		for index := range cells {
+			cells[index] = index % 4
+		}
+		gameState["cells"] = cells
+	}
+	return gameState
+}
+
+func (gl *counterGameLogic) ActionSchema() map[string]interface{} {
+	return gl.actionSchema
+}
+
+func (gl *counterGameLogic) GameStateDeltas() bool {
+	return gl.deltas
+}
+
+func (gl *counterGameLogic) Feedback() []protocol.PlayerFeedback {
+	if !gl.feedback {
+		return nil
+	}
+	return []protocol.PlayerFeedback{{PlayerID: 0,
+		Content: map[string]interface{}{"turn": gl.turnNumber}}}
+}
+
+func runGameLogicAsync(c *client.Client,
+	gameLogic client.GameLogic) chan error {
+	glExit := make(chan error, 1)
+	go func() {
+		glExit <- client.RunGameLogic(c, "gl", gameLogic)
+	}()
+	return glExit
+}
+
+// gameClient is a player or a visualization of a game run by playGame.
+type gameClient struct {
+	role string
+	// The connection settings (Compression, Serialization...). Can be nil.
+	bot *client.Client
+	// Run by RunPlayer. If nil, the client logs in but never reads anything.
+	player client.Player
+}
+
+// playGame connects the clients then the game logic to netorcai, in order,
+// starts the game from the prompt and waits for all of them to return.
+// glClient can be nil. The error of RunGameLogic is returned, and the
+// clients are only waited for if the game logic succeeded.
+func playGame(t *testing.T, proc *NetorcaiProcess, clients []gameClient,
+	glClient *client.Client, gameLogic client.GameLogic,
+	timeoutMS int) error {
+	exits := []chan error{}
+	for _, gameClient := range clients {
+		bot := gameClient.bot
+		if bot == nil {
+			bot = &client.Client{}
+		}
+		err := bot.Connect("localhost", 4242)
+		assert.NoError(t, err, "Cannot connect")
+		if gameClient.player != nil {
+			exits = append(exits, runPlayerAsync(bot, gameClient.role,
+				gameClient.player))
+		} else {
+			err = bot.SendLogin(gameClient.role, "bot", protocol.Version)
+			assert.NoError(t, err, "Cannot send LOGIN")
+		}
+		_, err = waitOutputTimeout(regexp.MustCompile(`New .* accepted`),
+			proc.outputControl, 1000, false)
+		assert.NoError(t, err, "Client has not been accepted")
+	}
+
+	if glClient == nil {
+		glClient = &client.Client{}
+	}
+	err := glClient.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+	glExit := runGameLogicAsync(glClient, gameLogic)
+	_, err = waitOutputTimeout(regexp.MustCompile(`Game logic accepted`),
+		proc.outputControl, 1000, false)
+	assert.NoError(t, err, "Game logic has not been accepted")
+
+	proc.inputControl <- "start"
+
+	select {
+	case err = <-glExit:
+	case <-time.After(time.Duration(timeoutMS) * time.Millisecond):
+		assert.FailNow(t, "RunGameLogic did not return")
+	}
+	if err != nil {
+		return err
+	}
+	for _, exit := range exits {
+		assert.NoError(t, waitPlayerExit(t, exit, 3000), "RunPlayer failed")
+	}
+	return nil
+}
+
+func subtestClientRunGameLogic(t *testing.T, winnerPlayerID int,
+	expectedError *regexp.Regexp) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=1",
+		"--nb-visus-max=0", "--nb-turns-max=3",
+		"--delay-first-turn=50", "--delay-turns=50"})
+	defer killallNetorcaiSIGKILL()
+
+	player := &recordingPlayer{}
+	gameLogic := &counterGameLogic{winnerPlayerID: winnerPlayerID}
+	err := playGame(t, proc, []gameClient{{role: "player", player: player}},
+		nil, gameLogic, 3000)
+
+	if expectedError != nil {
+		assert.Error(t, err, "RunGameLogic should fail")
+		assert.Regexp(t, expectedError, err.Error(),
+			"Unexpected RunGameLogic error")
+		return
+	}
+
+	assert.NoError(t, err, "RunGameLogic failed")
+	assert.Equal(t, 1, gameLogic.nbPlayers, "Unexpected nb_players")
+	assert.Equal(t, 3, gameLogic.turnNumber, "Unexpected number of DO_TURN")
+
+	assert.Len(t, player.gameStarts, 1, "Unexpected number of GAME_STARTS")
+	assert.Equal(t, map[string]interface{}{"turn": 0.0},
+		player.gameStarts[0].InitialGameState, "Unexpected initial game state")
+	for turnNumber, turn := range player.turns {
+		assert.Equal(t, map[string]interface{}{"turn": float64(turnNumber + 1)},
+			turn.GameState, "Unexpected game state")
+	}
+	assert.Len(t, player.gameEnds, 1, "Unexpected number of GAME_ENDS")
+	assert.Equal(t, winnerPlayerID, player.gameEnds[0].WinnerPlayerID,
+		"Unexpected winner")
+
+	// The player's actions of the first TURN are in the second DO_TURN.
+	assert.Len(t, gameLogic.playerActions[1], 1,
+		"Unexpected number of player actions")
+
+	waitCompletionTimeout(proc.completion, 1000)
+}
+
+func TestClientRunGameLogic(t *testing.T) {
+	subtestClientRunGameLogic(t, 0, nil)
+}
+
+func TestClientRunGameLogicNoWinner(t *testing.T) {
+	subtestClientRunGameLogic(t, -1, nil)
+}
+
+func TestClientRunGameLogicInvalidWinner(t *testing.T) {
+	subtestClientRunGameLogic(t, 1,
+		regexp.MustCompile(`Invalid winner_player_id 1`))
+}
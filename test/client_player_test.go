@@ -0,0 +1,126 @@
+package test
+
+import (
+	"github.com/netorcai/netorcai"
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// recordingPlayer records the messages it receives.
+// It sends the same actions on every turn, after latency.
+type recordingPlayer struct {
+	gameStarts []protocol.MessageGameStarts
+	turns      []protocol.MessageTurn
+	gameEnds   []protocol.MessageGameEnds
+
+	actions []interface{}
+	latency time.Duration
+	// How netorcai sends game states. "" means the default encoding.
+	encoding string
+}
+
+func (p *recordingPlayer) OnGameStarts(gameStarts protocol.MessageGameStarts) {
+	p.gameStarts = append(p.gameStarts, gameStarts)
+}
+
+func (p *recordingPlayer) OnTurn(turn protocol.MessageTurn) []interface{} {
+	p.turns = append(p.turns, turn)
+	time.Sleep(p.latency)
+	return p.actions
+}
+
+func (p *recordingPlayer) OnGameEnds(gameEnds protocol.MessageGameEnds) {
+	p.gameEnds = append(p.gameEnds, gameEnds)
+}
+
+func (p *recordingPlayer) GameStateEncoding() string {
+	return p.encoding
+}
+
+func runPlayerAsync(c *client.Client, role string,
+	player client.Player) chan error {
+	playerExit := make(chan error, 1)
+	go func() {
+		playerExit <- client.RunPlayer(c, role, "bot", player)
+	}()
+	return playerExit
+}
+
+func waitPlayerExit(t *testing.T, playerExit chan error,
+	timeoutMS int) error {
+	select {
+	case err := <-playerExit:
+		return err
+	case <-time.After(time.Duration(timeoutMS) * time.Millisecond):
+		assert.FailNow(t, "RunPlayer did not return")
+		return nil
+	}
+}
+
+func TestClientRunPlayer(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=1",
+		"--nb-visus-max=0", "--nb-turns-max=3",
+		"--delay-first-turn=50", "--delay-turns=50"})
+	defer killallNetorcaiSIGKILL()
+
+	bot := &client.Client{}
+	err := bot.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+
+	player := &recordingPlayer{}
+	playerExit := runPlayerAsync(bot, "player", player)
+	_, err = waitOutputTimeout(regexp.MustCompile(`New player accepted`),
+		proc.outputControl, 1000, false)
+	assert.NoError(t, err, "Player has not been accepted")
+
+	gl, err := connectClient(t, "game logic", "gl", netorcai.Version, 1000)
+	assert.NoError(t, err, "Cannot connect GL")
+	go helloGameLogic(t, gl, 1, 0, 3, 3, DefaultHelloGLCheckDoTurn,
+		DefaultHelloGLDoInitAck, DefaultHelloGlDoTurnAck,
+		regexp.MustCompile(`Game is finished`))
+
+	proc.inputControl <- "start"
+
+	err = waitPlayerExit(t, playerExit, 3000)
+	assert.NoError(t, err, "RunPlayer failed")
+
+	assert.Len(t, player.gameStarts, 1, "Unexpected number of GAME_STARTS")
+	assert.Equal(t, 0, player.gameStarts[0].PlayerID, "Unexpected player_id")
+	assert.Equal(t, 3, player.gameStarts[0].NbTurnsMax,
+		"Unexpected nb_turns_max")
+
+	assert.Len(t, player.turns, 2, "Unexpected number of TURN")
+	for turnNumber, turn := range player.turns {
+		assert.Equal(t, turnNumber, turn.TurnNumber, "Unexpected turn_number")
+		assert.True(t, turn.Actionable, "TURN should be actionable")
+	}
+
+	assert.Len(t, player.gameEnds, 1, "Unexpected number of GAME_ENDS")
+	assert.Equal(t, "finished", player.gameEnds[0].Status,
+		"Unexpected GAME_ENDS status")
+
+	waitCompletionTimeout(proc.completion, 1000)
+}
+
+func TestClientRunPlayerKicked(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=0"})
+	defer killallNetorcaiSIGKILL()
+
+	bot := &client.Client{}
+	err := bot.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+
+	player := &recordingPlayer{}
+	err = waitPlayerExit(t, runPlayerAsync(bot, "player", player), 1000)
+	assert.Error(t, err, "RunPlayer should fail")
+	assert.Regexp(t, `Kicked from netorcai`, err.Error(),
+		"Unexpected RunPlayer error")
+	assert.Empty(t, player.gameStarts, "OnGameStarts should not be called")
+
+	err = killNetorcaiGently(proc, 1000)
+	assert.NoError(t, err, "Netorcai could not be killed gently")
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=2",
+		"--nb-visus-max=0", "--nb-turns-max=5", "--fast"})
+	defer killallNetorcaiSIGKILL()
+
+	// The first player compresses messages, the second one does not.
+	compressions := [][]string{{"gzip"}, nil}
+	bots := []*client.Client{}
+	players := []*recordingPlayer{}
+	clients := []gameClient{}
+	for _, compression := range compressions {
+		bots = append(bots, &client.Client{Compression: compression})
+		players = append(players, &recordingPlayer{})
+		clients = append(clients, gameClient{role: "player",
+			bot: bots[len(bots)-1], player: players[len(players)-1]})
+	}
+	glClient := &client.Client{Compression: []string{"gzip"}}
+	err := playGame(t, proc, clients, glClient,
+		&counterGameLogic{winnerPlayerID: -1, boardSize: 1000}, 3000)
+	assert.NoError(t, err, "RunGameLogic failed")
+
+	// Compression is transparent for players.
+	for _, player := range players {
+		assert.Len(t, player.turns, 4, "Unexpected number of TURN")
+		for turnIndex, turn := range player.turns {
+			assert.Equal(t, float64(turnIndex+1), turn.GameState["turn"],
+				"Unexpected game state")
+			assert.Len(t, turn.GameState["cells"], 1000,
+				"Unexpected game state")
+		}
+	}
+
+	stats := bots[0].Stats()
+	assert.True(t, stats.BytesReceived < stats.UncompressedBytesReceived/2,
+		"TURN messages not compressed: %+v", stats)
+	stats = bots[1].Stats()
+	assert.Equal(t, stats.UncompressedBytesReceived, stats.BytesReceived,
+		"Messages compressed without negotiation")
+	stats = glClient.Stats()
+	assert.True(t, stats.BytesSent < stats.UncompressedBytesSent/2,
+		"DO_TURN_ACK messages not compressed: %+v", stats)
+
+	_, err = waitOutputTimeout(regexp.MustCompile(
+		`compression=gzip.*Client traffic|Client traffic.*compression=gzip`),
+		proc.outputControl, 1000, false)
+	assert.NoError(t, err, "Traffic of compressed clients not logged")
+}
+
+func subtestCompressionNegotiation(t *testing.T, args []string,
+	offered []string, expectedCompression string) {
+	proc := runNetorcaiWaitListening(t, args)
+	defer killallNetorcaiSIGKILL()
+
+	// The LOGIN is sent by hand, as the client library does not offer
+	// algorithms it does not support.
+	bot := &client.Client{Compression: offered}
+	err := bot.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+	err = bot.SendJSON(map[string]interface{}{
+		"message_type":         "LOGIN",
+		"nickname":             "bot",
+		"role":                 "player",
+		"metaprotocol_version": protocol.Version,
+		"compression":          offered,
+	})
+	assert.NoError(t, err, "Cannot send LOGIN")
+
+	loginAck, err := bot.ReadLoginAck()
+	assert.NoError(t, err, "Cannot read LOGIN_ACK")
+	assert.Equal(t, expectedCompression, loginAck.Compression,
+		"Unexpected compression")
+
+	_, err = waitOutputTimeout(regexp.MustCompile(`New player accepted`),
+		proc.outputControl, 1000, false)
+	assert.NoError(t, err, "Player has not been accepted")
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	subtestCompressionNegotiation(t, []string{},
+		[]string{"zstd", "gzip"}, "gzip")
+}
+
+func TestCompressionUnknown(t *testing.T) {
+	subtestCompressionNegotiation(t, []string{},
+		[]string{"zstd", "lz4"}, "")
+}
+
+func TestCompressionDisabled(t *testing.T) {
+	subtestCompressionNegotiation(t, []string{"--no-compression"},
+		[]string{"gzip"}, "")
+}
@@ -0,0 +1,125 @@
+package test
+
+import (
+	"github.com/netorcai/netorcai"
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func runConformance(t *testing.T, arguments []string) *NetorcaiProcess {
+	coverFile, _ := handleCoverage(t, 0)
+	proc, err := runNetorcaiCover(coverFile,
+		append([]string{"conformance"}, arguments...))
+	assert.NoError(t, err, "Cannot start netorcai conformance")
+	return proc
+}
+
+func subtestConformanceBot(t *testing.T, role string, botArguments string,
+	expectedExitCode int) {
+	proc := runConformance(t, []string{"--role=" + role,
+		"--client=netorcai-bot --role=" + role + botArguments})
+	defer killallNetorcaiSIGKILL()
+
+	nbScenarios := len(netorcai.ConformanceScenarios(role))
+	passedRe := regexp.MustCompile(`Scenario passed`)
+	nbPassed := 0
+	for expectedExitCode == 0 && nbPassed < nbScenarios {
+		_, err := waitOutputTimeout(passedRe, proc.outputControl, 5000,
+			false)
+		assert.NoError(t, err, "Scenario did not pass")
+		if err != nil {
+			break
+		}
+		nbPassed++
+	}
+
+	exitCode, err := waitCompletionTimeout(proc.completion, 20000)
+	assert.NoError(t, err, "netorcai conformance did not complete")
+	assert.Equal(t, expectedExitCode, exitCode, "Unexpected exit code")
+}
+
+func TestConformancePlayerBot(t *testing.T) {
+	subtestConformanceBot(t, "player", "", 0)
+}
+
+func TestConformanceVisuBot(t *testing.T) {
+	subtestConformanceBot(t, "visualization", "", 0)
+}
+
+func TestConformanceMalformedBot(t *testing.T) {
+	subtestConformanceBot(t, "player", " --malformed-turn-ack-at=1",
+		netorcai.EXIT_CONFORMANCE_FAILED)
+}
+
+func TestConformanceGameLogic(t *testing.T) {
+	proc := runConformance(t, []string{"--role=game-logic",
+		"--scenario=normal-game", "--scenario=large-messages",
+		"--scenario=episodes", "--scenario=games"})
+	defer killallNetorcaiSIGKILL()
+
+	// Without --client, the client under test is run by hand.
+	for scenario := 0; scenario < 4; scenario++ {
+		_, err := waitOutputTimeout(
+			regexp.MustCompile(`Waiting for the client under test`),
+			proc.outputControl, 1000, false)
+		assert.NoError(t, err, "netorcai conformance is not waiting")
+
+		glClient := &client.Client{}
+		err = glClient.Connect("localhost", 4242)
+		assert.NoError(t, err, "Cannot connect")
+		err = client.RunGameLogic(glClient, "gl",
+			&counterGameLogic{winnerPlayerID: -1})
+		assert.NoError(t, err, "RunGameLogic failed")
+		glClient.Disconnect()
+	}
+
+	exitCode, err := waitCompletionTimeout(proc.completion, 3000)
+	assert.NoError(t, err, "netorcai conformance did not complete")
+	assert.Equal(t, 0, exitCode, "Unexpected exit code")
+}
+
+func TestConformanceSingleGamePlayer(t *testing.T) {
+	proc := runConformance(t, []string{"--role=player",
+		"--scenario=episodes", "--scenario=games"})
+	defer killallNetorcaiSIGKILL()
+
+	for scenario := 0; scenario < 2; scenario++ {
+		_, err := waitOutputTimeout(
+			regexp.MustCompile(`Waiting for the client under test`),
+			proc.outputControl, 1000, false)
+		assert.NoError(t, err, "netorcai conformance is not waiting")
+
+		// This player leaves after the first GAME_ENDS.
+		bot := &client.Client{}
+		err = bot.Connect("localhost", 4242)
+		assert.NoError(t, err, "Cannot connect")
+		err = bot.SendLogin("player", "bot", netorcai.Version)
+		assert.NoError(t, err, "Cannot send LOGIN")
+		_, err = bot.ReadLoginAck()
+		assert.NoError(t, err, "Cannot read LOGIN_ACK")
+		player := &crashingPlayer{crashTurn: -1}
+		assert.NoError(t, player.run(bot), "Player failed")
+		bot.Disconnect()
+
+		_, err = waitOutputTimeout(regexp.MustCompile(`Scenario failed`),
+			proc.outputControl, 3000, false)
+		assert.NoError(t, err, "Scenario did not fail")
+	}
+
+	exitCode, err := waitCompletionTimeout(proc.completion, 3000)
+	assert.NoError(t, err, "netorcai conformance did not complete")
+	assert.Equal(t, netorcai.EXIT_CONFORMANCE_FAILED, exitCode,
+		"Unexpected exit code")
+}
+
+func TestConformanceUnknownScenario(t *testing.T) {
+	proc := runConformance(t, []string{"--role=player", "--scenario=meh"})
+	defer killallNetorcaiSIGKILL()
+
+	exitCode, err := waitCompletionTimeout(proc.completion, 1000)
+	assert.NoError(t, err, "netorcai conformance did not complete")
+	assert.Equal(t, netorcai.EXIT_BAD_ARGUMENTS, exitCode,
+		"Unexpected exit code")
+}
@@ -0,0 +1,115 @@
+package test
+
+import (
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestGameStateEncoding(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=3",
+		"--nb-visus-max=1", "--nb-turns-max=8", "--fast",
+		"--keyframe-interval=3"})
+	defer killallNetorcaiSIGKILL()
+
+	encodings := []string{"full", "merge-patch", "json-patch", ""}
+	roles := []string{"player", "player", "player", "visualization"}
+	players := []*recordingPlayer{}
+	clients := []gameClient{}
+	for index, encoding := range encodings {
+		players = append(players, &recordingPlayer{encoding: encoding})
+		clients = append(clients, gameClient{role: roles[index],
+			player: players[index]})
+	}
+	err := playGame(t, proc, clients, nil,
+		&counterGameLogic{winnerPlayerID: -1, deltas: true}, 3000)
+	assert.NoError(t, err, "RunGameLogic failed")
+
+	for index, player := range players {
+		assert.Len(t, player.turns, 7, "Unexpected number of TURN")
+		for turnIndex, turn := range player.turns {
+			// The game state is rebuilt by the client library.
+			assert.Equal(t, map[string]interface{}{
+				"turn": float64(turnIndex + 1)}, turn.GameState,
+				"Unexpected game state")
+
+			// Every third TURN is a keyframe.
+			isKeyframe := (turnIndex+1)%3 == 0
+			switch encodings[index] {
+			case "merge-patch":
+				assert.Equal(t, isKeyframe, turn.GameStatePatch == nil,
+					"Unexpected patch at turn %v", turnIndex)
+				assert.Nil(t, turn.GameStateJSONPatch, "Unexpected patch")
+			case "json-patch":
+				assert.Equal(t, isKeyframe, turn.GameStateJSONPatch == nil,
+					"Unexpected patch at turn %v", turnIndex)
+				assert.Nil(t, turn.GameStatePatch, "Unexpected patch")
+			default:
+				assert.Nil(t, turn.GameStatePatch, "Unexpected patch")
+				assert.Nil(t, turn.GameStateJSONPatch, "Unexpected patch")
+			}
+		}
+	}
+}
+
+func TestGameStateEncodingInvalid(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{})
+	defer killallNetorcaiSIGKILL()
+
+	bot := &client.Client{}
+	err := bot.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+	err = bot.SendLoginWithGameStateEncoding("player", "bot",
+		protocol.Version, "gzip")
+	assert.NoError(t, err, "Cannot send LOGIN")
+
+	_, err = waitOutputTimeout(regexp.MustCompile(
+		`Invalid game_state_encoding`), proc.outputControl, 1000, false)
+	assert.NoError(t, err, "Invalid encoding not detected")
+	_, err = bot.ReadLoginAck()
+	assert.Error(t, err, "LOGIN with invalid encoding accepted")
+}
+
+func TestGameLogicInvalidPatch(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=1",
+		"--nb-visus-max=0", "--nb-turns-max=3", "--fast", "--autostart"})
+	defer killallNetorcaiSIGKILL()
+
+	glClient := &client.Client{}
+	err := glClient.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+	err = glClient.SendLogin("game logic", "gl", protocol.Version)
+	assert.NoError(t, err, "Cannot send LOGIN")
+	_, err = glClient.ReadLoginAck()
+	assert.NoError(t, err, "Cannot read LOGIN_ACK")
+
+	bot := &client.Client{}
+	err = bot.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+	playerExit := runPlayerAsync(bot, "player", &recordingPlayer{})
+
+	_, err = glClient.ReadDoInit()
+	assert.NoError(t, err, "Cannot read DO_INIT")
+	err = glClient.SendDoInitAck(map[string]interface{}{})
+	assert.NoError(t, err, "Cannot send DO_INIT_ACK")
+	_, err = glClient.ReadDoTurn(1)
+	assert.NoError(t, err, "Cannot read DO_TURN")
+	err = glClient.SendJSON(map[string]interface{}{
+		"message_type":     "DO_TURN_ACK",
+		"winner_player_id": -1,
+		"game_state_json_patch": []interface{}{map[string]interface{}{
+			"op": "remove", "path": "/all_clients/missing"}},
+	})
+	assert.NoError(t, err, "Cannot send DO_TURN_ACK")
+
+	_, err = waitOutputTimeout(regexp.MustCompile(
+		`Invalid game_state_json_patch`), proc.outputControl, 1000, false)
+	assert.NoError(t, err, "Invalid patch not detected")
+
+	exitCode, err := waitCompletionTimeout(proc.completion, 1000)
+	assert.NoError(t, err, "netorcai did not complete")
+	assert.Equal(t, 3, exitCode, "Unexpected exit code")
+	waitPlayerExit(t, playerExit, 1000)
+}
@@ -0,0 +1,157 @@
+package test
+
+import (
+	"context"
+	"github.com/netorcai/netorcai"
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type recordingAgent struct {
+	gameStarts []protocol.MessageGameStarts
+	turns      []protocol.MessageTurn
+	gameEnds   []protocol.MessageGameEnds
+}
+
+func (a *recordingAgent) OnGameStarts(gameStarts protocol.MessageGameStarts) {
+	a.gameStarts = append(a.gameStarts, gameStarts)
+}
+
+func (a *recordingAgent) OnTurn(
+	turn protocol.MessageTurn) map[int][]interface{} {
+	a.turns = append(a.turns, turn)
+	actions := map[int][]interface{}{}
+	for _, playerID := range turn.ActionablePlayerIDs {
+		actions[playerID] = []interface{}{playerID}
+	}
+	return actions
+}
+
+func (a *recordingAgent) OnGameEnds(gameEnds protocol.MessageGameEnds) {
+	a.gameEnds = append(a.gameEnds, gameEnds)
+}
+
+func runAgentAsync(c *client.Client, nbSlots int,
+	agent client.Agent) chan error {
+	agentExit := make(chan error, 1)
+	go func() {
+		agentExit <- client.RunAgent(c, "agent", nbSlots, agent)
+	}()
+	return agentExit
+}
+
+func subtestEpisodes(t *testing.T, fast bool) {
+	config := netorcai.DefaultConfig()
+	config.NbPlayersMax = 3
+	config.NbVisusMax = 1
+	config.NbTurnsMax = 4
+	config.NbEpisodes = 3
+	config.MillisecondsBeforeFirstTurn = 50
+	config.MillisecondsBetweenTurns = 50
+	config.Fast = fast
+	server := startEmbeddedServer(t, config)
+	defer server.Shutdown()
+
+	player := &recordingPlayer{}
+	playerExit := runPlayerAsync(connectEmbeddedServer(t, server), "player",
+		player)
+	agent := &recordingAgent{}
+	agentExit := runAgentAsync(connectEmbeddedServer(t, server), 2, agent)
+	visu := &recordingPlayer{}
+	visuExit := runPlayerAsync(connectEmbeddedServer(t, server),
+		"visualization", visu)
+	gl := &counterGameLogic{winnerPlayerID: -1}
+	glExit := runGameLogicAsync(connectEmbeddedServer(t, server), gl)
+
+	err := server.StartGame()
+	for retry := 0; err != nil && retry < 100; retry++ {
+		time.Sleep(10 * time.Millisecond)
+		err = server.StartGame()
+	}
+	assert.NoError(t, err, "Cannot start game")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exitCode, err := server.Wait(ctx)
+	assert.NoError(t, err, "Server did not stop")
+	assert.Equal(t, netorcai.EXIT_SUCCESS, exitCode, "Unexpected exit code")
+	assert.NoError(t, <-glExit, "RunGameLogic failed")
+	assert.NoError(t, waitPlayerExit(t, playerExit, 1000), "RunPlayer failed")
+	assert.NoError(t, waitPlayerExit(t, agentExit, 1000), "RunAgent failed")
+	assert.NoError(t, waitPlayerExit(t, visuExit, 1000), "Visu failed")
+
+	// All clients see every episode, in order.
+	for _, gameEnds := range [][]protocol.MessageGameEnds{player.gameEnds,
+		agent.gameEnds, visu.gameEnds} {
+		if assert.Len(t, gameEnds, config.NbEpisodes,
+			"Unexpected number of GAME_ENDS") {
+			for episode, msg := range gameEnds {
+				assert.Equal(t, episode, msg.Episode)
+				assert.Equal(t, config.NbEpisodes, msg.NbEpisodes)
+				assert.Equal(t, "finished", msg.Status)
+			}
+		}
+	}
+	assert.Len(t, player.gameStarts, config.NbEpisodes)
+	assert.Len(t, visu.gameStarts, config.NbEpisodes)
+
+	// Only the first episode waits before its first turn.
+	for _, gameStarts := range append(player.gameStarts,
+		visu.gameStarts...) {
+		expectedDelay := 0.0
+		if gameStarts.Episode == 0 {
+			expectedDelay = config.MillisecondsBeforeFirstTurn
+		}
+		assert.Equal(t, expectedDelay, gameStarts.DelayFirstTurn,
+			"Unexpected delay before the first turn of episode %v",
+			gameStarts.Episode)
+	}
+
+	// The agent controls 2 of the 3 player slots, with stable player IDs.
+	if assert.Len(t, agent.gameStarts, config.NbEpisodes) {
+		agentPlayerIDs := agent.gameStarts[0].PlayerIDs
+		assert.Len(t, agentPlayerIDs, 2, "Unexpected agent player IDs")
+		for _, gameStarts := range agent.gameStarts {
+			assert.Equal(t, agentPlayerIDs, gameStarts.PlayerIDs)
+			assert.Equal(t, 3, gameStarts.NbPlayers)
+		}
+	}
+	for _, turn := range agent.turns {
+		assert.Len(t, turn.ActionablePlayerIDs, 2,
+			"Agent slots not actionable in turn %v", turn.TurnNumber)
+	}
+
+	// The game logic receives one action list per slot and turn,
+	// except on the first DO_TURN of each episode.
+	assert.Equal(t, 3, gl.nbPlayers)
+	assert.Len(t, gl.playerActions, config.NbEpisodes*config.NbTurnsMax)
+	if assert.NotEmpty(t, player.gameStarts) {
+		for index, actions := range gl.playerActions {
+			if index%config.NbTurnsMax == 0 {
+				assert.Empty(t, actions, "Actions before the first TURN")
+			} else if fast {
+				assert.Len(t, actions, 3, "Missing actions in fast mode")
+			}
+			for _, action := range actions {
+				if action.PlayerID == player.gameStarts[0].PlayerID {
+					continue
+				}
+				assert.Contains(t, agent.gameStarts[0].PlayerIDs,
+					action.PlayerID)
+				assert.Equal(t, []interface{}{float64(action.PlayerID)},
+					action.Actions, "Agent actions not forwarded")
+			}
+		}
+	}
+}
+
+func TestEpisodesTimers(t *testing.T) {
+	subtestEpisodes(t, false)
+}
+
+func TestEpisodesFast(t *testing.T) {
+	subtestEpisodes(t, true)
+}
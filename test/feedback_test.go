@@ -0,0 +1,57 @@
+package test
+
+import (
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func subtestFeedback(t *testing.T, visuFeedback bool) {
+	arguments := []string{"--nb-players-max=2", "--nb-visus-max=1",
+		"--nb-turns-max=3", "--fast"}
+	if visuFeedback {
+		arguments = append(arguments, "--visu-feedback")
+	}
+	proc := runNetorcaiWaitListening(t, arguments)
+	defer killallNetorcaiSIGKILL()
+
+	players := []*recordingPlayer{{}, {}, {}}
+	clients := []gameClient{}
+	for index, role := range []string{"player", "player", "visualization"} {
+		clients = append(clients, gameClient{role: role,
+			player: players[index]})
+	}
+	err := playGame(t, proc, clients, nil,
+		&counterGameLogic{winnerPlayerID: -1, feedback: true}, 3000)
+	assert.NoError(t, err, "RunGameLogic failed")
+
+	for index, player := range players {
+		assert.Len(t, player.gameStarts, 1, "Unexpected number of GAME_STARTS")
+		assert.Len(t, player.turns, 2, "Unexpected number of TURN")
+		if len(player.gameStarts) != 1 {
+			continue
+		}
+
+		isVisu := index == 2
+		receivesFeedback := player.gameStarts[0].PlayerID == 0 && !isVisu ||
+			isVisu && visuFeedback
+		for turnIndex, turn := range player.turns {
+			if !receivesFeedback {
+				assert.Empty(t, turn.Feedback, "Unexpected feedback")
+				continue
+			}
+			assert.Equal(t, []protocol.PlayerFeedback{{PlayerID: 0,
+				Content: map[string]interface{}{
+					"turn": float64(turnIndex + 1)}}},
+				turn.Feedback, "Unexpected feedback")
+		}
+	}
+}
+
+func TestFeedback(t *testing.T) {
+	subtestFeedback(t, false)
+}
+
+func TestFeedbackVisu(t *testing.T) {
+	subtestFeedback(t, true)
+}
@@ -2,7 +2,7 @@ package test
 
 import (
 	"fmt"
-	"github.com/netorcai/netorcai"
+	"github.com/netorcai/netorcai/protocol"
 	"github.com/stretchr/testify/assert"
 	"regexp"
 	"testing"
@@ -78,11 +78,11 @@ func doInitAckNastyNested(nbPlayers, nbSpecialPlayers, nbTurns int) string {
 }
 
 func subCheckFlattenedObject(t *testing.T, object map[string]interface{}) {
-	s, err := netorcai.ReadString(object, "string")
+	s, err := protocol.ReadString(object, "string")
 	assert.NoError(t, err, "Cannot read 'string' field in obj")
 	assert.Equal(t, "hello", s, "Unexpected value for 'string' field in obj")
 
-	i, err := netorcai.ReadInt(object, "integer")
+	i, err := protocol.ReadInt(object, "integer")
 	assert.NoError(t, err, "Cannot read 'integer' field in obj")
 	assert.Equal(t, 42, i, "Unexpected value for 'integer' field in obj")
 
@@ -90,11 +90,11 @@ func subCheckFlattenedObject(t *testing.T, object map[string]interface{}) {
 	assert.NoError(t, err, "Cannot read 'float' field in obj")
 	assert.Equal(t, 0.5, f, "Unexpected value for 'float' field in obj")
 
-	o, err := netorcai.ReadObject(object, "object")
+	o, err := protocol.ReadObject(object, "object")
 	assert.NoError(t, err, "Cannot read 'object' field in obj")
 	assert.Equal(t, 0, len(o), "Unexpected length for 'object' field in obj")
 
-	a, err := netorcai.ReadArray(object, "array")
+	a, err := protocol.ReadArray(object, "array")
 	assert.NoError(t, err, "Cannot read 'array' field in obj")
 	assert.Equal(t, 0, len(a), "Unexpected length for 'array' field in obj")
 
@@ -109,7 +109,7 @@ func checkGameStartsFlattened(t *testing.T,
 	playerID := checkGameStarts(t, msg, nbPlayers, nbSpecialPlayers, nbTurnsGL,
 		msBeforeFirstTurn, msBetweenTurns, isPlayer)
 
-	initialGS, err := netorcai.ReadObject(msg, "initial_game_state")
+	initialGS, err := protocol.ReadObject(msg, "initial_game_state")
 	assert.NoError(t, err, "Cannot read 'initial_game_state' in msg")
 	subCheckFlattenedObject(t, initialGS)
 
@@ -122,29 +122,29 @@ func checkGameStartsNastyNested(t *testing.T,
 	playerID := checkGameStarts(t, msg, nbPlayers, nbSpecialPlayers, nbTurnsGL,
 		msBeforeFirstTurn, msBetweenTurns, isPlayer)
 
-	initialGS, err := netorcai.ReadObject(msg, "initial_game_state")
+	initialGS, err := protocol.ReadObject(msg, "initial_game_state")
 	assert.NoError(t, err, "Cannot read 'initial_game_state' in msg")
 	subCheckFlattenedObject(t, initialGS)
 
-	nestedObject1, err := netorcai.ReadObject(initialGS, "nested_object")
+	nestedObject1, err := protocol.ReadObject(initialGS, "nested_object")
 	assert.NoError(t, err, "Cannot read 'nested_object' in msg")
 	subCheckFlattenedObject(t, nestedObject1)
 
-	nestedArray2, err := netorcai.ReadArray(nestedObject1, "nested_array")
+	nestedArray2, err := protocol.ReadArray(nestedObject1, "nested_array")
 	assert.NoError(t, err, "Cannot read 'nested_array' field in obj")
 	assert.Equal(t, 1, len(nestedArray2),
 		"Unexpected length for 'nested_array' field in obj")
 	nestedArray2FirstElement := nestedArray2[0].(map[string]interface{})
 	subCheckFlattenedObject(t, nestedArray2FirstElement)
 
-	nestedArray1, err := netorcai.ReadArray(initialGS, "nested_array")
+	nestedArray1, err := protocol.ReadArray(initialGS, "nested_array")
 	assert.NoError(t, err, "Cannot read 'nested_array' field in obj")
 	assert.Equal(t, 1, len(nestedArray1),
 		"Unexpected length for 'nested_array' field in obj")
 	nestedArray1FirstElement := nestedArray1[0].(map[string]interface{})
 	subCheckFlattenedObject(t, nestedArray1FirstElement)
 
-	nestedObject2, err := netorcai.ReadObject(nestedArray1FirstElement,
+	nestedObject2, err := protocol.ReadObject(nestedArray1FirstElement,
 		"nested_object")
 	assert.NoError(t, err, "Cannot read 'nested_object' in msg")
 	subCheckFlattenedObject(t, nestedObject2)
@@ -255,7 +255,7 @@ func checkTurnFlattened(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int, isPlayer bool) int {
 	turn := checkTurn(t, msg, expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber, isPlayer)
 
-	gs, err := netorcai.ReadObject(msg, "game_state")
+	gs, err := protocol.ReadObject(msg, "game_state")
 	assert.NoError(t, err, "Cannot read 'game_state' in msg")
 	subCheckFlattenedObject(t, gs)
 
@@ -266,29 +266,29 @@ func checkTurnNastyNested(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int, isPlayer bool) int {
 	turn := checkTurn(t, msg, expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber, isPlayer)
 
-	gs, err := netorcai.ReadObject(msg, "game_state")
+	gs, err := protocol.ReadObject(msg, "game_state")
 	assert.NoError(t, err, "Cannot read 'game_state' in msg")
 	subCheckFlattenedObject(t, gs)
 
-	nestedObject1, err := netorcai.ReadObject(gs, "nested_object")
+	nestedObject1, err := protocol.ReadObject(gs, "nested_object")
 	assert.NoError(t, err, "Cannot read 'nested_object' in msg")
 	subCheckFlattenedObject(t, nestedObject1)
 
-	nestedArray2, err := netorcai.ReadArray(nestedObject1, "nested_array")
+	nestedArray2, err := protocol.ReadArray(nestedObject1, "nested_array")
 	assert.NoError(t, err, "Cannot read 'nested_array' field in obj")
 	assert.Equal(t, 1, len(nestedArray2),
 		"Unexpected length for 'nested_array' field in obj")
 	nestedArray2FirstElement := nestedArray2[0].(map[string]interface{})
 	subCheckFlattenedObject(t, nestedArray2FirstElement)
 
-	nestedArray1, err := netorcai.ReadArray(gs, "nested_array")
+	nestedArray1, err := protocol.ReadArray(gs, "nested_array")
 	assert.NoError(t, err, "Cannot read 'nested_array' field in obj")
 	assert.Equal(t, 1, len(nestedArray1),
 		"Unexpected length for 'nested_array' field in obj")
 	nestedArray1FirstElement := nestedArray1[0].(map[string]interface{})
 	subCheckFlattenedObject(t, nestedArray1FirstElement)
 
-	nestedObject2, err := netorcai.ReadObject(nestedArray1FirstElement,
+	nestedObject2, err := protocol.ReadObject(nestedArray1FirstElement,
 		"nested_object")
 	assert.NoError(t, err, "Cannot read 'nested_object' in msg")
 	subCheckFlattenedObject(t, nestedObject2)
@@ -412,7 +412,7 @@ func checkDoTurnFlattened(t *testing.T, msg map[string]interface{},
 		for _, pAction := range pActions {
 			pAsObj := pAction.(map[string]interface{})
 
-			actions, err := netorcai.ReadArray(pAsObj, "actions")
+			actions, err := protocol.ReadArray(pAsObj, "actions")
 			assert.NoError(t, err, "Cannot read 'actions' field in obj")
 			assert.Equal(t, 1, len(actions), "Unexpected 'actions' length")
 
@@ -420,18 +420,18 @@ func checkDoTurnFlattened(t *testing.T, msg map[string]interface{},
 			subCheckFlattenedObject(t, firstElement)
 
 			// Check player_id consistency
-			playerID, err := netorcai.ReadInt(pAsObj, "player_id")
+			playerID, err := protocol.ReadInt(pAsObj, "player_id")
 			assert.NoError(t, err, "Cannot read 'player_id' field in obj")
 
-			whoami, err := netorcai.ReadInt(firstElement, "whoami")
+			whoami, err := protocol.ReadInt(firstElement, "whoami")
 			assert.NoError(t, err, "Cannot read 'whoami' field in obj")
 			assert.Equal(t, playerID, whoami, "Unexpected 'whoami' value")
 
 			// Check turn_number consistency
-			turnNumber, err := netorcai.ReadInt(pAsObj, "turn_number")
+			turnNumber, err := protocol.ReadInt(pAsObj, "turn_number")
 			assert.NoError(t, err, "Cannot read 'turn_number' field in obj")
 
-			sentAtTurn, err := netorcai.ReadInt(firstElement, "sent_at_turn")
+			sentAtTurn, err := protocol.ReadInt(firstElement, "sent_at_turn")
 			assert.NoError(t, err, "Cannot read 'sent_at_turn' field in obj")
 			assert.Equal(t, turnNumber, sentAtTurn,
 				"Unexpected 'sent_at_turn' value")
@@ -452,7 +452,7 @@ func checkDoTurnNastyNested(t *testing.T, msg map[string]interface{},
 		for _, pAction := range pActions {
 			pAsObj := pAction.(map[string]interface{})
 
-			actions, err := netorcai.ReadArray(pAsObj, "actions")
+			actions, err := protocol.ReadArray(pAsObj, "actions")
 			assert.NoError(t, err, "Cannot read 'actions' field in obj")
 			assert.Equal(t, 1, len(actions), "Unexpected 'actions' length")
 
@@ -460,36 +460,36 @@ func checkDoTurnNastyNested(t *testing.T, msg map[string]interface{},
 			subCheckFlattenedObject(t, firstElement)
 
 			// Check player_id consistency
-			playerID, err := netorcai.ReadInt(pAsObj, "player_id")
+			playerID, err := protocol.ReadInt(pAsObj, "player_id")
 			assert.NoError(t, err, "Cannot read 'player_id' field in obj")
 
-			whoami, err := netorcai.ReadInt(firstElement, "whoami")
+			whoami, err := protocol.ReadInt(firstElement, "whoami")
 			assert.NoError(t, err, "Cannot read 'whoami' field in obj")
 			assert.Equal(t, playerID, whoami, "Unexpected 'whoami' value")
 
 			// Check turn_number consistency
-			turnNumber, err := netorcai.ReadInt(pAsObj, "turn_number")
+			turnNumber, err := protocol.ReadInt(pAsObj, "turn_number")
 			assert.NoError(t, err, "Cannot read 'turn_number' field in obj")
 
-			sentAtTurn, err := netorcai.ReadInt(firstElement, "sent_at_turn")
+			sentAtTurn, err := protocol.ReadInt(firstElement, "sent_at_turn")
 			assert.NoError(t, err, "Cannot read 'sent_at_turn' field in obj")
 			assert.Equal(t, turnNumber, sentAtTurn,
 				"Unexpected 'sent_at_turn' value")
 
 			// Nesting check
-			nestedObject1, err := netorcai.ReadObject(firstElement,
+			nestedObject1, err := protocol.ReadObject(firstElement,
 				"nested_object")
 			assert.NoError(t, err, "Cannot read 'nested_object' in msg")
 			subCheckFlattenedObject(t, nestedObject1)
 
-			nestedArray2, err := netorcai.ReadArray(nestedObject1, "nested_array")
+			nestedArray2, err := protocol.ReadArray(nestedObject1, "nested_array")
 			assert.NoError(t, err, "Cannot read 'nested_array' field in obj")
 			assert.Equal(t, 1, len(nestedArray2),
 				"Unexpected length for 'nested_array' field in obj")
 			nestedArray2FirstElement := nestedArray2[0].(map[string]interface{})
 			subCheckFlattenedObject(t, nestedArray2FirstElement)
 
-			nestedArray1, err := netorcai.ReadArray(firstElement,
+			nestedArray1, err := protocol.ReadArray(firstElement,
 				"nested_array")
 			assert.NoError(t, err, "Cannot read 'nested_array' field in obj")
 			assert.Equal(t, 1, len(nestedArray1),
@@ -497,7 +497,7 @@ func checkDoTurnNastyNested(t *testing.T, msg map[string]interface{},
 			nestedArray1FirstElement := nestedArray1[0].(map[string]interface{})
 			subCheckFlattenedObject(t, nestedArray1FirstElement)
 
-			nestedObject2, err := netorcai.ReadObject(nestedArray1FirstElement,
+			nestedObject2, err := protocol.ReadObject(nestedArray1FirstElement,
 				"nested_object")
 			assert.NoError(t, err, "Cannot read 'nested_object' in msg")
 			subCheckFlattenedObject(t, nestedObject2)
@@ -2,8 +2,8 @@ package test
 
 import (
 	"fmt"
-	"github.com/netorcai/netorcai"
 	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
 	"github.com/stretchr/testify/assert"
 	"regexp"
 	"testing"
@@ -143,7 +143,7 @@ func helloClient(t *testing.T, client *client.Client, clientName string,
 					clientName, turn, nbTurnsClient)
 				turnReceived := checkTurnPotentialTurnsSkipped(t, msg, nbPlayers, nbSpecialPlayers, turn, isPlayer)
 
-				messageType, _ := netorcai.ReadString(msg, "message_type")
+				messageType, _ := protocol.ReadString(msg, "message_type")
 
 				switch messageType {
 				case "TURN":
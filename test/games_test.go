@@ -0,0 +1,141 @@
+package test
+
+import (
+	"context"
+	"github.com/netorcai/netorcai"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestGamesSession(t *testing.T) {
+	config := netorcai.DefaultConfig()
+	config.NbPlayersMax = 2
+	config.NbVisusMax = 1
+	config.NbTurnsMax = 3
+	config.NbEpisodes = 2
+	config.NbGames = 2
+	config.Fast = true
+	config.Autostart = true
+	server := startEmbeddedServer(t, config)
+	defer server.Shutdown()
+
+	players := []*recordingPlayer{{}, {}, {}}
+	exits := []chan error{}
+	for index, player := range players {
+		role := "player"
+		if index == 2 {
+			role = "visualization"
+		}
+		exits = append(exits, runPlayerAsync(
+			connectEmbeddedServer(t, server), role, player))
+	}
+	glExit := runGameLogicAsync(connectEmbeddedServer(t, server),
+		&counterGameLogic{winnerPlayerID: 0})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exitCode, err := server.Wait(ctx)
+	assert.NoError(t, err, "Server did not stop")
+	assert.Equal(t, netorcai.EXIT_SUCCESS, exitCode, "Unexpected exit code")
+	assert.NoError(t, <-glExit, "RunGameLogic failed")
+
+	// Clients play both games without logging in again.
+	for index, player := range players {
+		assert.NoError(t, waitPlayerExit(t, exits[index], 1000),
+			"RunPlayer failed")
+		if !assert.Len(t, player.gameStarts, 4) ||
+			!assert.Len(t, player.gameEnds, 4) {
+			continue
+		}
+		for index := range player.gameStarts {
+			assert.Equal(t, index/2, player.gameStarts[index].Game)
+			assert.Equal(t, index%2, player.gameStarts[index].Episode)
+			assert.Equal(t, index/2, player.gameEnds[index].Game)
+			assert.Equal(t, index == 1, player.gameEnds[index].NextGame,
+				"Unexpected next_game in GAME_ENDS %v", index)
+		}
+	}
+}
+
+// startEmbeddedGame starts a game as soon as the server allows it.
+func startEmbeddedGame(t *testing.T, server *netorcai.Server) {
+	err := server.StartGame()
+	for retry := 0; err != nil && retry < 100; retry++ {
+		time.Sleep(10 * time.Millisecond)
+		err = server.StartGame()
+	}
+	assert.NoError(t, err, "Cannot start game")
+}
+
+func TestGamesSessionNewPlayer(t *testing.T) {
+	config := netorcai.DefaultConfig()
+	config.NbPlayersMax = 1
+	config.NbVisusMax = 0
+	config.NbTurnsMax = 3
+	config.NbGames = 2
+	config.Fast = true
+	server := startEmbeddedServer(t, config)
+	defer server.Shutdown()
+
+	glExit := runGameLogicAsync(connectEmbeddedServer(t, server),
+		&counterGameLogic{winnerPlayerID: 0})
+
+	// The first player leaves after the first game.
+	bot := loginEmbeddedServer(t, server, "player")
+	startEmbeddedGame(t, server)
+	leaving := &crashingPlayer{crashTurn: -1}
+	assert.NoError(t, leaving.run(bot), "First player failed")
+	assert.Equal(t, 1, leaving.gameEnds, "First game not played")
+	bot.Disconnect()
+
+	// Another player takes its slot once the first game is over.
+	var err error
+	for retry := 0; retry < 100; retry++ {
+		bot = connectEmbeddedServer(t, server)
+		err = bot.SendLogin("player", "bot", netorcai.Version)
+		if err == nil {
+			_, err = bot.ReadLoginAck()
+		}
+		if err == nil {
+			break
+		}
+		bot.Disconnect()
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, err, "Cannot log in between games")
+	startEmbeddedGame(t, server)
+
+	gameStarts, err := bot.ReadGameStarts()
+	assert.NoError(t, err, "Cannot read GAME_STARTS")
+	assert.Equal(t, 1, gameStarts.Game)
+	assert.Equal(t, 0, gameStarts.PlayerID)
+	for err == nil {
+		var msg map[string]interface{}
+		msg, err = bot.ReadMessage()
+		if err != nil {
+			break
+		}
+		if msg["message_type"] == "GAME_ENDS" {
+			var gameEnds protocol.MessageGameEnds
+			gameEnds, err = protocol.ReadGameEndsMessage(msg)
+			assert.Equal(t, 1, gameEnds.Game)
+			assert.False(t, gameEnds.NextGame, "Game after the last one")
+			break
+		}
+		var turn protocol.MessageTurn
+		turn, err = protocol.ReadTurnMessage(msg)
+		if err == nil {
+			err = bot.SendTurnAck(turn.TurnNumber, []interface{}{})
+		}
+	}
+	assert.NoError(t, err, "Second player failed")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exitCode, err := server.Wait(ctx)
+	assert.NoError(t, err, "Server did not stop")
+	assert.Equal(t, netorcai.EXIT_SUCCESS, exitCode, "Unexpected exit code")
+	assert.NoError(t, <-glExit, "RunGameLogic failed")
+}
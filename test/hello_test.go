@@ -2,7 +2,7 @@ package test
 
 import (
 	"fmt"
-	"github.com/netorcai/netorcai"
+	"github.com/netorcai/netorcai/protocol"
 	"github.com/stretchr/testify/assert"
 	"regexp"
 	"testing"
@@ -671,7 +671,7 @@ func doTurnAckWinner(turn int, actions []interface{}) string {
 func checkGameEndsWinner(t *testing.T, msg map[string]interface{}, clientName string) {
 	checkGameEnds(t, msg, clientName)
 
-	winner, err := netorcai.ReadInt(msg, "winner_player_id")
+	winner, err := protocol.ReadInt(msg, "winner_player_id")
 	assert.NoError(t, err, "Cannot read 'winner_player_id'")
 	assert.Equal(t, 0, winner, "Unexpected 'winner_player_id' value")
 }
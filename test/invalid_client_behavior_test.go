@@ -1,6 +1,7 @@
 package test
 
 import (
+	"github.com/netorcai/netorcai"
 	"github.com/netorcai/netorcai/client/go"
 	"github.com/stretchr/testify/assert"
 	"regexp"
@@ -21,7 +22,7 @@ func TestInvalidGlMessageBeforeStart(t *testing.T) {
 	assert.NoError(t, err,
 		"Cannot read `Game logic failed` in netorcai output")
 
-	_, expRetCode := handleCoverage(t, 1)
+	_, expRetCode := handleCoverage(t, netorcai.EXIT_GAME_LOGIC_KICKED)
 	retCode, err := waitCompletionTimeout(proc.completion, 1000)
 	assert.NoError(t, err, "netorcai did not complete")
 	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
@@ -82,14 +83,15 @@ func TestInvalidGlNoDoInitAck(t *testing.T) {
 	assert.NoError(t, err,
 		"Cannot read `Game logic failed` in netorcai output")
 
-	_, expRetCode := handleCoverage(t, 1)
+	_, expRetCode := handleCoverage(t, netorcai.EXIT_GAME_LOGIC_TIMEOUT)
 	retCode, err := waitCompletionTimeout(proc.completion, 1000)
 	assert.NoError(t, err, "netorcai did not complete")
 	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
 
-	checkAllKicked(t, playerClients, regexp.MustCompile(`netorcai abort`),
+	// Clients receive GAME_ENDS before being kicked
+	checkAllKicked(t, playerClients, regexp.MustCompile(`Game is finished`),
 		1000)
-	checkAllKicked(t, visuClients, regexp.MustCompile(`netorcai abort`), 1000)
+	checkAllKicked(t, visuClients, regexp.MustCompile(`Game is finished`), 1000)
 }
 
 func TestInvalidGlNoDoInitAckSocketClosed(t *testing.T) {
@@ -111,7 +113,7 @@ func TestInvalidGlNoDoInitAckSocketClosed(t *testing.T) {
 	assert.NoError(t, err,
 		"Cannot read `Game logic failed` in netorcai output")
 
-	_, expRetCode := handleCoverage(t, 1)
+	_, expRetCode := handleCoverage(t, netorcai.EXIT_GAME_LOGIC_KICKED)
 	retCode, err := waitCompletionTimeout(proc.completion, 1000)
 	assert.NoError(t, err, "netorcai did not complete")
 	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
@@ -147,7 +149,7 @@ func TestInvalidGlNoDoTurnAckSocketClosed(t *testing.T) {
 	assert.NoError(t, err,
 		"Cannot read `Game logic failed` in netorcai output")
 
-	_, expRetCode := handleCoverage(t, 1)
+	_, expRetCode := handleCoverage(t, netorcai.EXIT_GAME_LOGIC_KICKED)
 	retCode, err := waitCompletionTimeout(proc.completion, 1000)
 	assert.NoError(t, err, "netorcai did not complete")
 	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
@@ -183,7 +185,7 @@ func TestInvalidGlNoDoTurnAckSocketClosedFast(t *testing.T) {
 	assert.NoError(t, err,
 		"Cannot read `Game logic failed` in netorcai output")
 
-	_, expRetCode := handleCoverage(t, 1)
+	_, expRetCode := handleCoverage(t, netorcai.EXIT_GAME_LOGIC_KICKED)
 	retCode, err := waitCompletionTimeout(proc.completion, 1000)
 	assert.NoError(t, err, "netorcai did not complete")
 	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
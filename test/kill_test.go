@@ -2,6 +2,7 @@ package test
 
 import (
 	"fmt"
+	"github.com/netorcai/netorcai"
 	"github.com/netorcai/netorcai/client/go"
 	"github.com/stretchr/testify/assert"
 	"regexp"
@@ -19,7 +20,7 @@ func TestKickallOnAbortKillSigterm(t *testing.T) {
 
 	retCode, err := waitCompletionTimeout(proc.completion, 1000)
 	assert.NoError(t, err, "netorcai did not complete")
-	_, expRetCode := handleCoverage(t, 1)
+	_, expRetCode := handleCoverage(t, netorcai.EXIT_SIGNAL)
 	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
 }
 
@@ -33,7 +34,7 @@ func TestKickallOnAbortKillSigtermSpecial(t *testing.T) {
 
 	retCode, err := waitCompletionTimeout(proc.completion, 1000)
 	assert.NoError(t, err, "netorcai did not complete")
-	_, expRetCode := handleCoverage(t, 1)
+	_, expRetCode := handleCoverage(t, netorcai.EXIT_SIGNAL)
 	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
 }
 
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"fmt"
+	"github.com/netorcai/netorcai"
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func subtestListenLogin(t *testing.T, arguments []string,
+	network, address string) {
+	proc := runNetorcaiWaitListening(t, arguments)
+	defer killallNetorcaiSIGKILL()
+	checkListenLogin(t, proc, network, address)
+}
+
+func checkListenLogin(t *testing.T, proc *NetorcaiProcess,
+	network, address string) {
+	player := &client.Client{}
+	err := player.ConnectAddress(network, address)
+	assert.NoError(t, err, "Cannot connect")
+
+	err = player.SendLogin("player", "bot", netorcai.Version)
+	assert.NoError(t, err, "Cannot send LOGIN")
+
+	msg, err := waitReadMessage(player, 1000)
+	assert.NoError(t, err, "Cannot read client message (LOGIN_ACK)")
+	checkLoginAck(t, msg)
+
+	err = killNetorcaiGently(proc, 1000)
+	assert.NoError(t, err, "Netorcai could not be killed gently")
+}
+
+func TestListenTCP(t *testing.T) {
+	subtestListenLogin(t, []string{"--listen=tcp://127.0.0.1:5151"},
+		"tcp", "127.0.0.1:5151")
+}
+
+func TestListenUnix(t *testing.T) {
+	socketPath := filepath.Join(os.TempDir(),
+		fmt.Sprintf("netorcai-test-%v.sock", os.Getpid()))
+	defer os.Remove(socketPath)
+
+	subtestListenLogin(t, []string{"--listen=unix://" + socketPath},
+		"unix", socketPath)
+}
+
+func TestListenSeveralAddresses(t *testing.T) {
+	socketPath := filepath.Join(os.TempDir(),
+		fmt.Sprintf("netorcai-test-%v.sock", os.Getpid()))
+	defer os.Remove(socketPath)
+
+	subtestListenLogin(t, []string{"--listen=tcp://127.0.0.1:5151",
+		"--listen=unix://" + socketPath}, "unix", socketPath)
+}
+
+func TestListenUnixStaleSocket(t *testing.T) {
+	socketPath := filepath.Join(os.TempDir(),
+		fmt.Sprintf("netorcai-test-%v.sock", os.Getpid()))
+	defer os.Remove(socketPath)
+
+	// Leave a socket file behind, as a killed netorcai would.
+	listener, err := net.ListenUnix("unix",
+		&net.UnixAddr{Name: socketPath, Net: "unix"})
+	assert.NoError(t, err, "Cannot create socket")
+	listener.SetUnlinkOnClose(false)
+	listener.Close()
+
+	coverFile, _ := handleCoverage(t, 0)
+	proc, err := runNetorcaiCover(coverFile,
+		[]string{"--listen=unix://" + socketPath})
+	assert.NoError(t, err, "Cannot start netorcai")
+	defer killallNetorcaiSIGKILL()
+
+	_, err = waitOutputTimeout(regexp.MustCompile(
+		`Removing stale unix socket`), proc.outputControl, 1000, true)
+	assert.NoError(t, err, "Stale socket not removed")
+	_, err = waitListening(proc.outputControl, 1000)
+	assert.NoError(t, err, "Netorcai is not listening")
+	checkListenLogin(t, proc, "unix", socketPath)
+}
+
+func TestListenUnixSocketMode(t *testing.T) {
+	socketPath := filepath.Join(os.TempDir(),
+		fmt.Sprintf("netorcai-test-%v.sock", os.Getpid()))
+	defer os.Remove(socketPath)
+
+	runNetorcaiWaitListening(t, []string{"--listen=unix://" + socketPath,
+		"--unix-socket-mode=0600"})
+	defer killallNetorcaiSIGKILL()
+
+	info, err := os.Stat(socketPath)
+	assert.NoError(t, err, "Cannot stat socket")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(),
+		"Unexpected socket permissions")
+}
+
+func TestListenInvalidUnixSocketMode(t *testing.T) {
+	coverFile, expRetCode := handleCoverage(t, netorcai.EXIT_BAD_ARGUMENTS)
+
+	proc, err := runNetorcaiCover(coverFile,
+		[]string{"--listen=unix:///tmp/netorcai.sock",
+			"--unix-socket-mode=0999"})
+	assert.NoError(t, err, "Cannot start netorcai")
+	defer killallNetorcaiSIGKILL()
+
+	retCode, err := waitCompletionTimeout(proc.completion, 1000)
+	assert.NoError(t, err, "netorcai did not complete")
+	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
+}
+
+func subtestListenInvalid(t *testing.T, listenAddress string) {
+	args := []string{"--listen=" + listenAddress}
+	coverFile, expRetCode := handleCoverage(t, netorcai.EXIT_BAD_ARGUMENTS)
+
+	proc, err := runNetorcaiCover(coverFile, args)
+	assert.NoError(t, err, "Cannot start netorcai")
+	defer killallNetorcaiSIGKILL()
+
+	retCode, err := waitCompletionTimeout(proc.completion, 1000)
+	assert.NoError(t, err, "netorcai did not complete")
+	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
+}
+
+func TestListenInvalidNoNetwork(t *testing.T) {
+	subtestListenInvalid(t, "127.0.0.1:4242")
+}
+
+func TestListenInvalidNetwork(t *testing.T) {
+	subtestListenInvalid(t, "udp://127.0.0.1:4242")
+}
+
+func TestListenInvalidPort(t *testing.T) {
+	subtestListenInvalid(t, "tcp://127.0.0.1:70000")
+}
+
+func TestListenInvalidEmptyUnixPath(t *testing.T) {
+	subtestListenInvalid(t, "unix://")
+}
@@ -0,0 +1,77 @@
+package test
+
+import (
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+// movingPlayer records what it receives and moves up on every turn.
+type movingPlayer struct {
+	recordingPlayer
+}
+
+func (p *movingPlayer) OnTurn(turn protocol.MessageTurn) []interface{} {
+	p.recordingPlayer.OnTurn(turn)
+	return []interface{}{map[string]interface{}{"move": "up"}}
+}
+
+func TestMockGameLogic(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=1",
+		"--nb-visus-max=0", "--nb-turns-max=3", "--fast", "--autostart",
+		"--mock-game-logic", `--mock-game-state={"board": "empty"}`})
+	defer killallNetorcaiSIGKILL()
+
+	bot := &client.Client{}
+	err := bot.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+	player := &movingPlayer{}
+	playerExit := runPlayerAsync(bot, "player", player)
+
+	err = waitPlayerExit(t, playerExit, 3000)
+	assert.NoError(t, err, "RunPlayer failed")
+
+	assert.Len(t, player.gameStarts, 1, "Unexpected number of GAME_STARTS")
+	assert.Equal(t, map[string]interface{}{"board": "empty",
+		"turn_number": 0.0, "player_actions": []interface{}{}},
+		player.gameStarts[0].InitialGameState,
+		"Unexpected initial game state")
+
+	assert.Len(t, player.turns, 2, "Unexpected number of TURN")
+	for turnNumber, turn := range player.turns {
+		assert.Equal(t, "empty", turn.GameState["board"],
+			"Mock game state field missing")
+		assert.Equal(t, float64(turnNumber+1), turn.GameState["turn_number"],
+			"Unexpected turn_number in game state")
+	}
+
+	// The actions of the first TURN are echoed in the game state that ends
+	// the game.
+	assert.Len(t, player.gameEnds, 1, "Unexpected number of GAME_ENDS")
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"player_id": 0.0, "turn_number": 1.0,
+		"actions": []interface{}{map[string]interface{}{"move": "up"}}}},
+		player.gameEnds[0].GameState["player_actions"],
+		"Player actions not echoed")
+
+	exitCode, err := waitCompletionTimeout(proc.completion, 1000)
+	assert.NoError(t, err, "netorcai did not complete")
+	assert.Equal(t, 0, exitCode, "Unexpected exit code")
+}
+
+func TestMockGameLogicInvalidState(t *testing.T) {
+	coverFile, expectedExitCode := handleCoverage(t, 1)
+	proc, err := runNetorcaiCover(coverFile, []string{"--mock-game-logic",
+		"--mock-game-state=[]"})
+	assert.NoError(t, err, "Cannot start netorcai")
+	defer killallNetorcaiSIGKILL()
+
+	_, err = waitOutputTimeout(regexp.MustCompile(`--mock-game-state`),
+		proc.outputControl, 1000, false)
+	assert.NoError(t, err, "No error about --mock-game-state")
+	exitCode, err := waitCompletionTimeout(proc.completion, 1000)
+	assert.NoError(t, err, "netorcai did not complete")
+	assert.Equal(t, expectedExitCode, exitCode, "Unexpected exit code")
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"github.com/netorcai/netorcai"
 	"github.com/stretchr/testify/assert"
 	"regexp"
 	"testing"
@@ -9,7 +10,7 @@ import (
 
 func TestTwoInstancesSamePort(t *testing.T) {
 	args := []string{"--port=5151"}
-	coverFile, expectedExitCode2 := handleCoverage(t, 1)
+	coverFile, expectedExitCode2 := handleCoverage(t, netorcai.EXIT_LISTEN_FAILURE)
 
 	proc1, err := runNetorcaiCover("", args) // never covered
 	assert.NoError(t, err, "Cannot start netorcai")
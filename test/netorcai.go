@@ -7,6 +7,7 @@ import (
 	"io"
 	"os/exec"
 	"strings"
+	"syscall"
 )
 
 type NetorcaiProcess struct {
@@ -45,10 +46,11 @@ func runNetorcai(command string, arguments []string) (*NetorcaiProcess, error) {
 		return proc, fmt.Errorf("Cannot start process. %v", err)
 	}
 
+	outputRead := make(chan struct{})
 	go lineReader(bufio.NewReader(proc.stdoutPipe), proc.outputControl,
-		&proc.printOutput)
+		&proc.printOutput, outputRead)
 	go lineWriter(bufio.NewWriter(proc.stdinPipe), proc.inputControl)
-	go waitCompletion(proc.cmd, proc.completion)
+	go waitCompletion(proc.cmd, outputRead, proc.completion)
 	return proc, nil
 }
 
@@ -71,7 +73,15 @@ func runNetorcaiCover(coverFile string, arguments []string) (
 	}
 }
 
-func lineReader(reader *bufio.Reader, lineRead chan string, doPrint *bool) {
+// lineReader forwards the output lines of the process to lineRead, then
+// closes done once the output has been read to its end.
+// Lines are queued, so that the output is read even if nobody receives them.
+func lineReader(reader *bufio.Reader, lineRead chan string, doPrint *bool,
+	done chan struct{}) {
+	queue := make(chan string)
+	go forwardLines(queue, lineRead)
+	defer close(queue)
+	defer close(done)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
@@ -81,7 +91,31 @@ func lineReader(reader *bufio.Reader, lineRead chan string, doPrint *bool) {
 			if *doPrint {
 				fmt.Printf("Netorcai output: %v\n", line)
 			}
-			lineRead <- line
+			queue <- line
+		}
+	}
+}
+
+// forwardLines sends the lines received on input to output, in order,
+// without ever blocking input.
+func forwardLines(input chan string, output chan string) {
+	var pending []string
+	for input != nil || len(pending) > 0 {
+		var send chan string
+		var next string
+		if len(pending) > 0 {
+			send = output
+			next = pending[0]
+		}
+		select {
+		case line, ok := <-input:
+			if !ok {
+				input = nil
+				continue
+			}
+			pending = append(pending, line)
+		case send <- next:
+			pending = pending[1:]
 		}
 	}
 }
@@ -100,10 +134,22 @@ func lineWriter(writer *bufio.Writer, lineToWrite chan string) {
 	}
 }
 
-func waitCompletion(cmd *exec.Cmd, onCompletion chan int) {
+// waitCompletion waits for the process once its output has been read
+// (outputRead is closed), as cmd.Wait closes the output pipe.
+func waitCompletion(cmd *exec.Cmd, outputRead chan struct{},
+	onCompletion chan int) {
+	<-outputRead
 	err := cmd.Wait()
 	if err != nil {
+		// Forward the process exit code if it can be retrieved
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				onCompletion <- status.ExitStatus()
+				return
+			}
+		}
 		onCompletion <- 1
+		return
 	}
 	onCompletion <- 0
 }
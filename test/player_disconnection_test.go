@@ -1,11 +1,15 @@
 package test
 
 import (
+	"context"
 	"fmt"
+	"github.com/netorcai/netorcai"
 	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
 	"github.com/stretchr/testify/assert"
 	"regexp"
 	"testing"
+	"time"
 )
 
 type ClientDisconnectionTurnFunc func(int) int
@@ -106,3 +110,183 @@ func TestPlayerDisconnectionDuringGameFast(t *testing.T) {
 		ClientDisconnectionWhenTurnIsGreaterThanPlayerID,
 		ClientDisconnectionWhenTurnIsGreaterThanPlayerID)
 }
+
+// crashingPlayer plays until it receives TURN crashTurn, then disconnects
+// from netorcai without acknowledging it.
+// It plays the whole game if crashTurn is -1.
+type crashingPlayer struct {
+	crashTurn int
+	nbTurns   int
+	gameEnds  int
+}
+
+func loginEmbeddedServer(t *testing.T, server *netorcai.Server,
+	role string) *client.Client {
+	bot := connectEmbeddedServer(t, server)
+	err := bot.SendLogin(role, "bot", netorcai.Version)
+	assert.NoError(t, err, "Cannot send LOGIN")
+	_, err = bot.ReadLoginAck()
+	assert.NoError(t, err, "Cannot read LOGIN_ACK")
+	return bot
+}
+
+func (p *crashingPlayer) run(bot *client.Client) error {
+	_, err := bot.ReadGameStarts()
+	for err == nil {
+		var msg map[string]interface{}
+		msg, err = bot.ReadMessage()
+		if err != nil {
+			break
+		}
+		if msg["message_type"] == "GAME_ENDS" {
+			p.gameEnds++
+			return nil
+		}
+
+		var turn protocol.MessageTurn
+		turn, err = protocol.ReadTurnMessage(msg)
+		if err != nil {
+			break
+		}
+		p.nbTurns++
+		if turn.TurnNumber == p.crashTurn {
+			return bot.Disconnect()
+		}
+		err = bot.SendTurnAck(turn.TurnNumber, []interface{}{})
+	}
+	return err
+}
+
+// slowInitGameLogic leaves time to the players to crash before GAME_STARTS.
+type slowInitGameLogic struct {
+	counterGameLogic
+}
+
+func (gl *slowInitGameLogic) Init(nbPlayers, nbSpecialPlayers,
+	nbTurnsMax int) map[string]interface{} {
+	time.Sleep(200 * time.Millisecond)
+	return gl.counterGameLogic.Init(nbPlayers, nbSpecialPlayers, nbTurnsMax)
+}
+
+// subtestPlayerCrashesFast runs a fast game in which players crash at the
+// turns given by crashTurn. nbInitCrashes other players crash before
+// receiving GAME_STARTS.
+func subtestPlayerCrashesFast(t *testing.T, nbPlayers, nbSpecialPlayers,
+	nbInitCrashes int, crashTurn func(playerIndex int) int) {
+	config := netorcai.DefaultConfig()
+	config.NbPlayersMax = nbPlayers + nbInitCrashes
+	config.NbSpecialPlayersMax = nbSpecialPlayers
+	config.NbVisusMax = 1
+	config.NbTurnsMax = 10
+	config.Fast = true
+	server := startEmbeddedServer(t, config)
+	defer server.Shutdown()
+
+	initCrashers := []*client.Client{}
+	for index := 0; index < nbInitCrashes; index++ {
+		initCrashers = append(initCrashers,
+			loginEmbeddedServer(t, server, "player"))
+	}
+	players := []*crashingPlayer{}
+	exits := []chan error{}
+	for index := 0; index < nbPlayers+nbSpecialPlayers; index++ {
+		role := "player"
+		if index >= nbPlayers {
+			role = "special player"
+		}
+		bot := loginEmbeddedServer(t, server, role)
+		player := &crashingPlayer{crashTurn: crashTurn(index)}
+		players = append(players, player)
+		playerExit := make(chan error, 1)
+		go func() {
+			playerExit <- player.run(bot)
+		}()
+		exits = append(exits, playerExit)
+	}
+	visu := &recordingPlayer{}
+	visuExit := runPlayerAsync(connectEmbeddedServer(t, server),
+		"visualization", visu)
+	glExit := runGameLogicAsync(connectEmbeddedServer(t, server),
+		&slowInitGameLogic{counterGameLogic{winnerPlayerID: -1}})
+
+	err := server.StartGame()
+	for retry := 0; err != nil && retry < 100; retry++ {
+		time.Sleep(10 * time.Millisecond)
+		err = server.StartGame()
+	}
+	assert.NoError(t, err, "Cannot start game")
+	for _, bot := range initCrashers {
+		bot.Disconnect()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exitCode, err := server.Wait(ctx)
+	assert.NoError(t, err, "Server did not stop")
+	assert.Equal(t, netorcai.EXIT_SUCCESS, exitCode, "Unexpected exit code")
+	assert.NoError(t, <-glExit, "RunGameLogic failed")
+
+	nbCrashes := nbInitCrashes
+	for index, player := range players {
+		assert.NoError(t, waitPlayerExit(t, exits[index], 1000),
+			"Player failed")
+		if player.crashTurn == -1 {
+			assert.Equal(t, config.NbTurnsMax-1, player.nbTurns,
+				"Unexpected number of TURN")
+			assert.Equal(t, 1, player.gameEnds,
+				"Unexpected number of GAME_ENDS")
+		} else {
+			assert.Equal(t, player.crashTurn+1, player.nbTurns,
+				"Unexpected number of TURN")
+			nbCrashes++
+		}
+	}
+
+	// The visualization is told which players have left.
+	assert.NoError(t, waitPlayerExit(t, visuExit, 1000), "Visu failed")
+	assert.Len(t, visu.gameEnds, 1, "Unexpected number of GAME_ENDS")
+	if assert.NotEmpty(t, visu.turns, "Visu received no TURN") {
+		lastTurn := visu.turns[len(visu.turns)-1]
+		nbDisconnected := 0
+		for _, info := range lastTurn.PlayersInfo {
+			if !info.IsConnected {
+				nbDisconnected++
+			}
+		}
+		assert.Equal(t, nbCrashes, nbDisconnected,
+			"Unexpected number of disconnected players in TURN %v",
+			lastTurn.TurnNumber)
+	}
+}
+
+func TestPlayerCrashesFastSameTurn(t *testing.T) {
+	subtestPlayerCrashesFast(t, 32, 4, 0, func(playerIndex int) int {
+		if playerIndex%16 == 0 {
+			return -1
+		}
+		return 2
+	})
+}
+
+func TestPlayerCrashesFastAllPlayers(t *testing.T) {
+	subtestPlayerCrashesFast(t, 32, 4, 0, func(playerIndex int) int {
+		return 1
+	})
+}
+
+func TestPlayerCrashesFastBeforeGameStarts(t *testing.T) {
+	subtestPlayerCrashesFast(t, 4, 1, 8, func(playerIndex int) int {
+		return -1
+	})
+}
+
+func TestPlayerCrashesFastStress(t *testing.T) {
+	for iteration := 0; iteration < 10; iteration++ {
+		subtestPlayerCrashesFast(t, 16, 2, 4, func(playerIndex int) int {
+			if playerIndex%4 == 0 {
+				return -1
+			}
+			return playerIndex % 3
+		})
+	}
+}
@@ -217,6 +217,16 @@ func TestPromptDelayTurns(t *testing.T) {
 		49.999, 500, 10000.001)
 }
 
+func TestPromptGlInitTimeout(t *testing.T) {
+	subtestPromptFloatVariablePrintSet(t, "gl-init-timeout", "meh", 3000,
+		-0.001, 500, 3600000.001)
+}
+
+func TestPromptGlTurnTimeout(t *testing.T) {
+	subtestPromptFloatVariablePrintSet(t, "gl-turn-timeout", "meh", 0,
+		-0.001, 500, 3600000.001)
+}
+
 func TestPromptPrintAll(t *testing.T) {
 	proc := runNetorcaiWaitListening(t, []string{})
 	defer killallNetorcaiSIGKILL()
@@ -247,6 +257,14 @@ func TestPromptPrintAll(t *testing.T) {
 		proc.outputControl, 1000, true)
 	assert.NoError(t, err, "Cannot read print delay-turns")
 
+	_, err = waitOutputTimeout(regexp.MustCompile(`gl-init-timeout=3000`),
+		proc.outputControl, 1000, true)
+	assert.NoError(t, err, "Cannot read print gl-init-timeout")
+
+	_, err = waitOutputTimeout(regexp.MustCompile(`gl-turn-timeout=0`),
+		proc.outputControl, 1000, true)
+	assert.NoError(t, err, "Cannot read print gl-turn-timeout")
+
 	err = killNetorcaiGently(proc, 1000)
 	assert.NoError(t, err, "Netorcai could not be killed gently")
 }
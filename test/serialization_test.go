@@ -0,0 +1,105 @@
+package test
+
+import (
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestSerialization(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=2",
+		"--nb-visus-max=1", "--nb-turns-max=4", "--fast"})
+	defer killallNetorcaiSIGKILL()
+
+	// netorcai transcodes between MessagePack and JSON clients.
+	serializations := []string{"msgpack", "json", "msgpack"}
+	roles := []string{"player", "player", "visualization"}
+	players := []*recordingPlayer{}
+	clients := []gameClient{}
+	for index, serialization := range serializations {
+		bot := &client.Client{Serialization: serialization}
+		if index == 2 {
+			bot.Compression = []string{"gzip"}
+		}
+		// The same (heterogeneous) actions are sent on every turn.
+		players = append(players, &recordingPlayer{actions: []interface{}{
+			map[string]interface{}{"move": 1.5,
+				"path": []interface{}{"up", -3, nil, true}}}})
+		clients = append(clients, gameClient{role: roles[index], bot: bot,
+			player: players[index]})
+	}
+	glClient := &client.Client{Serialization: "msgpack"}
+	gameLogic := &counterGameLogic{winnerPlayerID: -1, boardSize: 1000}
+	err := playGame(t, proc, clients, glClient, gameLogic, 3000)
+	assert.NoError(t, err, "RunGameLogic failed")
+
+	for _, player := range players {
+		assert.Len(t, player.turns, 3, "Unexpected number of TURN")
+		for turnIndex, turn := range player.turns {
+			assert.Equal(t, float64(turnIndex+1), turn.GameState["turn"],
+				"Unexpected game state")
+			assert.Len(t, turn.GameState["cells"], 1000,
+				"Unexpected game state")
+		}
+		assert.Len(t, player.gameEnds, 1, "Unexpected number of GAME_ENDS")
+	}
+
+	// The actions of both players reach the game logic unchanged.
+	expectedActions := []interface{}{map[string]interface{}{"move": 1.5,
+		"path": []interface{}{"up", -3.0, nil, true}}}
+	for turnIndex, playerActions := range gameLogic.playerActions[1:] {
+		assert.Len(t, playerActions, 2, "Unexpected number of player actions")
+		for _, playerAction := range playerActions {
+			assert.Equal(t, turnIndex, playerAction.TurnNumber)
+			assert.Equal(t, expectedActions, playerAction.Actions,
+				"Unexpected actions")
+		}
+	}
+}
+
+func TestSerializationInvalid(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{})
+	defer killallNetorcaiSIGKILL()
+
+	bot := &client.Client{Serialization: "cbor"}
+	err := bot.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+	err = bot.SendLogin("player", "bot", protocol.Version)
+	assert.NoError(t, err, "Cannot send LOGIN")
+
+	_, err = waitOutputTimeout(regexp.MustCompile(`Invalid serialization`),
+		proc.outputControl, 1000, false)
+	assert.NoError(t, err, "Invalid serialization not detected")
+	_, err = bot.ReadLoginAck()
+	assert.Error(t, err, "LOGIN with invalid serialization accepted")
+}
+
+func TestSerializationInvalidMessagePack(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{})
+	defer killallNetorcaiSIGKILL()
+
+	bot := &client.Client{Serialization: "msgpack"}
+	err := bot.Connect("localhost", 4242)
+	assert.NoError(t, err, "Cannot connect")
+	err = bot.SendLogin("player", "bot", protocol.Version)
+	assert.NoError(t, err, "Cannot send LOGIN")
+	loginAck, err := bot.ReadLoginAck()
+	assert.NoError(t, err, "Cannot read LOGIN_ACK")
+	assert.Equal(t, "msgpack", loginAck.Serialization)
+
+	// JSON is no longer accepted once MessagePack has been negotiated.
+	err = bot.SendString(`{"message_type": "TURN_ACK"}`)
+	assert.NoError(t, err, "Cannot send JSON message")
+
+	_, err = waitOutputTimeout(regexp.MustCompile(
+		`Invalid MessagePack message received`), proc.outputControl, 1000,
+		false)
+	assert.NoError(t, err, "Invalid MessagePack message not detected")
+
+	// The KICK message is serialized in MessagePack.
+	msg, err := bot.ReadMessage()
+	assert.NoError(t, err, "Cannot read KICK")
+	assert.Equal(t, "KICK", msg["message_type"])
+}
@@ -0,0 +1,286 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/netorcai/netorcai"
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func startEmbeddedServer(t *testing.T, config netorcai.Config) *netorcai.Server {
+	config.ListenAddresses = []string{"tcp://127.0.0.1:0"}
+	server, err := netorcai.NewServer(config)
+	assert.NoError(t, err, "Cannot create server")
+
+	err = server.Start()
+	assert.NoError(t, err, "Cannot start server")
+	assert.NotEqual(t, 0, server.Port(), "No bound port")
+	return server
+}
+
+func connectEmbeddedServer(t *testing.T, server *netorcai.Server) *client.Client {
+	c := &client.Client{}
+	err := c.Connect("127.0.0.1", server.Port())
+	assert.NoError(t, err, "Cannot connect")
+	return c
+}
+
+func TestServerAPIGame(t *testing.T) {
+	config := netorcai.DefaultConfig()
+	config.NbPlayersMax = 1
+	config.NbVisusMax = 0
+	config.NbTurnsMax = 3
+	config.MillisecondsBeforeFirstTurn = 50
+	config.MillisecondsBetweenTurns = 50
+	config.Autostart = true
+	server := startEmbeddedServer(t, config)
+
+	player := &recordingPlayer{}
+	playerExit := runPlayerAsync(connectEmbeddedServer(t, server), "player",
+		player)
+	glExit := runGameLogicAsync(connectEmbeddedServer(t, server),
+		&counterGameLogic{winnerPlayerID: 0})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	exitCode, err := server.Wait(ctx)
+	assert.NoError(t, err, "Server did not stop")
+	assert.Equal(t, netorcai.EXIT_SUCCESS, exitCode, "Unexpected exit code")
+
+	assert.NoError(t, waitPlayerExit(t, playerExit, 1000), "RunPlayer failed")
+	assert.NoError(t, <-glExit, "RunGameLogic failed")
+	assert.Len(t, player.gameEnds, 1, "Unexpected number of GAME_ENDS")
+}
+
+func TestServerAPIStartGame(t *testing.T) {
+	config := netorcai.DefaultConfig()
+	config.MillisecondsBeforeFirstTurn = 50
+	server := startEmbeddedServer(t, config)
+	defer server.Shutdown()
+
+	err := server.StartGame()
+	assert.Error(t, err, "Game started without game logic")
+
+	gl := connectEmbeddedServer(t, server)
+	err = gl.SendLogin("game logic", "gl", netorcai.Version)
+	assert.NoError(t, err, "Cannot send LOGIN")
+	_, err = gl.ReadLoginAck()
+	assert.NoError(t, err, "Cannot read LOGIN_ACK")
+
+	err = server.StartGame()
+	assert.NoError(t, err, "Cannot start game")
+	_, err = gl.ReadDoInit()
+	assert.NoError(t, err, "Cannot read DO_INIT")
+
+	err = server.StartGame()
+	assert.Error(t, err, "Game started twice")
+}
+
+func TestServerAPIShutdown(t *testing.T) {
+	server := startEmbeddedServer(t, netorcai.DefaultConfig())
+
+	player := connectEmbeddedServer(t, server)
+	err := player.SendLogin("player", "player", netorcai.Version)
+	assert.NoError(t, err, "Cannot send LOGIN")
+	_, err = player.ReadLoginAck()
+	assert.NoError(t, err, "Cannot read LOGIN_ACK")
+
+	server.Shutdown()
+
+	msg, err := waitReadMessage(player, 1000)
+	assert.NoError(t, err, "Cannot read KICK")
+	checkKick(t, msg, "Player", regexp.MustCompile(`netorcai abort`))
+
+	exitCode, err := server.Wait(context.Background())
+	assert.NoError(t, err, "Server did not stop")
+	assert.Equal(t, netorcai.EXIT_SIGNAL, exitCode, "Unexpected exit code")
+
+	err = player.Connect("127.0.0.1", server.Port())
+	assert.Error(t, err, "Server still listening after Shutdown")
+}
+
+func TestServerAPIWaitCancelled(t *testing.T) {
+	server := startEmbeddedServer(t, netorcai.DefaultConfig())
+	defer server.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := server.Wait(ctx)
+	assert.Equal(t, context.Canceled, err, "Wait did not honor ctx")
+}
+
+func TestServerAPIInvalidConfig(t *testing.T) {
+	config := netorcai.DefaultConfig()
+	config.NbTurnsMax = 0
+	_, err := netorcai.NewServer(config)
+	assert.Error(t, err, "No error on invalid NbTurnsMax")
+
+	config = netorcai.DefaultConfig()
+	config.ListenAddresses = []string{"udp://:4242"}
+	_, err = netorcai.NewServer(config)
+	assert.Error(t, err, "No error on invalid listen address")
+}
+
+func TestServerAPIListenFailure(t *testing.T) {
+	server := startEmbeddedServer(t, netorcai.DefaultConfig())
+	defer server.Shutdown()
+
+	config := netorcai.DefaultConfig()
+	config.ListenAddresses = []string{
+		fmt.Sprintf("tcp://127.0.0.1:%v", server.Port())}
+	server2, err := netorcai.NewServer(config)
+	assert.NoError(t, err, "Cannot create server")
+
+	err = server2.Start()
+	assert.Error(t, err, "Two servers listen on the same port")
+}
+
+// startDrainedGame starts a game with one player and returns once the game
+// logic has received its second DO_TURN, which it has not answered yet.
+func startDrainedGame(t *testing.T, msDrainTimeout float64) (
+	server *netorcai.Server, player, gl *client.Client) {
+	config := netorcai.DefaultConfig()
+	config.NbPlayersMax = 1
+	config.NbVisusMax = 0
+	config.MillisecondsBeforeFirstTurn = 50
+	config.MillisecondsBetweenTurns = 50
+	config.MillisecondsDrainTimeout = msDrainTimeout
+	config.Autostart = true
+	server = startEmbeddedServer(t, config)
+
+	player = connectEmbeddedServer(t, server)
+	err := player.SendLogin("player", "player", netorcai.Version)
+	assert.NoError(t, err, "Cannot send LOGIN")
+	_, err = player.ReadLoginAck()
+	assert.NoError(t, err, "Cannot read LOGIN_ACK")
+
+	gl = connectEmbeddedServer(t, server)
+	err = gl.SendLogin("game logic", "gl", netorcai.Version)
+	assert.NoError(t, err, "Cannot send LOGIN")
+	_, err = gl.ReadLoginAck()
+	assert.NoError(t, err, "Cannot read LOGIN_ACK")
+
+	_, err = gl.ReadDoInit()
+	assert.NoError(t, err, "Cannot read DO_INIT")
+	err = gl.SendDoInitAck(nil)
+	assert.NoError(t, err, "Cannot send DO_INIT_ACK")
+
+	_, err = gl.ReadDoTurn(1)
+	assert.NoError(t, err, "Cannot read DO_TURN")
+	err = gl.SendDoTurnAck(map[string]interface{}{"turn": 1}, -1)
+	assert.NoError(t, err, "Cannot send DO_TURN_ACK")
+	_, err = gl.ReadDoTurn(1)
+	assert.NoError(t, err, "Cannot read DO_TURN")
+	return server, player, gl
+}
+
+// readUntilGameEnds skips the GAME_STARTS and TURN messages sent to a player
+// and returns the next message (GAME_ENDS or KICK).
+func readUntilGameEnds(t *testing.T, player *client.Client) (
+	map[string]interface{}, error) {
+	for {
+		msg, err := waitReadMessage(player, 1000)
+		if err != nil {
+			return nil, err
+		}
+		messageType, _ := msg["message_type"].(string)
+		if messageType != "GAME_STARTS" && messageType != "TURN" {
+			return msg, nil
+		}
+	}
+}
+
+func TestServerAPIShutdownDrain(t *testing.T) {
+	server, player, gl := startDrainedGame(t, 3000)
+
+	shutdownDone := make(chan int)
+	go func() {
+		server.Shutdown()
+		close(shutdownDone)
+	}()
+
+	// The current turn must be finished before the game is aborted.
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-shutdownDone:
+		assert.FailNow(t, "Shutdown did not wait for the current turn")
+	default:
+	}
+	err := gl.SendDoTurnAck(map[string]interface{}{"turn": 2}, -1)
+	assert.NoError(t, err, "Cannot send DO_TURN_ACK")
+
+	msg, err := waitReadMessage(gl, 1000)
+	assert.NoError(t, err, "Cannot read KICK")
+	checkKick(t, msg, "GL", regexp.MustCompile(`netorcai abort`))
+
+	msg, err = readUntilGameEnds(t, player)
+	assert.NoError(t, err, "Cannot read GAME_ENDS")
+	gameEnds, err := protocol.ReadGameEndsMessage(msg)
+	assert.NoError(t, err, "Invalid GAME_ENDS")
+	assert.Equal(t, "aborted", gameEnds.Status, "Unexpected status")
+	assert.Equal(t, -1, gameEnds.WinnerPlayerID, "Unexpected winner")
+	assert.Equal(t, map[string]interface{}{"turn": 2.0}, gameEnds.GameState,
+		"GAME_ENDS does not contain the last game state")
+
+	<-shutdownDone
+	exitCode, err := server.Wait(context.Background())
+	assert.NoError(t, err, "Server did not stop")
+	assert.Equal(t, netorcai.EXIT_SIGNAL, exitCode, "Unexpected exit code")
+}
+
+func TestServerAPIShutdownBeforeFirstTurn(t *testing.T) {
+	config := netorcai.DefaultConfig()
+	config.NbPlayersMax = 1
+	config.NbVisusMax = 0
+	config.MillisecondsBeforeFirstTurn = 10000
+	config.MillisecondsDrainTimeout = 3000
+	config.Autostart = true
+	server := startEmbeddedServer(t, config)
+
+	player := loginEmbeddedServer(t, server, "player")
+	gl := loginEmbeddedServer(t, server, "game logic")
+	_, err := gl.ReadDoInit()
+	assert.NoError(t, err, "Cannot read DO_INIT")
+	err = gl.SendDoInitAck(map[string]interface{}{"turn": 0})
+	assert.NoError(t, err, "Cannot send DO_INIT_ACK")
+	_, err = player.ReadGameStarts()
+	assert.NoError(t, err, "Cannot read GAME_STARTS")
+
+	// The game is aborted without waiting for the first turn.
+	server.Shutdown()
+	msg, err := readUntilGameEnds(t, player)
+	assert.NoError(t, err, "Cannot read GAME_ENDS")
+	gameEnds, err := protocol.ReadGameEndsMessage(msg)
+	assert.NoError(t, err, "Invalid GAME_ENDS")
+	assert.Equal(t, "aborted", gameEnds.Status, "Unexpected status")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	exitCode, err := server.Wait(ctx)
+	assert.NoError(t, err, "Server did not stop")
+	assert.Equal(t, netorcai.EXIT_SIGNAL, exitCode, "Unexpected exit code")
+}
+
+func TestServerAPIShutdownDrainTimeout(t *testing.T) {
+	server, player, gl := startDrainedGame(t, 200)
+
+	// The game logic never answers: clients are kicked after the timeout.
+	server.Shutdown()
+
+	msg, err := waitReadMessage(gl, 1000)
+	assert.NoError(t, err, "Cannot read KICK")
+	checkKick(t, msg, "GL", regexp.MustCompile(`netorcai abort`))
+
+	msg, err = readUntilGameEnds(t, player)
+	assert.NoError(t, err, "Cannot read KICK")
+	checkKick(t, msg, "Player", regexp.MustCompile(`netorcai abort`))
+
+	exitCode, err := server.Wait(context.Background())
+	assert.NoError(t, err, "Server did not stop")
+	assert.Equal(t, netorcai.EXIT_SIGNAL, exitCode, "Unexpected exit code")
+}
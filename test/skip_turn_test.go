@@ -2,7 +2,7 @@ package test
 
 import (
 	"fmt"
-	"github.com/netorcai/netorcai"
+	"github.com/netorcai/netorcai/protocol"
 	"github.com/stretchr/testify/assert"
 	"regexp"
 	"sort"
@@ -22,7 +22,7 @@ func turnAckSkipOneTurnOverTwo(turn, playerID int) string {
 func checkTurnSkipOneTurnOverTwo(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int, isPlayer bool) int {
 
-	turn, err := netorcai.ReadInt(msg, "turn_number")
+	turn, err := protocol.ReadInt(msg, "turn_number")
 	assert.NoError(t, err, "Cannot read 'turn_number'")
 	assert.Equal(t, 0, turn%2, "Unexpected turn_number parity")
 
@@ -32,7 +32,7 @@ func checkTurnSkipOneTurnOverTwo(t *testing.T, msg map[string]interface{},
 func checkDoTurnSkipOneTurnOverTwo(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int) []interface{} {
 
-	actions, err := netorcai.ReadArray(msg, "player_actions")
+	actions, err := protocol.ReadArray(msg, "player_actions")
 	assert.NoError(t, err, "Cannot read 'player_actions'")
 
 	expectedPActionsLength := 0
@@ -73,7 +73,7 @@ func turnAckSkipFirstTurn(turn, playerID int) string {
 func checkTurnSkipFirstTurn(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int, isPlayer bool) int {
 
-	turn, err := netorcai.ReadInt(msg, "turn_number")
+	turn, err := protocol.ReadInt(msg, "turn_number")
 	assert.NoError(t, err, "Cannot read 'turn_number'")
 
 	return turn
@@ -82,7 +82,7 @@ func checkTurnSkipFirstTurn(t *testing.T, msg map[string]interface{},
 func checkDoTurnSkipFirstTurn(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int) []interface{} {
 
-	actions, err := netorcai.ReadArray(msg, "player_actions")
+	actions, err := protocol.ReadArray(msg, "player_actions")
 	assert.NoError(t, err, "Cannot read 'player_actions'")
 
 	expectedPActionsLength := 1
@@ -126,7 +126,7 @@ func turnAckSkipOneTurnMultiClient(turn, playerID int) string {
 func checkTurnSkipOneTurnMultiClient(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int, isPlayer bool) int {
 
-	turn, err := netorcai.ReadInt(msg, "turn_number")
+	turn, err := protocol.ReadInt(msg, "turn_number")
 	assert.NoError(t, err, "Cannot read 'turn_number'")
 
 	return turn
@@ -134,26 +134,26 @@ func checkTurnSkipOneTurnMultiClient(t *testing.T, msg map[string]interface{},
 
 func subCheckPlayerActionsObject(t *testing.T, obj map[string]interface{},
 	expectedPlayerID, expectedTurn int) {
-	playerID, err := netorcai.ReadInt(obj, "player_id")
+	playerID, err := protocol.ReadInt(obj, "player_id")
 	assert.NoError(t, err, "Cannot read 'player_id'")
 	assert.Equal(t, expectedPlayerID, playerID, "Unexpected 'player_id' value")
 
-	turnNumber, err := netorcai.ReadInt(obj, "turn_number")
+	turnNumber, err := protocol.ReadInt(obj, "turn_number")
 	assert.NoError(t, err, "Cannot read 'turn_number'")
 	assert.Equal(t, expectedTurn, turnNumber,
 		"Unexpected 'turn_number' value")
 
-	actions, err := netorcai.ReadArray(obj, "actions")
+	actions, err := protocol.ReadArray(obj, "actions")
 	assert.NoError(t, err, "Cannot read 'actions'")
 	assert.Len(t, actions, 1, "Unexpected 'actions' length")
 
 	firstElement := actions[0].(map[string]interface{})
 
-	whoami, err := netorcai.ReadInt(firstElement, "whoami")
+	whoami, err := protocol.ReadInt(firstElement, "whoami")
 	assert.NoError(t, err, "Cannot read 'whoami'")
 	assert.Equal(t, expectedPlayerID, whoami, "Unexpected 'whoami' value")
 
-	sendAtTurn, err := netorcai.ReadInt(firstElement, "sent_at_turn")
+	sendAtTurn, err := protocol.ReadInt(firstElement, "sent_at_turn")
 	assert.NoError(t, err, "Cannot read 'sent_at_turn'")
 	assert.Equal(t, expectedTurn, sendAtTurn,
 		"Unexpected 'sent_at_turn' value")
@@ -172,7 +172,7 @@ func checkDoTurnSkipOneTurnMultiClient(t *testing.T,
 	msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int) []interface{} {
 
-	actions, err := netorcai.ReadArray(msg, "player_actions")
+	actions, err := protocol.ReadArray(msg, "player_actions")
 	assert.NoError(t, err, "Cannot read 'player_actions'")
 
 	sort.Sort(ByPlayerID(actions))
@@ -251,3 +251,73 @@ func TestSkipOneTurnMultiClient(t *testing.T) {
 		regexp.MustCompile(`Game is finished`),
 		regexp.MustCompile(`Game is finished`))
 }
+
+func runSlowPlayerGame(t *testing.T, arguments []string,
+	latency time.Duration) *recordingPlayer {
+	proc := runNetorcaiWaitListening(t, append([]string{
+		"--nb-players-max=1", "--nb-visus-max=0", "--nb-turns-max=10",
+		"--delay-first-turn=50", "--delay-turns=50"}, arguments...))
+	defer killallNetorcaiSIGKILL()
+
+	player := &recordingPlayer{latency: latency}
+	err := playGame(t, proc, []gameClient{{role: "player", player: player}},
+		nil, &counterGameLogic{winnerPlayerID: -1}, 10000)
+	assert.NoError(t, err, "RunGameLogic failed")
+
+	// skipped_turns always counts the TURNs between two received TURNs.
+	for index := 1; index < len(player.turns); index++ {
+		assert.Equal(t, player.turns[index].TurnNumber-
+			player.turns[index-1].TurnNumber-1,
+			player.turns[index].SkippedTurns,
+			"Unexpected skipped_turns")
+	}
+	return player
+}
+
+func TestSkipPolicyDropOld(t *testing.T) {
+	player := runSlowPlayerGame(t, []string{}, 200*time.Millisecond)
+	skippedTurns := 0
+	for _, turn := range player.turns {
+		skippedTurns += turn.SkippedTurns
+	}
+	assert.NotZero(t, skippedTurns, "No TURN was skipped")
+}
+
+func TestSkipPolicyQueue(t *testing.T) {
+	player := runSlowPlayerGame(t, []string{"--player-skip-policy=queue:10"},
+		100*time.Millisecond)
+	// The TURNs still queued when the game ends are not sent.
+	assert.NotEmpty(t, player.turns, "No TURN received")
+	for index, turn := range player.turns {
+		assert.Equal(t, index, turn.TurnNumber, "TURNs have been dropped")
+	}
+}
+
+func TestSkipPolicyBlock(t *testing.T) {
+	start := time.Now()
+	player := runSlowPlayerGame(t, []string{"--player-skip-policy=block"},
+		200*time.Millisecond)
+	assert.Len(t, player.turns, 9, "TURNs have been dropped")
+	assert.True(t, time.Since(start) >= 9*200*time.Millisecond,
+		"The game did not wait for the player")
+}
+
+func TestSkipPolicyBlockFastVisu(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=1",
+		"--nb-visus-max=1", "--nb-turns-max=5", "--fast",
+		"--visu-skip-policy=block"})
+	defer killallNetorcaiSIGKILL()
+
+	players := []*recordingPlayer{{}, {latency: 100 * time.Millisecond}}
+	err := playGame(t, proc, []gameClient{
+		{role: "player", player: players[0]},
+		{role: "visualization", player: players[1]},
+	}, nil, &counterGameLogic{winnerPlayerID: -1}, 3000)
+	assert.NoError(t, err, "RunGameLogic failed")
+
+	// The fast player does not make the slow visualization skip TURNs.
+	assert.Len(t, players[1].turns, 4, "The visualization skipped TURNs")
+	for _, turn := range players[1].turns {
+		assert.Equal(t, 0, turn.SkippedTurns, "Unexpected skipped_turns")
+	}
+}
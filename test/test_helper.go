@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/netorcai/netorcai"
 	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"os/exec"
@@ -258,7 +259,7 @@ func checkAllKicked(t *testing.T, clients []*client.Client,
 				msg, err := waitReadMessage(c, timeoutMS)
 				assert.NoError(t, err, "Cannot read client message (KICK)")
 
-				messageType, err := netorcai.ReadString(msg, "message_type")
+				messageType, err := protocol.ReadString(msg, "message_type")
 				if messageType == "KICK" {
 					checkKick(t, msg, "AnyClient", reasonMatcher)
 					kickChan <- 0
@@ -282,25 +283,25 @@ func checkAllKicked(t *testing.T, clients []*client.Client,
 
 func checkKick(t *testing.T, msg map[string]interface{}, clientName string,
 	reasonMatcher *regexp.Regexp) {
-	messageType, err := netorcai.ReadString(msg, "message_type")
+	messageType, err := protocol.ReadString(msg, "message_type")
 	assert.NoError(t, err,
 		"%v cannot read 'message_type' field in received client message (KICK)", clientName)
 	assert.Equal(t, "KICK", messageType, "Unexpected message type")
 
-	kickReason, err := netorcai.ReadString(msg, "kick_reason")
+	kickReason, err := protocol.ReadString(msg, "kick_reason")
 	assert.NoError(t, err, "%v cannot read 'kick_reason' in received client message (KICK)", clientName)
 	assert.Regexp(t, reasonMatcher, kickReason, "%v got kicked for unexpected reason", clientName)
 }
 
 func checkLoginAck(t *testing.T, msg map[string]interface{}) {
-	messageType, err := netorcai.ReadString(msg, "message_type")
+	messageType, err := protocol.ReadString(msg, "message_type")
 	assert.NoError(t, err, "Cannot read 'message_type' field in "+
 		"received client message (LOGIN_ACK)")
 
 	switch messageType {
 	case "LOGIN_ACK":
 	case "KICK":
-		kickReason, err := netorcai.ReadString(msg, "kick_reason")
+		kickReason, err := protocol.ReadString(msg, "kick_reason")
 		assert.NoError(t, err, "Cannot read kick_reason")
 
 		assert.FailNow(t, "Expected LOGIN_ACK, got KICK", kickReason)
@@ -312,28 +313,28 @@ func checkLoginAck(t *testing.T, msg map[string]interface{}) {
 
 func checkDoInit(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedNbTurnsMax int) {
-	messageType, err := netorcai.ReadString(msg, "message_type")
+	messageType, err := protocol.ReadString(msg, "message_type")
 	assert.NoError(t, err, "Cannot read 'message_type' field in "+
 		"received client message (DO_INIT)")
 
 	switch messageType {
 	case "DO_INIT":
-		nbPlayers, err := netorcai.ReadInt(msg, "nb_players")
+		nbPlayers, err := protocol.ReadInt(msg, "nb_players")
 		assert.NoError(t, err, "Cannot read nb_players")
 		assert.Equal(t, expectedNbPlayers, nbPlayers,
 			"Unexpected value for nb_players in received DO_INIT message")
 
-		nbSpecialPlayers, err := netorcai.ReadInt(msg, "nb_special_players")
+		nbSpecialPlayers, err := protocol.ReadInt(msg, "nb_special_players")
 		assert.NoError(t, err, "Cannot read nb_special_players")
 		assert.Equal(t, expectedNbSpecialPlayers, nbSpecialPlayers,
 			"Unexpected value for nb_special_players in received DO_INIT message")
 
-		nbTurnsMax, err := netorcai.ReadInt(msg, "nb_turns_max")
+		nbTurnsMax, err := protocol.ReadInt(msg, "nb_turns_max")
 		assert.NoError(t, err, "Cannot read nb_turns_max")
 		assert.Equal(t, expectedNbTurnsMax, nbTurnsMax,
 			"Unexpected value for nb_turns_max in received DO_INIT message")
 	case "KICK":
-		kickReason, err := netorcai.ReadString(msg, "kick_reason")
+		kickReason, err := protocol.ReadString(msg, "kick_reason")
 		assert.NoError(t, err, "Cannot read kick_reason")
 
 		assert.FailNow(t, "Expected DO_INIT, got KICK", kickReason)
@@ -345,13 +346,13 @@ func checkDoInit(t *testing.T, msg map[string]interface{},
 
 func checkDoTurn(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int) []interface{} {
-	messageType, err := netorcai.ReadString(msg, "message_type")
+	messageType, err := protocol.ReadString(msg, "message_type")
 	assert.NoError(t, err, "Cannot read 'message_type' field in "+
 		"received client message (DO_TURN)")
 
 	switch messageType {
 	case "DO_TURN":
-		playerActions, err := netorcai.ReadArray(msg, "player_actions")
+		playerActions, err := protocol.ReadArray(msg, "player_actions")
 		assert.NoError(t, err, "Cannot read player_actions in DO_TURN message")
 		assert.Condition(t, func() bool {
 			return len(playerActions) <= expectedNbPlayers+expectedNbSpecialPlayers
@@ -362,7 +363,7 @@ func checkDoTurn(t *testing.T, msg map[string]interface{},
 		for playerIndex, pActions := range playerActions {
 			obj := pActions.(map[string]interface{})
 
-			playerID, err := netorcai.ReadInt(obj, "player_id")
+			playerID, err := protocol.ReadInt(obj, "player_id")
 			assert.NoError(t, err, "Invalid player_actions in DO_TURN "+
 				"message: Cannot read player_id in array element %v",
 				playerIndex)
@@ -372,7 +373,7 @@ func checkDoTurn(t *testing.T, msg map[string]interface{},
 				"message: Should be in [0,%v[",
 				playerID, playerIndex, expectedNbPlayers+expectedNbSpecialPlayers)
 
-			turnNumber, err := netorcai.ReadInt(obj, "turn_number")
+			turnNumber, err := protocol.ReadInt(obj, "turn_number")
 			assert.NoError(t, err, "Invalid player_actions in DO_TURN "+
 				"message: Cannot read turn_number in array element %v",
 				playerIndex)
@@ -380,7 +381,7 @@ func checkDoTurn(t *testing.T, msg map[string]interface{},
 				"Unexpected turn_number in DO_TURN player action %v",
 				playerIndex)
 
-			_, err = netorcai.ReadArray(obj, "actions")
+			_, err = protocol.ReadArray(obj, "actions")
 			assert.NoError(t, err, "Invalid player_actions in DO_TURN "+
 				"message: Cannot read the actions array in player action %v",
 				playerIndex)
@@ -388,7 +389,7 @@ func checkDoTurn(t *testing.T, msg map[string]interface{},
 			return playerActions
 		}
 	case "KICK":
-		kickReason, err := netorcai.ReadString(msg, "kick_reason")
+		kickReason, err := protocol.ReadString(msg, "kick_reason")
 		assert.NoError(t, err, "Cannot read kick_reason")
 
 		assert.FailNow(t, "Expected DO_TURN, got KICK", kickReason)
@@ -402,7 +403,7 @@ func checkDoTurn(t *testing.T, msg map[string]interface{},
 
 func checkPlayersInfo(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers int, isPlayer bool) {
-	playersInfo, err := netorcai.ReadArray(msg, "players_info")
+	playersInfo, err := protocol.ReadArray(msg, "players_info")
 	assert.NoError(t, err, "Cannot read players_info in GAME_STARTS")
 	if isPlayer {
 		assert.Equal(t, 0, len(playersInfo),
@@ -415,18 +416,18 @@ func checkPlayersInfo(t *testing.T, msg map[string]interface{},
 		for playerIndex, player := range playersInfo {
 			obj := player.(map[string]interface{})
 
-			pid, err := netorcai.ReadInt(obj, "player_id")
+			pid, err := protocol.ReadInt(obj, "player_id")
 			assert.NoError(t, err, "Cannot read player_id in "+
 				"players_info[%v] of GAME_STARTS message (as a visu)",
 				playerIndex)
 			playerIDs = append(playerIDs, pid)
 
-			_, err = netorcai.ReadString(obj, "nickname")
+			_, err = protocol.ReadString(obj, "nickname")
 			assert.NoError(t, err, "Cannot read nickname in "+
 				"players_info[%v] of GAME_STARTS message (as a visu)",
 				playerIndex)
 
-			_, err = netorcai.ReadString(obj, "remote_address")
+			_, err = protocol.ReadString(obj, "remote_address")
 			assert.NoError(t, err, "Cannot read remote_address in "+
 				"players_info[%v] of GAME_STARTS message (as a visu)",
 				playerIndex)
@@ -450,28 +451,28 @@ func checkGameStarts(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedNbTurnsMax int,
 	expectedMsBeforeFirstTurn, expectedMsBetweenTurns float64,
 	isPlayer bool) (playerID int) {
-	messageType, err := netorcai.ReadString(msg, "message_type")
+	messageType, err := protocol.ReadString(msg, "message_type")
 	assert.NoError(t, err, "Cannot read 'message_type' field in "+
 		"received client message (GAME_STARTS)")
 
 	switch messageType {
 	case "GAME_STARTS":
-		nbPlayers, err := netorcai.ReadInt(msg, "nb_players")
+		nbPlayers, err := protocol.ReadInt(msg, "nb_players")
 		assert.NoError(t, err, "Cannot read nb_players in GAME_STARTS")
 		assert.Equal(t, expectedNbPlayers, nbPlayers,
 			"Unexpected value for nb_players in received GAME_STARTS message")
 
-		nbSpecialPlayers, err := netorcai.ReadInt(msg, "nb_special_players")
+		nbSpecialPlayers, err := protocol.ReadInt(msg, "nb_special_players")
 		assert.NoError(t, err, "Cannot read nb_special_players in GAME_STARTS")
 		assert.Equal(t, expectedNbSpecialPlayers, nbSpecialPlayers,
 			"Unexpected value for nb_special_players in received GAME_STARTS message")
 
-		nbTurnsMax, err := netorcai.ReadInt(msg, "nb_turns_max")
+		nbTurnsMax, err := protocol.ReadInt(msg, "nb_turns_max")
 		assert.NoError(t, err, "Cannot read nb_turns_max")
 		assert.Equal(t, expectedNbTurnsMax, nbTurnsMax,
 			"Unexpected value for nb_turns_max in GAME_STARTS message")
 
-		playerID, err := netorcai.ReadInt(msg, "player_id")
+		playerID, err := protocol.ReadInt(msg, "player_id")
 		assert.NoError(t, err, "Cannot read player_id in GAME_STARTS")
 		if isPlayer {
 			assert.Condition(t, func() bool {
@@ -504,7 +505,7 @@ func checkGameStarts(t *testing.T, msg map[string]interface{},
 		checkPlayersInfo(t, msg, expectedNbPlayers, expectedNbSpecialPlayers, isPlayer)
 		return playerID
 	case "KICK":
-		kickReason, err := netorcai.ReadString(msg, "kick_reason")
+		kickReason, err := protocol.ReadString(msg, "kick_reason")
 		assert.NoError(t, err, "Cannot read kick_reason")
 
 		assert.FailNow(t, "Expected GAME_STARTS, got KICK", kickReason)
@@ -517,24 +518,24 @@ func checkGameStarts(t *testing.T, msg map[string]interface{},
 
 func checkTurn(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int, isPlayer bool) int {
-	messageType, err := netorcai.ReadString(msg, "message_type")
+	messageType, err := protocol.ReadString(msg, "message_type")
 	assert.NoError(t, err, "Cannot read 'message_type' field in "+
 		"received client message (TURN)")
 
 	switch messageType {
 	case "TURN":
-		turnNumber, err := netorcai.ReadInt(msg, "turn_number")
+		turnNumber, err := protocol.ReadInt(msg, "turn_number")
 		assert.NoError(t, err, "Cannot read turn_number in TURN")
 		assert.Equal(t, expectedTurnNumber, turnNumber,
 			"Unexpected value for turn_number in received TURN message")
 
-		_, err = netorcai.ReadObject(msg, "game_state")
+		_, err = protocol.ReadObject(msg, "game_state")
 		assert.NoError(t, err, "Cannot read game_state in TURN")
 
 		checkPlayersInfo(t, msg, expectedNbPlayers, expectedNbSpecialPlayers, isPlayer)
 		return turnNumber
 	case "KICK":
-		kickReason, err := netorcai.ReadString(msg, "kick_reason")
+		kickReason, err := protocol.ReadString(msg, "kick_reason")
 		assert.NoError(t, err, "Cannot read kick_reason")
 
 		assert.FailNow(t, "Expected TURN, got KICK", kickReason)
@@ -547,31 +548,31 @@ func checkTurn(t *testing.T, msg map[string]interface{},
 
 func checkTurnPotentialTurnsSkipped(t *testing.T, msg map[string]interface{},
 	expectedNbPlayers, expectedNbSpecialPlayers, expectedMinimalTurnNumber int, isPlayer bool) int {
-	messageType, err := netorcai.ReadString(msg, "message_type")
+	messageType, err := protocol.ReadString(msg, "message_type")
 	assert.NoError(t, err, "Cannot read 'message_type' field in "+
 		"received client message (TURN or GAME_ENDS)")
 
 	switch messageType {
 	case "TURN":
-		turnNumber, err := netorcai.ReadInt(msg, "turn_number")
+		turnNumber, err := protocol.ReadInt(msg, "turn_number")
 		assert.NoError(t, err, "Cannot read turn_number in TURN")
 		assert.Condition(t, func() bool {
 			return turnNumber >= expectedMinimalTurnNumber
 		})
 
-		_, err = netorcai.ReadObject(msg, "game_state")
+		_, err = protocol.ReadObject(msg, "game_state")
 		assert.NoError(t, err, "Cannot read game_state in TURN")
 
 		checkPlayersInfo(t, msg, expectedNbPlayers, expectedNbSpecialPlayers, isPlayer)
 		return turnNumber
 	case "GAME_ENDS":
-		_, err := netorcai.ReadInt(msg, "winner_player_id")
+		_, err := protocol.ReadInt(msg, "winner_player_id")
 		assert.NoError(t, err, "Cannot read winner_player_id in GAME_ENDS")
 
-		_, err = netorcai.ReadObject(msg, "game_state")
+		_, err = protocol.ReadObject(msg, "game_state")
 		assert.NoError(t, err, "Cannot read game_state in GAME_ENDS")
 	case "KICK":
-		kickReason, err := netorcai.ReadString(msg, "kick_reason")
+		kickReason, err := protocol.ReadString(msg, "kick_reason")
 		assert.NoError(t, err, "Cannot read kick_reason")
 
 		assert.FailNow(t, "Expected (TURN or GAME_ENDS), got KICK", kickReason)
@@ -583,19 +584,19 @@ func checkTurnPotentialTurnsSkipped(t *testing.T, msg map[string]interface{},
 }
 
 func checkGameEnds(t *testing.T, msg map[string]interface{}, clientName string) {
-	messageType, err := netorcai.ReadString(msg, "message_type")
+	messageType, err := protocol.ReadString(msg, "message_type")
 	assert.NoError(t, err,
 		"%v cannot read 'message_type' field in received message (GAME_ENDS)", clientName)
 
 	switch messageType {
 	case "GAME_ENDS":
-		_, err := netorcai.ReadInt(msg, "winner_player_id")
+		_, err := protocol.ReadInt(msg, "winner_player_id")
 		assert.NoError(t, err, "%v cannot read winner_player_id in GAME_ENDS", clientName)
 
-		_, err = netorcai.ReadObject(msg, "game_state")
+		_, err = protocol.ReadObject(msg, "game_state")
 		assert.NoError(t, err, "%v cannot read game_state in GAME_ENDS", clientName)
 	case "KICK":
-		kickReason, err := netorcai.ReadString(msg, "kick_reason")
+		kickReason, err := protocol.ReadString(msg, "kick_reason")
 		assert.NoError(t, err, "%v cannot read kick_reason", clientName)
 
 		assert.FailNow(t, fmt.Sprintf("%v expected GAME_ENDS, got KICK for reason '%v'", clientName, kickReason))
@@ -0,0 +1,124 @@
+package test
+
+import (
+	"fmt"
+	"github.com/netorcai/netorcai"
+	"github.com/netorcai/netorcai/client/go"
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func checkGameEndsStatus(t *testing.T, msg map[string]interface{},
+	clientName, expectedStatus string) {
+	checkGameEnds(t, msg, clientName)
+
+	status, err := protocol.ReadString(msg, "status")
+	assert.NoError(t, err, "%v cannot read status in GAME_ENDS", clientName)
+	assert.Equal(t, expectedStatus, status,
+		"%v received GAME_ENDS with unexpected status", clientName)
+}
+
+// Reads client messages until GAME_ENDS is received
+func waitGameEndsStatus(t *testing.T, client *client.Client,
+	clientName, expectedStatus string, timeoutMS int) {
+	for {
+		msg, err := waitReadMessage(client, timeoutMS)
+		assert.NoError(t, err, "%v could not read message (GAME_ENDS)",
+			clientName)
+		if err != nil {
+			return
+		}
+
+		messageType, _ := protocol.ReadString(msg, "message_type")
+		if messageType != "TURN" && messageType != "GAME_STARTS" {
+			checkGameEndsStatus(t, msg, clientName, expectedStatus)
+			return
+		}
+	}
+}
+
+func subtestGlTimeout(t *testing.T, arguments []string, nbTurnsGL int,
+	glKickReasonMatcher *regexp.Regexp) {
+	proc, _, players, _, visus, gl := runNetorcaiAndClients(t,
+		append([]string{"--delay-first-turn=50", "--delay-turns=50",
+			"--nb-players-max=2", "--nb-visus-max=1", "--autostart"},
+			arguments...), 1000, 2, 0, 1)
+	defer killallNetorcaiSIGKILL()
+
+	go func(glClient *client.Client) {
+		msg, err := waitReadMessage(glClient, 1000)
+		assert.NoError(t, err, "Could not read GLClient message (DO_INIT)")
+		checkDoInit(t, msg, 2, 0, 100)
+
+		if nbTurnsGL >= 0 {
+			err = glClient.SendString(DefaultHelloGLDoInitAck(2, 0, 100))
+			assert.NoError(t, err, "GLClient could not send DO_INIT_ACK")
+
+			for turn := 0; turn < nbTurnsGL; turn++ {
+				msg, err = waitReadMessage(glClient, 1000)
+				assert.NoError(t, err, "Could not read GLClient message (DO_TURN)")
+				err = glClient.SendString(DefaultHelloGlDoTurnAck(turn, nil))
+				assert.NoError(t, err, "GLClient could not send DO_TURN_ACK")
+			}
+		}
+
+		// Stop answering on purpose
+		for {
+			msg, err = waitReadMessage(glClient, 2000)
+			assert.NoError(t, err, "Could not read GLClient message (KICK)")
+			if err != nil {
+				return
+			}
+
+			messageType, _ := protocol.ReadString(msg, "message_type")
+			if messageType == "KICK" {
+				checkKick(t, msg, "GameLogic", glKickReasonMatcher)
+				return
+			}
+		}
+	}(gl[0])
+
+	// Players and visus do not acknowledge turns (they can be skipped)
+	for _, player := range players {
+		waitGameEndsStatus(t, player, "Player", "game logic timeout", 2000)
+	}
+	for _, visu := range visus {
+		waitGameEndsStatus(t, visu, "Visu", "game logic timeout", 2000)
+	}
+
+	checkAllKicked(t, players, regexp.MustCompile(`Game is finished`), 1000)
+	checkAllKicked(t, visus, regexp.MustCompile(`Game is finished`), 1000)
+
+	// The timeout has its own exit code, not the generic failure one.
+	_, err := waitOutputTimeout(regexp.MustCompile(fmt.Sprintf(
+		`exit code=%v exit reason="game logic timeout"`,
+		netorcai.EXIT_GAME_LOGIC_TIMEOUT)), proc.outputControl, 1000, false)
+	assert.NoError(t, err, "Cannot read exit reason in netorcai output")
+
+	_, expRetCode := handleCoverage(t, netorcai.EXIT_GAME_LOGIC_TIMEOUT)
+	retCode, err := waitCompletionTimeout(proc.completion, 1000)
+	assert.NoError(t, err, "netorcai did not complete")
+	assert.Equal(t, expRetCode, retCode, "Unexpected netorcai return code")
+}
+
+func TestGlInitTimeout(t *testing.T) {
+	subtestGlTimeout(t, []string{"--gl-init-timeout=500"}, -1,
+		regexp.MustCompile(`Did not receive DO_INIT_ACK after 0.5 seconds`))
+}
+
+func TestGlTurnTimeoutFirstTurn(t *testing.T) {
+	subtestGlTimeout(t, []string{"--gl-turn-timeout=500"}, 0,
+		regexp.MustCompile(`Did not receive DO_TURN_ACK after 0.5 seconds`))
+}
+
+func TestGlTurnTimeout(t *testing.T) {
+	subtestGlTimeout(t, []string{"--gl-turn-timeout=500"}, 3,
+		regexp.MustCompile(`Did not receive DO_TURN_ACK after 0.5 seconds`))
+}
+
+func TestGlTurnTimeoutFast(t *testing.T) {
+	subtestGlTimeout(t, []string{"--gl-turn-timeout=500", "--fast"}, 0,
+		regexp.MustCompile(`Did not receive DO_TURN_ACK after 0.5 seconds`))
+}
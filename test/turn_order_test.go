@@ -0,0 +1,191 @@
+package test
+
+import (
+	"github.com/netorcai/netorcai/protocol"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func checkTurnActionableField(t *testing.T, msg map[string]interface{},
+	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int, isPlayer bool) int {
+	turn := checkTurn(t, msg, expectedNbPlayers, expectedNbSpecialPlayers,
+		expectedTurnNumber, isPlayer)
+
+	actionable, err := readBool(msg, "actionable")
+	assert.NoError(t, err, "Cannot read 'actionable' in TURN")
+	if !isPlayer {
+		assert.False(t, actionable, "TURN should never be actionable for visus")
+	}
+
+	return turn
+}
+
+func checkDoTurnRoundRobin(t *testing.T, msg map[string]interface{},
+	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int) []interface{} {
+	actions, err := protocol.ReadArray(msg, "player_actions")
+	assert.NoError(t, err, "Cannot read 'player_actions'")
+
+	if expectedTurnNumber < 0 {
+		assert.Equal(t, 0, len(actions),
+			"Unexpected array length for 'player_actions'")
+		return actions
+	}
+
+	// Only the player whose turn it is should have played
+	assert.Equal(t, 1, len(actions),
+		"Unexpected array length for 'player_actions'. turn=%v",
+		expectedTurnNumber)
+	if len(actions) == 1 {
+		playerID, err := protocol.ReadInt(actions[0].(map[string]interface{}),
+			"player_id")
+		assert.NoError(t, err, "Cannot read 'player_id' in player action")
+		assert.Equal(t,
+			expectedTurnNumber%(expectedNbPlayers+expectedNbSpecialPlayers),
+			playerID, "Unexpected player in round-robin turn order")
+	}
+	return actions
+}
+
+func TestTurnOrderSimultaneousActionable(t *testing.T) {
+	subtestHelloGlActiveClients(t, []string{"--turn-order=simultaneous"},
+		2, 0, 1,
+		3, 3, 3, 3,
+		0, 0,
+		false, false,
+		DefaultHelloClientCheckGameStarts, checkTurnActionableField, checkTurnActionableField,
+		DefaultHelloClientCheckGameEnds, DefaultHelloGLCheckDoTurn,
+		DefaultHelloGLDoInitAck, DefaultHelloGlDoTurnAck,
+		DefaultHelloClientTurnAck, DefaultHelloClientTurnAck,
+		regexp.MustCompile(`Game is finished`),
+		regexp.MustCompile(`Game is finished`),
+		regexp.MustCompile(`Game is finished`))
+}
+
+func TestTurnOrderRoundRobinFast(t *testing.T) {
+	subtestHelloGlActiveClients(t, []string{"--fast", "--turn-order=round-robin"},
+		3, 0, 1,
+		10, 10, 10, 10,
+		0, 0,
+		false, true,
+		DefaultHelloClientCheckGameStarts, checkTurnActionableField, DefaultHelloClientCheckTurn,
+		DefaultHelloClientCheckGameEnds, checkDoTurnRoundRobin,
+		DefaultHelloGLDoInitAck, DefaultHelloGlDoTurnAck,
+		DefaultHelloClientTurnAck, DefaultHelloClientTurnAck,
+		regexp.MustCompile(`Game is finished`),
+		regexp.MustCompile(`Game is finished`),
+		regexp.MustCompile(`Game is finished`))
+}
+
+func TestTurnOrderRoundRobinSpecialFast(t *testing.T) {
+	subtestHelloGlActiveClients(t, []string{"--fast", "--turn-order=round-robin"},
+		2, 1, 0,
+		10, 10, 10, 10,
+		0, 0,
+		false, true,
+		DefaultHelloClientCheckGameStarts, checkTurnActionableField, DefaultHelloClientCheckTurn,
+		DefaultHelloClientCheckGameEnds, checkDoTurnRoundRobin,
+		DefaultHelloGLDoInitAck, DefaultHelloGlDoTurnAck,
+		DefaultHelloClientTurnAck, DefaultHelloClientTurnAck,
+		regexp.MustCompile(`Game is finished`),
+		regexp.MustCompile(`Game is finished`),
+		regexp.MustCompile(`Game is finished`))
+}
+
+// Active players chosen by the game logic
+func doTurnAckOnlyFirstPlayerActive(turn int, actions []interface{}) string {
+	return `{"message_type":"DO_TURN_ACK",
+		"winner_player_id":-1,
+		"active_players":[0],
+		"game_state":{"all_clients":{}}}`
+}
+
+func checkDoTurnOnlyFirstPlayerActive(t *testing.T, msg map[string]interface{},
+	expectedNbPlayers, expectedNbSpecialPlayers, expectedTurnNumber int) []interface{} {
+	actions, err := protocol.ReadArray(msg, "player_actions")
+	assert.NoError(t, err, "Cannot read 'player_actions'")
+
+	expectedPActionsLength := 1
+	if expectedTurnNumber < 0 {
+		expectedPActionsLength = 0
+	}
+
+	assert.Equal(t, expectedPActionsLength, len(actions),
+		"Unexpected array length for 'player_actions'. turn=%v",
+		expectedTurnNumber)
+	for _, action := range actions {
+		playerID, err := protocol.ReadInt(action.(map[string]interface{}),
+			"player_id")
+		assert.NoError(t, err, "Cannot read 'player_id' in player action")
+		assert.Equal(t, 0, playerID, "Action received from a non-active player")
+	}
+	return actions
+}
+
+func TestActivePlayersFromGameLogicFast(t *testing.T) {
+	subtestHelloGlActiveClients(t, []string{"--fast"},
+		2, 1, 1,
+		10, 10, 10, 10,
+		0, 0,
+		false, true,
+		DefaultHelloClientCheckGameStarts, checkTurnActionableField, checkTurnActionableField,
+		DefaultHelloClientCheckGameEnds, checkDoTurnOnlyFirstPlayerActive,
+		DefaultHelloGLDoInitAck, doTurnAckOnlyFirstPlayerActive,
+		DefaultHelloClientTurnAck, DefaultHelloClientTurnAck,
+		regexp.MustCompile(`Game is finished`),
+		regexp.MustCompile(`Game is finished`),
+		regexp.MustCompile(`Game is finished`))
+}
+
+func TestActivePlayersFromGameLogicOverridesTurnOrderFast(t *testing.T) {
+	subtestHelloGlActiveClients(t, []string{"--fast", "--turn-order=round-robin"},
+		3, 0, 0,
+		10, 10, 10, 10,
+		0, 0,
+		false, true,
+		DefaultHelloClientCheckGameStarts, checkTurnActionableField, checkTurnActionableField,
+		DefaultHelloClientCheckGameEnds, checkDoTurnOnlyFirstPlayerActive,
+		DefaultHelloGLDoInitAck, doTurnAckOnlyFirstPlayerActive,
+		DefaultHelloClientTurnAck, DefaultHelloClientTurnAck,
+		regexp.MustCompile(`Game is finished`),
+		regexp.MustCompile(`Game is finished`),
+		regexp.MustCompile(`Game is finished`))
+}
+
+func doTurnAckBadActivePlayers(turn int, actions []interface{}) string {
+	return `{"message_type":"DO_TURN_ACK", "winner_player_id":-1,` +
+		`"active_players":[42], "game_state":{"all_clients":{}}}`
+}
+
+func doTurnAckActivePlayersNotArray(turn int, actions []interface{}) string {
+	return `{"message_type":"DO_TURN_ACK", "winner_player_id":-1,` +
+		`"active_players":0, "game_state":{"all_clients":{}}}`
+}
+
+func TestInvalidDoTurnAckBadActivePlayers(t *testing.T) {
+	subtestHelloGlActiveClients(t, nil, 1, 0, 1,
+		3, 1, 0, 0,
+		0, 0,
+		false, false,
+		DefaultHelloClientCheckGameStarts, DefaultHelloClientCheckTurn, DefaultHelloClientCheckTurn,
+		DefaultHelloClientCheckGameEnds, DefaultHelloGLCheckDoTurn,
+		DefaultHelloGLDoInitAck, doTurnAckBadActivePlayers,
+		DefaultHelloClientTurnAck, DefaultHelloClientTurnAck,
+		regexp.MustCompile(`Invalid active_players`),
+		regexp.MustCompile(`netorcai abort`),
+		regexp.MustCompile(`netorcai abort`))
+}
+
+func TestInvalidDoTurnAckActivePlayersNotArray(t *testing.T) {
+	subtestHelloGlActiveClients(t, nil, 1, 0, 1,
+		3, 1, 0, 0,
+		0, 0,
+		false, false,
+		DefaultHelloClientCheckGameStarts, DefaultHelloClientCheckTurn, DefaultHelloClientCheckTurn,
+		DefaultHelloClientCheckGameEnds, DefaultHelloGLCheckDoTurn,
+		DefaultHelloGLDoInitAck, doTurnAckActivePlayersNotArray,
+		DefaultHelloClientTurnAck, DefaultHelloClientTurnAck,
+		regexp.MustCompile(`Non-array value for field 'active_players'`),
+		regexp.MustCompile(`netorcai abort`),
+		regexp.MustCompile(`netorcai abort`))
+}
@@ -0,0 +1,70 @@
+package test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// runVisuGame runs a game with one player and one visualization.
+// It returns what the player and the visualization received.
+func runVisuGame(t *testing.T, arguments []string) (*recordingPlayer,
+	*recordingPlayer) {
+	proc := runNetorcaiWaitListening(t, append([]string{
+		"--nb-players-max=1", "--nb-visus-max=1"}, arguments...))
+	defer killallNetorcaiSIGKILL()
+
+	players := []*recordingPlayer{{}, {}}
+	err := playGame(t, proc, []gameClient{
+		{role: "player", player: players[0]},
+		{role: "visualization", player: players[1]},
+	}, nil, &counterGameLogic{winnerPlayerID: -1}, 5000)
+	assert.NoError(t, err, "RunGameLogic failed")
+	return players[0], players[1]
+}
+
+func TestVisuDeltas(t *testing.T) {
+	player, visu := runVisuGame(t, []string{"--nb-turns-max=5", "--fast",
+		"--visu-deltas"})
+
+	assert.NotEmpty(t, visu.turns, "No TURN received")
+	for _, turn := range player.turns {
+		assert.Nil(t, turn.GameStatePatch, "Player received a patch")
+	}
+	for _, turn := range visu.turns {
+		assert.NotNil(t, turn.GameStatePatch, "Visu received no patch")
+		// The game state is rebuilt by the client library.
+		assert.Equal(t, map[string]interface{}{
+			"turn": float64(turn.TurnNumber + 1)}, turn.GameState,
+			"Unexpected game state")
+	}
+}
+
+func TestVisuMaxFrameRate(t *testing.T) {
+	player, visu := runVisuGame(t, []string{"--nb-turns-max=21",
+		"--delay-first-turn=50", "--delay-turns=50", "--visu-max-fps=4"})
+
+	// The game lasts about 1 s: the visu is sent about 5 TURNs.
+	assert.Len(t, player.turns, 20, "Player TURNs have been dropped")
+	assert.NotEmpty(t, visu.turns, "No TURN received")
+	assert.True(t, len(visu.turns) <= 7,
+		"Too many TURNs sent to the visu (%v)", len(visu.turns))
+	for index := 1; index < len(visu.turns); index++ {
+		assert.Equal(t, visu.turns[index].TurnNumber-
+			visu.turns[index-1].TurnNumber-1,
+			visu.turns[index].SkippedTurns, "Unexpected skipped_turns")
+	}
+}
+
+func TestVisuNotReadingFast(t *testing.T) {
+	proc := runNetorcaiWaitListening(t, []string{"--nb-players-max=1",
+		"--nb-visus-max=1", "--nb-turns-max=1000", "--fast",
+		"--visu-skip-policy=queue:100"})
+	defer killallNetorcaiSIGKILL()
+
+	// The visualization logs in but never reads anything.
+	err := playGame(t, proc, []gameClient{
+		{role: "player", player: &recordingPlayer{}},
+		{role: "visualization"},
+	}, nil, &counterGameLogic{winnerPlayerID: -1}, 10000)
+	assert.NoError(t, err, "RunGameLogic failed")
+}
@@ -1,10 +1,11 @@
 package netorcai
 
 import (
-	"fmt"
+	"github.com/netorcai/netorcai/protocol"
 )
 
-var VersionMajor = 2
-var VersionMinor = 0
-var VersionPatch = 0
-var Version = fmt.Sprintf("%d.%d.%d", VersionMajor, VersionMinor, VersionPatch)
+// netorcai's version is the version of the metaprotocol it implements.
+var VersionMajor = protocol.VersionMajor
+var VersionMinor = protocol.VersionMinor
+var VersionPatch = protocol.VersionPatch
+var Version = protocol.Version